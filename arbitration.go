@@ -0,0 +1,115 @@
+package m68kemu
+
+import "fmt"
+
+const (
+	// busGrantLatency covers BR to BG plus the end of the CPU's current bus
+	// cycle, rounded to whole clocks.
+	busGrantLatency uint32 = 2
+	// busReleaseLatency covers BGACK negation until the CPU drives the bus again.
+	busReleaseLatency uint32 = 2
+	// busTransferCycles is the length of one word transfer by another master.
+	busTransferCycles uint32 = 4
+)
+
+// BusMaster performs transfers while it owns the bus. It runs at the next
+// instruction boundary after RequestBus, or immediately while the CPU is
+// stopped, and every cycle it spends is charged to the CPU.
+type BusMaster func(grant *BusGrant) error
+
+type busRequest struct {
+	name   string
+	master BusMaster
+}
+
+// BusGrant is handed to a BusMaster for the duration of its bus tenure.
+type BusGrant struct {
+	name   string
+	cpu    *cpu
+	cycles uint64
+}
+
+// RequestBus asks for the bus on behalf of another master such as a DMA
+// controller or blitter. Requests are granted in order; masters that request
+// the bus while another holds it are served before the CPU resumes.
+func (s *CycleScheduler) RequestBus(name string, master BusMaster) {
+	if s == nil || master == nil {
+		return
+	}
+	s.busRequests = append(s.busRequests, busRequest{name: name, master: master})
+}
+
+// BusRequested reports whether a master is waiting for the bus.
+func (s *CycleScheduler) BusRequested() bool {
+	return s != nil && len(s.busRequests) != 0
+}
+
+// Name returns the name the master requested the bus with.
+func (g *BusGrant) Name() string {
+	return g.name
+}
+
+// Now returns the current CPU cycle count.
+func (g *BusGrant) Now() uint64 {
+	return g.cpu.cycles
+}
+
+// Cycles returns how many cycles the master has held the bus so far.
+func (g *BusGrant) Cycles() uint64 {
+	return g.cycles
+}
+
+// Read performs a bus read cycle as the granted master. Device wait states are
+// charged on top of the transfer time.
+func (g *BusGrant) Read(size Size, address uint32) (uint32, error) {
+	value, err := g.cpu.bus.Read(size, address)
+	g.charge(transferCycles(size))
+	return value, err
+}
+
+// Write performs a bus write cycle as the granted master.
+func (g *BusGrant) Write(size Size, address uint32, value uint32) error {
+	if g.cpu.blocks != nil {
+		g.cpu.blocks.noteWrite(address&0xffffff, size)
+	}
+	err := g.cpu.bus.Write(size, address, value)
+	g.charge(transferCycles(size))
+	return err
+}
+
+// Idle holds the bus for cycles without transferring data, for example while
+// a blitter computes or the shifter fetches on its own schedule.
+func (g *BusGrant) Idle(cycles uint32) {
+	g.charge(cycles)
+}
+
+func (g *BusGrant) charge(cycles uint32) {
+	g.cycles += uint64(cycles)
+	g.cpu.addCycles(cycles)
+}
+
+func transferCycles(size Size) uint32 {
+	if size == Long {
+		return 2 * busTransferCycles
+	}
+	return busTransferCycles
+}
+
+// arbitrateBus hands the bus to every waiting master and stalls the CPU until
+// they are done.
+func (cpu *cpu) arbitrateBus() error {
+	s := cpu.scheduler
+	cpu.addCycles(busGrantLatency)
+	for i := 0; i < len(s.busRequests); i++ {
+		request := s.busRequests[i]
+		s.busRequests[i] = busRequest{}
+		grant := BusGrant{name: request.name, cpu: cpu}
+		if err := request.master(&grant); err != nil {
+			s.busRequests = s.busRequests[:0]
+			return fmt.Errorf("bus master %s: %w", request.name, err)
+		}
+	}
+	s.busRequests = s.busRequests[:0]
+	cpu.addCycles(busReleaseLatency)
+	return nil
+}
@@ -0,0 +1,108 @@
+package m68kemu
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBusMasterStallsCPUAndTransfersData(t *testing.T) {
+	cpu, ram := newEnvironment(t)
+	scheduler := NewCycleScheduler()
+	cpu.SetScheduler(scheduler)
+
+	code := assemble(t, "NOP\nNOP")
+	for i, b := range code {
+		if err := ram.Write(Byte, cpu.regs.PC+uint32(i), uint32(b)); err != nil {
+			t.Fatalf("write code: %v", err)
+		}
+	}
+	if err := ram.Write(Long, 0x4000, 0xcafef00d); err != nil {
+		t.Fatalf("seed source: %v", err)
+	}
+
+	var grantedAt uint64
+	scheduler.ScheduleAfter(2, func(uint64) {
+		scheduler.RequestBus("dma", func(grant *BusGrant) error {
+			grantedAt = grant.Now()
+			for offset := uint32(0); offset < 4; offset += 2 {
+				value, err := grant.Read(Word, 0x4000+offset)
+				if err != nil {
+					return err
+				}
+				if err := grant.Write(Word, 0x5000+offset, value); err != nil {
+					return err
+				}
+			}
+			grant.Idle(6)
+			if grant.Cycles() != 4*4+6 {
+				t.Errorf("grant held the bus for %d cycles, want 22", grant.Cycles())
+			}
+			return nil
+		})
+	})
+
+	if err := cpu.Step(); err != nil {
+		t.Fatalf("first step failed: %v", err)
+	}
+	if !scheduler.BusRequested() {
+		t.Fatalf("bus request should wait for the instruction boundary")
+	}
+	if err := cpu.Step(); err != nil {
+		t.Fatalf("second step failed: %v", err)
+	}
+
+	if grantedAt != 4+uint64(busGrantLatency) {
+		t.Fatalf("bus granted at cycle %d, want %d", grantedAt, 4+busGrantLatency)
+	}
+	want := uint64(4 + busGrantLatency + 22 + busReleaseLatency + 4)
+	if cpu.cycles != want {
+		t.Fatalf("cycles = %d, want %d", cpu.cycles, want)
+	}
+	if got, err := ram.Read(Long, 0x5000); err != nil || got != 0xcafef00d {
+		t.Fatalf("copied long = (%x, %v), want (cafef00d, nil)", got, err)
+	}
+	if scheduler.BusRequested() {
+		t.Fatalf("bus request still pending after grant")
+	}
+}
+
+func TestBusMasterIsGrantedWhileCPUStopped(t *testing.T) {
+	cpu, _ := newEnvironment(t)
+	scheduler := NewCycleScheduler()
+	cpu.SetScheduler(scheduler)
+	cpu.stopped = true
+
+	granted := 0
+	scheduler.RequestBus("blitter", func(grant *BusGrant) error {
+		granted++
+		grant.Idle(100)
+		return nil
+	})
+
+	if err := cpu.RunCycles(50); err != nil {
+		t.Fatalf("RunCycles failed: %v", err)
+	}
+	if granted != 1 {
+		t.Fatalf("master granted %d times, want 1", granted)
+	}
+	if want := uint64(busGrantLatency + 100 + busReleaseLatency); cpu.cycles != want {
+		t.Fatalf("cycles = %d, want %d", cpu.cycles, want)
+	}
+}
+
+func TestBusMasterErrorStopsExecution(t *testing.T) {
+	cpu, _ := newEnvironment(t)
+	scheduler := NewCycleScheduler()
+	cpu.SetScheduler(scheduler)
+
+	scheduler.RequestBus("dma", func(grant *BusGrant) error {
+		_, err := grant.Read(Word, 0xff0000)
+		return err
+	})
+
+	err := cpu.Step()
+	var busErr BusError
+	if !errors.As(err, &busErr) {
+		t.Fatalf("Step error = %v, want wrapped BusError", err)
+	}
+}
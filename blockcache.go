@@ -0,0 +1,491 @@
+package m68kemu
+
+import "github.com/jenska/m68kdasm"
+
+// ExecutionEngine selects how RunCycles executes guest code.
+type ExecutionEngine int
+
+const (
+	// EngineInterpreter fetches, decodes, and dispatches every instruction.
+	EngineInterpreter ExecutionEngine = iota
+	// EngineBlockCache translates straight-line runs of code in direct RAM into
+	// cached blocks of pre-decoded operations. Common register-only forms run
+	// without going through the EA machinery; everything else dispatches to the
+	// resolved handler without refetching the opcode. Blocks are dropped when
+	// the CPU or a bus master writes to their pages; other writers must call
+	// InvalidateCode.
+	EngineBlockCache
+)
+
+const (
+	blockPageShift   = 12
+	blockPageCount   = 1 << (24 - blockPageShift)
+	blockTableSize   = 4096
+	blockMaxOps      = 64
+	blockPagePruning = 64
+)
+
+type blockOpKind uint8
+
+const (
+	blockOpGeneric blockOpKind = iota
+	blockOpMoveq
+	blockOpAddqData
+	blockOpSubqData
+	blockOpAddqAddress
+	blockOpSubqAddress
+	blockOpMoveData
+	blockOpMoveaData
+	blockOpMoveaAddress
+	blockOpAddData
+	blockOpSubData
+	blockOpCmpData
+	blockOpTstData
+	blockOpBranch
+	blockOpDbcc
+)
+
+// blockOp is one pre-decoded instruction. Register numbers, immediates, and
+// branch targets are resolved at translation time.
+type blockOp struct {
+	kind    blockOpKind
+	size    Size
+	src     uint8
+	dst     uint8
+	cond    uint16
+	opcode  uint16
+	cycles  uint32
+	imm     uint32
+	pc      uint32
+	next    uint32
+	target  uint32
+	handler instruction
+}
+
+type codeBlock struct {
+	start uint32
+	end   uint32
+	ops   []blockOp
+	valid bool
+}
+
+type blockCache struct {
+	table [blockTableSize]*codeBlock
+	pages [blockPageCount][]*codeBlock
+}
+
+func newBlockCache() *blockCache {
+	return &blockCache{}
+}
+
+func blockSlot(pc uint32) uint32 {
+	return (pc >> 1) & (blockTableSize - 1)
+}
+
+func (c *blockCache) lookup(pc uint32) *codeBlock {
+	if block := c.table[blockSlot(pc)]; block != nil && block.start == pc && block.valid {
+		return block
+	}
+	return nil
+}
+
+func (c *blockCache) insert(block *codeBlock) {
+	c.table[blockSlot(block.start)] = block
+	for page := block.start >> blockPageShift; page <= (block.end-1)>>blockPageShift; page++ {
+		blocks := c.pages[page]
+		if len(blocks) >= blockPagePruning {
+			blocks = c.prune(blocks)
+		}
+		c.pages[page] = append(blocks, block)
+	}
+}
+
+// prune drops blocks that were invalidated or evicted from the lookup table.
+func (c *blockCache) prune(blocks []*codeBlock) []*codeBlock {
+	kept := blocks[:0]
+	for _, block := range blocks {
+		if block.valid && c.table[blockSlot(block.start)] == block {
+			kept = append(kept, block)
+		}
+	}
+	clear(blocks[len(kept):])
+	return kept
+}
+
+// invalidate drops every block overlapping start..end (inclusive).
+func (c *blockCache) invalidate(start, end uint32) {
+	start &= 0xffffff
+	end &= 0xffffff
+	if end < start {
+		end = 0xffffff
+	}
+	for page := start >> blockPageShift; page <= end>>blockPageShift; page++ {
+		blocks := c.pages[page]
+		if len(blocks) == 0 {
+			continue
+		}
+		kept := blocks[:0]
+		for _, block := range blocks {
+			if block.start <= end && block.end > start {
+				block.valid = false
+				continue
+			}
+			kept = append(kept, block)
+		}
+		clear(blocks[len(kept):])
+		c.pages[page] = kept
+	}
+}
+
+func (c *blockCache) noteWrite(address uint32, size Size) {
+	if len(c.pages[address>>blockPageShift]) != 0 || len(c.pages[((address+uint32(size)-1)&0xffffff)>>blockPageShift]) != 0 {
+		c.invalidate(address, address+uint32(size)-1)
+	}
+}
+
+// SetExecutionEngine switches between the interpreter and the block cache.
+// Selecting the interpreter drops all cached blocks.
+func (cpu *cpu) SetExecutionEngine(engine ExecutionEngine) {
+	if engine == EngineBlockCache {
+		if cpu.blocks == nil {
+			cpu.blocks = newBlockCache()
+		}
+		return
+	}
+	cpu.blocks = nil
+}
+
+// InvalidateCode discards cached blocks covering length bytes at address. Call
+// it after changing code behind the CPU's back, for example by writing to a
+// RAM device directly.
+func (cpu *cpu) InvalidateCode(address, length uint32) {
+	if cpu.blocks == nil || length == 0 {
+		return
+	}
+	cpu.blocks.invalidate(address, address+length-1)
+}
+
+// blocksUsable reports whether RunCycles may take the block path. Debug
+// features that observe individual fetches keep the interpreter in charge.
+func (cpu *cpu) blocksUsable() bool {
+	return cpu.blocks != nil && !cpu.stopped && cpu.breakpoints == nil &&
+		cpu.preTrap == nil && !cpu.traceInstructions && !cpu.traceBus
+}
+
+// runBlock executes the block at PC, translating it first if needed. It
+// returns false when no block can be built there so the caller interprets the
+// instruction instead.
+func (cpu *cpu) runBlock(target uint64) (bool, error) {
+	pc := cpu.regs.PC
+	if pc > 0xffffff {
+		return false, nil
+	}
+	block := cpu.blocks.lookup(pc)
+	if block == nil {
+		block = cpu.translateBlock(pc)
+		if block == nil {
+			return false, nil
+		}
+		cpu.blocks.insert(block)
+	}
+
+	regs := &cpu.regs
+	for i := range block.ops {
+		op := &block.ops[i]
+		regs.IR = op.opcode
+		cpu.currentOpcodePC = op.pc
+		cpu.currentOpcodeValid = true
+		if cpu.busFast.timing != nil {
+			cpu.timeBlockOp(op)
+		} else {
+			cpu.chargeInstruction(op.cycles)
+		}
+
+		switch op.kind {
+		case blockOpMoveq:
+			regs.D[op.dst] = int32(op.imm)
+			replaceStatusFlags(cpu, statusMaskNZVC, nzFlags(op.imm, Long))
+			regs.PC = op.next
+		case blockOpAddqData:
+			dst := uint32(regs.D[op.dst])
+			result, flags := addWithFlags(op.imm, dst, op.size)
+			regs.D[op.dst] = int32(dst&^op.size.mask() | result)
+			replaceStatusFlags(cpu, statusMaskNZVCX, flags)
+			regs.PC = op.next
+		case blockOpSubqData:
+			dst := uint32(regs.D[op.dst])
+			result, flags := subWithFlags(op.imm, dst, op.size)
+			regs.D[op.dst] = int32(dst&^op.size.mask() | result)
+			replaceStatusFlags(cpu, statusMaskNZVCX, flags)
+			regs.PC = op.next
+		case blockOpAddqAddress:
+			regs.A[op.dst] += op.imm
+			regs.PC = op.next
+		case blockOpSubqAddress:
+			regs.A[op.dst] -= op.imm
+			regs.PC = op.next
+		case blockOpMoveData:
+			mask := op.size.mask()
+			value := uint32(regs.D[op.src]) & mask
+			regs.D[op.dst] = int32(uint32(regs.D[op.dst])&^mask | value)
+			replaceStatusFlags(cpu, statusMaskNZVC, nzFlags(value, op.size))
+			regs.PC = op.next
+		case blockOpMoveaData:
+			regs.A[op.dst] = signExtendAddress(uint32(regs.D[op.src]), op.size)
+			regs.PC = op.next
+		case blockOpMoveaAddress:
+			regs.A[op.dst] = signExtendAddress(regs.A[op.src], op.size)
+			regs.PC = op.next
+		case blockOpAddData:
+			dst := uint32(regs.D[op.dst])
+			result, flags := addWithFlags(uint32(regs.D[op.src]), dst, op.size)
+			regs.D[op.dst] = int32(dst&^op.size.mask() | result)
+			replaceStatusFlags(cpu, statusMaskNZVCX, flags)
+			regs.PC = op.next
+		case blockOpSubData:
+			dst := uint32(regs.D[op.dst])
+			result, flags := subWithFlags(uint32(regs.D[op.src]), dst, op.size)
+			regs.D[op.dst] = int32(dst&^op.size.mask() | result)
+			replaceStatusFlags(cpu, statusMaskNZVCX, flags)
+			regs.PC = op.next
+		case blockOpCmpData:
+			_, flags := subWithFlags(uint32(regs.D[op.src]), uint32(regs.D[op.dst]), op.size)
+			replaceStatusFlags(cpu, statusMaskNZVC, flags)
+			regs.PC = op.next
+		case blockOpTstData:
+			replaceStatusFlags(cpu, statusMaskNZVC, nzFlags(uint32(regs.D[op.src]), op.size))
+			regs.PC = op.next
+		case blockOpBranch:
+			if op.cond == 0 || conditionTrue(cpu, op.cond) {
+				regs.PC = op.target
+			} else {
+				regs.PC = op.next
+			}
+		case blockOpDbcc:
+			regs.PC = op.next
+			if !conditionTrue(cpu, op.cond) {
+				counter := uint16(regs.D[op.dst]) - 1
+				regs.D[op.dst] = regs.D[op.dst]&^0xffff | int32(counter)
+				if counter != 0xffff {
+					regs.PC = op.target
+				}
+			}
+		default:
+			regs.PC = op.pc + uint32(Word)
+			if err := op.handler(cpu); err != nil {
+				err = cpu.handleFaultError(err, true)
+				cpu.currentOpcodeValid = false
+				return true, err
+			}
+		}
+
+		if cpu.boundaryEvents {
+			cpu.syncScheduler()
+		}
+		if cpu.interrupts.HasPending(regs.SR) {
+			err := cpu.checkInterrupts()
+			cpu.currentOpcodeValid = false
+			if cpu.boundaryEvents {
+				cpu.syncScheduler()
+			}
+			return true, err
+		}
+		if regs.PC != op.next || !block.valid || cpu.stopped || cpu.cycles >= target || cpu.scheduler.BusRequested() {
+			break
+		}
+	}
+	cpu.currentOpcodeValid = false
+	return true, nil
+}
+
+// timeBlockOp charges an op's cycles under a bus timing policy, timing the
+// instruction words the interpreter would have fetched: the opcode, and the
+// extension words of ops other than generic ones, which fetch their own.
+func (cpu *cpu) timeBlockOp(op *blockOp) {
+	fc := FunctionCode(cpu.programFunctionCode())
+	cpu.aheadCycles = 0
+	cpu.busFast.directCycles(fc, false, Word, op.pc)
+	cpu.chargeInstruction(op.cycles)
+	if op.kind == blockOpGeneric {
+		return
+	}
+	for offset := uint32(Word); offset < (op.next-op.pc)&0xffffff; offset += uint32(Word) {
+		cpu.busFast.directCycles(fc, false, Word, (op.pc+offset)&0xffffff)
+	}
+}
+
+func signExtendAddress(value uint32, size Size) uint32 {
+	if size == Word {
+		return uint32(int32(int16(value)))
+	}
+	return value
+}
+
+// translateBlock decodes instructions from PC up to the next control transfer.
+// Only code inside the bus's direct RAM and ROM pages is translated, where
+// skipping the opcode fetch cannot hide wait states, function-code checks, or
+// faults. Blocks end where an instruction would cross into another page.
+func (cpu *cpu) translateBlock(pc uint32) *codeBlock {
+	if pc&1 != 0 {
+		return nil
+	}
+
+	block := &codeBlock{start: pc, valid: true}
+	for len(block.ops) < blockMaxOps {
+		mem, idx := cpu.busFast.directPage(pc, Word, false)
+		if mem == nil {
+			break
+		}
+		opcode := uint16(mem[idx])<<8 | uint16(mem[idx+1])
+		handler := opcodeTable[opcode]
+		if handler == nil {
+			break
+		}
+
+		length := instructionLength(mem[idx:], pc)
+		if length == 0 || idx+length > uint32(len(mem)) {
+			break
+		}
+		op := blockOp{
+			kind:    blockOpGeneric,
+			opcode:  opcode,
+			cycles:  opcodeCycleTable[opcode],
+			pc:      pc,
+			next:    (pc + length) & 0xffffff,
+			handler: handler,
+		}
+		decodeBlockOp(&op, mem[idx:idx+length])
+		block.ops = append(block.ops, op)
+		pc = op.next
+		if endsBlock(opcode) {
+			break
+		}
+	}
+	if len(block.ops) == 0 {
+		return nil
+	}
+	block.end = pc
+	if block.end <= block.start {
+		block.end = block.start + 2
+	}
+	return block
+}
+
+// instructionLength returns the encoded length of the instruction at the
+// start of code, or zero when it cannot be decoded.
+func instructionLength(code []byte, pc uint32) uint32 {
+	inst, err := m68kdasm.Decode(code[:min(len(code), maxDisassemblyBytes)], pc)
+	if err != nil || len(inst.Bytes) < 2 || len(inst.Bytes)&1 != 0 {
+		return 0
+	}
+	return uint32(len(inst.Bytes))
+}
+
+// endsBlock reports opcodes whose successor is not the next instruction.
+func endsBlock(opcode uint16) bool {
+	switch {
+	case opcode&0xf000 == 0x6000: // Bcc, BRA, BSR
+		return true
+	case opcode&0xf0f8 == 0x50c8: // DBcc
+		return true
+	case opcode&0xff80 == 0x4e80: // JSR, JMP
+		return true
+	case opcode&0xfff0 == 0x4e40: // TRAP
+		return true
+	case opcode == 0x4e72, opcode == 0x4e73, opcode == 0x4e75, opcode == 0x4e76, opcode == 0x4e77:
+		return true // STOP, RTE, RTS, TRAPV, RTR
+	}
+	return false
+}
+
+// decodeBlockOp specialises register-only forms of frequent instructions.
+// Anything else stays a generic op that calls the regular handler.
+func decodeBlockOp(op *blockOp, code []byte) {
+	opcode := op.opcode
+	mode := (opcode >> 3) & 0x7
+	reg := uint8(opcode & 0x7)
+	regX := uint8((opcode >> 9) & 0x7)
+
+	switch {
+	case opcode&0xf100 == 0x7000:
+		op.kind, op.dst, op.imm = blockOpMoveq, regX, uint32(int32(int8(opcode)))
+	case opcode&0xf000 == 0x5000 && opcode&0xc0 != 0xc0 && (mode == 0 || mode == 1):
+		quick := uint32(regX)
+		if quick == 0 {
+			quick = 8
+		}
+		op.imm, op.dst, op.size = quick, reg, operandSizeFromOpcode(opcode)
+		subtract := opcode&0x0100 != 0
+		switch {
+		case mode == 1 && subtract:
+			op.kind = blockOpSubqAddress
+		case mode == 1:
+			op.kind = blockOpAddqAddress
+		case subtract:
+			op.kind = blockOpSubqData
+		default:
+			op.kind = blockOpAddqData
+		}
+	case opcode&0xc000 == 0 && opcode&0x3000 != 0 && mode == 0:
+		size := moveSize(opcode)
+		dstMode := (opcode >> 6) & 0x7
+		switch dstMode {
+		case 0:
+			op.kind, op.src, op.dst, op.size = blockOpMoveData, reg, regX, size
+		case 1:
+			op.kind, op.src, op.dst, op.size = blockOpMoveaData, reg, regX, size
+		}
+	case opcode&0xc000 == 0 && opcode&0x3000 != 0 && opcode&0x3000 != 0x1000 && mode == 1 && (opcode>>6)&0x7 == 1:
+		op.kind, op.src, op.dst, op.size = blockOpMoveaAddress, reg, regX, moveSize(opcode)
+	case (opcode&0xf000 == 0xd000 || opcode&0xf000 == 0x9000 || opcode&0xf000 == 0xb000) && (opcode>>6)&0x7 <= 2 && mode == 0:
+		op.src, op.dst, op.size = reg, regX, operandSizeFromOpmode((opcode>>6)&0x7)
+		switch opcode & 0xf000 {
+		case 0xd000:
+			op.kind = blockOpAddData
+		case 0x9000:
+			op.kind = blockOpSubData
+		default:
+			op.kind = blockOpCmpData
+		}
+	case opcode&0xff00 == 0x4a00 && opcode&0xc0 != 0xc0 && mode == 0:
+		op.kind, op.src, op.size = blockOpTstData, reg, operandSizeFromOpcode(opcode)
+	case opcode&0xf000 == 0x6000 && (opcode>>8)&0xf != 1:
+		displacement := int32(int8(opcode))
+		if displacement == 0 {
+			if len(code) < 4 {
+				return
+			}
+			displacement = int32(int16(uint16(code[2])<<8 | uint16(code[3])))
+		}
+		op.kind, op.cond = blockOpBranch, (opcode>>8)&0xf
+		op.target = uint32(int32(op.pc) + 2 + displacement)
+		if int8(opcode) == 0 {
+			op.next = op.pc + 4
+		} else {
+			op.next = op.pc + 2
+		}
+	case opcode&0xf0f8 == 0x50c8:
+		if len(code) < 4 {
+			return
+		}
+		displacement := int32(int16(uint16(code[2])<<8 | uint16(code[3])))
+		op.kind, op.cond, op.dst = blockOpDbcc, (opcode>>8)&0xf, reg
+		op.target = uint32(int32(op.pc) + 2 + displacement)
+		op.next = op.pc + 4
+	}
+	if op.kind != blockOpGeneric && op.kind != blockOpBranch && op.kind != blockOpDbcc {
+		op.next = op.pc + 2
+	}
+}
+
+// moveSize decodes the size field of MOVE/MOVEA opcodes.
+func moveSize(opcode uint16) Size {
+	switch opcode & 0x3000 {
+	case 0x1000:
+		return Byte
+	case 0x3000:
+		return Word
+	default:
+		return Long
+	}
+}
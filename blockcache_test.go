@@ -0,0 +1,182 @@
+package m68kemu
+
+import (
+	"bytes"
+	"testing"
+)
+
+func runWithEngine(t *testing.T, engine ExecutionEngine, src string, cycles uint64, setup func(*cpu)) (*cpu, *RAM) {
+	t.Helper()
+	cpu, ram := newEnvironment(t)
+	cpu.SetExecutionEngine(engine)
+	code := assemble(t, src)
+	for i, b := range code {
+		if err := ram.Write(Byte, cpu.regs.PC+uint32(i), uint32(b)); err != nil {
+			t.Fatalf("write code: %v", err)
+		}
+	}
+	if setup != nil {
+		setup(cpu)
+	}
+	if err := cpu.RunCycles(cycles); err != nil {
+		t.Fatalf("RunCycles failed: %v", err)
+	}
+	return cpu, ram
+}
+
+func assertEnginesAgree(t *testing.T, src string, cycles uint64, setup func(*cpu)) {
+	t.Helper()
+	interp, interpRAM := runWithEngine(t, EngineInterpreter, src, cycles, setup)
+	blocks, blocksRAM := runWithEngine(t, EngineBlockCache, src, cycles, setup)
+
+	if interp.regs != blocks.regs {
+		t.Fatalf("registers differ:\ninterpreter %+v\nblock cache %+v", interp.regs, blocks.regs)
+	}
+	if interp.cycles != blocks.cycles {
+		t.Fatalf("cycles differ: interpreter %d, block cache %d", interp.cycles, blocks.cycles)
+	}
+	if !bytes.Equal(interpRAM.mem, blocksRAM.mem) {
+		t.Fatalf("memory differs between engines")
+	}
+}
+
+func TestBlockCacheMatchesInterpreter(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{
+			name: "Sieve",
+			src: `
+        LEA     $4000,A0
+        MOVE.W  #999,D0
+clear:  CLR.B   (A0)+
+        DBRA    D0,clear
+        LEA     $4000,A0
+        MOVEQ   #2,D0
+outer:  CMP.W   #1000,D0
+        BGE.S   done
+        TST.B   0(A0,D0.W)
+        BNE.S   next
+        MOVE.W  D0,D1
+        ADD.W   D0,D1
+inner:  CMP.W   #1000,D1
+        BGE.S   next
+        MOVE.B  #1,0(A0,D1.W)
+        ADD.W   D0,D1
+        BRA.S   inner
+next:   ADDQ.W  #1,D0
+        BRA.S   outer
+done:   BRA.S   done
+`,
+		},
+		{
+			name: "RegisterForms",
+			src: `
+        MOVEQ   #-3,D0
+        MOVEQ   #100,D7
+        MOVEA.L #$5000,A2
+loop:   ADDQ.B  #7,D0
+        SUBQ.W  #3,D1
+        ADD.L   D0,D2
+        SUB.B   D1,D3
+        CMP.W   D2,D3
+        BHI.W   skip
+        MOVE.B  D0,D4
+        MOVE.W  D2,D5
+        TST.L   D5
+        BMI.S   skip
+        MOVEA.W D4,A3
+        MOVEA.L A3,A4
+skip:   SUBQ.L  #4,A2
+        ADDQ.W  #2,A2
+        JSR     sub
+        DBEQ    D7,loop
+        STOP    #$2000
+sub:    MOVE.L  D2,(A2)
+        RTS
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, budget := range []uint64{1, 37, 1000, 250000} {
+				assertEnginesAgree(t, tt.src, budget, nil)
+			}
+		})
+	}
+}
+
+func TestBlockCacheDeliversInterruptsAtInstructionBoundaries(t *testing.T) {
+	src := `
+loop:   ADDQ.L  #1,D0
+        MOVE.L  D0,D1
+        ADD.L   D1,D2
+        BRA.S   loop
+handler:
+        ADDQ.L  #1,$6000
+        RTE
+`
+	assertEnginesAgree(t, src, 20000, func(cpu *cpu) {
+		handler := cpu.regs.PC + 8
+		if err := cpu.write(Long, uint32(autoVectorBase+3)<<2, handler); err != nil {
+			t.Fatalf("install handler: %v", err)
+		}
+		cpu.setSR(srSupervisor)
+		scheduler := NewCycleScheduler()
+		cpu.SetScheduler(scheduler)
+		var tick func(uint64)
+		tick = func(uint64) {
+			_ = cpu.RequestInterrupt(3, nil)
+			scheduler.ScheduleAfter(333, tick)
+		}
+		scheduler.ScheduleAfter(333, tick)
+	})
+}
+
+func TestBlockCacheInvalidatesSelfModifiedCode(t *testing.T) {
+	// The loop patches its own MOVEQ immediate after the first pass.
+	src := `
+        LEA     patch(PC),A0
+loop:
+patch:  MOVEQ   #1,D0
+        ADD.L   D0,D1
+        MOVE.B  #5,1(A0)
+        CMP.L   #50,D1
+        BLT.S   loop
+        STOP    #$2700
+`
+	cpu, _ := runWithEngine(t, EngineBlockCache, src, 10000, nil)
+	if cpu.regs.D[1] != 51 {
+		t.Fatalf("D1 = %d, want 51 after self-modification", cpu.regs.D[1])
+	}
+	assertEnginesAgree(t, src, 10000, nil)
+}
+
+func TestBlockCacheInvalidateCode(t *testing.T) {
+	cpu, ram := newEnvironment(t)
+	cpu.SetExecutionEngine(EngineBlockCache)
+	code := assemble(t, "loop: MOVEQ #1,D0\nBRA.S loop")
+	for i, b := range code {
+		if err := ram.Write(Byte, cpu.regs.PC+uint32(i), uint32(b)); err != nil {
+			t.Fatalf("write code: %v", err)
+		}
+	}
+	if err := cpu.RunCycles(100); err != nil {
+		t.Fatalf("RunCycles failed: %v", err)
+	}
+
+	// A direct RAM write bypasses the CPU, so the stale block keeps running
+	// until the caller invalidates it.
+	if err := ram.Write(Byte, 0x2001, 7); err != nil {
+		t.Fatalf("patch code: %v", err)
+	}
+	cpu.InvalidateCode(0x2000, 2)
+	if err := cpu.RunCycles(100); err != nil {
+		t.Fatalf("RunCycles failed: %v", err)
+	}
+	if cpu.regs.D[0] != 7 {
+		t.Fatalf("D0 = %d, want 7 after InvalidateCode", cpu.regs.D[0])
+	}
+}
@@ -24,11 +24,68 @@ type WaitStateDevice interface {
 	WaitStates(Size, uint32) uint32
 }
 
+// VPADevice optionally marks addresses as 6800-family peripherals that assert
+// VPA instead of DTACK. Accesses to them run as synchronous cycles locked to the
+// E clock (CPU clock / 10), so the bus charges a penalty that depends on the
+// current cycle phase.
+type VPADevice interface {
+	ValidPeripheralAddress(address uint32) bool
+}
+
 // PeekDevice exposes a side-effect-free read path for debugging and disassembly.
 type PeekDevice interface {
 	Peek(Size, uint32) (uint32, error)
 }
 
+// PokeDevice exposes a side-effect-free write path for loaders and debuggers.
+// Unlike Write, a poke must not trigger device behaviour and may store into
+// otherwise read-only storage.
+type PokeDevice interface {
+	Poke(Size, uint32, uint32) error
+}
+
+// FunctionCode is the value the 68000 drives on FC2-FC0 with every bus cycle.
+type FunctionCode uint8
+
+const (
+	FunctionCodeUserData          FunctionCode = 1
+	FunctionCodeUserProgram       FunctionCode = 2
+	FunctionCodeSupervisorData    FunctionCode = 5
+	FunctionCodeSupervisorProgram FunctionCode = 6
+	FunctionCodeCPUSpace          FunctionCode = 7
+)
+
+// FunctionCodeBus is implemented by address buses that want the function code
+// of every CPU access. The CPU prefers ReadFC/WriteFC over Read/Write when the
+// bus offers them.
+type FunctionCodeBus interface {
+	ReadFC(fc FunctionCode, s Size, address uint32) (uint32, error)
+	WriteFC(fc FunctionCode, s Size, address uint32, value uint32) error
+}
+
+// FunctionCodeDevice optionally receives the function code with each access,
+// so a device can reject user-mode accesses or decode program and data space
+// separately. Plain Read/Write calls stand for supervisor data accesses.
+type FunctionCodeDevice interface {
+	ReadFC(fc FunctionCode, s Size, address uint32) (uint32, error)
+	WriteFC(fc FunctionCode, s Size, address uint32, value uint32) error
+}
+
+// CycleCounter reports the cycle the next bus cycle starts on. The bus uses it
+// to find the phase of each access for a BusTimingPolicy and the E clock phase
+// of synchronous cycles. NewCPU's counter follows the bus cycles of the
+// current instruction rather than the instruction's end.
+type CycleCounter func() uint64
+
+const (
+	eClockDivider       = 10
+	synchronousCycleMin = 6
+
+	fastPageShift = 12
+	fastPageMask  = 1<<fastPageShift - 1
+	fastPageCount = 1 << (24 - fastPageShift)
+)
+
 // WaitHook can be used to simulate wait states or count cycles for bus access.
 type WaitHook func(states uint32)
 
@@ -38,10 +95,17 @@ type Bus struct {
 	devices             []Device
 	waitStates          uint32
 	waitHook            WaitHook
+	cycleCounter        CycleCounter
+	timing              BusTimingPolicy
+	cycleDone           func()
 	singleDevice        Device
 	singleRAM           *RAM
-	fastRAM             *RAM
+	fastPages           *[fastPageCount]fastPage
 	hasWaitStateDevices bool
+	hasVPADevices       bool
+	vpaAutovectors      bool
+	hasRegisterNamers   bool
+	hasFunctionCodes    bool
 	hasPageMap          bool
 	pageRanges          [256][]pageRange
 }
@@ -51,6 +115,14 @@ type MappedDevice struct {
 	start  uint32
 	end    uint32
 	device Device
+	vpa    bool
+}
+
+// SupervisorRegion guards a range of another device against user-mode
+// accesses, as the Atari ST GLUE does for $0-$7FF and the I/O area. Place it
+// on the bus ahead of the device it protects.
+type SupervisorRegion struct {
+	MappedDevice
 }
 
 type mappedWaitStateDevice struct {
@@ -58,6 +130,17 @@ type mappedWaitStateDevice struct {
 	waitStateDevice WaitStateDevice
 }
 
+// fastPage exposes the part of a 4 KiB page backed by RAM or ROM, so the CPU
+// can access it without device dispatch. mem holds the bytes from offset start
+// up to limit within the page; a zero limit marks a page that must go through
+// the bus.
+type fastPage struct {
+	mem      []byte
+	start    uint32
+	limit    uint32
+	writable bool
+}
+
 type pageRange struct {
 	start  uint32
 	end    uint32
@@ -77,11 +160,18 @@ func (b *Bus) AddDevice(device Device) {
 	b.refreshTopology()
 }
 
+// SetDevices replaces the attached devices, for machines whose memory map
+// changes at run time.
+func (b *Bus) SetDevices(devices ...Device) {
+	b.devices = devices
+	b.refreshTopology()
+}
+
 // SetWaitStates defines how many states the bus should report for each
 // transaction when a WaitHook is configured.
 func (b *Bus) SetWaitStates(states uint32) {
 	b.waitStates = states
-	b.refreshFastRAM()
+	b.refreshFastPages()
 }
 
 // SetWaitHook installs a callback that receives the configured wait states for
@@ -91,6 +181,19 @@ func (b *Bus) SetWaitHook(hook WaitHook) {
 	b.waitHook = hook
 }
 
+// SetCycleCounter installs the cycle source used to time synchronous 6800
+// cycles. NewCPU connects the bus to its CPU automatically.
+func (b *Bus) SetCycleCounter(counter CycleCounter) {
+	b.cycleCounter = counter
+}
+
+// SetVPAAutovectors makes autovectored interrupt acknowledge cycles pay the
+// same E clock synchronisation penalty as VPA device accesses, as they do on
+// real hardware where autovectoring is requested through VPA.
+func (b *Bus) SetVPAAutovectors(enabled bool) {
+	b.vpaAutovectors = enabled
+}
+
 // Reset propagates a reset to all attached devices.
 func (b *Bus) Reset() {
 	for _, dev := range b.devices {
@@ -101,6 +204,12 @@ func (b *Bus) Reset() {
 // Read forwards a read to the mapped device after performing alignment and
 // mapping checks.
 func (b *Bus) Read(s Size, address uint32) (uint32, error) {
+	return b.ReadFC(FunctionCodeSupervisorData, s, address)
+}
+
+// ReadFC is Read with the function code of the access, which is passed on to
+// FunctionCodeDevice implementations.
+func (b *Bus) ReadFC(fc FunctionCode, s Size, address uint32) (uint32, error) {
 	address &= 0xffffff
 
 	if err := b.validateAlignment(address, s); err != nil {
@@ -108,18 +217,18 @@ func (b *Bus) Read(s Size, address uint32) (uint32, error) {
 	}
 
 	if s == Long {
-		high, err := b.readCycle(Word, address)
+		high, err := b.readCycle(fc, Word, address)
 		if err != nil {
 			return 0, err
 		}
-		low, err := b.readCycle(Word, (address+uint32(Word))&0xffffff)
+		low, err := b.readCycle(fc, Word, (address+uint32(Word))&0xffffff)
 		if err != nil {
 			return 0, err
 		}
 		return (high << 16) | low, nil
 	}
 
-	return b.readCycle(s, address)
+	return b.readCycle(fc, s, address)
 }
 
 // Peek reads from the mapped device without charging wait states. Devices may
@@ -146,9 +255,34 @@ func (b *Bus) Peek(s Size, address uint32) (uint32, error) {
 	return b.peekCycle(s, address)
 }
 
+// Poke stores into the mapped device without charging wait states or
+// triggering device side effects. Image loaders use it to seed memory.
+func (b *Bus) Poke(s Size, address uint32, value uint32) error {
+	address &= 0xffffff
+
+	if err := b.validateAlignment(address, s); err != nil {
+		return err
+	}
+
+	if s == Long {
+		if err := b.pokeCycle(Word, address, value>>16); err != nil {
+			return err
+		}
+		return b.pokeCycle(Word, (address+uint32(Word))&0xffffff, value)
+	}
+
+	return b.pokeCycle(s, address, value)
+}
+
 // Write forwards a write to the mapped device after performing alignment and
 // mapping checks.
 func (b *Bus) Write(s Size, address uint32, value uint32) error {
+	return b.WriteFC(FunctionCodeSupervisorData, s, address, value)
+}
+
+// WriteFC is Write with the function code of the access, which is passed on
+// to FunctionCodeDevice implementations.
+func (b *Bus) WriteFC(fc FunctionCode, s Size, address uint32, value uint32) error {
 	address &= 0xffffff
 
 	if err := b.validateAlignment(address, s); err != nil {
@@ -156,39 +290,114 @@ func (b *Bus) Write(s Size, address uint32, value uint32) error {
 	}
 
 	if s == Long {
-		if err := b.writeCycle(Word, address, value>>16); err != nil {
+		if err := b.writeCycle(fc, Word, address, value>>16); err != nil {
 			return err
 		}
-		return b.writeCycle(Word, (address+uint32(Word))&0xffffff, value)
+		return b.writeCycle(fc, Word, (address+uint32(Word))&0xffffff, value)
 	}
 
-	return b.writeCycle(s, address, value)
+	return b.writeCycle(fc, s, address, value)
+}
+
+// RegisterName reports the name of the device register mapped at address, if
+// the device behind it implements RegisterNamer.
+func (b *Bus) RegisterName(address uint32) (string, bool) {
+	if !b.hasRegisterNamers {
+		return "", false
+	}
+	address &= 0xffffff
+	namer, ok := b.deviceForAddress(address).(RegisterNamer)
+	if !ok {
+		return "", false
+	}
+	return namer.RegisterName(address)
 }
 
-func (b *Bus) wait(size Size, address uint32, dev Device) {
-	if b.waitHook == nil || (b.waitStates == 0 && !b.hasWaitStateDevices) {
+func (b *Bus) wait(fc FunctionCode, write bool, size Size, address uint32, dev Device) {
+	if b.waitHook == nil || (b.waitStates == 0 && b.timing == nil && !b.hasWaitStateDevices && !b.hasVPADevices) {
 		return
 	}
 
-	states := b.waitStates
+	var states uint32
+	if b.timing != nil {
+		states = b.timing.BusCycleDelay(BusCycle{
+			Cycle:        b.now(),
+			Address:      address,
+			Size:         size,
+			Write:        write,
+			FunctionCode: fc,
+		})
+	}
+	states += b.waitStates
 	if b.hasWaitStateDevices {
 		if wsDev, ok := dev.(WaitStateDevice); ok {
 			states += wsDev.WaitStates(size, address)
 		}
 	}
+	if b.hasVPADevices {
+		if vpa, ok := dev.(VPADevice); ok && vpa.ValidPeripheralAddress(address) {
+			states += b.synchronousCycle(states)
+		}
+	}
 
 	if states > 0 {
 		b.waitHook(states)
 	}
+	// Tell the CPU the cycle is over, so its counter moves on to the start of
+	// the next one.
+	if b.cycleDone != nil {
+		b.cycleDone()
+	}
+}
+
+// directCycles times an access the CPU serves from a direct page, one word
+// cycle at a time like the regular bus path.
+func (b *Bus) directCycles(fc FunctionCode, write bool, size Size, address uint32) {
+	if size == Long {
+		b.wait(fc, write, Word, address, nil)
+		b.wait(fc, write, Word, (address+uint32(Word))&0xffffff, nil)
+		return
+	}
+	b.wait(fc, write, size, address, nil)
+}
+
+// acknowledgeAutovector charges the synchronous cycle of an autovectored
+// interrupt acknowledge when SetVPAAutovectors is enabled.
+func (b *Bus) acknowledgeAutovector() {
+	if !b.vpaAutovectors || b.waitHook == nil {
+		return
+	}
+	b.waitHook(b.synchronousCycle(0))
 }
 
-func (b *Bus) readCycle(s Size, address uint32) (uint32, error) {
+// synchronousCycle returns the extra cycles of a 6800 synchronous cycle that
+// starts pending cycles from now: the wait for the next E clock edge plus the
+// fixed part of the transfer. The result varies between 6 and 15 cycles.
+func (b *Bus) synchronousCycle(pending uint32) uint32 {
+	now := b.now() + uint64(pending)
+	return synchronousCycleMin + uint32((eClockDivider-now%eClockDivider)%eClockDivider)
+}
+
+// now returns the current CPU cycle, or zero without a cycle counter.
+func (b *Bus) now() uint64 {
+	if b.cycleCounter == nil {
+		return 0
+	}
+	return b.cycleCounter()
+}
+
+func (b *Bus) readCycle(fc FunctionCode, s Size, address uint32) (uint32, error) {
 	dev := b.deviceForAddress(address)
 	if dev == nil {
 		return 0, BusError(address)
 	}
 
-	b.wait(s, address, dev)
+	b.wait(fc, false, s, address, dev)
+	if b.hasFunctionCodes {
+		if fcDev, ok := dev.(FunctionCodeDevice); ok {
+			return fcDev.ReadFC(fc, s, address)
+		}
+	}
 	return dev.Read(s, address)
 }
 
@@ -201,21 +410,38 @@ func (b *Bus) peekCycle(s Size, address uint32) (uint32, error) {
 	return peekDevice(dev, s, address)
 }
 
-func (b *Bus) writeCycle(s Size, address uint32, value uint32) error {
+func (b *Bus) pokeCycle(s Size, address uint32, value uint32) error {
 	dev := b.deviceForAddress(address)
 	if dev == nil {
 		return BusError(address)
 	}
 
-	b.wait(s, address, dev)
+	return pokeDevice(dev, s, address, value)
+}
+
+func (b *Bus) writeCycle(fc FunctionCode, s Size, address uint32, value uint32) error {
+	dev := b.deviceForAddress(address)
+	if dev == nil {
+		return BusError(address)
+	}
+
+	b.wait(fc, true, s, address, dev)
+	if b.hasFunctionCodes {
+		if fcDev, ok := dev.(FunctionCodeDevice); ok {
+			return fcDev.WriteFC(fc, s, address, value)
+		}
+	}
 	return dev.Write(s, address, value)
 }
 
 func (b *Bus) refreshTopology() {
 	b.singleDevice = nil
 	b.singleRAM = nil
-	b.fastRAM = nil
+	b.fastPages = nil
 	b.hasWaitStateDevices = false
+	b.hasVPADevices = false
+	b.hasRegisterNamers = false
+	b.hasFunctionCodes = false
 	b.hasPageMap = false
 	b.pageRanges = [256][]pageRange{}
 
@@ -230,6 +456,15 @@ func (b *Bus) refreshTopology() {
 		if _, ok := dev.(WaitStateDevice); ok {
 			b.hasWaitStateDevices = true
 		}
+		if _, ok := dev.(RegisterNamer); ok {
+			b.hasRegisterNamers = true
+		}
+		if usesVPA(dev) {
+			b.hasVPADevices = true
+		}
+		if usesFunctionCodes(dev) {
+			b.hasFunctionCodes = true
+		}
 		if ranged, ok := dev.(AddressRangeDevice); ok {
 			start, end := ranged.AddressRange()
 			start &= 0xffffff
@@ -251,15 +486,115 @@ func (b *Bus) refreshTopology() {
 		}
 	}
 
-	b.refreshFastRAM()
+	b.refreshFastPages()
 }
 
-func (b *Bus) refreshFastRAM() {
-	b.fastRAM = nil
-	if b.waitStates != 0 || b.hasWaitStateDevices {
+// refreshFastPages builds the page table the CPU uses to reach RAM and ROM
+// directly. Each page maps the part of the first RAM or ROM covering it that
+// no earlier, higher-priority device claims, so overlays and supervisor
+// guards keep working while ordinary memory traffic skips device dispatch.
+// Pages shared with I/O devices fall back to the bus for the I/O part only.
+// ROM pages are read-only; writes still reach the ROM's write policy.
+func (b *Bus) refreshFastPages() {
+	b.fastPages = nil
+	if b.waitStates != 0 {
 		return
 	}
-	b.fastRAM = b.singleRAM
+
+	var pages *[fastPageCount]fastPage
+	for i, dev := range b.devices {
+		var mem []byte
+		var offset uint32
+		writable := false
+		switch memory := dev.(type) {
+		case *RAM:
+			mem, offset, writable = memory.mem, memory.offset, true
+		case *ROM:
+			mem, offset = memory.mem, memory.offset
+		default:
+			if _, ok := dev.(AddressRangeDevice); !ok {
+				// Later devices may be shadowed anywhere by this one.
+				b.fastPages = pages
+				return
+			}
+			continue
+		}
+		if len(mem) == 0 {
+			continue
+		}
+
+		start, end := dev.(AddressRangeDevice).AddressRange()
+		if end > 0xffffff {
+			continue
+		}
+		for page := start >> fastPageShift; page <= end>>fastPageShift; page++ {
+			if pages != nil && pages[page].limit != 0 {
+				continue
+			}
+			pageStart := page << fastPageShift
+			lo, hi, ok := unclaimedRange(b.devices[:i], max(start, pageStart), min(end, pageStart|fastPageMask))
+			if !ok {
+				continue
+			}
+			if pages == nil {
+				pages = new([fastPageCount]fastPage)
+			}
+			pages[page] = fastPage{
+				mem:      mem[lo-offset : hi-offset+1],
+				start:    lo - pageStart,
+				limit:    hi - pageStart + 1,
+				writable: writable,
+			}
+		}
+	}
+	b.fastPages = pages
+}
+
+// unclaimedRange trims start-end to the largest contiguous part that none of
+// the earlier devices claims.
+func unclaimedRange(earlier []Device, start, end uint32) (uint32, uint32, bool) {
+	for _, dev := range earlier {
+		ranged, ok := dev.(AddressRangeDevice)
+		if !ok {
+			return 0, 0, false
+		}
+		lo, hi := ranged.AddressRange()
+		lo &= 0xffffff
+		hi &= 0xffffff
+		if hi < start || lo > end {
+			continue
+		}
+		switch {
+		case lo <= start && hi >= end:
+			return 0, 0, false
+		case lo <= start:
+			start = hi + 1
+		case hi >= end:
+			end = lo - 1
+		case lo-start >= end-hi:
+			end = lo - 1
+		default:
+			start = hi + 1
+		}
+	}
+	return start, end, true
+}
+
+// directPage returns the backing bytes of the fast page holding an access and
+// the access's index into them, or nil when the access must use the bus.
+// Misaligned accesses always use the bus so it can raise the address error.
+// A nil bus has no direct pages, so CPUs on custom buses can call it freely.
+func (b *Bus) directPage(address uint32, size Size, write bool) ([]byte, uint32) {
+	if b == nil || b.fastPages == nil || (size != Byte && address&1 != 0) {
+		return nil, 0
+	}
+	pages := b.fastPages
+	page := &pages[(address>>fastPageShift)&(fastPageCount-1)]
+	offset := address & fastPageMask
+	if offset < page.start || offset+uint32(size) > page.limit || (write && !page.writable) {
+		return nil, 0
+	}
+	return page.mem, offset - page.start
 }
 
 func (b *Bus) findDevice(address uint32) Device {
@@ -312,6 +647,22 @@ func MapDevice(start, end uint32, device Device) Device {
 	return mapped
 }
 
+// MapVPADevice maps start-end of device like MapDevice and marks the range as
+// VPA-driven, so every access pays the E clock synchronisation penalty.
+func MapVPADevice(start, end uint32, device Device) Device {
+	mapped := &MappedDevice{start: start & 0xffffff, end: end & 0xffffff, device: device, vpa: true}
+	if ws, ok := device.(WaitStateDevice); ok {
+		return &mappedWaitStateDevice{MappedDevice: mapped, waitStateDevice: ws}
+	}
+	return mapped
+}
+
+// SupervisorOnly maps start-end of device so that user-mode accesses end in
+// a bus error while supervisor accesses pass through.
+func SupervisorOnly(start, end uint32, device Device) *SupervisorRegion {
+	return &SupervisorRegion{MappedDevice{start: start & 0xffffff, end: end & 0xffffff, device: device}}
+}
+
 func (d *MappedDevice) AddressRange() (uint32, uint32) {
 	return d.start, d.end
 }
@@ -339,6 +690,13 @@ func (d *MappedDevice) Peek(size Size, address uint32) (uint32, error) {
 	return peekable.Peek(size, address)
 }
 
+func (d *MappedDevice) Poke(size Size, address uint32, value uint32) error {
+	if !d.containsAccess(size, address) {
+		return BusError(address & 0xffffff)
+	}
+	return pokeDevice(d.device, size, address, value)
+}
+
 func (d *MappedDevice) Write(size Size, address uint32, value uint32) error {
 	if !d.containsAccess(size, address) {
 		return BusError(address & 0xffffff)
@@ -346,6 +704,110 @@ func (d *MappedDevice) Write(size Size, address uint32, value uint32) error {
 	return d.device.Write(size, address, value)
 }
 
+func (d *MappedDevice) ReadFC(fc FunctionCode, size Size, address uint32) (uint32, error) {
+	if !d.containsAccess(size, address) {
+		return 0, BusError(address & 0xffffff)
+	}
+	if fcDev, ok := d.device.(FunctionCodeDevice); ok {
+		return fcDev.ReadFC(fc, size, address)
+	}
+	return d.device.Read(size, address)
+}
+
+func (d *MappedDevice) WriteFC(fc FunctionCode, size Size, address uint32, value uint32) error {
+	if !d.containsAccess(size, address) {
+		return BusError(address & 0xffffff)
+	}
+	if fcDev, ok := d.device.(FunctionCodeDevice); ok {
+		return fcDev.WriteFC(fc, size, address, value)
+	}
+	return d.device.Write(size, address, value)
+}
+
+func (d *SupervisorRegion) ReadFC(fc FunctionCode, size Size, address uint32) (uint32, error) {
+	if !fc.Supervisor() {
+		return 0, BusError(address & 0xffffff)
+	}
+	return d.MappedDevice.ReadFC(fc, size, address)
+}
+
+func (d *SupervisorRegion) WriteFC(fc FunctionCode, size Size, address uint32, value uint32) error {
+	if !fc.Supervisor() {
+		return BusError(address & 0xffffff)
+	}
+	return d.MappedDevice.WriteFC(fc, size, address, value)
+}
+
+// Supervisor reports whether the function code belongs to a supervisor access.
+func (fc FunctionCode) Supervisor() bool {
+	return fc&4 != 0
+}
+
+// Program reports whether the function code addresses program space.
+func (fc FunctionCode) Program() bool {
+	return fc&3 == 2
+}
+
+func (d *MappedDevice) ValidPeripheralAddress(address uint32) bool {
+	if !d.Contains(address) {
+		return false
+	}
+	if d.vpa {
+		return true
+	}
+	vpa, ok := d.device.(VPADevice)
+	return ok && vpa.ValidPeripheralAddress(address)
+}
+
+// PollStable forwards idle loop polling queries to the wrapped device.
+func (d *MappedDevice) PollStable(address uint32) bool {
+	if !d.Contains(address) {
+		return false
+	}
+	stable, ok := d.device.(PollStableDevice)
+	return ok && stable.PollStable(address)
+}
+
+// usesVPA reports whether a device takes part in synchronous 6800 cycles.
+func usesVPA(dev Device) bool {
+	switch mapped := dev.(type) {
+	case *MappedDevice:
+		return mapped.vpa || usesVPA(mapped.device)
+	case *mappedWaitStateDevice:
+		return mapped.vpa || usesVPA(mapped.device)
+	case *SupervisorRegion:
+		return usesVPA(mapped.device)
+	}
+	_, ok := dev.(VPADevice)
+	return ok
+}
+
+// usesFunctionCodes reports whether the bus has to pass function codes to a
+// device. Mapped wrappers only count when the wrapped device cares.
+func usesFunctionCodes(dev Device) bool {
+	switch mapped := dev.(type) {
+	case *SupervisorRegion:
+		return true
+	case *MappedDevice:
+		return usesFunctionCodes(mapped.device)
+	case *mappedWaitStateDevice:
+		return usesFunctionCodes(mapped.device)
+	}
+	_, ok := dev.(FunctionCodeDevice)
+	return ok
+}
+
+func (d *MappedDevice) RegisterName(address uint32) (string, bool) {
+	if !d.Contains(address) {
+		return "", false
+	}
+	namer, ok := d.device.(RegisterNamer)
+	if !ok {
+		return "", false
+	}
+	return namer.RegisterName(address)
+}
+
 func (d *MappedDevice) Reset() {
 	d.device.Reset()
 }
@@ -376,6 +838,14 @@ func peekDevice(dev Device, size Size, address uint32) (uint32, error) {
 	return peekable.Peek(size, address)
 }
 
+func pokeDevice(dev Device, size Size, address uint32, value uint32) error {
+	pokeable, ok := dev.(PokeDevice)
+	if !ok {
+		return fmt.Errorf("poke unsupported at %08x", address&0xffffff)
+	}
+	return pokeable.Poke(size, address, value)
+}
+
 func (b *Bus) addPageRange(page, start, end uint32, dev Device) {
 	if start > end {
 		return
@@ -203,3 +203,261 @@ func TestBusLongWriteReportsSecondCycleBusError(t *testing.T) {
 		t.Fatalf("first word after partial long write = %04x, want aabb", got)
 	}
 }
+
+type functionCodeRecorder struct {
+	*stubMappedDevice
+	codes []FunctionCode
+}
+
+func (d *functionCodeRecorder) ReadFC(fc FunctionCode, size Size, address uint32) (uint32, error) {
+	d.codes = append(d.codes, fc)
+	return d.Read(size, address)
+}
+
+func (d *functionCodeRecorder) WriteFC(fc FunctionCode, size Size, address uint32, value uint32) error {
+	d.codes = append(d.codes, fc)
+	return d.Write(size, address, value)
+}
+
+func TestBusPassesFunctionCodesToDevices(t *testing.T) {
+	recorder := &functionCodeRecorder{stubMappedDevice: newStubMappedDevice(0x4000, 0x4fff)}
+	bus := NewBus(MapDevice(0x4000, 0x4fff, recorder), NewRAM(0, 0x4000))
+
+	if _, err := bus.ReadFC(FunctionCodeUserProgram, Word, 0x4000); err != nil {
+		t.Fatalf("ReadFC failed: %v", err)
+	}
+	if err := bus.WriteFC(FunctionCodeUserData, Long, 0x4010, 0x12345678); err != nil {
+		t.Fatalf("WriteFC failed: %v", err)
+	}
+	if _, err := bus.Read(Byte, 0x4001); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	want := []FunctionCode{
+		FunctionCodeUserProgram,
+		FunctionCodeUserData, FunctionCodeUserData,
+		FunctionCodeSupervisorData,
+	}
+	if len(recorder.codes) != len(want) {
+		t.Fatalf("function codes = %v, want %v", recorder.codes, want)
+	}
+	for i := range want {
+		if recorder.codes[i] != want[i] {
+			t.Fatalf("function codes = %v, want %v", recorder.codes, want)
+		}
+	}
+}
+
+func TestSupervisorOnlyRejectsUserAccesses(t *testing.T) {
+	ram := NewRAM(0, 0x10000)
+	bus := NewBus(SupervisorOnly(0, 0x7ff, ram), ram)
+
+	if err := bus.WriteFC(FunctionCodeSupervisorData, Word, 0x0400, 0xbeef); err != nil {
+		t.Fatalf("supervisor write failed: %v", err)
+	}
+	if got, err := bus.ReadFC(FunctionCodeSupervisorData, Word, 0x0400); err != nil || got != 0xbeef {
+		t.Fatalf("supervisor read = (%x, %v), want (beef, nil)", got, err)
+	}
+
+	_, err := bus.ReadFC(FunctionCodeUserData, Word, 0x0400)
+	expectBusError(t, err)
+	expectBusError(t, bus.WriteFC(FunctionCodeUserData, Byte, 0x07ff, 0))
+
+	if err := bus.WriteFC(FunctionCodeUserData, Word, 0x0800, 0x1234); err != nil {
+		t.Fatalf("user write outside protected range failed: %v", err)
+	}
+	if got, err := bus.Peek(Word, 0x0400); err != nil || got != 0xbeef {
+		t.Fatalf("Peek = (%x, %v), want (beef, nil)", got, err)
+	}
+}
+
+func TestCPUUserModeAccessToSupervisorRegionRaisesBusError(t *testing.T) {
+	ram := NewRAM(0, 0x10000)
+	bus := NewBus(SupervisorOnly(0, 0x7ff, ram), ram)
+
+	if err := ram.Write(Long, 0, 0x1000); err != nil {
+		t.Fatalf("seed SSP: %v", err)
+	}
+	if err := ram.Write(Long, 4, 0x2000); err != nil {
+		t.Fatalf("seed PC: %v", err)
+	}
+	handler := uint32(0x3000)
+	if err := ram.Write(Long, uint32(XBusError<<2), handler); err != nil {
+		t.Fatalf("install bus error vector: %v", err)
+	}
+
+	processor, err := NewCPU(bus)
+	if err != nil {
+		t.Fatalf("NewCPU failed: %v", err)
+	}
+	cpu := processor.(*cpu)
+
+	code := assemble(t, "MOVE.W D0,$400.W")
+	for i, b := range code {
+		if err := ram.Write(Byte, 0x2000+uint32(i), uint32(b)); err != nil {
+			t.Fatalf("write code: %v", err)
+		}
+	}
+	cpu.regs.USP = 0x8000
+	cpu.setSR(0x0000)
+
+	if err := cpu.Step(); err != nil {
+		t.Fatalf("Step failed: %v", err)
+	}
+	if cpu.regs.PC != handler {
+		t.Fatalf("PC = %08x, want bus error handler %08x", cpu.regs.PC, handler)
+	}
+
+	frame := cpu.regs.SSP - group0ExceptionFrameSize
+	status, err := ram.Read(Word, frame)
+	if err != nil {
+		t.Fatalf("read status word: %v", err)
+	}
+	if FunctionCode(status&7) != FunctionCodeUserData {
+		t.Fatalf("stacked function code = %d, want %d", status&7, FunctionCodeUserData)
+	}
+}
+
+func TestBusVPADeviceChargesEClockSynchronisation(t *testing.T) {
+	acia := newStubMappedDevice(0xfffc00, 0xfffc07)
+	bus := NewBus(MapVPADevice(0xfffc00, 0xfffc07, acia), NewRAM(0, 0x1000))
+
+	var now uint64
+	var charged []uint32
+	bus.SetCycleCounter(func() uint64 { return now })
+	bus.SetWaitHook(func(states uint32) {
+		charged = append(charged, states)
+		now += uint64(states)
+	})
+
+	tests := []struct {
+		now  uint64
+		want uint32
+	}{
+		{now: 0, want: 6},
+		{now: 1, want: 15},
+		{now: 7, want: 9},
+		{now: 19, want: 7},
+	}
+	for _, tt := range tests {
+		now = tt.now
+		charged = charged[:0]
+		if _, err := bus.Read(Byte, 0xfffc00); err != nil {
+			t.Fatalf("VPA read failed: %v", err)
+		}
+		if len(charged) != 1 || charged[0] != tt.want {
+			t.Fatalf("penalty at cycle %d = %v, want [%d]", tt.now, charged, tt.want)
+		}
+	}
+
+	charged = charged[:0]
+	if _, err := bus.Read(Word, 0x0100); err != nil {
+		t.Fatalf("RAM read failed: %v", err)
+	}
+	if len(charged) != 0 {
+		t.Fatalf("RAM access charged %v, want nothing", charged)
+	}
+}
+
+func TestFastPagesMapRAMAndROMAroundDevices(t *testing.T) {
+	ram := NewRAM(0, 0x10000)
+	rom := NewROM(0xfc0000, make([]byte, 0x2000))
+	io := newResetWaitDevice(0x8000, 0x80ff, 2)
+	bus := NewBus(SupervisorOnly(0, 0x7ff, ram), io, ram, rom)
+
+	cases := []struct {
+		page     uint32
+		start    uint32
+		limit    uint32
+		writable bool
+	}{
+		{0x000, 0x800, 0x1000, true},
+		{0x001, 0x000, 0x1000, true},
+		{0x008, 0x100, 0x1000, true},
+		{0x010, 0x000, 0x0000, false},
+		{0xfc0, 0x000, 0x1000, false},
+		{0xfc1, 0x000, 0x1000, false},
+	}
+	for _, tc := range cases {
+		page := bus.fastPages[tc.page]
+		if page.start != tc.start || page.limit != tc.limit || page.writable != tc.writable {
+			t.Fatalf("page %03x = (%03x, %04x, %v), want (%03x, %04x, %v)",
+				tc.page, page.start, page.limit, page.writable, tc.start, tc.limit, tc.writable)
+		}
+	}
+
+	bus.SetWaitStates(1)
+	if bus.fastPages != nil {
+		t.Fatalf("global wait states left direct pages enabled")
+	}
+}
+
+func TestBusSetDevicesRebuildsFastPages(t *testing.T) {
+	mem := make([]byte, 0x2000)
+	bus := NewBus(NewRAM(0, 0x1000))
+	bus.SetDevices(NewRAMFrom(0x1000, mem))
+
+	if _, err := bus.Read(Word, 0); err == nil {
+		t.Fatalf("replaced device still answers")
+	}
+	if err := bus.Write(Word, 0x1002, 0xbeef); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if mem[2] != 0xbe || mem[3] != 0xef {
+		t.Fatalf("RAM does not alias its backing slice: % x", mem[:4])
+	}
+	if page := bus.fastPages[0x001]; page.limit != 0x1000 || !page.writable {
+		t.Fatalf("page 001 = (%03x, %04x, %v), want a writable full page", page.start, page.limit, page.writable)
+	}
+	if page := bus.fastPages[0x000]; page.limit != 0 {
+		t.Fatalf("page 000 still mapped after SetDevices")
+	}
+}
+
+func TestCPUUsesFastPagesWithoutBypassingDevices(t *testing.T) {
+	ram := NewRAM(0, 0x10000)
+	rom := NewROM(0xfc0000, make([]byte, 0x100))
+	io := newResetWaitDevice(0x8000, 0x80ff, 2)
+	bus := NewBus(io, ram, rom)
+	if err := ram.Write(Long, 0, 0x1000); err != nil {
+		t.Fatalf("seed SSP: %v", err)
+	}
+	if err := ram.Write(Long, 4, 0xfc0000); err != nil {
+		t.Fatalf("seed PC: %v", err)
+	}
+	if err := ram.Write(Long, uint32(XBusError<<2), 0x3000); err != nil {
+		t.Fatalf("install bus error vector: %v", err)
+	}
+	code := assemble(t, "MOVE.W #$1234,$8010\nMOVE.W #$5678,$8110\nMOVE.W $FC0000,D0\nMOVE.W D0,$FC0000\n")
+	for i, b := range code {
+		if err := rom.Poke(Byte, 0xfc0000+uint32(i), uint32(b)); err != nil {
+			t.Fatalf("load ROM: %v", err)
+		}
+	}
+
+	processor, err := NewCPU(bus)
+	if err != nil {
+		t.Fatalf("NewCPU failed: %v", err)
+	}
+	for range 3 {
+		if err := processor.Step(); err != nil {
+			t.Fatalf("step failed: %v", err)
+		}
+	}
+	if got := io.data[0x8010]; got != 0x1234 {
+		t.Fatalf("I/O write = %04x, want 1234", got)
+	}
+	if got, _ := ram.Read(Word, 0x8110); got != 0x5678 {
+		t.Fatalf("RAM write next to I/O = %04x, want 5678", got)
+	}
+	if got := processor.Registers().D[0] & 0xffff; got != int32(code[0])<<8|int32(code[1]) {
+		t.Fatalf("ROM read = %04x, want %02x%02x", got, code[0], code[1])
+	}
+
+	if err := processor.Step(); err != nil {
+		t.Fatalf("step failed: %v", err)
+	}
+	if pc := processor.Registers().PC; pc != 0x3000 {
+		t.Fatalf("ROM write did not reach the write policy, PC = %08x", pc)
+	}
+}
@@ -0,0 +1,142 @@
+package m68kemu
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// ClockDomain is a clock derived from the CPU clock by an exact rational
+// ratio, such as an MFP timer clock or a sound chip clock. Its ticks count
+// from CPU cycle 0 and every conversion is computed from absolute times, so
+// periodic schedules never accumulate rounding drift.
+type ClockDomain struct {
+	name      string
+	scheduler *CycleScheduler
+	frequency uint64
+	// A domain runs ticks ticks for every cycles CPU cycles, reduced to
+	// lowest terms.
+	ticks  uint64
+	cycles uint64
+}
+
+// ClockListener is notified when a clock domain's tick count changes.
+type ClockListener interface {
+	AdvanceTicks(delta uint64, now uint64)
+}
+
+// clockListener adapts a ClockListener to the scheduler's cycle listeners.
+type clockListener struct {
+	domain   *ClockDomain
+	listener ClockListener
+	last     uint64
+}
+
+// NewClockDomain creates a clock running at frequency Hz alongside a CPU
+// clocked at cpuFrequency Hz.
+func (s *CycleScheduler) NewClockDomain(name string, frequency, cpuFrequency uint64) (*ClockDomain, error) {
+	if s == nil {
+		return nil, fmt.Errorf("clock domain %q: nil scheduler", name)
+	}
+	if frequency == 0 || cpuFrequency == 0 {
+		return nil, fmt.Errorf("clock domain %q: frequencies must be non-zero, got %d Hz and CPU %d Hz", name, frequency, cpuFrequency)
+	}
+	divisor := gcd(frequency, cpuFrequency)
+	return &ClockDomain{
+		name:      name,
+		scheduler: s,
+		frequency: frequency,
+		ticks:     frequency / divisor,
+		cycles:    cpuFrequency / divisor,
+	}, nil
+}
+
+// Name returns the name the domain was created with.
+func (d *ClockDomain) Name() string {
+	return d.name
+}
+
+// Frequency returns the domain's clock rate in Hz.
+func (d *ClockDomain) Frequency() uint64 {
+	return d.frequency
+}
+
+// Now returns the number of whole ticks elapsed at the scheduler's current
+// cycle. It is up to date inside CycleListener callbacks and scheduled events.
+func (d *ClockDomain) Now() uint64 {
+	return d.TicksAt(d.scheduler.Now())
+}
+
+// TicksAt returns the number of whole ticks elapsed at CPU cycle cycle.
+func (d *ClockDomain) TicksAt(cycle uint64) uint64 {
+	return mulDiv(cycle, d.ticks, d.cycles, false)
+}
+
+// CycleOf returns the first CPU cycle at which tick has elapsed.
+func (d *ClockDomain) CycleOf(tick uint64) uint64 {
+	return mulDiv(tick, d.cycles, d.ticks, true)
+}
+
+// Schedule queues fn to run at the first CPU cycle at which tick has elapsed.
+// fn receives the tick it was scheduled for.
+func (d *ClockDomain) Schedule(tick uint64, fn func(tick uint64)) EventHandle {
+	return d.ScheduleNamed("", tick, fn)
+}
+
+// ScheduleNamed is Schedule with an event name, see CycleScheduler.ScheduleNamed.
+func (d *ClockDomain) ScheduleNamed(name string, tick uint64, fn func(tick uint64)) EventHandle {
+	if fn == nil {
+		return EventHandle{}
+	}
+	return d.scheduler.ScheduleNamed(name, d.CycleOf(tick), func(uint64) { fn(tick) })
+}
+
+// ScheduleAfter queues fn delta ticks after the current tick.
+func (d *ClockDomain) ScheduleAfter(delta uint64, fn func(tick uint64)) EventHandle {
+	return d.Schedule(d.Now()+delta, fn)
+}
+
+// AddListener calls listener whenever CPU time crosses one or more of the
+// domain's ticks.
+func (d *ClockDomain) AddListener(listener ClockListener) {
+	if listener == nil {
+		return
+	}
+	d.scheduler.AddListener(&clockListener{domain: d, listener: listener, last: d.Now()})
+}
+
+func (l *clockListener) AdvanceCycles(_ uint64, now uint64) {
+	ticks := l.domain.TicksAt(now)
+	if ticks <= l.last {
+		// No tick elapsed, or the scheduler was reset to an earlier time.
+		l.last = ticks
+		return
+	}
+	delta := ticks - l.last
+	l.last = ticks
+	l.listener.AdvanceTicks(delta, ticks)
+}
+
+// mulDiv returns a*b/c rounded down or up, saturating when the result does
+// not fit in 64 bits.
+func mulDiv(a, b, c uint64, roundUp bool) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	if hi >= c {
+		return math.MaxUint64
+	}
+	quotient, remainder := bits.Div64(hi, lo, c)
+	if roundUp && remainder != 0 {
+		if quotient == math.MaxUint64 {
+			return quotient
+		}
+		quotient++
+	}
+	return quotient
+}
+
+func gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
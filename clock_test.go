@@ -0,0 +1,113 @@
+package m68kemu
+
+import (
+	"math"
+	"testing"
+)
+
+const stCPUFrequency = 8_000_000
+
+type tickCountingListener struct {
+	calls int
+	delta uint64
+	now   uint64
+}
+
+func (l *tickCountingListener) AdvanceTicks(delta uint64, now uint64) {
+	l.calls++
+	l.delta += delta
+	l.now = now
+}
+
+func TestClockDomainConvertsWithExactRatios(t *testing.T) {
+	scheduler := NewCycleScheduler()
+	tests := []struct {
+		name      string
+		frequency uint64
+		cycle     uint64
+		ticks     uint64
+	}{
+		{name: "MFP", frequency: 2_457_600, cycle: stCPUFrequency, ticks: 2_457_600},
+		{name: "YM2149", frequency: 2_000_000, cycle: 7, ticks: 1},
+		{name: "EClock", frequency: 800_000, cycle: 19, ticks: 1},
+		{name: "Video", frequency: 32_000_000, cycle: 3, ticks: 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domain, err := scheduler.NewClockDomain(tt.name, tt.frequency, stCPUFrequency)
+			if err != nil {
+				t.Fatalf("NewClockDomain failed: %v", err)
+			}
+			if got := domain.TicksAt(tt.cycle); got != tt.ticks {
+				t.Fatalf("TicksAt(%d) = %d, want %d", tt.cycle, got, tt.ticks)
+			}
+			for tick := range uint64(1000) {
+				cycle := domain.CycleOf(tick)
+				if domain.TicksAt(cycle) < tick || (cycle != 0 && domain.TicksAt(cycle-1) >= tick) {
+					t.Fatalf("CycleOf(%d) = %d is not the first cycle reaching the tick", tick, cycle)
+				}
+			}
+		})
+	}
+
+	if _, err := scheduler.NewClockDomain("broken", 0, stCPUFrequency); err == nil {
+		t.Fatalf("NewClockDomain accepted a zero frequency")
+	}
+	domain, _ := scheduler.NewClockDomain("video", 32_000_000, stCPUFrequency)
+	if got := domain.TicksAt(math.MaxUint64); got != math.MaxUint64 {
+		t.Fatalf("TicksAt overflow = %d, want saturation", got)
+	}
+}
+
+func TestClockDomainPeriodicEventsDoNotDrift(t *testing.T) {
+	scheduler := NewCycleScheduler()
+	mfp, err := scheduler.NewClockDomain("MFP", 2_457_600, stCPUFrequency)
+	if err != nil {
+		t.Fatalf("NewClockDomain failed: %v", err)
+	}
+
+	// A timer with a prescaler of 64 and a count of 192 interrupts at 200 Hz.
+	const period = 64 * 192
+	fired := 0
+	var tick func(uint64)
+	tick = func(at uint64) {
+		fired++
+		if now := scheduler.Now(); now != mfp.CycleOf(at) || mfp.Now() != at {
+			t.Fatalf("event for tick %d fired at cycle %d (tick %d), want cycle %d", at, now, mfp.Now(), mfp.CycleOf(at))
+		}
+		mfp.Schedule(at+period, tick)
+	}
+	mfp.ScheduleAfter(period, tick)
+
+	for range 1000 {
+		scheduler.Advance(stCPUFrequency / 1000)
+	}
+	if fired != 200 {
+		t.Fatalf("timer fired %d times in one second, want 200", fired)
+	}
+	if mfp.Now() != 2_457_600 {
+		t.Fatalf("MFP clock = %d ticks after one second, want 2457600", mfp.Now())
+	}
+}
+
+func TestClockDomainListenerSeesDomainTime(t *testing.T) {
+	scheduler := NewCycleScheduler()
+	eclock, err := scheduler.NewClockDomain("E", 800_000, stCPUFrequency)
+	if err != nil {
+		t.Fatalf("NewClockDomain failed: %v", err)
+	}
+	listener := &tickCountingListener{}
+	eclock.AddListener(listener)
+
+	for range 25 {
+		scheduler.Advance(4)
+	}
+	if listener.delta != 10 || listener.now != 10 || listener.calls != 10 {
+		t.Fatalf("listener saw %d calls, delta %d, now %d, want 10/10/10", listener.calls, listener.delta, listener.now)
+	}
+
+	scheduler.Advance(95)
+	if listener.delta != 19 || listener.now != 19 || listener.calls != 11 {
+		t.Fatalf("listener saw %d calls, delta %d, now %d, want 11/19/19", listener.calls, listener.delta, listener.now)
+	}
+}
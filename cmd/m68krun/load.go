@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	m68kemu "github.com/jenska/m68kemu"
+)
+
+const (
+	prgMagic  = 0x601a
+	hunkMagic = 0x000003f3
+)
+
+// detectFormat guesses the program format from its first bytes and, for
+// the text formats, its file name.
+func detectFormat(name string, data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte(elf.ELFMAG)):
+		return "elf"
+	case len(data) >= 28 && binary.BigEndian.Uint16(data) == prgMagic:
+		return "prg"
+	case len(data) >= 4 && binary.BigEndian.Uint32(data) == hunkMagic:
+		return "hunk"
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".s19", ".s28", ".s37", ".srec", ".mot":
+		return "srec"
+	case ".hex", ".ihex":
+		return "ihex"
+	}
+	return "raw"
+}
+
+// loadImage stores a program that runs directly on the bus and points the
+// CPU at its entry in supervisor mode.
+func (m *machine) loadImage(format string, data []byte) error {
+	var entry uint32
+	switch format {
+	case "raw":
+		if err := m.store(m.cfg.load, data); err != nil {
+			return err
+		}
+		entry = m.cfg.load
+	case "srec", "ihex":
+		load := m68kemu.LoadSRecord
+		if format == "ihex" {
+			load = m68kemu.LoadIntelHex
+		}
+		info, err := load(m.bus, bytes.NewReader(data), m68kemu.ImageOptions{})
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.HasEntry:
+			entry = info.Entry
+		case len(info.Segments) != 0:
+			entry = info.Segments[0].Start
+		}
+	case "elf":
+		var err error
+		if entry, err = m.loadELF(data); err != nil {
+			return err
+		}
+	case "hunk":
+		program, err := m68kemu.LoadHunk(m.bus, bytes.NewReader(data), m68kemu.HunkLoadOptions{
+			Region: m68kemu.AddressRange{Start: m.cfg.load, End: m.regionEnd(m.cfg.load)},
+		})
+		if err != nil {
+			return err
+		}
+		entry = program.Entry
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	if m.cfg.reset {
+		return m.cpu.Reset()
+	}
+	if m.cfg.entry.set {
+		entry = m.cfg.entry.value
+	}
+	stack := m.cfg.stack.value
+	if !m.cfg.stack.set {
+		stack = (m.cfg.ram[0].start + m.cfg.ram[0].size) &^ 1
+	}
+	var regs m68kemu.Registers
+	regs.SR = 0x2700
+	regs.A[7] = stack
+	regs.SSP = stack
+	regs.PC = entry
+	m.cpu.SetRegisters(regs)
+	return nil
+}
+
+// loadELF stores the loadable segments of a big-endian 68k ELF executable at
+// their physical addresses and clears the rest of each segment's memory.
+func (m *machine) loadELF(data []byte) (uint32, error) {
+	file, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	if file.Class != elf.ELFCLASS32 || file.Data != elf.ELFDATA2MSB || file.Machine != elf.EM_68K {
+		return 0, fmt.Errorf("not a 68k ELF executable: %v %v %v", file.Class, file.Data, file.Machine)
+	}
+	for _, segment := range file.Progs {
+		if segment.Type != elf.PT_LOAD || segment.Memsz == 0 {
+			continue
+		}
+		contents, err := io.ReadAll(segment.Open())
+		if err != nil {
+			return 0, fmt.Errorf("read ELF segment at %08x: %w", segment.Paddr, err)
+		}
+		address := uint32(segment.Paddr)
+		if err := m.store(address, contents); err != nil {
+			return 0, err
+		}
+		if bss := segment.Memsz - segment.Filesz; bss > 0 {
+			if err := m.store(address+uint32(segment.Filesz), make([]byte, bss)); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return uint32(file.Entry), nil
+}
+
+// store pokes data onto the bus, so it also lands in ROM.
+func (m *machine) store(address uint32, data []byte) error {
+	for i, b := range data {
+		if err := m.bus.Poke(m68kemu.Byte, address+uint32(i), uint32(b)); err != nil {
+			return fmt.Errorf("store %08x: %w", address+uint32(i), err)
+		}
+	}
+	return nil
+}
+
+// regionEnd returns the last address of the RAM region containing address.
+func (m *machine) regionEnd(address uint32) uint32 {
+	for _, r := range m.cfg.ram {
+		if address >= r.start && address-r.start < r.size {
+			return r.start + r.size - 1
+		}
+	}
+	return address
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	m68kemu "github.com/jenska/m68kemu"
+	"github.com/jenska/m68kemu/tos"
+)
+
+const (
+	// The run slices bound how long the CPU runs between limit checks.
+	runSliceCycles       = 1_000_000
+	runSliceInstructions = 100_000
+)
+
+// machine is the bus, CPU, and exit hooks a program runs on.
+type machine struct {
+	cfg config
+	bus *m68kemu.Bus
+	cpu m68kemu.CPU
+	tos *tos.System
+
+	lastException m68kemu.ExceptionInfo
+	hasException  bool
+}
+
+// exitRequest stops the CPU when the program writes to the exit address.
+type exitRequest struct {
+	code int
+}
+
+func (e exitRequest) Error() string {
+	return fmt.Sprintf("exit address written with %d", e.code)
+}
+
+// exitDevice claims the exit address. Any write to it ends the run.
+type exitDevice struct {
+	address uint32
+}
+
+func (d *exitDevice) Contains(address uint32) bool {
+	return address&^3 == d.address&^3
+}
+
+func (d *exitDevice) AddressRange() (uint32, uint32) {
+	return d.address &^ 3, d.address&^3 + 3
+}
+
+func (d *exitDevice) Read(m68kemu.Size, uint32) (uint32, error) {
+	return 0, nil
+}
+
+func (d *exitDevice) Write(size m68kemu.Size, _ uint32, value uint32) error {
+	switch size {
+	case m68kemu.Byte:
+		return exitRequest{code: int(int8(value))}
+	case m68kemu.Word:
+		return exitRequest{code: int(int16(value))}
+	default:
+		return exitRequest{code: int(int32(value))}
+	}
+}
+
+func (d *exitDevice) Reset() {}
+
+func newMachine(cfg config) (*machine, error) {
+	m := &machine{cfg: cfg}
+
+	var devices []m68kemu.Device
+	if cfg.exit.set {
+		devices = append(devices, &exitDevice{address: cfg.exit.value})
+	}
+	for _, image := range cfg.roms {
+		rom, err := m68kemu.LoadROM(image.start, image.path)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, rom)
+	}
+	for _, r := range cfg.ram {
+		devices = append(devices, m68kemu.NewRAM(r.start, r.size))
+	}
+	m.bus = m68kemu.NewBus(devices...)
+
+	cpu, err := m68kemu.NewCPU(m.bus)
+	if err != nil {
+		return nil, fmt.Errorf("create CPU: %w", err)
+	}
+	m.cpu = cpu
+
+	file, err := os.ReadFile(cfg.program)
+	if err != nil {
+		return nil, err
+	}
+	format := cfg.format
+	if format == "auto" {
+		format = detectFormat(cfg.program, file)
+	}
+	if format == "prg" {
+		if err := m.loadTOS(file); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := m.loadImage(format, file); err != nil {
+			return nil, err
+		}
+	}
+
+	if !cfg.noNF {
+		cpu.SetNatFeats(m68kemu.NewNatFeats(m68kemu.NatFeatsOptions{Name: "m68krun", Stderr: cfg.stderr}))
+	}
+	if cfg.trace || cfg.traceRegs {
+		logger := m68kemu.NewVerboseLogger(cpu, m.bus, cfg.stderr, m68kemu.VerboseLoggerOptions{
+			IncludeRegisters: cfg.traceRegs,
+			IncludeCycles:    true,
+		})
+		cpu.SetTracer(logger.Trace)
+	}
+
+	// An exception through an unset vector jumps to address 0, which holds
+	// the reset stack pointer and never code. Stop there and report the
+	// exception that led to it. The breakpoint is armed only then, since any
+	// breakpoint turns off the fast execution paths.
+	cpu.SetExceptionTracer(func(info m68kemu.ExceptionInfo) {
+		m.lastException, m.hasException = info, true
+		if info.NewPC == 0 {
+			cpu.AddBreakpoint(m68kemu.Breakpoint{Address: 0, OnExecute: true, Halt: true})
+		}
+	})
+	return m, nil
+}
+
+// loadTOS runs a GEMDOS executable on the tos package, with its transient
+// program area filling the RAM region at address 0.
+func (m *machine) loadTOS(file []byte) error {
+	top := uint32(0)
+	for _, r := range m.cfg.ram {
+		if r.start == 0 {
+			top = r.size
+		}
+	}
+	if top <= tos.DefaultTPAStart {
+		return errors.New("GEMDOS programs need a RAM region at address 0")
+	}
+	m.tos = tos.New(m.cpu, m.bus, tos.Options{
+		Root:      m.cfg.root,
+		Stdin:     os.Stdin,
+		Stdout:    m.cfg.stdout,
+		Stderr:    m.cfg.stderr,
+		MemoryTop: top,
+	})
+	_, err := m.tos.Load(bytes.NewReader(file), m.cfg.args...)
+	return err
+}
+
+// run executes the program until it exits, crashes, or hits a limit, and
+// returns the process exit code.
+func (m *machine) run() int {
+	var deadline time.Time
+	if m.cfg.timeout > 0 {
+		deadline = time.Now().Add(m.cfg.timeout)
+	}
+	startCycles := m.cpu.Cycles()
+	var instructions uint64
+
+	for {
+		ranCycles := m.cpu.Cycles() - startCycles
+		switch {
+		case m.cfg.maxCycles != 0 && ranCycles >= m.cfg.maxCycles:
+			return m.limit(fmt.Sprintf("cycle limit of %d reached", m.cfg.maxCycles))
+		case m.cfg.maxInstructions != 0 && instructions >= m.cfg.maxInstructions:
+			return m.limit(fmt.Sprintf("instruction limit of %d reached", m.cfg.maxInstructions))
+		case !deadline.IsZero() && time.Now().After(deadline):
+			return m.limit(fmt.Sprintf("timeout of %v reached", m.cfg.timeout))
+		}
+
+		var err error
+		if m.cfg.maxInstructions != 0 {
+			var result m68kemu.RunResult
+			result, err = m.cpu.RunUntil(m68kemu.RunUntilOptions{
+				MaxInstructions: min(runSliceInstructions, m.cfg.maxInstructions-instructions),
+			})
+			instructions += result.Instructions
+		} else {
+			budget := uint64(runSliceCycles)
+			if m.cfg.maxCycles != 0 {
+				budget = min(budget, m.cfg.maxCycles-ranCycles)
+			}
+			err = m.cpu.RunCycles(budget)
+		}
+		if err != nil {
+			return m.stop(err)
+		}
+	}
+}
+
+// stop turns the error that ended execution into an exit code.
+func (m *machine) stop(err error) int {
+	var nfExit m68kemu.NatFeatExit
+	var request exitRequest
+	var hit m68kemu.BreakpointHit
+	switch {
+	case errors.As(err, &nfExit):
+		return m.exitWith(nfExit.Code)
+	case errors.As(err, &request):
+		return m.exitWith(request.code)
+	case m.tos != nil && m.tos.Terminated():
+		return m.exitWith(m.tos.ExitCode())
+	case errors.As(err, &hit) && hit.Address == 0 && m.hasException:
+		m.crashReport(fmt.Sprintf("unhandled %s", vectorName(m.lastException.Vector)))
+	default:
+		m.crashReport(err.Error())
+	}
+	return exitFailure
+}
+
+func (m *machine) exitWith(code int) int {
+	if m.cfg.dumpRegs {
+		m.dumpRegisters()
+	}
+	return code & 0xff
+}
+
+func (m *machine) limit(reason string) int {
+	fmt.Fprintf(m.cfg.stderr, "m68krun: %s at PC %06x\n", reason, m.cpu.Registers().PC)
+	if m.cfg.dumpRegs {
+		m.dumpRegisters()
+	}
+	return exitLimit
+}
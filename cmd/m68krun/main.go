@@ -0,0 +1,234 @@
+// Command m68krun loads a 68000 program into a configurable memory map, runs
+// it headless, and exits with the program's exit code. It is meant for
+// running guest test binaries from Makefiles and CI jobs.
+//
+// Usage:
+//
+//	m68krun [flags] program [args...]
+//
+// The program may be a raw binary, a Motorola S-record, an Intel HEX file, an
+// ELF executable, an AmigaOS hunk executable, or a GEMDOS .PRG/.TOS/.TTP
+// executable. GEMDOS executables run on top of the tos package and receive
+// args as their command line.
+//
+// A guest ends the run through NatFeats (NF_EXIT or NF_SHUTDOWN), by writing
+// its exit code to the -exit-addr address, or, for GEMDOS programs, with
+// Pterm. m68krun exits with 124 when a limit stops the program and with 125
+// when the program crashes or cannot be loaded.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	exitLimit   = 124
+	exitFailure = 125
+	exitUsage   = 2
+
+	defaultRAMSize     = 0x100000
+	defaultLoadAddress = 0x1000
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// config holds the parsed command line.
+type config struct {
+	program string
+	args    []string
+	format  string
+
+	ram    []region
+	roms   []romImage
+	load   uint32
+	entry  optionalAddress
+	stack  optionalAddress
+	reset  bool
+	exit   optionalAddress
+	root   string
+	noNF   bool
+	stdout io.Writer
+	stderr io.Writer
+
+	maxInstructions uint64
+	maxCycles       uint64
+	timeout         time.Duration
+
+	trace     bool
+	traceRegs bool
+	dumpRegs  bool
+}
+
+// region is a RAM range given as START:SIZE.
+type region struct {
+	start, size uint32
+}
+
+// romImage is a ROM file mapped at START, given as START:FILE.
+type romImage struct {
+	start uint32
+	path  string
+}
+
+// optionalAddress is an address flag that records whether it was set.
+type optionalAddress struct {
+	value uint32
+	set   bool
+}
+
+func (a *optionalAddress) String() string {
+	if !a.set {
+		return ""
+	}
+	return fmt.Sprintf("0x%x", a.value)
+}
+
+func (a *optionalAddress) Set(text string) error {
+	value, err := parseAddress(text)
+	if err != nil {
+		return err
+	}
+	a.value, a.set = value, true
+	return nil
+}
+
+type regionList []region
+
+func (l *regionList) String() string {
+	parts := make([]string, len(*l))
+	for i, r := range *l {
+		parts[i] = fmt.Sprintf("0x%x:0x%x", r.start, r.size)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *regionList) Set(text string) error {
+	startText, sizeText, ok := strings.Cut(text, ":")
+	if !ok {
+		return fmt.Errorf("want START:SIZE, got %q", text)
+	}
+	start, err := parseAddress(startText)
+	if err != nil {
+		return err
+	}
+	size, err := parseAddress(sizeText)
+	if err != nil {
+		return err
+	}
+	if size == 0 || uint64(start)+uint64(size) > 1<<24 {
+		return fmt.Errorf("RAM %q must be non-empty and inside the 24-bit address space", text)
+	}
+	*l = append(*l, region{start: start, size: size})
+	return nil
+}
+
+type romList []romImage
+
+func (l *romList) String() string {
+	parts := make([]string, len(*l))
+	for i, r := range *l {
+		parts[i] = fmt.Sprintf("0x%x:%s", r.start, r.path)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *romList) Set(text string) error {
+	startText, path, ok := strings.Cut(text, ":")
+	if !ok || path == "" {
+		return fmt.Errorf("want START:FILE, got %q", text)
+	}
+	start, err := parseAddress(startText)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, romImage{start: start, path: path})
+	return nil
+}
+
+// parseAddress accepts decimal, 0x-prefixed, and $-prefixed numbers.
+func parseAddress(text string) (uint32, error) {
+	text = strings.TrimSpace(text)
+	if rest, ok := strings.CutPrefix(text, "$"); ok {
+		text = "0x" + rest
+	}
+	value, err := strconv.ParseUint(text, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address %q", text)
+	}
+	return uint32(value), nil
+}
+
+func parseFlags(args []string, stderr io.Writer) (config, error) {
+	var cfg config
+	var ram regionList
+	var roms romList
+
+	flags := flag.NewFlagSet("m68krun", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: m68krun [flags] program [args...]")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&cfg.format, "format", "auto", "program format: auto, raw, srec, ihex, elf, hunk, or prg")
+	flags.Var(&ram, "ram", "RAM region START:SIZE, repeatable (default 0:0x100000)")
+	flags.Var(&roms, "rom", "ROM image START:FILE, repeatable")
+	load := optionalAddress{value: defaultLoadAddress}
+	flags.Var(&load, "load", "load address of raw binaries and base of hunk executables (default 0x1000)")
+	flags.Var(&cfg.entry, "entry", "start address, overriding the program's entry point")
+	flags.Var(&cfg.stack, "sp", "initial supervisor stack pointer (default end of the first RAM region)")
+	flags.BoolVar(&cfg.reset, "reset", false, "take the stack pointer and start address from the reset vectors at 0 and 4")
+	flags.Var(&cfg.exit, "exit-addr", "a write to this address ends the run with the written value as exit code")
+	flags.StringVar(&cfg.root, "root", ".", "host directory that GEMDOS programs see as drive C:")
+	flags.BoolVar(&cfg.noNF, "no-natfeats", false, "disable the NatFeats opcodes")
+	flags.Uint64Var(&cfg.maxInstructions, "max-instructions", 0, "stop after this many instructions (0 = no limit)")
+	flags.Uint64Var(&cfg.maxCycles, "max-cycles", 0, "stop after this many CPU cycles (0 = no limit)")
+	flags.DurationVar(&cfg.timeout, "timeout", 0, "stop after this much wall-clock time (0 = no limit)")
+	flags.BoolVar(&cfg.trace, "trace", false, "trace every instruction to stderr")
+	flags.BoolVar(&cfg.traceRegs, "trace-regs", false, "include registers in the trace")
+	flags.BoolVar(&cfg.dumpRegs, "regs", false, "dump the registers to stderr when the program ends")
+
+	if err := flags.Parse(args); err != nil {
+		return config{}, err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return config{}, errors.New("missing program")
+	}
+	cfg.program = flags.Arg(0)
+	cfg.args = flags.Args()[1:]
+	cfg.load = load.value
+	cfg.ram = ram
+	if len(cfg.ram) == 0 {
+		cfg.ram = []region{{start: 0, size: defaultRAMSize}}
+	}
+	cfg.roms = roms
+	return cfg, nil
+}
+
+// run is main without the process exit, so tests can call it.
+func run(args []string, stdout, stderr io.Writer) int {
+	cfg, err := parseFlags(args, stderr)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+	cfg.stdout, cfg.stderr = stdout, stderr
+
+	machine, err := newMachine(cfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "m68krun: %v\n", err)
+		return exitFailure
+	}
+	return machine.run()
+}
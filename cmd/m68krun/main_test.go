@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	asm "github.com/jenska/m68kasm"
+)
+
+// natFeatExit calls NF_EXIT with the code in D0.
+const natFeatExit = `
+        MOVE.L  D0,-(A7)
+        LEA     nfexit(PC),A0
+        MOVE.L  A0,-(A7)
+        BSR     nf_id
+        ADDQ.L  #4,A7
+        MOVE.L  D0,-(A7)
+        BSR     nf_call
+nf_id:  DC.W    $7300
+        RTS
+nf_call:
+        DC.W    $7301
+        RTS
+nfexit: DC.B    110,102,95,101,120,105,116,0
+`
+
+func assembleFile(tb testing.TB, name, source string) string {
+	tb.Helper()
+	code, _, err := asm.AssembleStringWithListing(source)
+	if err != nil {
+		tb.Fatalf("Assembler failed: %v", err)
+	}
+	path := filepath.Join(tb.TempDir(), name)
+	if err := os.WriteFile(path, code, 0o644); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+func runCommand(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRunRawBinaryExitsThroughNatFeats(t *testing.T) {
+	program := assembleFile(t, "exit.bin", "MOVEQ #7,D0\n"+natFeatExit)
+
+	code, _, stderr := runCommand("-load", "0x2000", "-regs", program)
+	if code != 7 {
+		t.Fatalf("exit code = %d, want 7 (stderr %q)", code, stderr)
+	}
+	if !strings.Contains(stderr, "SSP 00100000") {
+		t.Fatalf("register dump = %q, want the stack at the end of the default RAM", stderr)
+	}
+
+	if code, _, _ := runCommand("-no-natfeats", program); code != exitFailure {
+		t.Fatalf("exit code without NatFeats = %d, want a crash", code)
+	}
+}
+
+func TestRunExitAddress(t *testing.T) {
+	program := assembleFile(t, "exit.bin", "MOVE.W #3,$F00000\nloop: BRA.S loop\n")
+
+	if code, _, stderr := runCommand("-exit-addr", "$F00000", program); code != 3 {
+		t.Fatalf("exit code = %d, want 3 (stderr %q)", code, stderr)
+	}
+	if start, end := (&exitDevice{address: 0xf00002}).AddressRange(); start != 0xf00000 || end != 0xf00003 {
+		t.Fatalf("exit device claims %06x-%06x, want the longword at f00000", start, end)
+	}
+}
+
+func TestRunReportsCrashesAndLimits(t *testing.T) {
+	crash := assembleFile(t, "crash.bin", "NOP\nDC.W $4AFC\n")
+	code, _, stderr := runCommand(crash)
+	if code != exitFailure || !strings.Contains(stderr, "unhandled illegal instruction (vector 4)") {
+		t.Fatalf("crash: exit code = %d, stderr = %q, want an illegal instruction report", code, stderr)
+	}
+	if !strings.Contains(stderr, "exception at PC 001002") {
+		t.Fatalf("crash report = %q, want the faulting PC", stderr)
+	}
+
+	odd := assembleFile(t, "odd.bin", "MOVE.W $1001,D0\n")
+	if code, _, stderr := runCommand(odd); code != exitFailure || !strings.Contains(stderr, "unhandled address error") {
+		t.Fatalf("address error: exit code = %d, stderr = %q, want an address error report", code, stderr)
+	}
+
+	loop := assembleFile(t, "loop.bin", "loop: BRA.S loop\n")
+	if code, _, stderr := runCommand("-max-instructions", "1000", loop); code != exitLimit || !strings.Contains(stderr, "instruction limit") {
+		t.Fatalf("instruction limit: exit code = %d, stderr = %q", code, stderr)
+	}
+	if code, _, stderr := runCommand("-max-cycles", "5000", loop); code != exitLimit || !strings.Contains(stderr, "cycle limit") {
+		t.Fatalf("cycle limit: exit code = %d, stderr = %q", code, stderr)
+	}
+}
+
+func TestRunLoadsELFExecutables(t *testing.T) {
+	code, _, err := asm.AssembleStringWithListing("MOVEQ #9,D0\n" + natFeatExit)
+	if err != nil {
+		t.Fatalf("Assembler failed: %v", err)
+	}
+	const entry = 0x4000
+	var image bytes.Buffer
+	image.Write([]byte{0x7f, 'E', 'L', 'F', 1, 2, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	header := []any{
+		uint16(2), uint16(4), uint32(1), uint32(entry), uint32(52), uint32(0), uint32(0),
+		uint16(52), uint16(32), uint16(1), uint16(40), uint16(0), uint16(0),
+		// PT_LOAD with 16 bytes of BSS after the code.
+		uint32(1), uint32(84), uint32(entry), uint32(entry), uint32(len(code)), uint32(len(code) + 16), uint32(5), uint32(2),
+	}
+	for _, field := range header {
+		_ = binary.Write(&image, binary.BigEndian, field)
+	}
+	image.Write(code)
+	path := filepath.Join(t.TempDir(), "prog.elf")
+	if err := os.WriteFile(path, image.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if exit, _, stderr := runCommand("-ram", "0:0x10000", path); exit != 9 {
+		t.Fatalf("exit code = %d, want 9 (stderr %q)", exit, stderr)
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"a.elf", []byte("\x7fELF\x01\x02"), "elf"},
+		{"A.TOS", append([]byte{0x60, 0x1a}, make([]byte, 26)...), "prg"},
+		{"a.out", []byte{0, 0, 3, 0xf3}, "hunk"},
+		{"a.S19", []byte("S00600004844521B\n"), "srec"},
+		{"a.hex", []byte(":00000001FF\n"), "ihex"},
+		{"a.bin", []byte{0x4e, 0x71}, "raw"},
+	}
+	for _, tc := range tests {
+		if got := detectFormat(tc.name, tc.data); got != tc.want {
+			t.Fatalf("detectFormat(%q) = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRegionEndIsInclusive(t *testing.T) {
+	m := &machine{cfg: config{ram: []region{{start: 0x1000, size: 0x1000}}}}
+	if end := m.regionEnd(0x1800); end != 0x1fff {
+		t.Fatalf("regionEnd = %06x, want 001fff", end)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+
+	m68kemu "github.com/jenska/m68kemu"
+)
+
+const crashDisassemblyBytes = 16
+
+var vectorNames = map[uint32]string{
+	m68kemu.XBusError:      "bus error",
+	m68kemu.XAddressError:  "address error",
+	m68kemu.XIllegal:       "illegal instruction",
+	m68kemu.XDivByZero:     "division by zero",
+	6:                      "CHK exception",
+	7:                      "TRAPV exception",
+	m68kemu.XPrivViolation: "privilege violation",
+	9:                      "trace exception",
+	m68kemu.XLineA:         "Line-A emulator",
+	m68kemu.XLineF:         "Line-F emulator",
+	24:                     "spurious interrupt",
+}
+
+func vectorName(vector uint32) string {
+	switch {
+	case vectorNames[vector] != "":
+		return fmt.Sprintf("%s (vector %d)", vectorNames[vector], vector)
+	case vector >= 25 && vector <= 31:
+		return fmt.Sprintf("level %d interrupt (vector %d)", vector-24, vector)
+	case vector >= m68kemu.XTrap && vector < m68kemu.XTrap+16:
+		return fmt.Sprintf("TRAP #%d (vector %d)", vector-m68kemu.XTrap, vector)
+	default:
+		return fmt.Sprintf("exception vector %d", vector)
+	}
+}
+
+// crashReport explains why the program stopped and shows the instruction and
+// registers at the fault.
+func (m *machine) crashReport(reason string) {
+	w := m.cfg.stderr
+	fmt.Fprintf(w, "m68krun: crash: %s\n", reason)
+
+	pc := m.cpu.Registers().PC
+	if m.hasException {
+		info := m.lastException
+		pc = info.OpcodeAddress
+		fmt.Fprintf(w, "  exception at PC %06x, opcode %04x, SR %04x\n", info.OpcodeAddress, info.Opcode, info.SR)
+		if info.FaultValid {
+			fmt.Fprintf(w, "  fault address %06x\n", info.FaultAddress)
+		}
+	}
+	if lines, err := m68kemu.DisassembleMemoryRange(m.bus, pc, crashDisassemblyBytes); err == nil {
+		for _, line := range lines {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+	m.dumpRegisters()
+}
+
+func (m *machine) dumpRegisters() {
+	regs := m.cpu.Registers()
+	fmt.Fprintf(m.cfg.stderr, "%s", regs.String())
+	fmt.Fprintf(m.cfg.stderr, "cycles %d\n", m.cpu.Cycles())
+}
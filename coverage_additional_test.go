@@ -129,7 +129,7 @@ func TestBusPeekLongAcrossAdjacentDevices(t *testing.T) {
 	}
 }
 
-func TestSchedulerNilAndCompactionPaths(t *testing.T) {
+func TestSchedulerNilAndSlotReusePaths(t *testing.T) {
 	var nilScheduler *CycleScheduler
 	nilScheduler.Reset(12)
 	nilScheduler.AddListener(nil)
@@ -140,28 +140,30 @@ func TestSchedulerNilAndCompactionPaths(t *testing.T) {
 		t.Fatalf("nil scheduler Now = %d, want 0", got)
 	}
 
-	scheduler := &CycleScheduler{
-		events: []ScheduledEvent{{At: 1}, {At: 2}, {At: 3}, {At: 4}},
+	if handle := nilScheduler.Schedule(5, func(uint64) {}); handle.Pending() || handle.Cancel() || handle.Reschedule(1) {
+		t.Fatalf("nil scheduler returned a live handle")
 	}
-	scheduler.eventHead = 1
-	scheduler.compactEvents()
-	if scheduler.eventHead != 1 || len(scheduler.events) != 4 {
-		t.Fatalf("compactEvents compacted too early: head=%d len=%d", scheduler.eventHead, len(scheduler.events))
+	if _, ok := nilScheduler.NextEvent(); ok {
+		t.Fatalf("nil scheduler reported a pending event")
 	}
 
-	scheduler.eventHead = 2
-	scheduler.compactEvents()
-	if scheduler.eventHead != 0 || len(scheduler.events) != 2 {
-		t.Fatalf("compactEvents did not compact at threshold: head=%d len=%d", scheduler.eventHead, len(scheduler.events))
-	}
-	if scheduler.events[0].At != 3 || scheduler.events[1].At != 4 {
-		t.Fatalf("compacted events = %+v, want At 3 and 4", scheduler.events)
-	}
-
-	scheduler.eventHead = len(scheduler.events)
-	scheduler.compactEvents()
-	if scheduler.eventHead != 0 || len(scheduler.events) != 0 {
-		t.Fatalf("compactEvents did not clear consumed queue: head=%d len=%d", scheduler.eventHead, len(scheduler.events))
+	scheduler := NewCycleScheduler()
+	fired := 0
+	for _, at := range []uint64{4, 1, 3, 2} {
+		scheduler.Schedule(at, func(uint64) { fired++ })
+	}
+	scheduler.Advance(2)
+	if pending := len(scheduler.PendingEvents()); fired != 2 || pending != 2 || scheduler.freeSlot == 0 {
+		t.Fatalf("after Advance(2): fired=%d pending=%d freeSlot=%d, want 2 fired, 2 queued and a free slot", fired, pending, scheduler.freeSlot)
+	}
+	scheduler.Schedule(10, func(uint64) { fired++ })
+	scheduler.Schedule(11, func(uint64) { fired++ })
+	if len(scheduler.slots) != 4 || scheduler.freeSlot != 0 {
+		t.Fatalf("slots were not reused: slots=%d freeSlot=%d", len(scheduler.slots), scheduler.freeSlot)
+	}
+	scheduler.Reset(0)
+	if len(scheduler.queue) != 0 || len(scheduler.run) != 0 || len(scheduler.slots) != 0 || scheduler.freeSlot != 0 {
+		t.Fatalf("Reset left queue=%d run=%d slots=%d freeSlot=%d", len(scheduler.queue), len(scheduler.run), len(scheduler.slots), scheduler.freeSlot)
 	}
 }
 
@@ -34,6 +34,11 @@ const (
 	exceptionCyclesDivByZero  uint32 = 38
 	exceptionCyclesCHK        uint32 = 40
 	exceptionCyclesBusAddress uint32 = 50
+
+	// interruptAcknowledgeCycle is where the interrupt acknowledge cycle
+	// starts within exception processing, after the internal cycles and the
+	// first stack write.
+	interruptAcknowledgeCycle uint32 = 10
 )
 
 const (
@@ -157,6 +162,7 @@ type (
 		Write            bool
 		InstructionFetch bool
 		PC               uint32
+		Register         string
 	}
 
 	BusAccessCallback func(BusAccessInfo)
@@ -260,19 +266,32 @@ type (
 	}
 
 	CycleScheduler struct {
-		now       uint64
-		listeners []CycleListener
-		events    []ScheduledEvent
-		eventHead int
+		now         uint64
+		target      uint64 // end of the Advance in progress
+		listeners   []CycleListener
+		slots       []eventSlot
+		freeSlot    int32 // first free slot plus one
+		queue       []queueEntry
+		run         []queueEntry
+		runHead     int // first entry of run that has not fired
+		runDead     int // cancelled entries between runHead and the end of run
+		nextID      uint64
+		nextOrder   uint64
+		busRequests []busRequest
 	}
 
 	CycleListener interface {
 		AdvanceCycles(delta uint64, now uint64)
 	}
 
+	// ScheduledEvent describes a pending event. ID is unique for the
+	// lifetime of the scheduler; Name is the caller's stable label, which save
+	// states can use to reconnect events to their callbacks.
 	ScheduledEvent struct {
-		At uint64
-		Fn func(now uint64)
+		ID   uint64
+		Name string
+		At   uint64
+		Fn   func(now uint64)
 	}
 
 	// Registers represents the programmer visible registers of the 68000 CPU.
@@ -289,6 +308,7 @@ type (
 	// CPU exposes the minimal interface for interacting with the emulator core.
 	CPU interface {
 		Registers() Registers
+		SetRegisters(Registers)
 		DebugState() DebugState
 		Step() error
 		RunCycles(budget uint64) error
@@ -302,8 +322,16 @@ type (
 		SetInterruptTracer(InterruptCallback)
 		SetScheduler(*CycleScheduler)
 		Scheduler() *CycleScheduler
+		SetExecutionEngine(ExecutionEngine)
+		InvalidateCode(address, length uint32)
+		SetIdleLoopDetection(enabled bool)
+		SetEventDelivery(EventDelivery)
+		SetTrapHandler(vector uint32, handler TrapHandler)
+		SetNatFeats(*NatFeats)
+		RunFrame(cycles uint64) (FrameResult, error)
 		AddBreakpoint(Breakpoint)
 		RequestInterrupt(level uint8, vector *uint8) error
+		Interrupts() *InterruptController
 		Cycles() uint64
 		SetHistoryLimit(limit int)
 		History() []HistoryEntry
@@ -326,6 +354,9 @@ type (
 		cycles        uint64
 		bus           AddressBus
 		busFast       *Bus
+		blocks        *blockCache
+		fcBus         FunctionCodeBus
+		registerNamer RegisterNamer
 		trap          TraceCallback
 		preTrap       PreTraceCallback
 		exceptionTrap ExceptionCallback
@@ -333,8 +364,24 @@ type (
 		interruptTrap InterruptCallback
 		scheduler     *CycleScheduler
 		interrupts    *InterruptController
+		trapHandlers  *[256]TrapHandler
+		natFeats      *NatFeats
+
+		// srcOperand and dstOperand hold the effective addresses resolved by
+		// the current instruction.
+		srcOperand operand
+		dstOperand operand
+
+		// aheadCycles is how far cycles runs ahead of the bus: cycles charged
+		// up front for work the current instruction's bus cycles have not
+		// reached yet. The next bus cycle starts at cycles-aheadCycles.
+		aheadCycles uint32
 
-		stopped bool
+		stopped        bool
+		idleLoops      bool
+		boundaryEvents bool
+		frameEnd       uint64
+		frameValid     bool
 
 		fault              faultInfo
 		inException        bool
@@ -496,7 +543,7 @@ func (cpu *cpu) readContext(size Size, address uint32, ctx accessContext) (uint3
 				return 0, err
 			}
 		}
-		if result, ok, err := cpu.fastRAMRead(size, address); ok {
+		if result, ok, err := cpu.fastRAMRead(FunctionCode(ctx.functionCode), size, address); ok {
 			if err != nil {
 				cpu.recordFault(faultAddress(address, err), ctx)
 			} else if cpu.shouldTraceBusAccess(ctx) {
@@ -504,7 +551,7 @@ func (cpu *cpu) readContext(size Size, address uint32, ctx accessContext) (uint3
 			}
 			return result, err
 		}
-		result, err := cpu.bus.Read(size, address)
+		result, err := cpu.busRead(FunctionCode(ctx.functionCode), size, address)
 		if err != nil {
 			cpu.recordFault(faultAddress(address, err), ctx)
 		} else if cpu.shouldTraceBusAccess(ctx) {
@@ -534,7 +581,10 @@ func (cpu *cpu) writeContext(size Size, address uint32, value uint32, ctx access
 				return err
 			}
 		}
-		if ok, err := cpu.fastRAMWrite(size, address, value); ok {
+		if cpu.blocks != nil {
+			cpu.blocks.noteWrite(address, size)
+		}
+		if ok, err := cpu.fastRAMWrite(FunctionCode(ctx.functionCode), size, address, value); ok {
 			if err != nil {
 				cpu.recordFault(faultAddress(address, err), ctx)
 			} else if cpu.shouldTraceBusAccess(ctx) {
@@ -542,7 +592,7 @@ func (cpu *cpu) writeContext(size Size, address uint32, value uint32, ctx access
 			}
 			return err
 		}
-		if err := cpu.bus.Write(size, address, value); err != nil {
+		if err := cpu.busWrite(FunctionCode(ctx.functionCode), size, address, value); err != nil {
 			cpu.recordFault(faultAddress(address, err), ctx)
 			return err
 		}
@@ -556,101 +606,54 @@ func (cpu *cpu) writeContext(size Size, address uint32, value uint32, ctx access
 	}
 }
 
-func (cpu *cpu) fastRAMDevice() *RAM {
-	if cpu.busFast == nil {
-		return nil
-	}
-	return cpu.busFast.fastRAM
-}
-
-func (cpu *cpu) fastRAMRead(size Size, address uint32) (uint32, bool, error) {
-	ram := cpu.fastRAMDevice()
-	if ram == nil {
+// fastRAMRead serves accesses inside the bus's direct RAM and ROM pages.
+// Other accesses fall back to the regular bus path.
+func (cpu *cpu) fastRAMRead(fc FunctionCode, size Size, address uint32) (uint32, bool, error) {
+	mem, idx := cpu.busFast.directPage(address, size, false)
+	if mem == nil {
 		return 0, false, nil
 	}
-
-	memLen := uint32(len(ram.mem))
-	if address < ram.offset {
-		return 0, true, BusError(address)
+	if cpu.busFast.timing != nil {
+		cpu.busFast.directCycles(fc, false, size, address)
 	}
-	idx := address - ram.offset
 
 	switch size {
 	case Byte:
-		if idx >= memLen {
-			return 0, true, BusError(address)
-		}
-		return uint32(ram.mem[idx]), true, nil
+		return uint32(mem[idx]), true, nil
 	case Word:
-		if address&1 != 0 {
-			return 0, true, AddressError(address)
-		}
-		if idx+1 >= memLen {
-			return 0, true, BusError(address)
-		}
-		return uint32(ram.mem[idx])<<8 | uint32(ram.mem[idx+1]), true, nil
+		return uint32(mem[idx])<<8 | uint32(mem[idx+1]), true, nil
 	case Long:
-		if address&1 != 0 {
-			return 0, true, AddressError(address)
-		}
-		if idx+1 >= memLen {
-			return 0, true, BusError(address)
-		}
-		if idx+3 >= memLen {
-			return 0, true, BusError((address + uint32(Word)) & 0xffffff)
-		}
-		return uint32(ram.mem[idx])<<24 |
-			uint32(ram.mem[idx+1])<<16 |
-			uint32(ram.mem[idx+2])<<8 |
-			uint32(ram.mem[idx+3]), true, nil
+		return uint32(mem[idx])<<24 |
+			uint32(mem[idx+1])<<16 |
+			uint32(mem[idx+2])<<8 |
+			uint32(mem[idx+3]), true, nil
 	default:
 		return 0, false, nil
 	}
 }
 
-func (cpu *cpu) fastRAMWrite(size Size, address uint32, value uint32) (bool, error) {
-	ram := cpu.fastRAMDevice()
-	if ram == nil {
+func (cpu *cpu) fastRAMWrite(fc FunctionCode, size Size, address uint32, value uint32) (bool, error) {
+	mem, idx := cpu.busFast.directPage(address, size, true)
+	if mem == nil {
 		return false, nil
 	}
-
-	memLen := uint32(len(ram.mem))
-	if address < ram.offset {
-		return true, BusError(address)
+	if cpu.busFast.timing != nil {
+		cpu.busFast.directCycles(fc, true, size, address)
 	}
-	idx := address - ram.offset
 
 	switch size {
 	case Byte:
-		if idx >= memLen {
-			return true, BusError(address)
-		}
-		ram.mem[idx] = uint8(value)
+		mem[idx] = uint8(value)
 		return true, nil
 	case Word:
-		if address&1 != 0 {
-			return true, AddressError(address)
-		}
-		if idx+1 >= memLen {
-			return true, BusError(address)
-		}
-		ram.mem[idx] = uint8(value >> 8)
-		ram.mem[idx+1] = uint8(value)
+		mem[idx] = uint8(value >> 8)
+		mem[idx+1] = uint8(value)
 		return true, nil
 	case Long:
-		if address&1 != 0 {
-			return true, AddressError(address)
-		}
-		if idx+1 >= memLen {
-			return true, BusError(address)
-		}
-		ram.mem[idx] = uint8(value >> 24)
-		ram.mem[idx+1] = uint8(value >> 16)
-		if idx+3 >= memLen {
-			return true, BusError((address + uint32(Word)) & 0xffffff)
-		}
-		ram.mem[idx+2] = uint8(value >> 8)
-		ram.mem[idx+3] = uint8(value)
+		mem[idx] = uint8(value >> 24)
+		mem[idx+1] = uint8(value >> 16)
+		mem[idx+2] = uint8(value >> 8)
+		mem[idx+3] = uint8(value)
 		return true, nil
 	default:
 		return false, nil
@@ -661,6 +664,20 @@ func (cpu *cpu) Registers() Registers {
 	return cpu.regs
 }
 
+// SetRegisters replaces the programmer-visible register file. A7 is taken as
+// the active stack pointer for the privilege state encoded in regs.SR and
+// overrides SSP in supervisor mode or USP in user mode, so loaders and
+// debuggers can set PC, SP, and SR in one call.
+func (cpu *cpu) SetRegisters(regs Registers) {
+	if regs.SR&srSupervisor != 0 {
+		regs.SSP = regs.A[7]
+	} else {
+		regs.USP = regs.A[7]
+	}
+	cpu.regs = regs
+	cpu.lastOpcodePCValid = false
+}
+
 func (cpu *cpu) DebugState() DebugState {
 	return DebugState{
 		Registers:     cpu.regs,
@@ -723,6 +740,12 @@ func (cpu *cpu) RequestInterrupt(level uint8, vector *uint8) error {
 	return cpu.interrupts.Request(level, vector)
 }
 
+// Interrupts returns the CPU's interrupt controller so devices can attach
+// interrupt lines to it.
+func (cpu *cpu) Interrupts() *InterruptController {
+	return cpu.interrupts
+}
+
 func (cpu *cpu) AddBreakpoint(bp Breakpoint) {
 	if cpu.breakpoints == nil {
 		cpu.breakpoints = make(map[uint32]Breakpoint)
@@ -832,6 +855,9 @@ func (cpu *cpu) consumeOpcodePC() uint32 {
 func (cpu *cpu) opcodeException(vector uint32, instructionPC uint32) error {
 	if vector == XLineA || vector == XLineF {
 		cpu.overrideInstructionCycles(exceptionCyclesIllegal)
+		if handled, err := cpu.interceptException(vector, instructionPC); handled || err != nil {
+			return err
+		}
 		return cpu.raiseExceptionWithPC(vector, cpu.regs.SR|srSupervisor, instructionPC, instructionPC)
 	}
 	return cpu.exceptionWithCycles(vector, exceptionCyclesIllegal)
@@ -852,10 +878,17 @@ func (cpu *cpu) executeInstruction(opcode uint16) error {
 
 	cpu.regs.IR = opcode
 
-	cpu.addCycles(opcodeCycleTable[opcode])
+	cpu.chargeInstruction(opcodeCycleTable[opcode])
 
 	handler := opcodeTable[opcode]
 	if handler == nil {
+		if cpu.natFeats != nil && (opcode == OpcodeNatFeatID || opcode == OpcodeNatFeatCall) {
+			if handled, err := cpu.natFeat(opcode, instructionPC); err != nil {
+				return cpu.handleFaultError(err, true)
+			} else if handled {
+				return nil
+			}
+		}
 		return cpu.opcodeException(exceptionVectorForOpcode(opcode), instructionPC)
 	}
 
@@ -890,6 +923,9 @@ func (cpu *cpu) trapException(vector uint32) error {
 	if stackedPC == instructionPC {
 		stackedPC = (instructionPC + uint32(Word)) & 0xffffff
 	}
+	if handled, err := cpu.interceptException(vector, stackedPC); handled || err != nil {
+		return err
+	}
 	return cpu.synchronousException(vector, cpu.regs.SR|srSupervisor, stackedPC)
 }
 
@@ -949,6 +985,9 @@ func (cpu *cpu) exception(vector uint32) error {
 
 func (cpu *cpu) exceptionWithCycles(vector uint32, total uint32) error {
 	cpu.overrideInstructionCycles(total)
+	if handled, err := cpu.interceptException(vector, cpu.regs.PC); handled || err != nil {
+		return err
+	}
 	return cpu.exception(vector)
 }
 
@@ -1024,6 +1063,7 @@ func (cpu *cpu) group0ExceptionForCurrentInstruction(vector uint32, total uint32
 
 func (cpu *cpu) group0ExceptionWithoutInstruction(vector uint32, total uint32) error {
 	cpu.addCycles(total)
+	cpu.aheadCycles = total
 	return cpu.raiseGroup0Exception(vector, cpu.regs.SR|srSupervisor)
 }
 
@@ -1064,6 +1104,11 @@ func (cpu *cpu) interrupt(level uint8, vector uint32, autoVector bool) error {
 	originalSR := cpu.regs.SR
 	newSR := (cpu.regs.SR & ^uint16(srInterruptMask)) | srSupervisor | (uint16(level) << 8)
 	cpu.addCycles(exceptionCyclesInterrupt)
+	cpu.aheadCycles = exceptionCyclesInterrupt - interruptAcknowledgeCycle
+	if autoVector && cpu.busFast != nil {
+		cpu.busFast.acknowledgeAutovector()
+	}
+	cpu.busCycleDone()
 	if err := cpu.raiseException(vector, newSR); err != nil {
 		return err
 	}
@@ -1143,10 +1188,16 @@ func (cpu *cpu) executeNext() error {
 		cpu.endInstructionContext()
 		return err
 	}
+	if cpu.boundaryEvents {
+		cpu.syncScheduler()
+	}
 	if err := cpu.checkInterrupts(); err != nil {
 		cpu.endInstructionContext()
 		return err
 	}
+	if cpu.boundaryEvents {
+		cpu.syncScheduler()
+	}
 	if cpu.traceInstructions {
 		cpu.sendTrace(pc, beforeRegs, uint32(cpu.cycles-beforeCycles))
 	}
@@ -1159,6 +1210,15 @@ func (cpu *cpu) Step() error {
 	if cpu.stepExceptionValid || cpu.stepInterruptValid || len(cpu.traceBytes) != 0 || len(cpu.stepBusAccesses) != 0 {
 		cpu.resetStepDebugState()
 	}
+	if cpu.boundaryEvents {
+		cpu.syncScheduler()
+	}
+
+	if cpu.scheduler.BusRequested() {
+		if err := cpu.arbitrateBus(); err != nil {
+			return err
+		}
+	}
 
 	if cpu.stopped {
 		if err := cpu.checkInterrupts(); err != nil {
@@ -1183,6 +1243,31 @@ func (cpu *cpu) RunCycles(budget uint64) error {
 		if cpu.stepExceptionValid || cpu.stepInterruptValid || len(cpu.traceBytes) != 0 || len(cpu.stepBusAccesses) != 0 {
 			cpu.resetStepDebugState()
 		}
+		if cpu.boundaryEvents {
+			cpu.syncScheduler()
+		}
+		if cpu.scheduler.BusRequested() {
+			if err := cpu.arbitrateBus(); err != nil {
+				return err
+			}
+			continue
+		}
+		if cpu.idleLoops && cpu.idleLoopsUsable() {
+			if ran, err := cpu.runIdleLoop(target); ran {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if cpu.blocks != nil && cpu.blocksUsable() {
+			if ran, err := cpu.runBlock(target); ran {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		}
 		before := cpu.cycles
 
 		// Inline Step() for performance
@@ -1191,8 +1276,15 @@ func (cpu *cpu) RunCycles(budget uint64) error {
 				return err
 			}
 			if cpu.stopped {
-				// If still stopped, consume cycles to prevent infinite tight loop without progress
-				cpu.addCycles(4)
+				if cpu.idleLoops || !cpu.scheduler.hasListeners() {
+					// Only a scheduled event can raise an interrupt, so jump
+					// straight to the next event or the end of the budget.
+					cpu.skipCycles(cpu.idleCycles(target, true))
+				} else {
+					// A CycleListener may raise an interrupt at any time, so
+					// advance in small steps to take it promptly.
+					cpu.addCycles(4)
+				}
 				continue
 			}
 		}
@@ -1205,6 +1297,9 @@ func (cpu *cpu) RunCycles(budget uint64) error {
 			return fmt.Errorf("execution stalled at %04x: cycles not advancing", cpu.regs.PC)
 		}
 	}
+	if cpu.boundaryEvents {
+		cpu.syncScheduler()
+	}
 	return nil
 }
 
@@ -1359,6 +1454,7 @@ func (cpu *cpu) checkAccessBreakpoint(address uint32, kind BreakpointType) error
 
 func (cpu *cpu) fetchOpcode() (uint16, error) {
 	fetchPC := cpu.regs.PC
+	cpu.aheadCycles = 0
 	if opcode, ok, err := cpu.readProgramFastWord(cpu.regs.PC); ok {
 		if err != nil {
 			cpu.recordProgramFault(cpu.regs.PC, err)
@@ -1378,6 +1474,21 @@ func (cpu *cpu) fetchOpcode() (uint16, error) {
 	}
 }
 
+// busRead passes the function code on when the bus understands it.
+func (cpu *cpu) busRead(fc FunctionCode, size Size, address uint32) (uint32, error) {
+	if cpu.fcBus != nil {
+		return cpu.fcBus.ReadFC(fc, size, address)
+	}
+	return cpu.bus.Read(size, address)
+}
+
+func (cpu *cpu) busWrite(fc FunctionCode, size Size, address uint32, value uint32) error {
+	if cpu.fcBus != nil {
+		return cpu.fcBus.WriteFC(fc, size, address, value)
+	}
+	return cpu.bus.Write(size, address, value)
+}
+
 func (cpu *cpu) Reset() error {
 	cpu.regs = Registers{SR: 0x2700}
 	if cpu.interrupts == nil {
@@ -1386,13 +1497,13 @@ func (cpu *cpu) Reset() error {
 		cpu.interrupts.Reset()
 	}
 	cpu.stopped = false
-	ssp, err := cpu.bus.Read(Long, 0)
+	ssp, err := cpu.busRead(FunctionCodeSupervisorProgram, Long, 0)
 	if err != nil {
 		return err
 	}
 	cpu.regs.A[7] = ssp
 	cpu.regs.SSP = ssp
-	pc, err := cpu.bus.Read(Long, 4)
+	pc, err := cpu.busRead(FunctionCodeSupervisorProgram, Long, 4)
 	if err != nil {
 		return err
 	}
@@ -1416,6 +1527,7 @@ func (cpu *cpu) Reset() error {
 	cpu.stepBusAccesses = cpu.stepBusAccesses[:0]
 	cpu.historyNext = 0
 	cpu.historyCount = 0
+	cpu.frameValid = false
 	cpu.refreshDebugModes()
 	if cpu.scheduler != nil {
 		cpu.scheduler.Reset(0)
@@ -1425,6 +1537,12 @@ func (cpu *cpu) Reset() error {
 
 func NewCPU(bus AddressBus) (CPU, error) {
 	c := cpu{bus: bus}
+	if namer, ok := bus.(RegisterNamer); ok {
+		c.registerNamer = namer
+	}
+	if fcBus, ok := bus.(FunctionCodeBus); ok {
+		c.fcBus = fcBus
+	}
 
 	if b, ok := bus.(*Bus); ok {
 		c.busFast = b
@@ -1435,6 +1553,8 @@ func NewCPU(bus AddressBus) (CPU, error) {
 			}
 			c.addCycles(states)
 		})
+		b.SetCycleCounter(func() uint64 { return c.cycles - uint64(c.aheadCycles) })
+		b.cycleDone = c.busCycleDone
 	}
 
 	if err := c.Reset(); err != nil {
@@ -1558,33 +1678,23 @@ func (cpu *cpu) popPc(s Size) (uint32, error) {
 	}
 }
 
-// readProgramFastWord keeps the single-RAM fast path active while still
+// readProgramFastWord keeps the direct page fast path active while still
 // reporting instruction fetches through the debug bus hook.
 func (cpu *cpu) readProgramFastWord(address uint32) (uint16, bool, error) {
 	if cpu.breakpoints != nil {
 		return 0, false, nil
 	}
 
-	ram := cpu.fastRAMDevice()
-	if ram == nil {
-		return 0, false, nil
-	}
-
 	address &= 0xffffff
-	if address&1 != 0 {
-		return 0, true, AddressError(address)
-	}
-	if address < ram.offset {
-		return 0, true, BusError(address)
+	mem, idx := cpu.busFast.directPage(address, Word, false)
+	if mem == nil {
+		return 0, false, nil
 	}
-
-	idx := address - ram.offset
-	memLen := uint32(len(ram.mem))
-	if idx+1 >= memLen {
-		return 0, true, BusError(address)
+	if cpu.busFast.timing != nil {
+		cpu.busFast.directCycles(FunctionCode(cpu.programFunctionCode()), false, Word, address)
 	}
 
-	value := uint16(ram.mem[idx])<<8 | uint16(ram.mem[idx+1])
+	value := uint16(mem[idx])<<8 | uint16(mem[idx+1])
 	if cpu.traceInstructions || cpu.traceBus {
 		ctx := accessContext{functionCode: cpu.programFunctionCode()}
 		if cpu.shouldTraceBusAccess(ctx) {
@@ -1599,32 +1709,19 @@ func (cpu *cpu) readProgramFastLong(address uint32) (uint32, bool, error) {
 		return 0, false, nil
 	}
 
-	ram := cpu.fastRAMDevice()
-	if ram == nil {
-		return 0, false, nil
-	}
-
 	address &= 0xffffff
-	if address&1 != 0 {
-		return 0, true, AddressError(address)
-	}
-	if address < ram.offset {
-		return 0, true, BusError(address)
-	}
-
-	idx := address - ram.offset
-	memLen := uint32(len(ram.mem))
-	if idx+1 >= memLen {
-		return 0, true, BusError(address)
+	mem, idx := cpu.busFast.directPage(address, Long, false)
+	if mem == nil {
+		return 0, false, nil
 	}
-	if idx+3 >= memLen {
-		return 0, true, BusError((address + uint32(Word)) & 0xffffff)
+	if cpu.busFast.timing != nil {
+		cpu.busFast.directCycles(FunctionCode(cpu.programFunctionCode()), false, Long, address)
 	}
 
-	value := uint32(ram.mem[idx])<<24 |
-		uint32(ram.mem[idx+1])<<16 |
-		uint32(ram.mem[idx+2])<<8 |
-		uint32(ram.mem[idx+3])
+	value := uint32(mem[idx])<<24 |
+		uint32(mem[idx+1])<<16 |
+		uint32(mem[idx+2])<<8 |
+		uint32(mem[idx+3])
 	if cpu.traceInstructions || cpu.traceBus {
 		ctx := accessContext{functionCode: cpu.programFunctionCode()}
 		if cpu.shouldTraceBusAccess(ctx) {
@@ -1643,18 +1740,35 @@ func (cpu *cpu) recordProgramFault(address uint32, err error) {
 // type.
 func (cpu *cpu) addCycles(c uint32) {
 	cpu.cycles += uint64(c)
-	if cpu.scheduler != nil {
+	if cpu.scheduler != nil && !cpu.boundaryEvents {
 		cpu.scheduler.Advance(uint64(c))
 	}
 }
 
+// chargeInstruction charges an instruction's cycles when it starts, after its
+// opcode fetch. The cycle counter then runs ahead of the bus, which places the
+// instruction's remaining bus cycles back to back from there and its internal
+// work after them.
+func (cpu *cpu) chargeInstruction(c uint32) {
+	cpu.addCycles(c)
+	cpu.aheadCycles = c - min(c, 4)
+}
+
+// busCycleDone moves the bus 4 cycles closer to the cycle counter when a bus
+// cycle finishes.
+func (cpu *cpu) busCycleDone() {
+	cpu.aheadCycles -= min(cpu.aheadCycles, 4)
+}
+
 func (cpu *cpu) overrideInstructionCycles(total uint32) {
 	current := opcodeCycleTable[cpu.regs.IR]
 	if total >= current {
 		cpu.cycles += uint64(total - current)
+		cpu.aheadCycles += total - current
 		return
 	}
 	cpu.cycles -= uint64(current - total)
+	cpu.aheadCycles -= min(cpu.aheadCycles, current-total)
 }
 
 // Cycles returns the total number of cycles executed since the last reset.
@@ -1747,6 +1861,9 @@ func (cpu *cpu) traceBusAccess(size Size, address uint32, value uint32, ctx acce
 		InstructionFetch: ctx.instructionFetch(),
 		PC:               cpu.debugPC(),
 	}
+	if cpu.registerNamer != nil && !info.InstructionFetch {
+		info.Register, _ = cpu.registerNamer.RegisterName(info.Address)
+	}
 
 	if ctx.instructionFetch() && !ctx.write {
 		cpu.traceBytes = appendTraceValue(cpu.traceBytes, size, value)
@@ -176,6 +176,27 @@ func TestPopPcFastLongFaultUsesSecondCycleAddress(t *testing.T) {
 	}
 }
 
+func TestSetRegistersUsesA7AsActiveStackPointer(t *testing.T) {
+	cpu, _ := newEnvironment(t)
+
+	cpu.SetRegisters(Registers{SR: 0x0000, A: [8]uint32{7: 0x3000}, SSP: 0x1000, USP: 0x2000, PC: 0x2000})
+	regs := cpu.Registers()
+	if regs.USP != 0x3000 || regs.SSP != 0x1000 || regs.A[7] != 0x3000 {
+		t.Fatalf("user mode: A7 %04x USP %04x SSP %04x, want A7 and USP 3000, SSP 1000", regs.A[7], regs.USP, regs.SSP)
+	}
+
+	// Entering supervisor mode switches A7 to the untouched SSP.
+	cpu.setSR(0x2700)
+	if cpu.regs.A[7] != 0x1000 || cpu.regs.USP != 0x3000 {
+		t.Fatalf("after entering supervisor mode A7 %04x USP %04x, want 1000 and 3000", cpu.regs.A[7], cpu.regs.USP)
+	}
+
+	cpu.SetRegisters(Registers{SR: 0x2700, A: [8]uint32{7: 0x4000}, SSP: 0x1000, USP: 0x2000, PC: 0x2000})
+	if regs := cpu.Registers(); regs.SSP != 0x4000 || regs.USP != 0x2000 {
+		t.Fatalf("supervisor mode: USP %04x SSP %04x, want 2000 and 4000", regs.USP, regs.SSP)
+	}
+}
+
 func TestCycleCounterBasicSequence(t *testing.T) {
 	cpu, ram := newEnvironment(t)
 
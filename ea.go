@@ -1,75 +1,34 @@
 package m68kemu
 
 import (
+	"fmt"
 	"unsafe"
 )
 
-type (
-	ea interface {
-		init(cpu *cpu, o Size) (modifier, error)
-		computedAddress() uint32
-		// cycles() int
-	}
-
-	modifier interface {
-		computedAddress() uint32
-		read() (uint32, error)
-		write(uint32) error
-	}
-
-	eaRegister struct {
-		areg func(cpu *cpu) *uint32
-		cpu  *cpu
-		size Size
-	}
-
-	eaRegisterIndirect struct {
-		eaRegister
-		address uint32
-	}
-
-	eaPostIncrement struct {
-		eaRegisterIndirect
-	}
-
-	eaPreDecrement struct {
-		eaRegisterIndirect
-	}
-
-	eaDisplacement struct {
-		eaRegisterIndirect
-	}
-
-	eaIndirectIndex struct {
-		eaRegisterIndirect
-		index func(cpu *cpu, a uint32) (uint32, error)
-	}
-
-	eaAbsolute struct {
-		cpu     *cpu
-		eaSize  Size
-		size    Size
-		address uint32
-	}
-
-	eaPCDisplacement struct {
-		eaDisplacement
-	}
+// operandKind selects how a resolved operand is accessed.
+type operandKind uint8
 
-	eaPCIndirectIndex struct {
-		eaIndirectIndex
-	}
-
-	eaImmediate struct {
-		value uint32
-	}
-
-	eaStatusRegister struct {
-		size Size
-		sr   *uint16
-	}
+const (
+	operandDataRegister operandKind = iota
+	operandAddressRegister
+	operandMemory
+	operandImmediate
+	operandStatusRegister
 )
 
+// operand is a resolved effective address. Each CPU owns one source and one
+// destination operand that are filled in place, so resolving an EA neither
+// allocates nor dispatches through interfaces, and read/write switch on the
+// operand kind.
+type operand struct {
+	cpu     *cpu
+	kind    operandKind
+	reg     uint8
+	size    Size
+	address uint32
+	value   uint32
+}
+
 var (
 	eaCycleTable = [8][8]uint32{
 		{0, 0, 0, 0, 0, 0, 0, 0},         // Dn
@@ -82,104 +41,139 @@ var (
 		{8, 12, 8, 10, 0, 0, 0, 0},       // (xxx).W, (xxx).L, (d16,PC), (d8,PC,Xn), #<data>
 	}
 
-	eaSrc = []ea{
-		&eaRegister{areg: dy},
-		&eaRegister{areg: ay},
-		&eaRegisterIndirect{eaRegister{areg: ay}, 0},
-		&eaPostIncrement{eaRegisterIndirect{eaRegister{areg: ay}, 0}},
-		&eaPreDecrement{eaRegisterIndirect{eaRegister{areg: ay}, 0}},
-		&eaDisplacement{eaRegisterIndirect{eaRegister{areg: ay}, 0}},
-		&eaIndirectIndex{eaRegisterIndirect{eaRegister{areg: ay}, 0}, ix68000},
-		&eaAbsolute{eaSize: Word},
-		&eaAbsolute{eaSize: Long},
-		&eaPCDisplacement{eaDisplacement{eaRegisterIndirect{eaRegister{areg: nil}, 0}}},
-		&eaPCIndirectIndex{eaIndirectIndex{eaRegisterIndirect{eaRegister{areg: nil}, 0}, ix68000}},
-		&eaImmediate{},
-	}
-
-	eaSrc2 = []ea{
-		&eaRegister{areg: dy},
-		&eaRegister{areg: ay},
-		&eaRegisterIndirect{eaRegister{areg: ay}, 0},
-		&eaPostIncrement{eaRegisterIndirect{eaRegister{areg: ay}, 0}},
-		&eaPreDecrement{eaRegisterIndirect{eaRegister{areg: ay}, 0}},
-		&eaDisplacement{eaRegisterIndirect{eaRegister{areg: ay}, 0}},
-		&eaIndirectIndex{eaRegisterIndirect{eaRegister{areg: ay}, 0}, ix68000},
-		&eaAbsolute{eaSize: Word},
-		&eaAbsolute{eaSize: Long},
-		&eaPCDisplacement{eaDisplacement{eaRegisterIndirect{eaRegister{areg: nil}, 0}}},
-		&eaPCIndirectIndex{eaIndirectIndex{eaRegisterIndirect{eaRegister{areg: nil}, 0}, ix68000}},
-		&eaStatusRegister{},
-	}
-
-	eaDst = []ea{
-		&eaRegister{areg: udx},
-		&eaRegister{areg: ax},
-		&eaRegisterIndirect{eaRegister{areg: ax}, 0},
-		&eaPostIncrement{eaRegisterIndirect{eaRegister{areg: ax}, 0}},
-		&eaPreDecrement{eaRegisterIndirect{eaRegister{areg: ax}, 0}},
-		&eaDisplacement{eaRegisterIndirect{eaRegister{areg: ax}, 0}},
-		&eaIndirectIndex{eaRegisterIndirect{eaRegister{areg: ax}, 0}, ix68000},
-		&eaAbsolute{eaSize: Word},
-		&eaAbsolute{eaSize: Long},
-		&eaPCDisplacement{eaDisplacement{eaRegisterIndirect{eaRegister{areg: nil}, 0}}},
-		&eaPCIndirectIndex{eaIndirectIndex{eaRegisterIndirect{eaRegister{areg: nil}, 0}, ix68000}},
-		&eaStatusRegister{},
-	}
-
 	opcodeMetaTable [0x10000]opcodeMeta
 )
 
 type opcodeMeta struct {
-	x        uint8
-	y        uint8
-	srcMode  uint8
-	srcIndex uint8
-	dstMode  uint8
-	dstIndex uint8
-	opSize   Size
+	x       uint8
+	y       uint8
+	srcMode uint8
+	dstMode uint8
+	opSize  Size
 }
 
 func init() {
 	for opcode := range len(opcodeMetaTable) {
 		ir := uint16(opcode)
-		srcMode := (ir >> 3) & 0x7
-		srcIndex := srcMode
-		if srcMode == 7 {
-			srcIndex += ir & 0x7
-		}
-
-		dstMode := (ir >> 6) & 0x7
-		dstIndex := dstMode
-		if dstMode == 7 {
-			dstIndex += (ir >> 9) & 0x7
-		}
-
 		opcodeMetaTable[opcode] = opcodeMeta{
-			x:        uint8((ir >> 9) & 0x7),
-			y:        uint8(ir & 0x7),
-			srcMode:  uint8(srcMode),
-			srcIndex: uint8(srcIndex),
-			dstMode:  uint8(dstMode),
-			dstIndex: uint8(dstIndex),
-			opSize:   opSizes[(ir>>6)&0x3],
+			x:       uint8((ir >> 9) & 0x7),
+			y:       uint8(ir & 0x7),
+			srcMode: uint8((ir >> 3) & 0x7),
+			dstMode: uint8((ir >> 6) & 0x7),
+			opSize:  opSizes[(ir>>6)&0x3],
 		}
 	}
 }
 
-func (cpu *cpu) ResolveSrcEA(o Size) (modifier, error) {
-	meta := opcodeMetaTable[cpu.regs.IR]
-	return eaSrc[meta.srcIndex].init(cpu, o)
-}
-
-func (cpu *cpu) ResolveSrcEA2(o Size) (modifier, error) {
-	meta := opcodeMetaTable[cpu.regs.IR]
-	return eaSrc2[meta.srcIndex].init(cpu, o)
+func (cpu *cpu) ResolveSrcEA(o Size) (*operand, error) {
+	meta := &opcodeMetaTable[cpu.regs.IR]
+	op := &cpu.srcOperand
+	return op, cpu.resolveEA(op, meta.srcMode, meta.y, o, operandImmediate)
+}
+
+// ResolveSrcEA2 resolves the source field like ResolveSrcEA, except that mode
+// 7/4 addresses the status register instead of an immediate.
+func (cpu *cpu) ResolveSrcEA2(o Size) (*operand, error) {
+	meta := &opcodeMetaTable[cpu.regs.IR]
+	op := &cpu.srcOperand
+	return op, cpu.resolveEA(op, meta.srcMode, meta.y, o, operandStatusRegister)
+}
+
+func (cpu *cpu) ResolveDstEA(o Size) (*operand, error) {
+	meta := &opcodeMetaTable[cpu.regs.IR]
+	op := &cpu.dstOperand
+	return op, cpu.resolveEA(op, meta.dstMode, meta.x, o, operandStatusRegister)
+}
+
+// resolveEA decodes one effective address field into op, consuming extension
+// words and applying register side effects. special is the kind used for
+// mode 7/4.
+func (cpu *cpu) resolveEA(op *operand, mode, reg uint8, o Size, special operandKind) error {
+	op.cpu = cpu
+	op.reg = reg
+	op.size = o
+	switch mode {
+	case 0:
+		op.kind = operandDataRegister
+		return nil
+	case 1:
+		op.kind = operandAddressRegister
+		return nil
+	case 2:
+		op.address = cpu.regs.A[reg]
+	case 3:
+		op.address = cpu.regs.A[reg]
+		cpu.regs.A[reg] += addressRegisterStep(uint16(reg), o)
+	case 4:
+		cpu.regs.A[reg] -= addressRegisterStep(uint16(reg), o)
+		op.address = cpu.regs.A[reg]
+	case 5:
+		offset, err := cpu.popPc(Word)
+		if err != nil {
+			return err
+		}
+		op.address = uint32(int32(cpu.regs.A[reg]) + int32(int16(offset)))
+	case 6:
+		address, err := ix68000(cpu, cpu.regs.A[reg])
+		if err != nil {
+			return err
+		}
+		op.address = address
+	default:
+		return cpu.resolveSpecialEA(op, special)
+	}
+	op.kind = operandMemory
+	return nil
 }
 
-func (cpu *cpu) ResolveDstEA(o Size) (modifier, error) {
-	meta := opcodeMetaTable[cpu.regs.IR]
-	return eaDst[meta.dstIndex].init(cpu, o)
+// resolveSpecialEA handles mode 7, where the register field selects absolute,
+// PC-relative, and immediate (or status register) addressing.
+func (cpu *cpu) resolveSpecialEA(op *operand, special operandKind) error {
+	op.kind = operandMemory
+	switch op.reg {
+	case 0:
+		address, err := cpu.popPc(Word)
+		if err != nil {
+			return err
+		}
+		op.address = uint32(int32(int16(address)))
+	case 1:
+		address, err := cpu.popPc(Long)
+		if err != nil {
+			return err
+		}
+		op.address = address
+	case 2:
+		basePC := cpu.regs.PC
+		offset, err := cpu.popPc(Word)
+		if err != nil {
+			return err
+		}
+		op.address = uint32(int32(basePC) + int32(int16(offset)))
+	case 3:
+		address, err := ix68000(cpu, cpu.regs.PC)
+		if err != nil {
+			return err
+		}
+		op.address = address
+	case 4:
+		op.kind = special
+		if special == operandStatusRegister {
+			return nil
+		}
+		readSize := op.size
+		if readSize == Byte {
+			readSize = Word
+		}
+		value, err := cpu.popPc(readSize)
+		if err != nil {
+			return err
+		}
+		op.value = value & op.size.mask()
+	default:
+		return fmt.Errorf("invalid effective address mode 7 register %d", op.reg)
+	}
+	return nil
 }
 
 func x(ir uint16) uint16 { return uint16(opcodeMetaTable[ir].x) }
@@ -192,226 +186,46 @@ func dy(cpu *cpu) *uint32  { return (*uint32)(unsafe.Pointer(&cpu.regs.D[y(cpu.r
 func ax(cpu *cpu) *uint32 { return &cpu.regs.A[x(cpu.regs.IR)] }
 func ay(cpu *cpu) *uint32 { return &cpu.regs.A[y(cpu.regs.IR)] }
 
-// -------------------------------------------------------------------
-// register direct
-
-func (ea *eaRegister) init(cpu *cpu, o Size) (modifier, error) {
-	ea.cpu, ea.size = cpu, o
-	return ea, nil
-}
-
-func (ea *eaRegister) read() (uint32, error) {
-	return *ea.areg(ea.cpu) & ea.size.mask(), nil
-}
-
-func (ea *eaRegister) write(v uint32) error {
-	reg := ea.areg(ea.cpu)
-	mask := ea.size.mask()
-	*reg = (*reg & ^mask) | (v & mask)
-	return nil
-}
-
-func (ea *eaRegister) computedAddress() uint32 {
-	panic("no address in register addressing mode")
-}
-
-// -------------------------------------------------------------------
-// Address register indirect
-
-func (ea *eaRegisterIndirect) init(cpu *cpu, o Size) (modifier, error) {
-	ea.cpu, ea.size, ea.address = cpu, o, *ea.areg(cpu)
-	return ea, nil
-}
-
-func (ea *eaRegisterIndirect) read() (uint32, error) {
-	return ea.cpu.read(ea.size, ea.address)
-}
-
-func (ea *eaRegisterIndirect) write(v uint32) error {
-	return ea.cpu.write(ea.size, ea.address, v)
-}
-
-func (ea *eaRegisterIndirect) computedAddress() uint32 {
-	return ea.address
-}
-
-// -------------------------------------------------------------------
-// Post increment
-
-func (ea *eaPostIncrement) init(cpu *cpu, o Size) (modifier, error) {
-	ea.cpu, ea.size, ea.address = cpu, o, *ea.areg(cpu)
-	incr := uint32(o)
-	if o == Byte && ea.areg(cpu) == &cpu.regs.A[7] {
-		incr = 2
+func (op *operand) read() (uint32, error) {
+	switch op.kind {
+	case operandDataRegister:
+		return uint32(op.cpu.regs.D[op.reg]) & op.size.mask(), nil
+	case operandAddressRegister:
+		return op.cpu.regs.A[op.reg] & op.size.mask(), nil
+	case operandMemory:
+		return op.cpu.read(op.size, op.address)
+	case operandImmediate:
+		return op.value, nil
+	default:
+		return uint32(op.cpu.regs.SR) & op.size.mask(), nil
+	}
+}
+
+func (op *operand) write(v uint32) error {
+	mask := op.size.mask()
+	switch op.kind {
+	case operandDataRegister:
+		reg := (*uint32)(unsafe.Pointer(&op.cpu.regs.D[op.reg]))
+		*reg = (*reg &^ mask) | (v & mask)
+	case operandAddressRegister:
+		reg := &op.cpu.regs.A[op.reg]
+		*reg = (*reg &^ mask) | (v & mask)
+	case operandMemory:
+		return op.cpu.write(op.size, op.address, v)
+	case operandImmediate:
+		panic("write on immediate addressing mode")
+	default:
+		srMask := uint16(mask)
+		op.cpu.regs.SR = (op.cpu.regs.SR &^ srMask) | (uint16(v) & srMask)
 	}
-	*ea.areg(cpu) += incr
-	return ea, nil
-}
-
-func (ea *eaPostIncrement) read() (uint32, error) {
-	return ea.cpu.read(ea.size, ea.address)
-}
-
-func (ea *eaPostIncrement) write(v uint32) error {
-	return ea.cpu.write(ea.size, ea.address, v)
-}
-
-// -------------------------------------------------------------------
-// Pre decrement
-
-func (ea *eaPreDecrement) init(cpu *cpu, o Size) (modifier, error) {
-	decr := uint32(o)
-	if o == Byte && ea.areg(cpu) == &cpu.regs.A[7] {
-		decr = 2
-	}
-	*ea.areg(cpu) -= decr
-	ea.cpu, ea.size, ea.address = cpu, o, *ea.areg(cpu)
-	return ea, nil
-}
-
-func (ea *eaPreDecrement) read() (uint32, error) {
-	return ea.cpu.read(ea.size, ea.address)
-}
-
-func (ea *eaPreDecrement) write(v uint32) error {
-	return ea.cpu.write(ea.size, ea.address, v)
-}
-
-// -------------------------------------------------------------------
-// Displacement
-
-func (ea *eaDisplacement) init(cpu *cpu, o Size) (modifier, error) {
-	ea.cpu, ea.size = cpu, o
-	offset, err := cpu.popPc(Word)
-	if err != nil {
-		return nil, err
-	}
-	ea.address = uint32(int32(*ea.areg(cpu)) + int32(int16(offset)))
-	return ea, nil
-}
-
-func (ea *eaDisplacement) computedAddress() uint32 {
-	return ea.address
-}
-
-func (ea *eaPCDisplacement) init(cpu *cpu, o Size) (modifier, error) {
-	ea.cpu, ea.size = cpu, o
-	basePC := cpu.regs.PC
-	offset, err := cpu.popPc(Word)
-	if err != nil {
-		return nil, err
-	}
-	ea.address = uint32(int32(basePC) + int32(int16(offset)))
-	return ea, nil
-}
-
-func (ea *eaPCDisplacement) computedAddress() uint32 {
-	return ea.address
-}
-
-// -------------------------------------------------------------------
-// Indirect + index
-
-func (ea *eaIndirectIndex) init(cpu *cpu, o Size) (modifier, error) {
-	ea.cpu, ea.size = cpu, o
-	address, err := ea.index(cpu, *ea.areg(cpu))
-	if err != nil {
-		return nil, err
-	}
-	ea.address = address
-	return ea, nil
-}
-
-func (ea *eaPCIndirectIndex) init(cpu *cpu, o Size) (modifier, error) {
-	ea.cpu, ea.size = cpu, o
-	basePC := cpu.regs.PC
-	address, err := ea.index(cpu, basePC)
-	if err != nil {
-		return nil, err
-	}
-	ea.address = address
-	return ea, nil
-}
-
-// -------------------------------------------------------------------
-// absolute word and long
-
-func (ea *eaAbsolute) init(cpu *cpu, o Size) (modifier, error) {
-	ea.cpu, ea.size = cpu, o
-	address, err := cpu.popPc(ea.eaSize)
-	if err != nil {
-		return nil, err
-	}
-	if ea.eaSize == Word {
-		address = uint32(int32(int16(address)))
-	}
-	ea.address = address
-	return ea, nil
-}
-
-func (ea *eaAbsolute) read() (uint32, error) {
-	return ea.cpu.read(ea.size, ea.address)
-}
-
-func (ea *eaAbsolute) write(v uint32) error {
-	return ea.cpu.write(ea.size, ea.address, v)
-}
-
-func (ea *eaAbsolute) computedAddress() uint32 {
-	return ea.address
-}
-
-// -------------------------------------------------------------------
-// immediate
-
-func (ea *eaImmediate) init(cpu *cpu, o Size) (modifier, error) {
-	readSize := o
-	if o == Byte {
-		readSize = Word
-	}
-	value, err := cpu.popPc(readSize)
-	if err != nil {
-		return nil, err
-	}
-	if o == Byte {
-		value &= 0xff
-	}
-	ea.value = value
-	return ea, nil
-}
-
-func (ea *eaImmediate) read() (uint32, error) {
-	return ea.value, nil
-}
-
-func (ea *eaImmediate) write(v uint32) error {
-	panic("write on immediate addressing mode")
-}
-
-func (ea *eaImmediate) computedAddress() uint32 {
-	panic("no adress in immediate addressing mode")
-}
-
-// -------------------------------------------------------------------
-// sr
-
-func (ea *eaStatusRegister) init(cpu *cpu, o Size) (modifier, error) {
-	ea.sr = &cpu.regs.SR
-	return ea, nil
-}
-
-func (ea *eaStatusRegister) read() (uint32, error) {
-	return uint32(*ea.sr) & ea.size.mask(), nil
-}
-
-func (ea *eaStatusRegister) write(v uint32) error {
-	mask := uint16(ea.size.mask())
-	*ea.sr = (*ea.sr & ^mask) | (uint16(v) & mask)
 	return nil
 }
 
-func (ea *eaStatusRegister) computedAddress() uint32 {
-	panic("no adress in status register addressing mode")
+func (op *operand) computedAddress() uint32 {
+	if op.kind != operandMemory {
+		panic("no address in register, immediate, or status register addressing mode")
+	}
+	return op.address
 }
 
 // -------------------------------------------------------------------
@@ -4,10 +4,10 @@ import "testing"
 
 func TestEARegisterDirectReadWrite(t *testing.T) {
 	cpu, _ := newEnvironment(t)
-	cpu.regs.IR = 0x0001 // y=1 selects D1
+	cpu.regs.IR = 0x0001 // mode 0, y=1 selects D1
 	cpu.regs.D[1] = -0x10000
 
-	ea, err := (&eaRegister{areg: dy}).init(cpu, Word)
+	ea, err := cpu.ResolveSrcEA(Word)
 	if err != nil {
 		t.Fatalf("init failed: %v", err)
 	}
@@ -27,10 +27,10 @@ func TestEARegisterDirectReadWrite(t *testing.T) {
 
 func TestEAPostIncrementUpdatesAddressAndRegister(t *testing.T) {
 	cpu, _ := newEnvironment(t)
-	cpu.regs.IR = 0x0000 // y=0 selects A0
+	cpu.regs.IR = 0x0018 // mode 3, y=0 selects (A0)+
 	cpu.regs.A[0] = 0x2000
 
-	ea, err := (&eaPostIncrement{eaRegisterIndirect{eaRegister{areg: ay}, 0}}).init(cpu, Word)
+	ea, err := cpu.ResolveSrcEA(Word)
 	if err != nil {
 		t.Fatalf("init failed: %v", err)
 	}
@@ -45,10 +45,10 @@ func TestEAPostIncrementUpdatesAddressAndRegister(t *testing.T) {
 
 func TestEAPreDecrementUsesUpdatedAddress(t *testing.T) {
 	cpu, ram := newEnvironment(t)
-	cpu.regs.IR = 0x0000 // y=0 selects A0
+	cpu.regs.IR = 0x0020 // mode 4, y=0 selects -(A0)
 	cpu.regs.A[0] = 0x2000
 
-	ea, err := (&eaPreDecrement{eaRegisterIndirect{eaRegister{areg: ay}, 0}}).init(cpu, Byte)
+	ea, err := cpu.ResolveSrcEA(Byte)
 	if err != nil {
 		t.Fatalf("init failed: %v", err)
 	}
@@ -70,14 +70,14 @@ func TestEAPreDecrementUsesUpdatedAddress(t *testing.T) {
 
 func TestEADisplacementUsesOffset(t *testing.T) {
 	cpu, _ := newEnvironment(t)
-	cpu.regs.IR = 0x0000 // y=0 selects A0
+	cpu.regs.IR = 0x0028 // mode 5, y=0 selects (d16,A0)
 	cpu.regs.A[0] = 0x1000
 
 	if err := cpu.bus.Write(Word, cpu.regs.PC, 0xFFFE); err != nil {
 		t.Fatalf("failed to write displacement: %v", err)
 	}
 
-	ea, err := (&eaDisplacement{eaRegisterIndirect{eaRegister{areg: ay}, 0}}).init(cpu, Word)
+	ea, err := cpu.ResolveSrcEA(Word)
 	if err != nil {
 		t.Fatalf("init failed: %v", err)
 	}
@@ -92,12 +92,13 @@ func TestEADisplacementUsesOffset(t *testing.T) {
 
 func TestEAPCDisplacementUsesProgramCounter(t *testing.T) {
 	cpu, _ := newEnvironment(t)
+	cpu.regs.IR = 0x003a // mode 7/2 selects (d16,PC)
 
 	if err := cpu.bus.Write(Word, cpu.regs.PC, 0x0004); err != nil {
 		t.Fatalf("failed to write displacement: %v", err)
 	}
 
-	ea, err := (&eaPCDisplacement{eaDisplacement{eaRegisterIndirect{eaRegister{areg: nil}, 0}}}).init(cpu, Word)
+	ea, err := cpu.ResolveSrcEA(Word)
 	if err != nil {
 		t.Fatalf("init failed: %v", err)
 	}
@@ -112,11 +113,12 @@ func TestEAPCDisplacementUsesProgramCounter(t *testing.T) {
 
 func TestEAAbsoluteWordAndLong(t *testing.T) {
 	cpu, _ := newEnvironment(t)
+	cpu.regs.IR = 0x0038 // mode 7/0 selects (xxx).W
 
 	if err := cpu.bus.Write(Word, cpu.regs.PC, 0x1234); err != nil {
 		t.Fatalf("write absolute word failed: %v", err)
 	}
-	eaWord, err := (&eaAbsolute{eaSize: Word}).init(cpu, Word)
+	eaWord, err := cpu.ResolveSrcEA(Word)
 	if err != nil {
 		t.Fatalf("init word failed: %v", err)
 	}
@@ -127,7 +129,7 @@ func TestEAAbsoluteWordAndLong(t *testing.T) {
 	if err := cpu.bus.Write(Word, cpu.regs.PC, 0x8001); err != nil {
 		t.Fatalf("write signed absolute word failed: %v", err)
 	}
-	eaWordSigned, err := (&eaAbsolute{eaSize: Word}).init(cpu, Word)
+	eaWordSigned, err := cpu.ResolveSrcEA(Word)
 	if err != nil {
 		t.Fatalf("init signed word failed: %v", err)
 	}
@@ -138,7 +140,8 @@ func TestEAAbsoluteWordAndLong(t *testing.T) {
 	if err := cpu.bus.Write(Long, cpu.regs.PC, 0xAABBCCDD); err != nil {
 		t.Fatalf("write absolute long failed: %v", err)
 	}
-	eaLong, err := (&eaAbsolute{eaSize: Long}).init(cpu, Long)
+	cpu.regs.IR = 0x0039 // mode 7/1 selects (xxx).L
+	eaLong, err := cpu.ResolveSrcEA(Long)
 	if err != nil {
 		t.Fatalf("init long failed: %v", err)
 	}
@@ -189,12 +192,13 @@ func TestEAAbsoluteWordUsesSignExtendedAddressForAccess(t *testing.T) {
 
 func TestEAImmediateReadsFromPC(t *testing.T) {
 	cpu, _ := newEnvironment(t)
+	cpu.regs.IR = 0x003c // mode 7/4 selects #<data>
 
 	if err := cpu.bus.Write(Word, cpu.regs.PC, 0x00FF); err != nil {
 		t.Fatalf("write immediate failed: %v", err)
 	}
 
-	ea, err := (&eaImmediate{}).init(cpu, Word)
+	ea, err := cpu.ResolveSrcEA(Word)
 	if err != nil {
 		t.Fatalf("init failed: %v", err)
 	}
@@ -210,8 +214,9 @@ func TestEAImmediateReadsFromPC(t *testing.T) {
 func TestEAStatusRegisterReadWrite(t *testing.T) {
 	cpu, _ := newEnvironment(t)
 	cpu.regs.SR = 0xAAAA
+	cpu.regs.IR = 0x003c // mode 7/4 selects SR for ResolveSrcEA2
 
-	eaByte, err := (&eaStatusRegister{size: Byte}).init(cpu, Byte)
+	eaByte, err := cpu.ResolveSrcEA2(Byte)
 	if err != nil {
 		t.Fatalf("init byte failed: %v", err)
 	}
@@ -225,7 +230,7 @@ func TestEAStatusRegisterReadWrite(t *testing.T) {
 		t.Fatalf("byte write corrupted SR: %04x", cpu.regs.SR)
 	}
 
-	eaWord, err := (&eaStatusRegister{size: Word}).init(cpu, Word)
+	eaWord, err := cpu.ResolveSrcEA2(Word)
 	if err != nil {
 		t.Fatalf("init word failed: %v", err)
 	}
@@ -239,7 +244,7 @@ func TestEAStatusRegisterReadWrite(t *testing.T) {
 
 func TestEAIndirectIndexUsesIndexAndDisplacement(t *testing.T) {
 	cpu, _ := newEnvironment(t)
-	cpu.regs.IR = 0x0000 // y=0 selects A0
+	cpu.regs.IR = 0x0030 // mode 6, y=0 selects (d8,A0,Xn)
 	cpu.regs.A[0] = 0x3000
 	cpu.regs.D[0] = 0x10
 
@@ -247,7 +252,7 @@ func TestEAIndirectIndexUsesIndexAndDisplacement(t *testing.T) {
 		t.Fatalf("failed to write index extension: %v", err)
 	}
 
-	ea, err := (&eaIndirectIndex{eaRegisterIndirect{eaRegister{areg: ay}, 0}, ix68000}).init(cpu, Word)
+	ea, err := cpu.ResolveSrcEA(Word)
 	if err != nil {
 		t.Fatalf("init failed: %v", err)
 	}
@@ -262,13 +267,14 @@ func TestEAIndirectIndexUsesIndexAndDisplacement(t *testing.T) {
 
 func TestEAPCIndirectIndexUsesExtensionWordAddressAsBase(t *testing.T) {
 	cpu, _ := newEnvironment(t)
+	cpu.regs.IR = 0x003b // mode 7/3 selects (d8,PC,Xn)
 	cpu.regs.D[0] = 0x10
 
 	if err := cpu.bus.Write(Word, cpu.regs.PC, 0x0801); err != nil {
 		t.Fatalf("failed to write PC index extension: %v", err)
 	}
 
-	ea, err := (&eaPCIndirectIndex{eaIndirectIndex{eaRegisterIndirect{eaRegister{areg: nil}, 0}, ix68000}}).init(cpu, Word)
+	ea, err := cpu.ResolveSrcEA(Word)
 	if err != nil {
 		t.Fatalf("init failed: %v", err)
 	}
@@ -280,3 +286,32 @@ func TestEAPCIndirectIndexUsesExtensionWordAddressAsBase(t *testing.T) {
 		t.Fatalf("PC not advanced after PC index extension: %04x", cpu.regs.PC)
 	}
 }
+
+func TestEAInvalidModeSevenEncodingFails(t *testing.T) {
+	cpu, _ := newEnvironment(t)
+	cpu.regs.IR = 0x003d // mode 7/5 is not a valid 68000 addressing mode
+
+	if _, err := cpu.ResolveSrcEA(Word); err == nil {
+		t.Fatalf("expected error for invalid mode 7 register field")
+	}
+}
+
+func TestEAResolveDoesNotAllocate(t *testing.T) {
+	cpu, _ := newEnvironment(t)
+	cpu.regs.IR = 0x0018 // (A0)+
+	cpu.regs.A[0] = 0x3000
+
+	allocs := testing.AllocsPerRun(100, func() {
+		cpu.regs.A[0] = 0x3000
+		ea, err := cpu.ResolveSrcEA(Word)
+		if err != nil {
+			t.Fatalf("resolve failed: %v", err)
+		}
+		if err := ea.write(0x1234); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("EA resolution allocated %.1f times per run", allocs)
+	}
+}
@@ -0,0 +1,70 @@
+package m68kemu
+
+// EventDelivery selects when scheduled events fire relative to instructions.
+type EventDelivery int
+
+const (
+	// EventDeliveryImmediate advances the scheduler whenever an instruction
+	// adds cycles, so events can fire between the bus cycles of an
+	// instruction.
+	EventDeliveryImmediate EventDelivery = iota
+	// EventDeliveryBoundary advances the scheduler only at instruction
+	// boundaries. Events due during an instruction fire after it completes and
+	// before the CPU samples interrupts, so an interrupt raised by an event is
+	// taken at exactly that boundary. Callbacks still receive the event's due
+	// cycle; CycleScheduler.Overshoot tells how far the boundary lies past it.
+	EventDeliveryBoundary
+)
+
+// FrameResult describes one RunFrame call.
+type FrameResult struct {
+	// Cycles is the number of cycles executed during the frame.
+	Cycles uint64
+	// Overshoot is how far the last instruction ran past the end of the
+	// frame. The next frame is shortened by the same amount.
+	Overshoot uint64
+}
+
+// SetEventDelivery switches between immediate and instruction-boundary event
+// delivery.
+func (cpu *cpu) SetEventDelivery(mode EventDelivery) {
+	cpu.boundaryEvents = mode == EventDeliveryBoundary
+	cpu.syncScheduler()
+}
+
+// RunFrame runs one frame of cycles. Frames are laid end to end from the cycle
+// count at the first call, so the cycles an instruction spends past the end
+// of one frame are taken from the next and frames never drift against the
+// emulated clock. Call it once per displayed frame, for example with 160256
+// cycles for a 50 Hz Atari ST.
+func (cpu *cpu) RunFrame(cycles uint64) (FrameResult, error) {
+	if !cpu.frameValid || cpu.cycles < cpu.frameEnd {
+		// First frame, or the cycle counter was reset.
+		cpu.frameEnd = cpu.cycles
+		cpu.frameValid = true
+	}
+	start := cpu.cycles
+	target := cpu.frameEnd + cycles
+	cpu.frameEnd = target
+
+	var err error
+	if target > cpu.cycles {
+		err = cpu.RunCycles(target - cpu.cycles)
+	}
+	result := FrameResult{Cycles: cpu.cycles - start}
+	if cpu.cycles > target {
+		result.Overshoot = cpu.cycles - target
+	}
+	return result, err
+}
+
+// syncScheduler brings a scheduler that only advances at instruction
+// boundaries up to the CPU's cycle count, firing the events that fell due.
+func (cpu *cpu) syncScheduler() {
+	if !cpu.boundaryEvents || cpu.scheduler == nil {
+		return
+	}
+	if now := cpu.scheduler.now; cpu.cycles > now {
+		cpu.scheduler.Advance(cpu.cycles - now)
+	}
+}
@@ -0,0 +1,132 @@
+package m68kemu
+
+import "testing"
+
+func loadProgram(t *testing.T, ram *RAM, address uint32, src string) {
+	t.Helper()
+	for i, b := range assemble(t, src) {
+		if err := ram.Write(Byte, address+uint32(i), uint32(b)); err != nil {
+			t.Fatalf("write code: %v", err)
+		}
+	}
+}
+
+func TestBoundaryEventDeliveryFiresAfterInstruction(t *testing.T) {
+	cpu, ram := newEnvironment(t)
+	loadProgram(t, ram, cpu.regs.PC, "MOVEQ #100,D0\nMOVEQ #7,D1\nDIVU D1,D0\nNOP\n")
+	loadProgram(t, ram, 0x3000, "MOVEQ #1,D7\nRTE\n")
+	if err := ram.Write(Long, uint32(autoVectorBase+4)<<2, 0x3000); err != nil {
+		t.Fatalf("install handler: %v", err)
+	}
+	cpu.regs.SR = 0x2000
+
+	scheduler := NewCycleScheduler()
+	cpu.SetScheduler(scheduler)
+	cpu.SetEventDelivery(EventDeliveryBoundary)
+
+	var firedAt, cpuCycles, overshoot uint64
+	var quotient int32
+	scheduler.Schedule(12, func(now uint64) {
+		firedAt, cpuCycles, overshoot = now, cpu.cycles, scheduler.Overshoot()
+		quotient = cpu.regs.D[0]
+		_ = cpu.RequestInterrupt(4, nil)
+	})
+
+	if err := cpu.RunInstructions(2); err != nil {
+		t.Fatalf("RunInstructions failed: %v", err)
+	}
+	start := cpu.cycles
+	if err := cpu.Step(); err != nil {
+		t.Fatalf("DIVU failed: %v", err)
+	}
+	boundary := start + uint64(opcodeCycleTable[cpu.regs.IR])
+	if quotient != 100/7|(100%7)<<16 || firedAt != 12 {
+		t.Fatalf("event fired at %d with D0 = %08x, want 12 after DIVU completed", firedAt, quotient)
+	}
+	if cpuCycles < boundary || overshoot != cpuCycles-12 {
+		t.Fatalf("event saw CPU at %d with overshoot %d, want the boundary at or after %d and overshoot %d", cpuCycles, overshoot, boundary, cpuCycles-12)
+	}
+	if cpu.regs.PC != 0x3000 {
+		t.Fatalf("PC = %06x, want the interrupt taken at the DIVU boundary", cpu.regs.PC)
+	}
+	if scheduler.Now() != cpu.cycles {
+		t.Fatalf("scheduler at %d, want it synced to the CPU at %d", scheduler.Now(), cpu.cycles)
+	}
+}
+
+func TestBoundaryEventDeliveryMatchesImmediateDelivery(t *testing.T) {
+	src := `
+        MOVE.W  #$2000,SR
+        MOVEQ   #0,D0
+loop:   ADDQ.L  #1,D0
+        MULU    D0,D1
+        MOVE.L  D0,$6000
+        BRA.S   loop
+`
+	run := func(mode EventDelivery, engine ExecutionEngine) *cpu {
+		cpu, ram := newEnvironment(t)
+		loadProgram(t, ram, cpu.regs.PC, src)
+		loadProgram(t, ram, 0x3000, "ADDQ.W #1,$6004\nRTE\n")
+		if err := ram.Write(Long, uint32(autoVectorBase+3)<<2, 0x3000); err != nil {
+			t.Fatalf("install handler: %v", err)
+		}
+		scheduler := NewCycleScheduler()
+		cpu.SetScheduler(scheduler)
+		cpu.SetEventDelivery(mode)
+		cpu.SetExecutionEngine(engine)
+		var tick func(uint64)
+		tick = func(now uint64) {
+			_ = cpu.RequestInterrupt(3, nil)
+			scheduler.Schedule(now+333, tick)
+		}
+		scheduler.Schedule(333, tick)
+		if err := cpu.RunCycles(50_000); err != nil {
+			t.Fatalf("RunCycles failed: %v", err)
+		}
+		return cpu
+	}
+
+	want := run(EventDeliveryImmediate, EngineInterpreter)
+	for _, engine := range []ExecutionEngine{EngineInterpreter, EngineBlockCache} {
+		got := run(EventDeliveryBoundary, engine)
+		if got.regs != want.regs || got.cycles != want.cycles {
+			t.Fatalf("engine %d: boundary delivery diverged\nwant %+v cycles %d\ngot  %+v cycles %d", engine, want.regs, want.cycles, got.regs, got.cycles)
+		}
+		wantCount, _ := want.read(Word, 0x6004)
+		gotCount, _ := got.read(Word, 0x6004)
+		if gotCount != wantCount || gotCount == 0 {
+			t.Fatalf("engine %d: %d interrupts, want %d", engine, gotCount, wantCount)
+		}
+		if got.scheduler.Now() != got.cycles {
+			t.Fatalf("engine %d: scheduler at %d, CPU at %d after RunCycles", engine, got.scheduler.Now(), got.cycles)
+		}
+	}
+}
+
+func TestRunFrameCarriesOvershoot(t *testing.T) {
+	cpu, ram := newEnvironment(t)
+	loadProgram(t, ram, cpu.regs.PC, "MOVEQ #3,D1\nloop: DIVU D1,D0\nBRA.S loop\n")
+	cpu.SetScheduler(NewCycleScheduler())
+	cpu.SetEventDelivery(EventDeliveryBoundary)
+
+	const frame = 1000
+	start := cpu.cycles
+	var carried, total uint64
+	for i := range 20 {
+		result, err := cpu.RunFrame(frame)
+		if err != nil {
+			t.Fatalf("RunFrame failed: %v", err)
+		}
+		if result.Cycles+carried != frame+result.Overshoot {
+			t.Fatalf("frame %d ran %d cycles with overshoot %d after carrying %d", i, result.Cycles, result.Overshoot, carried)
+		}
+		carried = result.Overshoot
+		total += result.Cycles
+	}
+	if carried == 0 {
+		t.Fatalf("test program never overshot a frame")
+	}
+	if cpu.cycles-start != total || total != 20*frame+carried {
+		t.Fatalf("ran %d cycles in 20 frames, want %d", total, 20*frame+carried)
+	}
+}
@@ -0,0 +1,534 @@
+package m68kemu
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	hunkUnit         = 0x3e7
+	hunkName         = 0x3e8
+	hunkCode         = 0x3e9
+	hunkData         = 0x3ea
+	hunkBSS          = 0x3eb
+	hunkReloc32      = 0x3ec
+	hunkExt          = 0x3ef
+	hunkSymbol       = 0x3f0
+	hunkDebug        = 0x3f1
+	hunkEnd          = 0x3f2
+	hunkHeader       = 0x3f3
+	hunkDrel32       = 0x3f7
+	hunkReloc32Short = 0x3fc
+
+	hunkTypeMask  = 0x3fffffff
+	hunkFlagsMask = 0xc0000000
+
+	hunkExtDef = 1
+	hunkExtAbs = 2
+	hunkExtRes = 3
+
+	// hunkSegmentHeader is the AmigaDOS LoadSeg prefix in front of every
+	// segment: the allocation size followed by the BPTR to the next segment.
+	hunkSegmentHeader = 8
+	hunkAlignment     = 8
+
+	// hunkRelocChunk bounds how many relocation offsets are allocated ahead
+	// of reading them, so a corrupt count cannot exhaust memory.
+	hunkRelocChunk = 1024
+)
+
+// HunkSegmentKind identifies the contents of one loaded hunk.
+type HunkSegmentKind int
+
+const (
+	HunkSegmentCode HunkSegmentKind = iota
+	HunkSegmentData
+	HunkSegmentBSS
+)
+
+// HunkLoadOptions controls where LoadHunk places the segments of a hunk file.
+type HunkLoadOptions struct {
+	// Region is the memory the loader may allocate segments from.
+	Region AddressRange
+	// UseWrite forces ordinary bus writes even when the bus supports Poke.
+	UseWrite bool
+}
+
+// HunkSegment describes one hunk after it has been placed in memory.
+type HunkSegment struct {
+	Kind        HunkSegmentKind
+	Name        string
+	Address     uint32
+	Size        uint32
+	MemoryFlags uint32
+}
+
+// HunkSymbol is a HUNK_SYMBOL or exported HUNK_EXT definition resolved to an
+// absolute address.
+type HunkSymbol struct {
+	Name    string
+	Segment int
+	Offset  uint32
+	Address uint32
+}
+
+// HunkProgram reports the result of LoadHunk. SegList is the BCPL pointer to
+// the first segment, as returned by dos.library LoadSeg, so a stub OS can hand
+// it to guest code unchanged.
+type HunkProgram struct {
+	Segments []HunkSegment
+	Symbols  []HunkSymbol
+	SegList  uint32
+	Entry    uint32
+}
+
+type hunkReloc struct {
+	target  int
+	offsets []uint32
+}
+
+type parsedHunk struct {
+	kind    HunkSegmentKind
+	name    string
+	flags   uint32
+	memSize uint32
+	data    []byte
+	relocs  []hunkReloc
+	symbols []HunkSymbol
+}
+
+type hunkReader struct {
+	r io.Reader
+	// limit is the size of the load region; no segment can be larger.
+	limit uint64
+}
+
+// LoadHunk reads an AmigaOS hunk executable (HUNK_HEADER) or object file
+// (HUNK_UNIT), allocates its segments in options.Region using the LoadSeg
+// layout, applies HUNK_RELOC32 relocations, and collects its symbols.
+// Overlays and unresolved external references are not supported.
+func LoadHunk(bus AddressBus, r io.Reader, options HunkLoadOptions) (HunkProgram, error) {
+	reader := &hunkReader{r: r, limit: uint64(options.Region.End) - uint64(options.Region.Start) + 1}
+	first, err := reader.long()
+	if err != nil {
+		return HunkProgram{}, fmt.Errorf("read hunk file type: %w", err)
+	}
+
+	var hunks []*parsedHunk
+	switch first & hunkTypeMask {
+	case hunkHeader:
+		hunks, err = reader.readHeader()
+	case hunkUnit:
+		if _, err = reader.string(); err == nil {
+			hunks, err = reader.readHunks(nil)
+		}
+	default:
+		return HunkProgram{}, fmt.Errorf("not a hunk file: type %08x", first)
+	}
+	if err != nil {
+		return HunkProgram{}, err
+	}
+	if len(hunks) == 0 {
+		return HunkProgram{}, fmt.Errorf("hunk file has no segments")
+	}
+
+	return placeHunks(bus, hunks, options)
+}
+
+func (h *hunkReader) readHeader() ([]*parsedHunk, error) {
+	// Resident library names are not used by LoadSeg; skip any that are present.
+	if err := h.skipString(); err != nil {
+		return nil, fmt.Errorf("read resident library list: %w", err)
+	}
+	tableSize, err := h.long()
+	if err != nil {
+		return nil, err
+	}
+	firstHunk, err := h.long()
+	if err != nil {
+		return nil, err
+	}
+	lastHunk, err := h.long()
+	if err != nil {
+		return nil, err
+	}
+	if lastHunk < firstHunk || lastHunk-firstHunk+1 > tableSize || tableSize > 0xffff {
+		return nil, fmt.Errorf("invalid hunk table %d..%d of %d", firstHunk, lastHunk, tableSize)
+	}
+
+	sizes := make([]*parsedHunk, lastHunk-firstHunk+1)
+	for i := range sizes {
+		value, err := h.long()
+		if err != nil {
+			return nil, err
+		}
+		flags := value & hunkFlagsMask
+		if flags == hunkFlagsMask {
+			if flags, err = h.long(); err != nil {
+				return nil, err
+			}
+		}
+		sizes[i] = &parsedHunk{flags: flags, memSize: (value & hunkTypeMask) << 2}
+	}
+	return h.readHunks(sizes)
+}
+
+// readHunks parses hunk bodies. Executables pass the preallocated sizes from
+// HUNK_HEADER; object files allocate each hunk as it is encountered.
+func (h *hunkReader) readHunks(hunks []*parsedHunk) ([]*parsedHunk, error) {
+	index := 0
+	name := ""
+	var current *parsedHunk
+	fromHeader := hunks != nil
+
+	for {
+		value, err := h.long()
+		if errors.Is(err, io.EOF) {
+			if current != nil {
+				return nil, fmt.Errorf("hunk %d: missing HUNK_END", index)
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch kind := value & hunkTypeMask; kind {
+		case hunkName:
+			if name, err = h.string(); err != nil {
+				return nil, err
+			}
+		case hunkCode, hunkData, hunkBSS:
+			if current != nil {
+				return nil, fmt.Errorf("hunk %d: missing HUNK_END", index)
+			}
+			if index >= len(hunks) {
+				if fromHeader {
+					return nil, fmt.Errorf("hunk %d not declared in HUNK_HEADER", index)
+				}
+				hunks = append(hunks, &parsedHunk{flags: value & hunkFlagsMask})
+			}
+			current = hunks[index]
+			current.name, name = name, ""
+			if err := h.readSegment(current, kind); err != nil {
+				return nil, fmt.Errorf("hunk %d: %w", index, err)
+			}
+		case hunkReloc32:
+			if current == nil {
+				return nil, fmt.Errorf("HUNK_RELOC32 outside of a hunk")
+			}
+			if err := h.readRelocs(current, false); err != nil {
+				return nil, fmt.Errorf("hunk %d: %w", index, err)
+			}
+		case hunkReloc32Short, hunkDrel32:
+			if current == nil {
+				return nil, fmt.Errorf("HUNK_RELOC32SHORT outside of a hunk")
+			}
+			if err := h.readRelocs(current, true); err != nil {
+				return nil, fmt.Errorf("hunk %d: %w", index, err)
+			}
+		case hunkSymbol:
+			if current == nil {
+				return nil, fmt.Errorf("HUNK_SYMBOL outside of a hunk")
+			}
+			if err := h.readSymbols(current, index); err != nil {
+				return nil, fmt.Errorf("hunk %d: %w", index, err)
+			}
+		case hunkExt:
+			if current == nil {
+				return nil, fmt.Errorf("HUNK_EXT outside of a hunk")
+			}
+			if err := h.readExternals(current, index); err != nil {
+				return nil, fmt.Errorf("hunk %d: %w", index, err)
+			}
+		case hunkDebug:
+			count, err := h.long()
+			if err != nil {
+				return nil, err
+			}
+			if err := h.skip(count); err != nil {
+				return nil, err
+			}
+		case hunkEnd:
+			if current == nil {
+				return nil, fmt.Errorf("HUNK_END outside of a hunk")
+			}
+			current = nil
+			index++
+		default:
+			return nil, fmt.Errorf("hunk %d: unsupported hunk type %08x", index, value)
+		}
+	}
+
+	if index != len(hunks) {
+		return nil, fmt.Errorf("hunk file declares %d hunks but contains %d", len(hunks), index)
+	}
+	return hunks, nil
+}
+
+func (h *hunkReader) readSegment(hunk *parsedHunk, kind uint32) error {
+	value, err := h.long()
+	if err != nil {
+		return err
+	}
+	size := (value & hunkTypeMask) << 2
+	if uint64(size) > h.limit {
+		return fmt.Errorf("hunk segment of %d bytes exceeds the %d byte region", size, h.limit)
+	}
+	hunk.memSize = max(hunk.memSize, size)
+
+	switch kind {
+	case hunkCode:
+		hunk.kind = HunkSegmentCode
+	case hunkData:
+		hunk.kind = HunkSegmentData
+	default:
+		hunk.kind = HunkSegmentBSS
+		return nil
+	}
+
+	hunk.data = make([]byte, size)
+	_, err = io.ReadFull(h.r, hunk.data)
+	return err
+}
+
+func (h *hunkReader) readRelocs(hunk *parsedHunk, short bool) error {
+	read := h.long
+	if short {
+		read = h.word
+	}
+
+	words := 0
+	for {
+		count, err := read()
+		if err != nil {
+			return err
+		}
+		words++
+		if count == 0 {
+			break
+		}
+		target, err := read()
+		if err != nil {
+			return err
+		}
+		reloc := hunkReloc{target: int(target), offsets: make([]uint32, 0, min(count, hunkRelocChunk))}
+		for range count {
+			offset, err := read()
+			if err != nil {
+				return err
+			}
+			reloc.offsets = append(reloc.offsets, offset)
+		}
+		words += 1 + int(count)
+		hunk.relocs = append(hunk.relocs, reloc)
+	}
+
+	// Short relocation blocks are padded to a longword boundary.
+	if short && words%2 != 0 {
+		_, err := h.word()
+		return err
+	}
+	return nil
+}
+
+func (h *hunkReader) readSymbols(hunk *parsedHunk, index int) error {
+	for {
+		name, ok, err := h.optionalString()
+		if err != nil || !ok {
+			return err
+		}
+		value, err := h.long()
+		if err != nil {
+			return err
+		}
+		hunk.symbols = append(hunk.symbols, HunkSymbol{Name: name, Segment: index, Offset: value})
+	}
+}
+
+func (h *hunkReader) readExternals(hunk *parsedHunk, index int) error {
+	for {
+		header, err := h.long()
+		if err != nil {
+			return err
+		}
+		if header == 0 {
+			return nil
+		}
+		name, err := h.name(header & 0xffffff)
+		if err != nil {
+			return err
+		}
+		symbol := HunkSymbol{Name: name, Segment: index}
+
+		switch header >> 24 {
+		case hunkExtDef, hunkExtRes:
+			if symbol.Offset, err = h.long(); err != nil {
+				return err
+			}
+			hunk.symbols = append(hunk.symbols, symbol)
+		case hunkExtAbs:
+			if symbol.Offset, err = h.long(); err != nil {
+				return err
+			}
+			symbol.Segment = -1
+			hunk.symbols = append(hunk.symbols, symbol)
+		default:
+			return fmt.Errorf("unresolved external reference %q", symbol.Name)
+		}
+	}
+}
+
+// placeHunks lays the parsed hunks out back to back inside the region, patches
+// relocations against the final addresses, and stores everything on the bus.
+func placeHunks(bus AddressBus, hunks []*parsedHunk, options HunkLoadOptions) (HunkProgram, error) {
+	base := (options.Region.Start + hunkAlignment - 1) &^ (hunkAlignment - 1)
+	addresses := make([]uint32, len(hunks))
+	next := uint64(base)
+	for i, hunk := range hunks {
+		next = (next + hunkAlignment - 1) &^ (hunkAlignment - 1)
+		addresses[i] = uint32(next) + hunkSegmentHeader
+		next += hunkSegmentHeader + uint64(hunk.memSize)
+	}
+	if len(hunks) != 0 && next-1 > uint64(options.Region.End) {
+		return HunkProgram{}, fmt.Errorf("hunk segments need %d bytes, region %08x-%08x is too small",
+			next-uint64(base), options.Region.Start, options.Region.End)
+	}
+
+	program := HunkProgram{
+		Segments: make([]HunkSegment, len(hunks)),
+		SegList:  (addresses[0] - 4) >> 2,
+		Entry:    addresses[0],
+	}
+	store := newImageStore(bus, ImageOptions{UseWrite: options.UseWrite})
+	for i, hunk := range hunks {
+		for _, reloc := range hunk.relocs {
+			if reloc.target < 0 || reloc.target >= len(hunks) {
+				return HunkProgram{}, fmt.Errorf("hunk %d: relocation against missing hunk %d", i, reloc.target)
+			}
+			for _, offset := range reloc.offsets {
+				if uint64(offset)+4 > uint64(len(hunk.data)) {
+					return HunkProgram{}, fmt.Errorf("hunk %d: relocation offset %08x out of range", i, offset)
+				}
+				value := binary.BigEndian.Uint32(hunk.data[offset:])
+				binary.BigEndian.PutUint32(hunk.data[offset:], value+addresses[reloc.target])
+			}
+		}
+
+		segment := make([]byte, hunkSegmentHeader+hunk.memSize)
+		binary.BigEndian.PutUint32(segment, uint32(len(segment)))
+		if i+1 < len(hunks) {
+			binary.BigEndian.PutUint32(segment[4:], (addresses[i+1]-4)>>2)
+		}
+		copy(segment[hunkSegmentHeader:], hunk.data)
+		if err := store.store(addresses[i]-hunkSegmentHeader, segment); err != nil {
+			return HunkProgram{}, fmt.Errorf("hunk %d: %w", i, err)
+		}
+
+		program.Segments[i] = HunkSegment{
+			Kind:        hunk.kind,
+			Name:        hunk.name,
+			Address:     addresses[i],
+			Size:        hunk.memSize,
+			MemoryFlags: hunk.flags,
+		}
+		for _, symbol := range hunk.symbols {
+			symbol.Address = symbol.Offset
+			if symbol.Segment >= 0 {
+				symbol.Address += addresses[symbol.Segment]
+			}
+			program.Symbols = append(program.Symbols, symbol)
+		}
+	}
+	return program, nil
+}
+
+// Symbol looks up a loaded symbol by name.
+func (p HunkProgram) Symbol(name string) (HunkSymbol, bool) {
+	for _, symbol := range p.Symbols {
+		if symbol.Name == name {
+			return symbol, true
+		}
+	}
+	return HunkSymbol{}, false
+}
+
+func (h *hunkReader) long() (uint32, error) {
+	var buf [4]byte
+	if _, err := io.ReadFull(h.r, buf[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, fmt.Errorf("truncated hunk file: %w", err)
+		}
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(buf[:]), nil
+}
+
+func (h *hunkReader) word() (uint32, error) {
+	var buf [2]byte
+	if _, err := io.ReadFull(h.r, buf[:]); err != nil {
+		return 0, fmt.Errorf("truncated hunk file: %w", err)
+	}
+	return uint32(binary.BigEndian.Uint16(buf[:])), nil
+}
+
+func (h *hunkReader) skip(longs uint32) error {
+	_, err := io.CopyN(io.Discard, h.r, int64(longs)<<2)
+	return err
+}
+
+func (h *hunkReader) string() (string, error) {
+	name, _, err := h.optionalString()
+	return name, err
+}
+
+// optionalString reads a longword-counted string; a zero count reports ok=false.
+func (h *hunkReader) optionalString() (string, bool, error) {
+	count, err := h.long()
+	if err != nil || count == 0 {
+		return "", false, err
+	}
+	name, err := h.name(count)
+	if err != nil {
+		return "", false, err
+	}
+	return name, true, nil
+}
+
+// name reads a string of the given number of longwords. A name cannot be
+// larger than the load region, which bounds the allocation.
+func (h *hunkReader) name(longs uint32) (string, error) {
+	size := uint64(longs) << 2
+	if size > h.limit {
+		return "", fmt.Errorf("hunk name of %d bytes exceeds the %d byte region", size, h.limit)
+	}
+	name := make([]byte, size)
+	if _, err := io.ReadFull(h.r, name); err != nil {
+		return "", err
+	}
+	return trimHunkString(name), nil
+}
+
+// skipString skips a zero-terminated list of longword-counted strings.
+func (h *hunkReader) skipString() error {
+	for {
+		count, err := h.long()
+		if err != nil || count == 0 {
+			return err
+		}
+		if err := h.skip(count); err != nil {
+			return err
+		}
+	}
+}
+
+func trimHunkString(name []byte) string {
+	for i, value := range name {
+		if value == 0 {
+			return string(name[:i])
+		}
+	}
+	return string(name)
+}
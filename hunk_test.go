@@ -0,0 +1,154 @@
+package m68kemu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+type hunkBuilder struct {
+	bytes.Buffer
+}
+
+func (b *hunkBuilder) long(values ...uint32) {
+	for _, value := range values {
+		_ = binary.Write(&b.Buffer, binary.BigEndian, value)
+	}
+}
+
+func (b *hunkBuilder) name(text string) {
+	padded := make([]byte, (len(text)+3)&^3)
+	copy(padded, text)
+	b.long(uint32(len(padded) / 4))
+	b.Write(padded)
+}
+
+func TestLoadHunkExecutableRelocatesAndExposesSymbols(t *testing.T) {
+	code := assemble(t, "LEA $4.L,A0\nMOVE.L (A0),D0\nRTS\n")
+	for len(code)%4 != 0 {
+		code = append(code, 0x4e, 0x71)
+	}
+
+	var file hunkBuilder
+	file.long(hunkHeader, 0, 3, 0, 2)
+	file.long(uint32(len(code)/4), 1, 0x40000000|4)
+
+	file.long(hunkCode, uint32(len(code)/4))
+	file.Write(code)
+	file.long(hunkReloc32, 1, 1, 2, 0)
+	file.long(hunkSymbol)
+	file.name("_start")
+	file.long(0, 0)
+	file.long(hunkEnd)
+
+	file.long(hunkData, 1, 0xcafef00d, hunkEnd)
+	file.long(hunkBSS, 4, hunkEnd)
+
+	ram := NewRAM(0, 0x10000)
+	for address := uint32(0x4000); address < 0x4100; address++ {
+		_ = ram.Write(Byte, address, 0xff)
+	}
+
+	program, err := LoadHunk(NewBus(ram), &file, HunkLoadOptions{Region: AddressRange{Start: 0x4000, End: 0x7fff}})
+	if err != nil {
+		t.Fatalf("LoadHunk failed: %v", err)
+	}
+
+	if len(program.Segments) != 3 {
+		t.Fatalf("segments = %d, want 3", len(program.Segments))
+	}
+	codeSeg, dataSeg, bssSeg := program.Segments[0], program.Segments[1], program.Segments[2]
+	if codeSeg.Address != 0x4008 || program.Entry != codeSeg.Address {
+		t.Fatalf("code segment at %08x entry %08x, want 00004008", codeSeg.Address, program.Entry)
+	}
+	if program.SegList != (codeSeg.Address-4)>>2 {
+		t.Fatalf("seglist BPTR = %08x", program.SegList)
+	}
+	if bssSeg.Kind != HunkSegmentBSS || bssSeg.Size != 16 || bssSeg.MemoryFlags != 0x40000000 {
+		t.Fatalf("bss segment = %+v", bssSeg)
+	}
+
+	if got, _ := ram.Read(Long, codeSeg.Address+2); got != dataSeg.Address+4 {
+		t.Fatalf("relocated LEA operand = %08x, want %08x", got, dataSeg.Address+4)
+	}
+	if got, _ := ram.Read(Long, dataSeg.Address); got != 0xcafef00d {
+		t.Fatalf("data segment = %08x, want cafef00d", got)
+	}
+	if got, _ := ram.Read(Long, bssSeg.Address); got != 0 {
+		t.Fatalf("bss segment not cleared: %08x", got)
+	}
+	if got, _ := ram.Read(Long, codeSeg.Address-4); got != (dataSeg.Address-4)>>2 {
+		t.Fatalf("segment link = %08x, want BPTR to data segment", got)
+	}
+
+	symbol, ok := program.Symbol("_start")
+	if !ok || symbol.Address != codeSeg.Address {
+		t.Fatalf("_start symbol = %+v, %v", symbol, ok)
+	}
+}
+
+func TestLoadHunkObjectRejectsUnresolvedReference(t *testing.T) {
+	var file hunkBuilder
+	file.long(hunkUnit)
+	file.name("test.o")
+	file.long(hunkCode, 1, 0x4e754e75)
+	file.long(hunkExt, 129<<24|1)
+	file.Write([]byte("_foo"))
+	file.long(1, 0, 0)
+	file.long(hunkEnd)
+
+	ram := NewRAM(0, 0x1000)
+	if _, err := LoadHunk(NewBus(ram), &file, HunkLoadOptions{Region: AddressRange{Start: 0x100, End: 0xfff}}); err == nil {
+		t.Fatalf("expected unresolved external reference error")
+	}
+}
+
+func TestLoadHunkRejectsRegionOverflow(t *testing.T) {
+	var file hunkBuilder
+	file.long(hunkHeader, 0, 1, 0, 0, 64)
+	file.long(hunkBSS, 64, hunkEnd)
+
+	ram := NewRAM(0, 0x1000)
+	if _, err := LoadHunk(NewBus(ram), &file, HunkLoadOptions{Region: AddressRange{Start: 0x100, End: 0x17f}}); err == nil {
+		t.Fatalf("expected region overflow error")
+	}
+}
+
+func TestLoadHunkRejectsOversizedCountsBeforeAllocating(t *testing.T) {
+	ram := NewRAM(0, 0x1000)
+	region := HunkLoadOptions{Region: AddressRange{Start: 0x100, End: 0xfff}}
+
+	// A code hunk claiming 4 GiB fails on its size, not on allocation.
+	var file hunkBuilder
+	file.long(hunkUnit)
+	file.name("test.o")
+	file.long(hunkCode, 0x3fffffff, 0x4e754e75)
+	if _, err := LoadHunk(NewBus(ram), &file, region); err == nil {
+		t.Fatalf("expected oversized segment error")
+	}
+
+	// A relocation block claiming 4G offsets ends at the truncated file.
+	file.Reset()
+	file.long(hunkUnit)
+	file.name("test.o")
+	file.long(hunkCode, 1, 0x4e754e75)
+	file.long(hunkReloc32, 0xffffffff, 0, 0)
+	if _, err := LoadHunk(NewBus(ram), &file, region); err == nil {
+		t.Fatalf("expected truncated relocation error")
+	}
+
+	// Unit, symbol, and external names claiming gigabytes fail on their size.
+	for _, tail := range [][]uint32{
+		{hunkUnit, 0x3fffffff},
+		{hunkUnit, 0, hunkCode, 1, 0x4e754e75, hunkSymbol, 0xffffffff},
+		{hunkUnit, 0, hunkCode, 1, 0x4e754e75, hunkExt, hunkExtDef<<24 | 0xffffff},
+	} {
+		file.Reset()
+		file.long(tail...)
+		_, err := LoadHunk(NewBus(ram), &file, region)
+		if err == nil || !strings.Contains(err.Error(), "hunk name") {
+			t.Fatalf("oversized name %x: err = %v, want a size error", tail, err)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package m68kemu
+
+// PollStableDevice lets idle loop detection fast-forward code that polls one
+// of the device's registers. PollStable reports whether reading address keeps
+// returning the same value, without side effects, until the next scheduled
+// event fires.
+type PollStableDevice interface {
+	PollStable(address uint32) bool
+}
+
+type idleLoopKind uint8
+
+const (
+	idleLoopNone idleLoopKind = iota
+	// idleLoopDelay is a DBcc that branches to itself.
+	idleLoopDelay
+	// idleLoopPoll is BTST #n,<ea> or TST <ea> followed by a Bcc.S back to it.
+	idleLoopPoll
+)
+
+// idleLoop describes a busy-wait loop recognised at the program counter.
+type idleLoop struct {
+	kind         idleLoopKind
+	instructions int
+	address      uint32
+	size         Size
+}
+
+// SetIdleLoopDetection enables fast-forwarding of recognised busy-wait loops in
+// RunCycles: DBcc delay loops that branch to themselves, and BTST or TST
+// polling loops on RAM, ROM, or a PollStableDevice register. The skipped
+// iterations are charged exactly as if they had run, and the skip ends before
+// the next scheduled event or the end of the budget. CycleListeners still see
+// the skipped cycles, but must not change polled state outside scheduled
+// events while detection is enabled. Loops are not skipped while the bus has a
+// BusTimingPolicy.
+//
+// A STOPped CPU jumps straight to the next scheduled event when the scheduler
+// has no CycleListeners. With listeners it advances 4 cycles at a time, so an
+// interrupt a listener raises is taken promptly, unless detection is enabled,
+// which makes STOP skip ahead as well.
+func (cpu *cpu) SetIdleLoopDetection(enabled bool) {
+	cpu.idleLoops = enabled
+}
+
+// skipCycles advances time without executing instructions.
+func (cpu *cpu) skipCycles(n uint64) {
+	cpu.cycles += n
+	if cpu.scheduler != nil {
+		cpu.scheduler.Advance(n)
+	}
+}
+
+// idleCycles returns how far a stopped or idling CPU may skip ahead: up to the
+// budget end, and short of the next scheduled event so the event fires inside
+// a normally executed instruction. It never returns zero while budget remains.
+func (cpu *cpu) idleCycles(target uint64, stopped bool) uint64 {
+	if cpu.cycles >= target {
+		return 0
+	}
+	limit := target - cpu.cycles
+	if at, ok := cpu.scheduler.NextEvent(); ok {
+		now := cpu.scheduler.Now()
+		switch {
+		case at <= now:
+			limit = 1
+		case stopped:
+			// A stopped CPU has no instruction to finish, so it may land on the
+			// event itself and take the interrupt right away.
+			limit = min(limit, at-now)
+		default:
+			limit = min(limit, at-now-1)
+		}
+	}
+	if stopped && limit == 0 {
+		limit = 1
+	}
+	return limit
+}
+
+// runIdleLoop runs one iteration of a busy-wait loop at PC and then skips as
+// many further iterations as provably behave the same. It returns false when
+// PC does not hold a recognised loop, leaving execution to the caller.
+func (cpu *cpu) runIdleLoop(target uint64) (bool, error) {
+	pc := cpu.regs.PC
+	loop := cpu.matchIdleLoop(pc)
+	if loop.kind == idleLoopNone {
+		return false, nil
+	}
+	if cpu.interrupts != nil && cpu.interrupts.HasPending(cpu.regs.SR) {
+		return false, nil
+	}
+
+	start := cpu.cycles
+	for range loop.instructions {
+		if err := cpu.executeNext(); err != nil || cpu.cycles >= target {
+			return true, err
+		}
+	}
+	if cpu.regs.PC != pc || cpu.stopped || cpu.scheduler.BusRequested() || cpu.cycles == start {
+		return true, nil
+	}
+	if loop.kind == idleLoopPoll && !cpu.pollStable(loop.address, loop.size) {
+		return true, nil
+	}
+
+	cost := cpu.cycles - start
+	iterations := cpu.idleCycles(target, false) / cost
+	if loop.kind == idleLoopDelay {
+		// The counter holds the number of taken iterations still to come.
+		reg := cpu.regs.IR & 0x7
+		iterations = min(iterations, uint64(uint16(cpu.regs.D[reg])))
+		counter := uint16(cpu.regs.D[reg]) - uint16(iterations)
+		cpu.regs.D[reg] = (cpu.regs.D[reg] &^ 0xffff) | int32(counter)
+	}
+	if iterations != 0 {
+		cpu.skipCycles(iterations * cost)
+	}
+	return true, nil
+}
+
+// matchIdleLoop recognises the loop forms handled by runIdleLoop. Only code in
+// direct RAM or ROM pages is considered.
+func (cpu *cpu) matchIdleLoop(pc uint32) idleLoop {
+	mem, idx := cpu.busFast.directPage(pc, Word, false)
+	if mem == nil {
+		return idleLoop{}
+	}
+	code := mem[idx:]
+	word := func(i int) (uint16, bool) {
+		if 2*i+1 >= len(code) {
+			return 0, false
+		}
+		return uint16(code[2*i])<<8 | uint16(code[2*i+1]), true
+	}
+
+	opcode, _ := word(0)
+	if opcode&0xf0f8 == 0x50c8 {
+		if displacement, ok := word(1); ok && displacement == 0xfffe {
+			return idleLoop{kind: idleLoopDelay, instructions: 1}
+		}
+		return idleLoop{}
+	}
+
+	var loop idleLoop
+	var length int // in words
+	switch {
+	case opcode&0xffc0 == 0x0800: // BTST #n,<ea>
+		loop.size = Byte
+		length = 2
+	case opcode&0xff00 == 0x4a00 && opcode&0x00c0 != 0x00c0: // TST <ea>
+		loop.size = operandSizeFromOpcode(opcode)
+		length = 1
+	default:
+		return idleLoop{}
+	}
+
+	reg := opcode & 0x7
+	switch (opcode >> 3) & 0x7 {
+	case 2: // (An)
+		loop.address = cpu.regs.A[reg]
+	case 7:
+		switch reg {
+		case 0: // (xxx).W
+			address, ok := word(length)
+			if !ok {
+				return idleLoop{}
+			}
+			loop.address = uint32(int32(int16(address)))
+			length++
+		case 1: // (xxx).L
+			high, ok := word(length)
+			low, ok2 := word(length + 1)
+			if !ok || !ok2 {
+				return idleLoop{}
+			}
+			loop.address = uint32(high)<<16 | uint32(low)
+			length += 2
+		default:
+			return idleLoop{}
+		}
+	default:
+		return idleLoop{}
+	}
+
+	branch, ok := word(length)
+	if !ok || branch&0xf000 != 0x6000 || (branch>>8)&0xf < 2 || int(int8(branch)) != -2*(length+1) {
+		return idleLoop{}
+	}
+	loop.kind = idleLoopPoll
+	loop.instructions = 2
+	loop.address &= 0xffffff
+	return loop
+}
+
+// pollStable reports whether a polled location cannot change until the next
+// scheduled event. Memory behind direct pages only changes through the CPU,
+// bus masters, and scheduled code; other devices have to vouch for their
+// registers, and E clock synchronised accesses vary in length, so they never
+// qualify.
+func (cpu *cpu) pollStable(address uint32, size Size) bool {
+	if mem, _ := cpu.busFast.directPage(address, size, false); mem != nil {
+		return true
+	}
+	if cpu.busFast == nil {
+		return false
+	}
+	dev := cpu.busFast.deviceForAddress(address)
+	if vpa, ok := dev.(VPADevice); ok && vpa.ValidPeripheralAddress(address) {
+		return false
+	}
+	stable, ok := dev.(PollStableDevice)
+	return ok && stable.PollStable(address)
+}
+
+// idleLoopsUsable reports whether RunCycles may fast-forward busy-wait loops.
+// Like the block cache, it stays out of the way of per-instruction debugging.
+// A bus timing policy's delays depend on the phase an iteration starts on, so
+// one measured iteration does not tell what the others cost.
+func (cpu *cpu) idleLoopsUsable() bool {
+	return cpu.idleLoops && !cpu.stopped && cpu.breakpoints == nil &&
+		cpu.preTrap == nil && !cpu.traceInstructions && !cpu.traceBus &&
+		(cpu.busFast == nil || cpu.busFast.timing == nil)
+}
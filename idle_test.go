@@ -0,0 +1,247 @@
+package m68kemu
+
+import "testing"
+
+type callCountingListener struct {
+	calls int
+}
+
+func (l *callCountingListener) AdvanceCycles(uint64, uint64) {
+	l.calls++
+}
+
+type pollStableStub struct {
+	*stubMappedDevice
+}
+
+func (d pollStableStub) PollStable(uint32) bool { return true }
+
+// runIdleProgram runs src with or without idle loop detection. A scheduled
+// event sets the byte at $6000 after release cycles and raises a level 3
+// autovector interrupt every 700 cycles; the handler counts in $6002.
+func runIdleProgram(t *testing.T, src string, budget uint64, detect bool, io Device) (*cpu, *callCountingListener) {
+	t.Helper()
+	ram := NewRAM(0, 0x10000)
+	devices := []Device{ram}
+	if io != nil {
+		devices = []Device{io, ram}
+	}
+	processor, err := NewCPU(NewBus(devices...))
+	if err != nil {
+		t.Fatalf("NewCPU failed: %v", err)
+	}
+	cpu := processor.(*cpu)
+	cpu.regs.A[7] = 0x1000
+	cpu.regs.PC = 0x2000
+
+	code := assemble(t, src)
+	for i, b := range code {
+		if err := ram.Write(Byte, 0x2000+uint32(i), uint32(b)); err != nil {
+			t.Fatalf("write code: %v", err)
+		}
+	}
+	handler := assemble(t, "ADDQ.W #1,$6002\nRTE\n")
+	for i, b := range handler {
+		if err := ram.Write(Byte, 0x3000+uint32(i), uint32(b)); err != nil {
+			t.Fatalf("write handler: %v", err)
+		}
+	}
+	if err := ram.Write(Long, uint32(autoVectorBase+3)<<2, 0x3000); err != nil {
+		t.Fatalf("install handler: %v", err)
+	}
+
+	scheduler := NewCycleScheduler()
+	listener := &callCountingListener{}
+	scheduler.AddListener(listener)
+	cpu.SetScheduler(scheduler)
+	scheduler.ScheduleAfter(9000, func(uint64) {
+		_ = ram.Write(Byte, 0x6000, 0x80)
+	})
+	var tick func(uint64)
+	tick = func(uint64) {
+		_ = cpu.RequestInterrupt(3, nil)
+		scheduler.ScheduleAfter(700, tick)
+	}
+	scheduler.ScheduleAfter(700, tick)
+
+	cpu.SetIdleLoopDetection(detect)
+	if err := cpu.RunCycles(budget); err != nil {
+		t.Fatalf("RunCycles failed: %v", err)
+	}
+	return cpu, listener
+}
+
+func TestIdleLoopDetectionMatchesInterpreter(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{
+			name: "DelayLoop",
+			src: `
+        MOVE.W  #$2000,SR
+        MOVE.W  #4000,D0
+wait:   DBF     D0,wait
+        MOVEQ   #1,D1
+        MOVE.W  #$ffff,D0
+again:  DBRA    D0,again
+        MOVEQ   #2,D1
+done:   BRA.S   done
+`,
+		},
+		{
+			name: "PollRAMFlag",
+			src: `
+        MOVE.W  #$2000,SR
+poll:   BTST    #7,$6000.W
+        BEQ.S   poll
+        MOVEQ   #1,D1
+        LEA     $6000,A0
+tst:    TST.B   (A0)
+        BNE.S   tst
+done:   BRA.S   done
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, budget := range []uint64{1, 333, 5000, 20000, 400000} {
+				plain, plainListener := runIdleProgram(t, tt.src, budget, false, nil)
+				fast, fastListener := runIdleProgram(t, tt.src, budget, true, nil)
+				if plain.regs != fast.regs || plain.cycles != fast.cycles {
+					t.Fatalf("budget %d: detection changed state\nplain: %+v cycles %d\nfast:  %+v cycles %d",
+						budget, plain.regs, plain.cycles, fast.regs, fast.cycles)
+				}
+				plainCount, _ := plain.read(Word, 0x6002)
+				fastCount, _ := fast.read(Word, 0x6002)
+				if plainCount != fastCount {
+					t.Fatalf("budget %d: interrupt count = %d, want %d", budget, fastCount, plainCount)
+				}
+				if budget == 400000 && fastListener.calls*4 > plainListener.calls {
+					t.Fatalf("detection ran %d scheduler steps, interpreter %d", fastListener.calls, plainListener.calls)
+				}
+			}
+		})
+	}
+}
+
+func TestIdleLoopDetectionPollsOnlyStableDevices(t *testing.T) {
+	src := `
+poll:   BTST    #0,$8001.W
+        BEQ.S   poll
+`
+	for _, tt := range []struct {
+		name   string
+		io     Device
+		skips  bool
+		budget uint64
+	}{
+		{name: "UnknownDevice", io: newStubMappedDevice(0xff8000, 0xff80ff), budget: 100000},
+		{name: "PollStable", io: pollStableStub{newStubMappedDevice(0xff8000, 0xff80ff)}, skips: true, budget: 100000},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			plain, plainListener := runIdleProgram(t, "MOVE.W #$2700,SR\n"+src, tt.budget, false, tt.io)
+			fast, fastListener := runIdleProgram(t, "MOVE.W #$2700,SR\n"+src, tt.budget, true, tt.io)
+			if plain.regs != fast.regs || plain.cycles != fast.cycles {
+				t.Fatalf("detection changed state: %+v/%d vs %+v/%d", plain.regs, plain.cycles, fast.regs, fast.cycles)
+			}
+			skipped := fastListener.calls*4 < plainListener.calls
+			if skipped != tt.skips {
+				t.Fatalf("skipped = %v (%d vs %d scheduler steps), want %v", skipped, fastListener.calls, plainListener.calls, tt.skips)
+			}
+		})
+	}
+}
+
+func TestStopFastForwardsToNextEvent(t *testing.T) {
+	cpu, ram := newEnvironment(t)
+	code := assemble(t, "STOP #$2000\nADDQ.L #1,D0\nSTOP #$2700\n")
+	for i, b := range code {
+		if err := ram.Write(Byte, cpu.regs.PC+uint32(i), uint32(b)); err != nil {
+			t.Fatalf("write code: %v", err)
+		}
+	}
+	handler := assemble(t, "RTE\n")
+	for i, b := range handler {
+		if err := ram.Write(Byte, 0x3000+uint32(i), uint32(b)); err != nil {
+			t.Fatalf("write handler: %v", err)
+		}
+	}
+	if err := ram.Write(Long, uint32(autoVectorBase+3)<<2, 0x3000); err != nil {
+		t.Fatalf("install handler: %v", err)
+	}
+
+	// With listeners, STOP skips ahead only once idle loop detection opts in.
+	scheduler := NewCycleScheduler()
+	listener := &callCountingListener{}
+	scheduler.AddListener(listener)
+	cpu.SetScheduler(scheduler)
+	cpu.SetIdleLoopDetection(true)
+	var raisedAt uint64
+	scheduler.Schedule(50_001, func(now uint64) {
+		raisedAt = now
+		_ = cpu.RequestInterrupt(3, nil)
+	})
+
+	if err := cpu.RunCycles(1_000_000); err != nil {
+		t.Fatalf("RunCycles failed: %v", err)
+	}
+	if raisedAt != 50_001 || cpu.regs.D[0] != 1 {
+		t.Fatalf("interrupt at %d, D0 = %d, want 50001 and 1", raisedAt, cpu.regs.D[0])
+	}
+	if cpu.cycles != 1_000_000 {
+		t.Fatalf("cycles = %d, want exactly the budget while stopped", cpu.cycles)
+	}
+	if listener.calls > 20 {
+		t.Fatalf("STOP advanced the scheduler %d times, want a handful", listener.calls)
+	}
+}
+
+// interruptingListener raises a level 3 interrupt once the scheduler passes at.
+type interruptingListener struct {
+	cpu    *cpu
+	at     uint64
+	raised uint64
+}
+
+func (l *interruptingListener) AdvanceCycles(_ uint64, now uint64) {
+	if l.raised == 0 && now >= l.at {
+		l.raised = now
+		_ = l.cpu.RequestInterrupt(3, nil)
+	}
+}
+
+func TestStopTakesListenerInterruptsPromptly(t *testing.T) {
+	cpu, ram := newEnvironment(t)
+	code := assemble(t, "STOP #$2000\nSTOP #$2700\n")
+	for i, b := range code {
+		if err := ram.Write(Byte, cpu.regs.PC+uint32(i), uint32(b)); err != nil {
+			t.Fatalf("write code: %v", err)
+		}
+	}
+	handler := assemble(t, "RTE\n")
+	for i, b := range handler {
+		if err := ram.Write(Byte, 0x3000+uint32(i), uint32(b)); err != nil {
+			t.Fatalf("write handler: %v", err)
+		}
+	}
+	if err := ram.Write(Long, uint32(autoVectorBase+3)<<2, 0x3000); err != nil {
+		t.Fatalf("install handler: %v", err)
+	}
+
+	scheduler := NewCycleScheduler()
+	listener := &interruptingListener{cpu: cpu, at: 1_000}
+	scheduler.AddListener(listener)
+	cpu.SetScheduler(scheduler)
+
+	if err := cpu.RunCycles(100_000); err != nil {
+		t.Fatalf("RunCycles failed: %v", err)
+	}
+	if listener.raised == 0 || listener.raised > 1_010 {
+		t.Fatalf("listener saw cycle %d first, want about 1000", listener.raised)
+	}
+	if cpu.regs.PC != 0x2000+uint32(len(code)) || cpu.regs.SR&0x0700 != 0x0700 {
+		t.Fatalf("PC %04x SR %04x, want the CPU resumed and stopped again", cpu.regs.PC, cpu.regs.SR)
+	}
+}
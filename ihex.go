@@ -0,0 +1,154 @@
+package m68kemu
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	intelHexData                   = 0x00
+	intelHexEndOfFile              = 0x01
+	intelHexExtendedSegmentAddress = 0x02
+	intelHexStartSegmentAddress    = 0x03
+	intelHexExtendedLinearAddress  = 0x04
+	intelHexStartLinearAddress     = 0x05
+)
+
+// IntelHexSaveOptions controls the output of SaveIntelHex.
+type IntelHexSaveOptions struct {
+	RecordSize int
+	Entry      uint32
+	HasEntry   bool
+}
+
+// LoadIntelHex reads Intel HEX records and stores their data on the bus.
+// Extended segment and extended linear address records are honoured, and a
+// start linear (or start segment) address record becomes the image entry
+// point and, when options.CPU is set, the CPU's program counter.
+func LoadIntelHex(bus AddressBus, r io.Reader, options ImageOptions) (ImageInfo, error) {
+	store := newImageStore(bus, options)
+	scanner := bufio.NewScanner(r)
+	line := 0
+	base := uint32(0)
+	terminated := false
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if terminated {
+			return ImageInfo{}, fmt.Errorf("intel hex line %d: record after end-of-file record", line)
+		}
+		if text[0] != ':' {
+			return ImageInfo{}, fmt.Errorf("intel hex line %d: missing ':' prefix", line)
+		}
+
+		raw, err := parseHexBytes(text[1:])
+		if err != nil {
+			return ImageInfo{}, fmt.Errorf("intel hex line %d: %w", line, err)
+		}
+		if len(raw) < 5 || int(raw[0]) != len(raw)-5 {
+			return ImageInfo{}, fmt.Errorf("intel hex line %d: malformed record length", line)
+		}
+		var sum byte
+		for _, value := range raw {
+			sum += value
+		}
+		if sum != 0 {
+			return ImageInfo{}, fmt.Errorf("intel hex line %d: checksum mismatch", line)
+		}
+
+		offset := uint32(raw[1])<<8 | uint32(raw[2])
+		data := raw[4 : len(raw)-1]
+		switch raw[3] {
+		case intelHexData:
+			if err := store.store(base+offset, data); err != nil {
+				return ImageInfo{}, fmt.Errorf("intel hex line %d: %w", line, err)
+			}
+		case intelHexEndOfFile:
+			terminated = true
+		case intelHexExtendedSegmentAddress:
+			if len(data) != 2 {
+				return ImageInfo{}, fmt.Errorf("intel hex line %d: segment address needs 2 bytes", line)
+			}
+			base = (uint32(data[0])<<8 | uint32(data[1])) << 4
+		case intelHexExtendedLinearAddress:
+			if len(data) != 2 {
+				return ImageInfo{}, fmt.Errorf("intel hex line %d: linear address needs 2 bytes", line)
+			}
+			base = (uint32(data[0])<<8 | uint32(data[1])) << 16
+		case intelHexStartSegmentAddress:
+			if len(data) != 4 {
+				return ImageInfo{}, fmt.Errorf("intel hex line %d: start segment address needs 4 bytes", line)
+			}
+			segment := uint32(data[0])<<8 | uint32(data[1])
+			store.setEntry(segment<<4 + (uint32(data[2])<<8 | uint32(data[3])))
+		case intelHexStartLinearAddress:
+			if len(data) != 4 {
+				return ImageInfo{}, fmt.Errorf("intel hex line %d: start linear address needs 4 bytes", line)
+			}
+			store.setEntry(uint32(data[0])<<24 | uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3]))
+		default:
+			return ImageInfo{}, fmt.Errorf("intel hex line %d: unsupported record type %02x", line, raw[3])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return ImageInfo{}, err
+	}
+
+	return store.finish(options), nil
+}
+
+// SaveIntelHex dumps length bytes starting at start as Intel HEX records,
+// emitting extended linear address records whenever the upper 16 address
+// bits change.
+func SaveIntelHex(w io.Writer, bus AddressBus, start, length uint32, options IntelHexSaveOptions) error {
+	data, err := readImageBytes(bus, start, length)
+	if err != nil {
+		return err
+	}
+
+	recordSize := options.RecordSize
+	if recordSize <= 0 {
+		recordSize = defaultImageRecordSize
+	}
+	if recordSize > 255 {
+		return fmt.Errorf("intel hex record size %d too large", recordSize)
+	}
+
+	out := bufio.NewWriter(w)
+	upper := uint32(0)
+	for offset := 0; offset < len(data); {
+		address := start + uint32(offset)
+		if address>>16 != upper {
+			upper = address >> 16
+			writeIntelHexRecord(out, intelHexExtendedLinearAddress, 0, []byte{byte(upper >> 8), byte(upper)})
+		}
+
+		// Records never cross a 64 KiB boundary so the 16-bit offset stays valid.
+		chunk := min(recordSize, len(data)-offset, int(0x10000-(address&0xffff)))
+		writeIntelHexRecord(out, intelHexData, uint16(address), data[offset:offset+chunk])
+		offset += chunk
+	}
+
+	if options.HasEntry {
+		entry := options.Entry
+		writeIntelHexRecord(out, intelHexStartLinearAddress, 0, []byte{byte(entry >> 24), byte(entry >> 16), byte(entry >> 8), byte(entry)})
+	}
+	writeIntelHexRecord(out, intelHexEndOfFile, 0, nil)
+	return out.Flush()
+}
+
+func writeIntelHexRecord(w *bufio.Writer, kind byte, offset uint16, data []byte) {
+	sum := byte(len(data)) + byte(offset>>8) + byte(offset) + kind
+	fmt.Fprintf(w, ":%02X%04X%02X", len(data), offset, kind)
+	for _, value := range data {
+		sum += value
+		fmt.Fprintf(w, "%02X", value)
+	}
+	fmt.Fprintf(w, "%02X\n", byte(-sum))
+}
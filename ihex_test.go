@@ -0,0 +1,53 @@
+package m68kemu
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLoadIntelHexHonoursExtendedLinearAddress(t *testing.T) {
+	ram := NewRAM(0x010000, 0x100)
+	image := strings.Join([]string{
+		":020000040001F9",
+		":0400100012345678D8",
+		":0400000500010010E6",
+		":00000001FF",
+	}, "\n")
+
+	info, err := LoadIntelHex(NewBus(ram), strings.NewReader(image), ImageOptions{})
+	if err != nil {
+		t.Fatalf("LoadIntelHex failed: %v", err)
+	}
+	if got, _ := ram.Read(Long, 0x010010); got != 0x12345678 {
+		t.Fatalf("stored long = %08x, want 12345678", got)
+	}
+	if !info.HasEntry || info.Entry != 0x010010 {
+		t.Fatalf("entry = (%08x, %v), want (00010010, true)", info.Entry, info.HasEntry)
+	}
+}
+
+func TestSaveIntelHexRoundTripAcrossSegmentBoundary(t *testing.T) {
+	source := NewRAM(0xfff0, 0x20)
+	for i := range uint32(0x20) {
+		if err := source.Write(Byte, 0xfff0+i, 0xa0+i); err != nil {
+			t.Fatalf("seed source: %v", err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := SaveIntelHex(&out, NewBus(source), 0xfff0, 0x20, IntelHexSaveOptions{}); err != nil {
+		t.Fatalf("SaveIntelHex failed: %v", err)
+	}
+	if !strings.Contains(out.String(), ":020000040001F9") {
+		t.Fatalf("expected extended linear address record, got:\n%s", out.String())
+	}
+
+	target := NewRAM(0xfff0, 0x20)
+	if _, err := LoadIntelHex(NewBus(target), &out, ImageOptions{}); err != nil {
+		t.Fatalf("LoadIntelHex failed: %v", err)
+	}
+	if !bytes.Equal(source.mem, target.mem) {
+		t.Fatalf("round trip data mismatch")
+	}
+}
@@ -0,0 +1,150 @@
+package m68kemu
+
+import (
+	"fmt"
+)
+
+// ImageOptions controls how LoadSRecord and LoadIntelHex store an image.
+type ImageOptions struct {
+	// Offset is added to every record address before it is stored.
+	Offset uint32
+	// UseWrite forces ordinary bus writes even when the bus supports Poke.
+	UseWrite bool
+	// CPU, when set, receives the image start address as its program counter.
+	CPU CPU
+}
+
+// ImageSegment is one contiguous run of bytes stored by an image loader.
+type ImageSegment struct {
+	Start  uint32
+	Length uint32
+}
+
+// ImageInfo summarises an image file after it has been stored on a bus.
+type ImageInfo struct {
+	Header   string
+	Segments []ImageSegment
+	Entry    uint32
+	HasEntry bool
+}
+
+// imageStore funnels loader output into a bus, preferring the side-effect-free
+// Poke path and coalescing adjacent records into segments.
+type imageStore struct {
+	bus    AddressBus
+	poker  PokeDevice
+	offset uint32
+	info   ImageInfo
+}
+
+func newImageStore(bus AddressBus, options ImageOptions) *imageStore {
+	store := &imageStore{bus: bus, offset: options.Offset}
+	if poker, ok := bus.(PokeDevice); ok && !options.UseWrite {
+		store.poker = poker
+	}
+	return store
+}
+
+func (s *imageStore) store(address uint32, data []byte) error {
+	address += s.offset
+	for i, value := range data {
+		target := (address + uint32(i)) & 0xffffff
+		var err error
+		if s.poker != nil {
+			err = s.poker.Poke(Byte, target, uint32(value))
+		} else {
+			err = s.bus.Write(Byte, target, uint32(value))
+		}
+		if err != nil {
+			return fmt.Errorf("store image byte at %08x: %w", target, err)
+		}
+	}
+	s.addSegment(address&0xffffff, uint32(len(data)))
+	return nil
+}
+
+func (s *imageStore) addSegment(start, length uint32) {
+	if length == 0 {
+		return
+	}
+	if n := len(s.info.Segments); n != 0 {
+		last := &s.info.Segments[n-1]
+		if last.Start+last.Length == start {
+			last.Length += length
+			return
+		}
+	}
+	s.info.Segments = append(s.info.Segments, ImageSegment{Start: start, Length: length})
+}
+
+func (s *imageStore) setEntry(entry uint32) {
+	s.info.Entry = (entry + s.offset) & 0xffffff
+	s.info.HasEntry = true
+}
+
+// finish applies the start address to the optional CPU once the whole image
+// has been stored.
+func (s *imageStore) finish(options ImageOptions) ImageInfo {
+	if options.CPU != nil && s.info.HasEntry {
+		regs := options.CPU.Registers()
+		regs.PC = s.info.Entry
+		options.CPU.SetRegisters(regs)
+	}
+	return s.info
+}
+
+// readImageBytes captures a memory range for the image writers, using Peek when
+// the bus offers it so that dumping I/O space does not disturb devices.
+func readImageBytes(bus AddressBus, start, length uint32) ([]byte, error) {
+	peeker, _ := bus.(PeekDevice)
+	data := make([]byte, length)
+	for i := range length {
+		address := (start + i) & 0xffffff
+		var (
+			value uint32
+			err   error
+		)
+		if peeker != nil {
+			value, err = peeker.Peek(Byte, address)
+		} else {
+			value, err = bus.Read(Byte, address)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read image byte at %08x: %w", address, err)
+		}
+		data[i] = byte(value)
+	}
+	return data, nil
+}
+
+func parseHexBytes(text string) ([]byte, error) {
+	if len(text)%2 != 0 {
+		return nil, fmt.Errorf("odd number of hex digits")
+	}
+	data := make([]byte, len(text)/2)
+	for i := range data {
+		high, ok := hexDigit(text[2*i])
+		if !ok {
+			return nil, fmt.Errorf("invalid hex digit %q", text[2*i])
+		}
+		low, ok := hexDigit(text[2*i+1])
+		if !ok {
+			return nil, fmt.Errorf("invalid hex digit %q", text[2*i+1])
+		}
+		data[i] = high<<4 | low
+	}
+	return data, nil
+}
+
+func hexDigit(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	default:
+		return 0, false
+	}
+}
@@ -315,3 +315,81 @@ func TestNestedInterruptRegressionRestoresExactSRPCAndFrames(t *testing.T) {
 		t.Fatalf("stack pointer not restored after nested interrupts: got %08x want %08x", cpu.regs.A[7], initialSP)
 	}
 }
+
+func TestAutovectoredInterruptPaysEClockSynchronisation(t *testing.T) {
+	cpu, ram := newEnvironment(t)
+	cpu.busFast.SetVPAAutovectors(true)
+
+	if err := ram.Write(Long, uint32(autoVectorBase+4)<<2, 0x3000); err != nil {
+		t.Fatalf("failed to install autovector handler: %v", err)
+	}
+	cpu.setSR(srSupervisor)
+	cpu.cycles = 3
+
+	if err := cpu.RequestInterrupt(4, nil); err != nil {
+		t.Fatalf("failed to request interrupt: %v", err)
+	}
+	if err := cpu.checkInterrupts(); err != nil {
+		t.Fatalf("interrupt failed: %v", err)
+	}
+
+	// The acknowledge cycle starts at 3+10, 7 cycles short of the next E
+	// clock edge.
+	want := uint64(3 + exceptionCyclesInterrupt + 6 + 7)
+	if cpu.cycles != want {
+		t.Fatalf("cycles after autovectored interrupt = %d, want %d", cpu.cycles, want)
+	}
+
+	vector := uint8(0x40)
+	if err := ram.Write(Long, uint32(vector)<<2, 0x3000); err != nil {
+		t.Fatalf("failed to install vector handler: %v", err)
+	}
+	cpu.setSR(srSupervisor)
+	before := cpu.cycles
+	if err := cpu.RequestInterrupt(4, &vector); err != nil {
+		t.Fatalf("failed to request interrupt: %v", err)
+	}
+	if err := cpu.checkInterrupts(); err != nil {
+		t.Fatalf("interrupt failed: %v", err)
+	}
+	if got := cpu.cycles - before; got != uint64(exceptionCyclesInterrupt) {
+		t.Fatalf("vectored interrupt cycles = %d, want %d", got, exceptionCyclesInterrupt)
+	}
+}
+
+func TestInterruptLineStaysPendingUntilReleased(t *testing.T) {
+	ic := NewInterruptController()
+	acks := 0
+	vectored := ic.NewLine(func(level uint8) (uint8, bool) {
+		acks++
+		return 0x40, false
+	})
+	auto := ic.NewLine(nil)
+
+	vectored.Set(4)
+	auto.Set(4)
+	for range 2 {
+		level, vector, autoVector, ok := ic.Pending(0)
+		if !ok || level != 4 || vector != 0x40 || autoVector {
+			t.Fatalf("Pending = %d, %#x, %v, %v, want the first line's vector at level 4", level, vector, autoVector, ok)
+		}
+	}
+	if acks != 2 {
+		t.Fatalf("acknowledge calls = %d, want 2", acks)
+	}
+	if ic.HasPending(4 << 8) {
+		t.Fatalf("level 4 lines pending under mask 4")
+	}
+
+	vectored.Set(0)
+	level, vector, autoVector, ok := ic.Pending(0)
+	if !ok || level != 4 || vector != autoVectorBase+4 || !autoVector {
+		t.Fatalf("Pending = %d, %d, %v, %v, want the autovectored line", level, vector, autoVector, ok)
+	}
+
+	ic.Reset()
+	auto.Set(0)
+	if ic.HasPending(0) {
+		t.Fatalf("released lines still pending")
+	}
+}
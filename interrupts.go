@@ -10,9 +10,26 @@ type (
 		autoVector bool
 	}
 
+	// InterruptAcknowledge answers the CPU's interrupt acknowledge cycle for
+	// an InterruptLine. It returns the vector the device puts on the bus, or
+	// autoVector true when the device asserts VPA instead.
+	InterruptAcknowledge func(level uint8) (vector uint8, autoVector bool)
+
+	// InterruptLine is a level-sensitive interrupt output of a device, such as
+	// an MFP or ACIA IRQ pin. Unlike Request, which queues one interrupt, a
+	// line stays pending for as long as the device holds it at a level, and
+	// the device decides the vector when the CPU acknowledges it.
+	InterruptLine struct {
+		controller  *InterruptController
+		level       uint8
+		acknowledge InterruptAcknowledge
+	}
+
 	InterruptController struct {
-		requests [8][]pendingInterrupt
-		maxLevel uint8
+		requests  [8][]pendingInterrupt
+		maxLevel  uint8
+		lines     []*InterruptLine
+		lineLevel uint8
 	}
 )
 
@@ -20,11 +37,46 @@ func NewInterruptController() *InterruptController {
 	return &InterruptController{}
 }
 
+// Reset drops queued requests. Interrupt lines keep their level because
+// their devices own them; devices release them in their own Reset.
 func (ic *InterruptController) Reset() {
 	ic.requests = [8][]pendingInterrupt{}
 	ic.maxLevel = 0
 }
 
+// NewLine attaches a level-sensitive interrupt line to the controller.
+// acknowledge supplies the vector when the CPU takes the line's interrupt; a
+// nil acknowledge autovectors. When several lines hold the same level, the
+// line created first wins, like the first device in a daisy chain.
+func (ic *InterruptController) NewLine(acknowledge InterruptAcknowledge) *InterruptLine {
+	line := &InterruptLine{controller: ic, acknowledge: acknowledge}
+	ic.lines = append(ic.lines, line)
+	return line
+}
+
+// Set drives the line at level 1-7, or releases it with level 0. Levels above
+// 7 are clamped to 7.
+func (l *InterruptLine) Set(level uint8) {
+	level = min(level, 7)
+	if l.level == level {
+		return
+	}
+	l.level = level
+	l.controller.updateLineLevel()
+}
+
+// Level returns the level the line is driven at, or 0 when it is released.
+func (l *InterruptLine) Level() uint8 {
+	return l.level
+}
+
+func (ic *InterruptController) updateLineLevel() {
+	ic.lineLevel = 0
+	for _, line := range ic.lines {
+		ic.lineLevel = max(ic.lineLevel, line.level)
+	}
+}
+
 func (ic *InterruptController) Request(level uint8, vector *uint8) error {
 	if level > 7 {
 		return fmt.Errorf("invalid interrupt level %d", level)
@@ -53,6 +105,9 @@ func (ic *InterruptController) Request(level uint8, vector *uint8) error {
 
 func (ic *InterruptController) Pending(mask uint16) (uint8, uint32, bool, bool) {
 	interruptMask := uint8((mask & srInterruptMask) >> 8)
+	if ic.lineLevel > interruptMask && ic.lineLevel > ic.maxLevel {
+		return ic.acknowledgeLine(ic.lineLevel)
+	}
 	if ic.maxLevel <= interruptMask {
 		return 0, 0, false, false
 	}
@@ -85,5 +140,23 @@ func (ic *InterruptController) Pending(mask uint16) (uint8, uint32, bool, bool)
 }
 
 func (ic *InterruptController) HasPending(mask uint16) bool {
-	return ic.maxLevel > uint8((mask&srInterruptMask)>>8)
+	return max(ic.maxLevel, ic.lineLevel) > uint8((mask&srInterruptMask)>>8)
+}
+
+// acknowledgeLine runs the acknowledge cycle of the first line at level.
+func (ic *InterruptController) acknowledgeLine(level uint8) (uint8, uint32, bool, bool) {
+	for _, line := range ic.lines {
+		if line.level != level {
+			continue
+		}
+		if line.acknowledge == nil {
+			return level, uint32(autoVectorBase + level), true, true
+		}
+		vector, autoVector := line.acknowledge(level)
+		if autoVector {
+			vector = uint8(autoVectorBase + level)
+		}
+		return level, uint32(vector), autoVector, true
+	}
+	return 0, 0, false, false
 }
@@ -0,0 +1,283 @@
+// Package machine assembles a reference 68000 single-board computer from the
+// library's parts: RAM, a ROM whose reset vectors appear at address 0, a
+// DUART or ACIA console, a periodic timer interrupt, and an exit port, all
+// on one Bus and clocked by the CPU's CycleScheduler. It is a ready-made
+// target for running bare-metal programs and an example of composing the
+// emulator into a machine.
+//
+// The memory map is:
+//
+//	$000000-RAMSize  RAM; the first 8 bytes mirror the ROM's reset vectors
+//	$E00000-$EFFFFF  ROM
+//	$FF0000-$FF001F  MC68681 DUART on the odd byte lane (ConsoleDUART)
+//	$FF0100-$FF0103  MC6850 ACIA on the odd byte lane (ConsoleACIA)
+//	$FF0200-$FF0201  timer control
+//	$FF0300-$FF0303  exit port
+//
+// Everything else bus-errors.
+package machine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	m68kemu "github.com/jenska/m68kemu"
+	"github.com/jenska/m68kemu/peripheral"
+)
+
+// Memory map.
+const (
+	RAMBase    = 0x000000
+	ROMBase    = 0xe00000
+	DUARTBase  = 0xff0000
+	ACIABase   = 0xff0100
+	TimerBase  = 0xff0200
+	ExitBase   = 0xff0300
+	maxRAMSize = ROMBase
+	maxROMSize = 0x100000
+)
+
+// Interrupt levels. The DUART supplies its IVR as the vector; the ACIA and
+// the timer are autovectored.
+const (
+	ConsoleLevel = 5
+	TimerLevel   = 6
+)
+
+const (
+	// DefaultRAMSize is the RAM size unless Config.RAMSize says otherwise.
+	DefaultRAMSize = 0x100000
+	// DefaultLoadAddress is where the stub ROM starts a program when the
+	// machine has no ROM image.
+	DefaultLoadAddress = 0x1000
+	// DefaultTimerFrequency is the timer interrupt rate in Hz.
+	DefaultTimerFrequency = 100
+
+	bootOverlaySize = 8
+	runSlice        = 1_000_000
+)
+
+// Console selects the serial chip the console is attached to.
+type Console int
+
+const (
+	ConsoleDUART Console = iota
+	ConsoleACIA
+)
+
+// ErrCycleLimit is returned by Run when the program exceeds its cycle budget.
+var ErrCycleLimit = errors.New("machine: cycle limit reached")
+
+// Config configures a Machine. The zero value is a 1 MiB machine with a
+// DUART console that discards output.
+type Config struct {
+	// ROM is the image mapped at ROMBase. It starts with the reset SSP and
+	// PC. Without a ROM, a stub ROM starts the program at DefaultLoadAddress
+	// with the stack at the top of RAM.
+	ROM []byte
+	// RAMSize defaults to DefaultRAMSize.
+	RAMSize uint32
+	// Console picks the serial chip; Input and Output connect it to the
+	// host, on channel A of the DUART. Input is polled and should not block.
+	Console Console
+	Input   io.Reader
+	Output  io.Writer
+	// TimerFrequency is the timer interrupt rate in Hz, defaulting to
+	// DefaultTimerFrequency.
+	TimerFrequency uint64
+	// CPUFrequency defaults to m68kemu.FrequencyST.
+	CPUFrequency uint64
+	// NoNatFeats disables NatFeats. They are on by default, with NF_STDERR
+	// writing to Stderr, or to Output when Stderr is nil.
+	NoNatFeats bool
+	Stderr     io.Writer
+}
+
+// Machine is the assembled computer.
+type Machine struct {
+	cpu       m68kemu.CPU
+	bus       *m68kemu.Bus
+	scheduler *m68kemu.CycleScheduler
+	ram       *m68kemu.RAM
+	rom       *m68kemu.ROM
+	duart     *peripheral.DUART
+	acia      *peripheral.ACIA
+	timer     *Timer
+}
+
+// New builds a machine and resets the CPU, which fetches its reset vectors
+// from the ROM.
+func New(config Config) (*Machine, error) {
+	if config.RAMSize == 0 {
+		config.RAMSize = DefaultRAMSize
+	}
+	if config.RAMSize > maxRAMSize {
+		return nil, fmt.Errorf("machine: RAM size %#x exceeds %#x", config.RAMSize, maxRAMSize)
+	}
+	if config.TimerFrequency == 0 {
+		config.TimerFrequency = DefaultTimerFrequency
+	}
+	if config.CPUFrequency == 0 {
+		config.CPUFrequency = m68kemu.FrequencyST
+	}
+	if config.Output == nil {
+		config.Output = io.Discard
+	}
+	if config.Stderr == nil {
+		config.Stderr = config.Output
+	}
+	if config.ROM == nil {
+		config.ROM = stubROM(config.RAMSize)
+	}
+	if len(config.ROM) < bootOverlaySize || len(config.ROM) > maxROMSize {
+		return nil, fmt.Errorf("machine: ROM size %d is outside %d-%d bytes", len(config.ROM), bootOverlaySize, maxROMSize)
+	}
+
+	m := &Machine{
+		ram: m68kemu.NewRAM(RAMBase, config.RAMSize),
+		rom: m68kemu.NewROM(ROMBase, config.ROM),
+	}
+	// The overlay comes first so it wins over the RAM underneath.
+	m.bus = m68kemu.NewBus(m68kemu.NewBootOverlay(m.rom, bootOverlaySize), m.ram, m.rom)
+	cpu, err := m68kemu.NewCPU(m.bus)
+	if err != nil {
+		return nil, fmt.Errorf("machine: %w", err)
+	}
+	m.cpu = cpu
+	m.scheduler = m68kemu.NewCycleScheduler()
+	cpu.SetScheduler(m.scheduler)
+
+	switch config.Console {
+	case ConsoleDUART:
+		m.duart, err = peripheral.NewDUART(cpu, peripheral.DUARTOptions{
+			Base:         DUARTBase,
+			Layout:       m68kemu.RegisterLayoutOdd,
+			CPUFrequency: config.CPUFrequency,
+			Level:        ConsoleLevel,
+			A:            peripheral.DUARTChannelOptions{Input: config.Input, Output: config.Output},
+		})
+		if err == nil {
+			m.bus.AddDevice(m.duart)
+		}
+	case ConsoleACIA:
+		m.acia, err = peripheral.NewACIA(cpu, peripheral.ACIAOptions{
+			Base:         ACIABase,
+			Layout:       m68kemu.RegisterLayoutOdd,
+			CPUFrequency: config.CPUFrequency,
+			Input:        config.Input,
+			Output:       config.Output,
+			Level:        ConsoleLevel,
+		})
+		if err == nil {
+			m.bus.AddDevice(m.acia)
+		}
+	default:
+		err = fmt.Errorf("unknown console %d", config.Console)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("machine: %w", err)
+	}
+
+	if m.timer, err = newTimer(cpu, TimerBase, config.TimerFrequency, config.CPUFrequency); err != nil {
+		return nil, fmt.Errorf("machine: %w", err)
+	}
+	m.bus.AddDevice(m.timer)
+	m.bus.AddDevice(&exitPort{})
+
+	if !config.NoNatFeats {
+		cpu.SetNatFeats(m68kemu.NewNatFeats(m68kemu.NatFeatsOptions{Name: "m68kemu machine", Stderr: config.Stderr}))
+	}
+	if err := m.Reset(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// stubROM holds only reset vectors: the stack at the top of RAM and the
+// program at DefaultLoadAddress.
+func stubROM(ramSize uint32) []byte {
+	stack := RAMBase + ramSize
+	return []byte{
+		byte(stack >> 24), byte(stack >> 16), byte(stack >> 8), byte(stack),
+		0, 0, DefaultLoadAddress >> 8, DefaultLoadAddress & 0xff,
+	}
+}
+
+// CPU returns the machine's processor.
+func (m *Machine) CPU() m68kemu.CPU { return m.cpu }
+
+// Bus returns the system bus.
+func (m *Machine) Bus() *m68kemu.Bus { return m.bus }
+
+// Scheduler returns the cycle scheduler that clocks the peripherals.
+func (m *Machine) Scheduler() *m68kemu.CycleScheduler { return m.scheduler }
+
+// RAM returns the main memory.
+func (m *Machine) RAM() *m68kemu.RAM { return m.ram }
+
+// ROM returns the ROM at ROMBase.
+func (m *Machine) ROM() *m68kemu.ROM { return m.rom }
+
+// DUART returns the console DUART, or nil with an ACIA console.
+func (m *Machine) DUART() *peripheral.DUART { return m.duart }
+
+// ACIA returns the console ACIA, or nil with a DUART console.
+func (m *Machine) ACIA() *peripheral.ACIA { return m.acia }
+
+// Timer returns the periodic timer.
+func (m *Machine) Timer() *Timer { return m.timer }
+
+// Load stores data on the bus at address, where it may land in RAM or, for
+// patching, in the ROM.
+func (m *Machine) Load(address uint32, data []byte) error {
+	for i, b := range data {
+		if err := m.bus.Poke(m68kemu.Byte, address+uint32(i), uint32(b)); err != nil {
+			return fmt.Errorf("machine: load %06x: %w", address+uint32(i), err)
+		}
+	}
+	return nil
+}
+
+// Reset resets the CPU, which fetches the reset vectors again and rewinds
+// the scheduler, and then the devices, so they restart their timing from
+// cycle 0. RAM keeps its contents. Use it instead of CPU().Reset, which
+// leaves the devices waiting for events the scheduler no longer has.
+func (m *Machine) Reset() error {
+	if err := m.cpu.Reset(); err != nil {
+		return fmt.Errorf("machine: reset: %w", err)
+	}
+	m.bus.Reset()
+	return nil
+}
+
+// Run executes until the program exits through the exit port, NF_EXIT, or
+// NF_SHUTDOWN and returns its exit code. A maxCycles of zero means no limit;
+// otherwise Run stops with ErrCycleLimit once the budget is spent. Other
+// errors, such as breakpoints, stop Run as they are.
+func (m *Machine) Run(maxCycles uint64) (int, error) {
+	start := m.cpu.Cycles()
+	for {
+		slice := uint64(runSlice)
+		if maxCycles != 0 {
+			ran := m.cpu.Cycles() - start
+			if ran >= maxCycles {
+				return 0, ErrCycleLimit
+			}
+			slice = min(slice, maxCycles-ran)
+		}
+		err := m.cpu.RunCycles(slice)
+		if err == nil {
+			continue
+		}
+		var exit Exit
+		var nfExit m68kemu.NatFeatExit
+		switch {
+		case errors.As(err, &exit):
+			return exit.Code, nil
+		case errors.As(err, &nfExit):
+			return nfExit.Code, nil
+		}
+		return 0, err
+	}
+}
@@ -0,0 +1,126 @@
+package machine
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	asm "github.com/jenska/m68kasm"
+)
+
+func assemble(tb testing.TB, source string) []byte {
+	tb.Helper()
+	code, _, err := asm.AssembleStringWithListing(source)
+	if err != nil {
+		tb.Fatalf("Assembler failed: %v", err)
+	}
+	return code
+}
+
+func newMachine(tb testing.TB, config Config, program string) *Machine {
+	tb.Helper()
+	m, err := New(config)
+	if err != nil {
+		tb.Fatalf("New failed: %v", err)
+	}
+	if program != "" {
+		if err := m.Load(DefaultLoadAddress, assemble(tb, program)); err != nil {
+			tb.Fatalf("Load failed: %v", err)
+		}
+	}
+	return m
+}
+
+func TestMachineDUARTConsoleAndExitPort(t *testing.T) {
+	var output bytes.Buffer
+	m := newMachine(t, Config{Input: strings.NewReader("x"), Output: &output}, `
+        LEA     $FF0001,A0
+        MOVE.B  #$13,(A0)        ; MR1A: 8 bits, no parity
+        MOVE.B  #$07,(A0)        ; MR2A: 1 stop bit
+        MOVE.B  #$CC,2(A0)       ; CSRA: 38400 baud
+        MOVE.B  #$05,4(A0)       ; CRA: enable RX and TX
+wait:   BTST    #0,2(A0)
+        BEQ.S   wait
+        MOVE.B  6(A0),D0
+        MOVE.B  D0,6(A0)
+drain:  BTST    #3,2(A0)         ; TxEMT
+        BEQ.S   drain
+        MOVE.B  #-3,$FF0300
+`)
+	code, err := m.Run(1_000_000)
+	if err != nil || code != -3 {
+		t.Fatalf("Run = %d, %v, want exit code -3", code, err)
+	}
+	if output.String() != "x" {
+		t.Fatalf("console output %q, want x", output.String())
+	}
+}
+
+func TestMachineTimerInterrupts(t *testing.T) {
+	m := newMachine(t, Config{TimerFrequency: 1000}, `
+        LEA     tick(PC),A0
+        MOVE.L  A0,$78           ; level 6 autovector
+        MOVE.B  #1,$FF0200       ; enable the timer interrupt
+        MOVE.W  #$2000,SR
+wait:   CMP.L   #5,D7
+        BNE.S   wait
+        MOVE.L  D7,$FF0300
+tick:   ADDQ.L  #1,D7
+        MOVE.B  #1,$FF0201       ; acknowledge
+        RTE
+`)
+	code, err := m.Run(0)
+	if err != nil || code != 5 {
+		t.Fatalf("Run = %d, %v, want 5 ticks", code, err)
+	}
+	// Five ticks at 1 kHz take 5 ms of an 8 MHz CPU, plus interrupt latency.
+	if cycles := m.CPU().Cycles(); cycles < 40_000 || cycles > 40_500 {
+		t.Fatalf("five ticks took %d cycles, want about 40000", cycles)
+	}
+}
+
+func TestMachineBootsFromROMWithACIAConsole(t *testing.T) {
+	rom := assemble(t, `
+        DC.L    $8000            ; reset SSP
+        DC.L    $E00008          ; reset PC
+        LEA     $FF0101,A0
+        MOVE.B  #3,(A0)          ; master reset
+        MOVE.B  #$15,(A0)        ; 8N1, /16
+        MOVE.B  #79,2(A0)
+        MOVE.B  #75,2(A0)
+drain:  BTST    #1,(A0)
+        BEQ.S   drain
+        MOVEQ   #42,D0
+        MOVE.L  D0,$FF0300
+`)
+	var output bytes.Buffer
+	m := newMachine(t, Config{ROM: rom, Console: ConsoleACIA, Output: &output}, "")
+	if regs := m.CPU().Registers(); regs.PC != ROMBase+8 || regs.SSP != 0x8000 {
+		t.Fatalf("reset PC %06x SSP %06x, want the ROM's vectors", regs.PC, regs.SSP)
+	}
+	if code, err := m.Run(1_000_000); err != nil || code != 42 {
+		t.Fatalf("Run = %d, %v, want 42", code, err)
+	}
+	// The second byte is still in the shift register when the program exits.
+	if !strings.HasPrefix("OK", output.String()) || output.Len() == 0 {
+		t.Fatalf("console output %q, want OK", output.String())
+	}
+	if m.DUART() != nil || m.ACIA() == nil {
+		t.Fatalf("ACIA console machine has DUART %v, ACIA %v", m.DUART(), m.ACIA())
+	}
+}
+
+func TestMachineLimitsAndFaults(t *testing.T) {
+	m := newMachine(t, Config{}, "loop: BRA.S loop\n")
+	if _, err := m.Run(10_000); !errors.Is(err, ErrCycleLimit) {
+		t.Fatalf("Run = %v, want ErrCycleLimit", err)
+	}
+
+	if _, err := New(Config{ROM: []byte{1, 2}}); err == nil {
+		t.Fatalf("New accepted a ROM without reset vectors")
+	}
+	if err := m.Load(0xfe0000, []byte{1}); err == nil {
+		t.Fatalf("Load into unmapped space succeeded")
+	}
+}
@@ -0,0 +1,159 @@
+package machine
+
+import (
+	"fmt"
+
+	m68kemu "github.com/jenska/m68kemu"
+)
+
+// Timer registers at TimerBase.
+const (
+	// TimerControl bit 0 enables the timer interrupt.
+	TimerControl = 0
+	// TimerStatus bit 0 is set on every tick; writing 1 clears it and
+	// acknowledges the interrupt.
+	TimerStatus = 1
+
+	timerEnable  = 0x01
+	timerPending = 0x01
+)
+
+// Timer ticks at a fixed rate and raises an autovectored TimerLevel interrupt
+// while a tick is pending and the interrupt is enabled. It stands in for
+// the system tick of a real board, such as a 68230 timer or a 555 on IPL.
+type Timer struct {
+	bank  *m68kemu.RegisterBank
+	clock *m68kemu.ClockDomain
+	line  *m68kemu.InterruptLine
+	event m68kemu.EventHandle
+}
+
+func newTimer(cpu m68kemu.CPU, base uint32, frequency, cpuFrequency uint64) (*Timer, error) {
+	clock, err := cpu.Scheduler().NewClockDomain("timer", frequency, cpuFrequency)
+	if err != nil {
+		return nil, err
+	}
+	t := &Timer{clock: clock, line: cpu.Interrupts().NewLine(nil)}
+	t.bank = m68kemu.NewRegisterBank("TIMER", base, 2, m68kemu.RegisterLayoutPacked).
+		Add(m68kemu.Register{Name: "CONTROL", Offset: TimerControl, Width: m68kemu.Byte, WriteMask: timerEnable, OnWrite: t.write(TimerControl)}).
+		Add(m68kemu.Register{Name: "STATUS", Offset: TimerStatus, Width: m68kemu.Byte, WriteMask: timerPending, OnWrite: t.acknowledge})
+	t.Reset()
+	return t, nil
+}
+
+func (t *Timer) Contains(address uint32) bool {
+	return t.bank.Contains(address)
+}
+
+func (t *Timer) AddressRange() (uint32, uint32) {
+	return t.bank.AddressRange()
+}
+
+func (t *Timer) Read(s m68kemu.Size, address uint32) (uint32, error) {
+	return t.bank.Read(s, address)
+}
+
+func (t *Timer) Write(s m68kemu.Size, address uint32, value uint32) error {
+	return t.bank.Write(s, address, value)
+}
+
+// RegisterName implements m68kemu.RegisterNamer.
+func (t *Timer) RegisterName(address uint32) (string, bool) {
+	return t.bank.RegisterName(address)
+}
+
+// Reset disables the interrupt, drops a pending tick, and restarts the tick
+// sequence.
+func (t *Timer) Reset() {
+	t.bank.Reset()
+	t.event.Cancel()
+	t.schedule(t.clock.Now() + 1)
+	t.update()
+}
+
+// Frequency returns the tick rate in Hz.
+func (t *Timer) Frequency() uint64 {
+	return t.clock.Frequency()
+}
+
+func (t *Timer) schedule(tick uint64) {
+	t.event = t.clock.ScheduleNamed("timer", tick, func(tick uint64) {
+		t.bank.SetValue(TimerStatus, timerPending)
+		t.update()
+		t.schedule(tick + 1)
+	})
+}
+
+func (t *Timer) write(register uint32) func(old, value uint32) uint32 {
+	return func(_, value uint32) uint32 {
+		t.bank.SetValue(register, value)
+		t.update()
+		return value
+	}
+}
+
+// acknowledge clears the status bits written as 1.
+func (t *Timer) acknowledge(old, value uint32) uint32 {
+	t.bank.SetValue(TimerStatus, old&^value)
+	t.update()
+	return old &^ value
+}
+
+func (t *Timer) update() {
+	if t.bank.Value(TimerControl)&timerEnable != 0 && t.bank.Value(TimerStatus)&timerPending != 0 {
+		t.line.Set(TimerLevel)
+	} else {
+		t.line.Set(0)
+	}
+}
+
+// Exit is returned by the CPU when the program writes its exit code to the
+// exit port. Run turns it into its exit code.
+type Exit struct {
+	Code int
+}
+
+func (e Exit) Error() string {
+	return fmt.Sprintf("machine: exit port written with %d", e.Code)
+}
+
+// exitPort ends the run when the program writes its exit code. A long write,
+// which the bus performs as two word writes, latches the high word at
+// ExitBase and exits with the 32-bit code once the low word at ExitBase+2
+// arrives. A byte write exits at once with the byte sign extended, so
+// MOVE.B #-1 exits with -1 just like MOVE.L #-1.
+type exitPort struct {
+	high uint32
+}
+
+func (*exitPort) Contains(address uint32) bool {
+	return address >= ExitBase && address < ExitBase+4
+}
+
+func (*exitPort) AddressRange() (uint32, uint32) {
+	return ExitBase, ExitBase + 3
+}
+
+func (*exitPort) Read(m68kemu.Size, uint32) (uint32, error) {
+	return 0, nil
+}
+
+func (p *exitPort) Write(size m68kemu.Size, address uint32, value uint32) error {
+	switch {
+	case size == m68kemu.Byte:
+		return Exit{Code: int(int8(value))}
+	case size == m68kemu.Word && address == ExitBase:
+		p.high = value & 0xffff
+		return nil
+	case size == m68kemu.Word:
+		code := p.high<<16 | value&0xffff
+		p.high = 0
+		return Exit{Code: int(int32(code))}
+	}
+	p.high = 0
+	return Exit{Code: int(int32(value))}
+}
+
+func (p *exitPort) Reset() {
+	p.high = 0
+}
@@ -47,6 +47,10 @@ func (ram *RAM) Peek(s Size, address uint32) (uint32, error) {
 	return ram.Read(s, address)
 }
 
+func (ram *RAM) Poke(s Size, address uint32, value uint32) error {
+	return ram.Write(s, address, value)
+}
+
 func (ram *RAM) Write(s Size, address uint32, value uint32) error {
 	if !ram.rangeCheck(address, s) {
 		return BusError(address)
@@ -75,3 +79,9 @@ func (ram *RAM) Reset() {
 func NewRAM(offset, size uint32) *RAM {
 	return &RAM{offset: offset, mem: make([]byte, size)}
 }
+
+// NewRAMFrom creates RAM at offset backed by mem without copying it, so a
+// memory controller can put memory it also decodes itself on the bus.
+func NewRAMFrom(offset uint32, mem []byte) *RAM {
+	return &RAM{offset: offset, mem: mem}
+}
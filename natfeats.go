@@ -0,0 +1,278 @@
+package m68kemu
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// NatFeats opcodes. Both are illegal on a real 68000, so guest code probes for
+// NatFeats with an illegal instruction handler in place.
+const (
+	OpcodeNatFeatID   = 0x7300
+	OpcodeNatFeatCall = 0x7301
+)
+
+const (
+	natFeatIDShift  = 20
+	natFeatSubIDMax = 1<<natFeatIDShift - 1
+	natFeatVersion  = 0x00010000
+	natFeatCycles   = 4
+	natFeatMaxText  = 64 * 1024
+)
+
+// NatFeatHandler implements a native feature. Its result is returned to the
+// guest in D0. An error stops execution and is returned from Step or Run.
+type NatFeatHandler func(call *NatFeatCall) (uint32, error)
+
+// NatFeat describes a native feature in a NatFeats registry.
+type NatFeat struct {
+	// Name is what the guest passes to NF_ID. Lookups ignore case.
+	Name string
+	// Supervisor restricts the feature to supervisor mode. User-mode calls
+	// raise a privilege violation.
+	Supervisor bool
+	Handler    NatFeatHandler
+}
+
+// NatFeatCall gives a NatFeatHandler access to the call's arguments.
+type NatFeatCall struct {
+	// Name is the feature's registered name.
+	Name string
+	// SubID is the sub-function the guest selected in the low 20 bits of the
+	// feature ID.
+	SubID uint32
+	// Regs are the live registers.
+	Regs *Registers
+
+	frame TrapFrame
+}
+
+// NatFeatExit is returned from Step or Run when the guest calls NF_EXIT or
+// NF_SHUTDOWN.
+type NatFeatExit struct {
+	// Code is the exit status passed to NF_EXIT. NF_SHUTDOWN exits with 0.
+	Code int
+}
+
+func (e NatFeatExit) Error() string {
+	return fmt.Sprintf("guest exited with code %d", e.Code)
+}
+
+// NatFeatsOptions configures the built-in features of a NatFeats registry.
+type NatFeatsOptions struct {
+	// Name is returned by NF_NAME. It defaults to "m68kemu".
+	Name string
+	// Stderr receives NF_STDERR output. A nil Stderr discards it.
+	Stderr io.Writer
+}
+
+// NatFeats is a registry of native features: host functions the guest finds
+// by name with the NF_ID opcode ($7300) and calls with NF_CALL ($7301), as in
+// ARAnyM and Hatari. Both opcodes take their arguments from the stack above
+// the return address of the small subroutine that wraps them, and return
+// their result in D0.
+type NatFeats struct {
+	features []NatFeat
+}
+
+// NewNatFeats returns a registry with the standard NF_NAME, NF_VERSION,
+// NF_STDERR, and NF_SHUTDOWN features and Hatari's NF_EXIT.
+func NewNatFeats(options NatFeatsOptions) *NatFeats {
+	if options.Name == "" {
+		options.Name = "m68kemu"
+	}
+	stderr := options.Stderr
+	if stderr == nil {
+		stderr = io.Discard
+	}
+
+	n := &NatFeats{}
+	_ = n.Register(NatFeat{Name: "NF_NAME", Handler: func(call *NatFeatCall) (uint32, error) {
+		buffer, err := call.Arg(0)
+		if err != nil {
+			return 0, err
+		}
+		size, err := call.Arg(1)
+		if err != nil {
+			return 0, err
+		}
+		return uint32(len(options.Name)), call.WriteString(buffer, size, options.Name)
+	}})
+	_ = n.Register(NatFeat{Name: "NF_VERSION", Handler: func(*NatFeatCall) (uint32, error) {
+		return natFeatVersion, nil
+	}})
+	_ = n.Register(NatFeat{Name: "NF_STDERR", Handler: func(call *NatFeatCall) (uint32, error) {
+		address, err := call.Arg(0)
+		if err != nil {
+			return 0, err
+		}
+		text, err := call.ReadString(address)
+		if err != nil {
+			return 0, err
+		}
+		_, _ = io.WriteString(stderr, text)
+		return uint32(len(text)), nil
+	}})
+	_ = n.Register(NatFeat{Name: "NF_SHUTDOWN", Supervisor: true, Handler: func(*NatFeatCall) (uint32, error) {
+		return 0, NatFeatExit{}
+	}})
+	_ = n.Register(NatFeat{Name: "NF_EXIT", Handler: func(call *NatFeatCall) (uint32, error) {
+		code, err := call.Arg(0)
+		if err != nil {
+			return 0, err
+		}
+		return 0, NatFeatExit{Code: int(int32(code))}
+	}})
+	return n
+}
+
+// Register adds a feature. Registering a name again replaces the feature but
+// keeps its ID.
+func (n *NatFeats) Register(feature NatFeat) error {
+	if feature.Name == "" || feature.Handler == nil {
+		return fmt.Errorf("natfeat %q needs a name and a handler", feature.Name)
+	}
+	if index, ok := n.lookup(feature.Name); ok {
+		n.features[index] = feature
+		return nil
+	}
+	if len(n.features) >= 1<<(32-natFeatIDShift)-1 {
+		return fmt.Errorf("natfeat %q: registry is full", feature.Name)
+	}
+	n.features = append(n.features, feature)
+	return nil
+}
+
+// ID returns the feature ID NF_ID reports for name, or 0 when it is unknown.
+func (n *NatFeats) ID(name string) uint32 {
+	index, ok := n.lookup(name)
+	if !ok {
+		return 0
+	}
+	return uint32(index+1) << natFeatIDShift
+}
+
+func (n *NatFeats) lookup(name string) (int, bool) {
+	for i, feature := range n.features {
+		if strings.EqualFold(feature.Name, name) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// SetNatFeats enables the NatFeats opcodes with the features in n. A nil
+// registry disables them again, so $7300 and $7301 raise illegal instruction
+// exceptions as on real hardware.
+func (cpu *cpu) SetNatFeats(n *NatFeats) {
+	cpu.natFeats = n
+}
+
+// natFeat executes NF_ID or NF_CALL. It reports false for an unknown feature
+// ID, which then raises an illegal instruction exception.
+func (cpu *cpu) natFeat(opcode uint16, instructionPC uint32) (bool, error) {
+	call := NatFeatCall{
+		Regs: &cpu.regs,
+		frame: TrapFrame{
+			Vector:   XIllegal,
+			Opcode:   opcode,
+			PC:       instructionPC,
+			ReturnPC: cpu.regs.PC,
+			Regs:     &cpu.regs,
+			cpu:      cpu,
+		},
+	}
+	if opcode == OpcodeNatFeatID {
+		address, err := call.Arg(0)
+		if err != nil {
+			return false, err
+		}
+		name, err := call.ReadString(address)
+		if err != nil {
+			return false, err
+		}
+		cpu.addCycles(natFeatCycles)
+		cpu.regs.D[0] = int32(cpu.natFeats.ID(name))
+		return true, nil
+	}
+
+	id, err := call.frame.Arg(Long, 4)
+	if err != nil {
+		return false, err
+	}
+	index := int(id>>natFeatIDShift) - 1
+	if index < 0 || index >= len(cpu.natFeats.features) {
+		return false, nil
+	}
+	feature := cpu.natFeats.features[index]
+	if feature.Supervisor {
+		if ok, err := cpu.requireSupervisor(); !ok {
+			return true, err
+		}
+	}
+	call.Name = feature.Name
+	call.SubID = id & natFeatSubIDMax
+	cpu.addCycles(natFeatCycles)
+	result, err := feature.Handler(&call)
+	if err != nil {
+		return true, err
+	}
+	cpu.regs.D[0] = int32(result)
+	return true, nil
+}
+
+// Arg reads the index-th long argument after the feature ID. For NF_ID,
+// argument 0 is the feature name.
+func (c *NatFeatCall) Arg(index int) (uint32, error) {
+	offset := uint32(4 + 4*index)
+	if c.frame.Opcode == OpcodeNatFeatCall {
+		offset += 4
+	}
+	return c.frame.Arg(Long, offset)
+}
+
+// Read reads guest memory with the caller's privileges.
+func (c *NatFeatCall) Read(size Size, address uint32) (uint32, error) {
+	return c.frame.Read(size, address)
+}
+
+// Write writes guest memory with the caller's privileges.
+func (c *NatFeatCall) Write(size Size, address uint32, value uint32) error {
+	return c.frame.Write(size, address, value)
+}
+
+// ReadString reads a NUL-terminated string of at most 64 KiB from guest
+// memory.
+func (c *NatFeatCall) ReadString(address uint32) (string, error) {
+	var b strings.Builder
+	for b.Len() < natFeatMaxText {
+		value, err := c.frame.Read(Byte, address)
+		if err != nil {
+			return "", err
+		}
+		if value == 0 {
+			return b.String(), nil
+		}
+		b.WriteByte(byte(value))
+		address++
+	}
+	return b.String(), nil
+}
+
+// WriteString stores s into a guest buffer of size bytes, truncating it to
+// leave room for the terminating NUL.
+func (c *NatFeatCall) WriteString(address, size uint32, s string) error {
+	if size == 0 {
+		return nil
+	}
+	if uint32(len(s)) >= size {
+		s = s[:size-1]
+	}
+	for i := range len(s) {
+		if err := c.frame.Write(Byte, address+uint32(i), uint32(s[i])); err != nil {
+			return err
+		}
+	}
+	return c.frame.Write(Byte, address+uint32(len(s)), 0)
+}
@@ -0,0 +1,135 @@
+package m68kemu
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// natFeatStubs are the subroutines guest code wraps the NatFeats opcodes in.
+const natFeatStubs = `
+nf_id:  DC.W    $7300
+        RTS
+nf_call:
+        DC.W    $7301
+        RTS
+`
+
+func TestNatFeatsStderrAndExit(t *testing.T) {
+	cpu, ram := newEnvironment(t)
+	loadProgram(t, ram, cpu.regs.PC, `
+        LEA     stderr(PC),A0
+        MOVE.L  A0,-(A7)
+        BSR     nf_id
+        ADDQ.L  #4,A7
+        MOVE.L  D0,D7
+        LEA     msg(PC),A0
+        MOVE.L  A0,-(A7)
+        MOVE.L  D7,-(A7)
+        BSR     nf_call
+        ADDQ.L  #8,A7
+        MOVE.L  D0,D6
+        LEA     exit(PC),A0
+        MOVE.L  A0,-(A7)
+        BSR     nf_id
+        ADDQ.L  #4,A7
+        MOVE.L  #42,-(A7)
+        MOVE.L  D0,-(A7)
+        BSR     nf_call
+`+natFeatStubs+`
+stderr: DC.B    78,70,95,83,84,68,69,82,82,0
+exit:   DC.B    110,102,95,101,120,105,116,0
+msg:    DC.B    104,105,10,0
+`)
+	var out bytes.Buffer
+	cpu.SetNatFeats(NewNatFeats(NatFeatsOptions{Stderr: &out}))
+
+	err := cpu.RunCycles(10_000)
+	var exit NatFeatExit
+	if !errors.As(err, &exit) || exit.Code != 42 {
+		t.Fatalf("RunCycles = %v, want NF_EXIT with code 42", err)
+	}
+	if out.String() != "hi\n" || cpu.regs.D[6] != 3 {
+		t.Fatalf("stderr = %q, D6 = %d, want %q and 3", out.String(), cpu.regs.D[6], "hi\n")
+	}
+	if cpu.regs.D[7] != 3<<natFeatIDShift {
+		t.Fatalf("NF_STDERR ID = %08x, want %08x", cpu.regs.D[7], 3<<natFeatIDShift)
+	}
+}
+
+func TestNatFeatsCustomFeatureAndPrivileges(t *testing.T) {
+	cpu, ram := newEnvironment(t)
+	loadProgram(t, ram, cpu.regs.PC, `
+        LEA     name(PC),A0
+        MOVE.L  A0,-(A7)
+        BSR     nf_id
+        ADDQ.L  #4,A7
+        ORI.L   #5,D0
+        MOVE.L  #$1234,-(A7)
+        MOVE.L  D0,-(A7)
+        BSR     nf_call
+        ADDQ.L  #8,A7
+        MOVE.L  D0,D7
+        LEA     $800,A1
+        MOVE.L  A1,USP
+        ANDI.W  #$DFFF,SR
+        MOVE.L  #$400000,-(A7)
+        BSR     nf_call
+`+natFeatStubs+`
+name:   DC.B    104,111,115,116,0
+`)
+	if err := ram.Write(Long, XPrivViolation<<2, 0x3000); err != nil {
+		t.Fatalf("install vector: %v", err)
+	}
+	features := NewNatFeats(NatFeatsOptions{})
+	var call NatFeatCall
+	if err := features.Register(NatFeat{Name: "HOST", Handler: func(c *NatFeatCall) (uint32, error) {
+		call = *c
+		arg, err := c.Arg(0)
+		return arg + c.SubID, err
+	}}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if err := features.Register(NatFeat{Name: "broken"}); err == nil {
+		t.Fatalf("Register accepted a feature without a handler")
+	}
+	cpu.SetNatFeats(features)
+
+	if _, err := cpu.RunUntil(RunUntilOptions{StopAtPC: []uint32{0x3000}, MaxInstructions: 100}); err != nil {
+		t.Fatalf("RunUntil failed: %v", err)
+	}
+	if call.Name != "HOST" || call.SubID != 5 || cpu.regs.D[7] != 0x1239 {
+		t.Fatalf("call = %+v, D7 = %08x, want HOST sub-function 5 returning 1239", call, cpu.regs.D[7])
+	}
+	if cpu.lastException.Vector != XPrivViolation {
+		t.Fatalf("user-mode NF_SHUTDOWN raised vector %d, want a privilege violation", cpu.lastException.Vector)
+	}
+}
+
+func TestNatFeatsOpcodesAreIllegalWhenDisabledOrUnknown(t *testing.T) {
+	cpu, ram := newEnvironment(t)
+	loadProgram(t, ram, cpu.regs.PC, "MOVE.L #$7FF00000,-(A7)\nDC.W $7301\n")
+	if err := ram.Write(Long, XIllegal<<2, 0x3000); err != nil {
+		t.Fatalf("install vector: %v", err)
+	}
+
+	cpu.SetNatFeats(NewNatFeats(NatFeatsOptions{}))
+	if err := cpu.RunInstructions(2); err != nil {
+		t.Fatalf("RunInstructions failed: %v", err)
+	}
+	if cpu.regs.PC != 0x3000 || cpu.lastException.Vector != XIllegal {
+		t.Fatalf("unknown NF_CALL ID: PC = %04x, want the illegal instruction handler", cpu.regs.PC)
+	}
+
+	cpu, ram = newEnvironment(t)
+	loadProgram(t, ram, cpu.regs.PC, "DC.W $7300\n")
+	if err := ram.Write(Long, XIllegal<<2, 0x3000); err != nil {
+		t.Fatalf("install vector: %v", err)
+	}
+	if err := cpu.Step(); err != nil {
+		t.Fatalf("Step failed: %v", err)
+	}
+	if cpu.regs.PC != 0x3000 {
+		t.Fatalf("NF_ID without NatFeats: PC = %04x, want the illegal instruction handler", cpu.regs.PC)
+	}
+}
@@ -343,7 +343,7 @@ func bitDynamic(cpu *cpu) error {
 	bitNumber := uint32(cpu.regs.D[index])
 	mode := (cpu.regs.IR >> 3) & 0x7
 
-	var dst modifier
+	var dst *operand
 	if mode != 0 {
 		var err error
 		dst, err = cpu.ResolveSrcEA(Byte)
@@ -363,7 +363,7 @@ func bitImmediate(cpu *cpu) error {
 		return err
 	}
 
-	var dst modifier
+	var dst *operand
 	if mode != 0 {
 		var err error
 		dst, err = cpu.ResolveSrcEA(Byte)
@@ -375,7 +375,7 @@ func bitImmediate(cpu *cpu) error {
 	return bitOperation(cpu, imm, mode, dst)
 }
 
-func bitOperation(cpu *cpu, bitNumber uint32, mode uint16, dst modifier) error {
+func bitOperation(cpu *cpu, bitNumber uint32, mode uint16, dst *operand) error {
 	op := (cpu.regs.IR >> 6) & 0x7
 	opType := op & 0x3 // 0=BTST, 1=BCHG, 2=BCLR, 3=BSET
 
@@ -401,14 +401,6 @@ func bitOperation(cpu *cpu, bitNumber uint32, mode uint16, dst modifier) error {
 		return nil
 	}
 
-	if dst == nil {
-		var err error
-		dst, err = cpu.ResolveSrcEA(Byte)
-		if err != nil {
-			return err
-		}
-	}
-
 	value, err := dst.read()
 	if err != nil {
 		return err
@@ -0,0 +1,312 @@
+package peripheral
+
+import (
+	"fmt"
+	"io"
+
+	m68kemu "github.com/jenska/m68kemu"
+)
+
+const (
+	// ACIAKeyboardBase and ACIAMIDIBase are where the Atari ST maps its two
+	// ACIAs, on the even byte lane.
+	ACIAKeyboardBase = 0xfffc00
+	ACIAMIDIBase     = 0xfffc04
+	// DefaultACIAClock is the 500 kHz transmit and receive clock of the ST's
+	// ACIAs, which with the divide-by-64 setting gives the IKBD's 7812.5 baud.
+	DefaultACIAClock = 500_000
+)
+
+// ACIA register numbers. Each one is a read and a write register.
+const (
+	aciaControl = iota // write: control, read: status
+	aciaData           // write: transmit data, read: receive data
+	aciaRegisters
+)
+
+const (
+	aciaDivideMask   = 0x03
+	aciaMasterReset  = 0x03
+	aciaWordMask     = 0x1c
+	aciaTxMask       = 0x60
+	aciaTxInterrupt  = 0x20
+	aciaRxInterrupt  = 0x80
+	aciaStatusRDRF   = 0x01
+	aciaStatusTDRE   = 0x02
+	aciaStatusFE     = 0x10
+	aciaStatusOVRN   = 0x20
+	aciaStatusIRQ    = 0x80
+	aciaStatusErrors = aciaStatusFE | aciaStatusOVRN | 0x40
+)
+
+// aciaDividers are the clock divide ratios selected by CR1-CR0.
+var aciaDividers = [4]uint64{1, 16, 64, 0}
+
+// ACIAOptions configures an ACIA.
+type ACIAOptions struct {
+	// Base is the address of the control/status register and Layout places
+	// the data register after it. The ST's ACIAs use ACIAKeyboardBase or
+	// ACIAMIDIBase with m68kemu.RegisterLayoutEven.
+	Base   uint32
+	Layout m68kemu.RegisterLayout
+	// Name prefixes the register names in bus traces. It defaults to "ACIA".
+	Name string
+	// Clock is the transmit and receive clock in Hz, before the divider the
+	// control register selects. It defaults to DefaultACIAClock.
+	Clock uint64
+	// CPUFrequency is the CPU clock in Hz, defaulting to m68kemu.FrequencyST.
+	CPUFrequency uint64
+	// Input is the serial line into the receive data register. The ACIA
+	// takes the next byte one character time after the program has read the
+	// previous one, and ignores Input after a read error or io.EOF.
+	Input io.Reader
+	// Output receives every transmitted byte once its last stop bit is sent.
+	Output io.Writer
+	// Level, when non-zero, connects the IRQ output to an autovectored
+	// interrupt line at that level.
+	Level uint8
+	// OnIRQ, when set, is called as the IRQ output changes, for wiring it to
+	// another chip, such as GPIP 4 of the ST's MFP.
+	OnIRQ func(active bool)
+}
+
+// ACIA models the MC6850 asynchronous communications interface adapter: a
+// control/status register, double-buffered transmit and receive data
+// registers, the clock divider and word format settings, and an IRQ output.
+//
+// Characters take the time the divider and word format give them on a clock
+// domain of the CPU's CycleScheduler. The ACIA is a 6800-family device, so
+// it reports every register address as VPA and the bus synchronises accesses
+// to the E clock.
+type ACIA struct {
+	bank   *m68kemu.RegisterBank
+	clock  *m68kemu.ClockDomain
+	line   *m68kemu.InterruptLine
+	level  uint8
+	onIRQ  func(bool)
+	serial hostLink
+
+	control uint8
+	irq     bool
+}
+
+// NewACIA creates an ACIA in its master reset state, timing characters on a
+// clock domain of cpu's scheduler, which is created if cpu has none. The
+// receiver starts reading options.Input once the program leaves master reset.
+// Add the ACIA to the bus as a device.
+func NewACIA(cpu m68kemu.CPU, options ACIAOptions) (*ACIA, error) {
+	if options.Name == "" {
+		options.Name = "ACIA"
+	}
+	if options.Level > 7 {
+		return nil, fmt.Errorf("acia: invalid interrupt level %d", options.Level)
+	}
+	if options.Clock == 0 {
+		options.Clock = DefaultACIAClock
+	}
+	if options.CPUFrequency == 0 {
+		options.CPUFrequency = m68kemu.FrequencyST
+	}
+	if options.Output == nil {
+		options.Output = io.Discard
+	}
+
+	clock, err := schedulerOf(cpu).NewClockDomain(options.Name, options.Clock, options.CPUFrequency)
+	if err != nil {
+		return nil, fmt.Errorf("acia: %w", err)
+	}
+
+	a := &ACIA{clock: clock, level: options.Level, onIRQ: options.OnIRQ}
+	a.serial = hostLink{
+		clock:   clock,
+		input:   options.Input,
+		output:  options.Output,
+		txTicks: a.characterTicks,
+		rxTicks: a.characterTicks,
+		load:    a.loadShifter,
+		room:    func() bool { return a.status()&aciaStatusRDRF == 0 },
+		deliver: func(b byte) { a.receive(b, false) },
+		pending: -1,
+	}
+	if options.Level != 0 {
+		a.line = cpu.Interrupts().NewLine(nil)
+	}
+	size := uint32(aciaRegisters)
+	if options.Layout != m68kemu.RegisterLayoutPacked {
+		size *= 2
+	}
+	a.bank = m68kemu.NewRegisterBank(options.Name, options.Base, size, options.Layout)
+	a.bank.
+		Add(m68kemu.Register{Name: "CR/SR", Offset: aciaControl, Width: m68kemu.Byte, Reset: aciaStatusTDRE, OnWrite: a.writeControl}).
+		Add(m68kemu.Register{Name: "DATA", Offset: aciaData, Width: m68kemu.Byte, OnRead: a.readData, OnWrite: a.writeData})
+	a.Reset()
+	return a, nil
+}
+
+func (a *ACIA) Contains(address uint32) bool {
+	return a.bank.Contains(address)
+}
+
+func (a *ACIA) AddressRange() (uint32, uint32) {
+	return a.bank.AddressRange()
+}
+
+func (a *ACIA) Read(s m68kemu.Size, address uint32) (uint32, error) {
+	return a.bank.Read(s, address)
+}
+
+func (a *ACIA) Write(s m68kemu.Size, address uint32, value uint32) error {
+	return a.bank.Write(s, address, value)
+}
+
+// Peek returns the status and receive data registers without clearing the
+// receive flags.
+func (a *ACIA) Peek(s m68kemu.Size, address uint32) (uint32, error) {
+	return a.bank.Peek(s, address)
+}
+
+// RegisterName implements m68kemu.RegisterNamer.
+func (a *ACIA) RegisterName(address uint32) (string, bool) {
+	return a.bank.RegisterName(address)
+}
+
+// ValidPeripheralAddress implements m68kemu.VPADevice.
+func (a *ACIA) ValidPeripheralAddress(address uint32) bool {
+	return a.bank.Contains(address)
+}
+
+// Reset performs a master reset: it drops characters in flight, empties the
+// data registers, and releases the IRQ output.
+func (a *ACIA) Reset() {
+	a.bank.Reset()
+	a.control = aciaMasterReset
+	a.serial.stopTransmitter()
+	a.serial.stopReceiver()
+	a.updateIRQ()
+}
+
+// Receive puts a byte from the serial line into the receive data register. A
+// byte arriving before the program read the previous one is lost and sets
+// the overrun flag. It reports whether the byte was taken.
+func (a *ACIA) Receive(b byte) bool {
+	return a.receive(b, false)
+}
+
+// ReceiveBreak receives a break, which the 6850 reports as a zero byte with
+// a framing error.
+func (a *ACIA) ReceiveBreak() {
+	a.receive(0, true)
+}
+
+func (a *ACIA) receive(b byte, framingError bool) bool {
+	if a.control&aciaDivideMask == aciaMasterReset {
+		return false
+	}
+	status := a.status()
+	if status&aciaStatusRDRF != 0 {
+		a.setStatus(status | aciaStatusOVRN)
+		return false
+	}
+	if a.control&aciaWordMask < 0x10 {
+		b &= 0x7f
+	}
+	status = status&^aciaStatusFE | aciaStatusRDRF
+	if framingError {
+		status |= aciaStatusFE
+	}
+	a.bank.SetValue(aciaData, uint32(b))
+	a.setStatus(status)
+	return true
+}
+
+// characterTicks returns the clock ticks one character takes on the line:
+// the divider times a start bit, the data bits, parity, and the stop bits.
+func (a *ACIA) characterTicks() uint64 {
+	bits := uint64(11)
+	if word := a.control & aciaWordMask >> 2; word == 2 || word == 3 || word == 5 {
+		bits = 10
+	}
+	return bits * aciaDividers[a.control&aciaDivideMask]
+}
+
+func (a *ACIA) status() uint32 {
+	return a.bank.Value(aciaControl)
+}
+
+func (a *ACIA) setStatus(status uint32) {
+	a.bank.SetValue(aciaControl, status)
+	a.updateIRQ()
+}
+
+// updateIRQ drives the IRQ output for a full receive register or overrun
+// when receive interrupts are enabled, and for an empty transmit register
+// when transmit interrupts are enabled.
+func (a *ACIA) updateIRQ() {
+	status := a.status()
+	irq := a.control&aciaRxInterrupt != 0 && status&(aciaStatusRDRF|aciaStatusOVRN) != 0 ||
+		a.control&aciaTxMask == aciaTxInterrupt && status&aciaStatusTDRE != 0
+	if irq {
+		status |= aciaStatusIRQ
+	} else {
+		status &^= aciaStatusIRQ
+	}
+	a.bank.SetValue(aciaControl, status)
+	if irq == a.irq {
+		return
+	}
+	a.irq = irq
+	if a.line != nil {
+		if irq {
+			a.line.Set(a.level)
+		} else {
+			a.line.Set(0)
+		}
+	}
+	if a.onIRQ != nil {
+		a.onIRQ(irq)
+	}
+}
+
+// writeControl stores the control register, which is write-only: the bank
+// keeps the status register in its place. Counter divide select 3 is the
+// master reset; leaving it starts the receiver.
+func (a *ACIA) writeControl(old, value uint32) uint32 {
+	wasReset := a.control&aciaDivideMask == aciaMasterReset
+	if value&aciaDivideMask == aciaMasterReset {
+		a.Reset()
+		return a.status()
+	}
+	a.control = uint8(value)
+	if wasReset {
+		a.serial.startReceiver()
+	}
+	a.updateIRQ()
+	return a.status()
+}
+
+// readData returns the receive data register and clears the receive flags.
+func (a *ACIA) readData(value uint32) uint32 {
+	a.setStatus(a.status() &^ (aciaStatusRDRF | aciaStatusErrors))
+	return value
+}
+
+// writeData queues a byte for the transmitter. The transmit data register
+// empties again as soon as the shift register takes the byte.
+func (a *ACIA) writeData(old, value uint32) uint32 {
+	if a.control&aciaDivideMask == aciaMasterReset {
+		return old
+	}
+	a.setStatus(a.status() &^ aciaStatusTDRE)
+	a.serial.send(byte(value))
+	return old
+}
+
+// loadShifter empties the transmit data register into the shift register,
+// dropping bit 7 for 7-bit word formats.
+func (a *ACIA) loadShifter(b byte) byte {
+	a.setStatus(a.status() | aciaStatusTDRE)
+	if a.control&aciaWordMask < 0x10 {
+		b &= 0x7f
+	}
+	return b
+}
@@ -0,0 +1,142 @@
+package peripheral
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	asm "github.com/jenska/m68kasm"
+	m68kemu "github.com/jenska/m68kemu"
+)
+
+func newACIAEnvironment(tb testing.TB, options ACIAOptions) (m68kemu.CPU, *m68kemu.Bus, *ACIA) {
+	tb.Helper()
+	bus := m68kemu.NewBus(m68kemu.NewRAM(0, 0x10000))
+	cpu, err := m68kemu.NewCPU(bus)
+	if err != nil {
+		tb.Fatalf("NewCPU failed: %v", err)
+	}
+	if options.Base == 0 {
+		options.Base, options.Layout = ACIAKeyboardBase, m68kemu.RegisterLayoutEven
+	}
+	acia, err := NewACIA(cpu, options)
+	if err != nil {
+		tb.Fatalf("NewACIA failed: %v", err)
+	}
+	bus.AddDevice(acia)
+	return cpu, bus, acia
+}
+
+func TestACIATransmitTiming(t *testing.T) {
+	var output bytes.Buffer
+	cpu, bus, _ := newACIAEnvironment(t, ACIAOptions{Output: &output})
+	scheduler := cpu.Scheduler()
+	status := func() uint32 {
+		value, _ := bus.Read(m68kemu.Byte, ACIAKeyboardBase)
+		return value
+	}
+
+	// 8N1 with the divide-by-64 clock: 640 ACIA clocks, 10240 CPU cycles.
+	const character = 10 * 64 * m68kemu.FrequencyST / DefaultACIAClock
+	_ = bus.Write(m68kemu.Byte, ACIAKeyboardBase, 0x36) // TX interrupts, 8N1, /64
+	_ = bus.Write(m68kemu.Byte, ACIAKeyboardBase+2, 'o')
+	start := scheduler.Now()
+	if status() != aciaStatusTDRE|aciaStatusIRQ {
+		t.Fatalf("status = %02x, want the shift register to take the first byte", status())
+	}
+	_ = bus.Write(m68kemu.Byte, ACIAKeyboardBase+2, 'k')
+	if status() != 0 {
+		t.Fatalf("status = %02x, want a full transmit register", status())
+	}
+
+	// Characters start on an edge of the ACIA clock, 16 CPU cycles apart.
+	end, _ := scheduler.NextEvent()
+	if end <= start+character-16 || end > start+character {
+		t.Fatalf("first character ends %d cycles after the write, want %d rounded to the ACIA clock", end-start, character)
+	}
+	scheduler.Advance(end - 1 - scheduler.Now())
+	if output.Len() != 0 {
+		t.Fatalf("output %q before the first stop bit", output.String())
+	}
+	scheduler.Advance(1)
+	if output.String() != "o" || status()&aciaStatusTDRE == 0 {
+		t.Fatalf("output %q, status %02x after one character", output.String(), status())
+	}
+	scheduler.Advance(character)
+	if output.String() != "ok" {
+		t.Fatalf("output %q after two characters, want ok", output.String())
+	}
+}
+
+func TestACIAReceiveStatusAndInterrupts(t *testing.T) {
+	var irq []bool
+	cpu, bus, acia := newACIAEnvironment(t, ACIAOptions{
+		Input: strings.NewReader("a"),
+		Level: 6,
+		OnIRQ: func(active bool) { irq = append(irq, active) },
+	})
+	ic := cpu.Interrupts()
+
+	_ = bus.Write(m68kemu.Byte, ACIAKeyboardBase, 0x96) // RX interrupts, 8N1, /64
+	cpu.Scheduler().Advance(10 * 64 * m68kemu.FrequencyST / DefaultACIAClock)
+	if status, _ := acia.Peek(m68kemu.Byte, ACIAKeyboardBase); status != aciaStatusIRQ|aciaStatusTDRE|aciaStatusRDRF {
+		t.Fatalf("status = %02x, want a received byte and IRQ", status)
+	}
+	if level, vector, autoVector, ok := ic.Pending(0); !ok || level != 6 || vector != 30 || !autoVector {
+		t.Fatalf("Pending = %d, %d, %v, %v, want a level 6 autovector", level, vector, autoVector, ok)
+	}
+
+	if acia.Receive('b') {
+		t.Fatalf("full receive register took another byte")
+	}
+	if data, _ := bus.Read(m68kemu.Byte, ACIAKeyboardBase+2); data != 'a' {
+		t.Fatalf("data = %q, want a", rune(data))
+	}
+	if status, _ := bus.Read(m68kemu.Byte, ACIAKeyboardBase); status != aciaStatusTDRE || ic.HasPending(0) {
+		t.Fatalf("status = %02x after reading the data, want only TDRE and no interrupt", status)
+	}
+
+	acia.ReceiveBreak()
+	if status, _ := bus.Read(m68kemu.Byte, ACIAKeyboardBase); status&aciaStatusFE == 0 {
+		t.Fatalf("status = %02x after a break, want a framing error", status)
+	}
+	_ = bus.Write(m68kemu.Byte, ACIAKeyboardBase, aciaMasterReset)
+	if status, _ := bus.Read(m68kemu.Byte, ACIAKeyboardBase); status != aciaStatusTDRE || acia.Receive('c') {
+		t.Fatalf("status = %02x after master reset, want an idle ACIA that ignores the line", status)
+	}
+	if want := []bool{true, false, true, false}; !slices.Equal(irq, want) {
+		t.Fatalf("IRQ changes = %v, want %v", irq, want)
+	}
+}
+
+func TestACIASerialConsoleEcho(t *testing.T) {
+	var output bytes.Buffer
+	cpu, bus, _ := newACIAEnvironment(t, ACIAOptions{Input: strings.NewReader("hi\n"), Output: &output})
+	code, _, err := asm.AssembleStringWithListing(`
+        MOVE.B  #3,$FFFC00       ; master reset
+        MOVE.B  #$15,$FFFC00     ; 8N1, /16
+wait:   BTST    #0,$FFFC00
+        BEQ.S   wait
+        MOVE.B  $FFFC02,D0
+tx:     BTST    #1,$FFFC00
+        BEQ.S   tx
+        MOVE.B  D0,$FFFC02
+        CMP.B   #10,D0
+        BNE.S   wait
+done:   BRA.S   done
+`)
+	if err != nil {
+		t.Fatalf("Assembler failed: %v", err)
+	}
+	for i, b := range code {
+		_ = bus.Write(m68kemu.Byte, 0x1000+uint32(i), uint32(b))
+	}
+	cpu.SetRegisters(m68kemu.Registers{SR: 0x2700, A: [8]uint32{7: 0x8000}, SSP: 0x8000, PC: 0x1000})
+	if err := cpu.RunCycles(100_000); err != nil {
+		t.Fatalf("RunCycles failed: %v", err)
+	}
+	if output.String() != "hi\n" {
+		t.Fatalf("echoed %q, want hi", output.String())
+	}
+}
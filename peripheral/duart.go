@@ -0,0 +1,664 @@
+package peripheral
+
+import (
+	"fmt"
+	"io"
+
+	m68kemu "github.com/jenska/m68kemu"
+)
+
+// DefaultDUARTClock is the 3.6864 MHz X1 crystal the 68681 baud rate table
+// assumes.
+const DefaultDUARTClock = 3_686_400
+
+// DUART register numbers. Most of them are one register when read and
+// another when written.
+const (
+	duartMRA   = iota // MR1A/MR2A
+	duartSRA          // read: SRA, write: CSRA
+	duartCRA          // write: CRA
+	duartRHRA         // read: RHRA, write: THRA
+	duartIPCR         // read: IPCR, write: ACR
+	duartISR          // read: ISR, write: IMR
+	duartCUR          // read: CUR, write: CTUR
+	duartCLR          // read: CLR, write: CTLR
+	duartMRB          // MR1B/MR2B
+	duartSRB          // read: SRB, write: CSRB
+	duartCRB          // write: CRB
+	duartRHRB         // read: RHRB, write: THRB
+	duartIVR          // IVR
+	duartIP           // read: input port, write: OPCR
+	duartStart        // read: start counter, write: set output port bits
+	duartStop         // read: stop counter, write: reset output port bits
+	duartRegisters
+)
+
+const (
+	duartSRRxRDY   = 0x01
+	duartSRFFULL   = 0x02
+	duartSRTxRDY   = 0x04
+	duartSRTxEMT   = 0x08
+	duartSROverrun = 0x10
+
+	duartISRCounter     = 0x08
+	duartISRInputChange = 0x80
+
+	duartMR1FFULLInt = 0x40
+	duartACRBRGSet2  = 0x80
+	duartACRTimer    = 0x40
+
+	duartFIFODepth  = 3
+	duartResetIVR   = 0x0f
+	duartCounterMax = 0x10000
+)
+
+// duartBitTicks holds the bit time in X1 clocks for the baud rate selects
+// 0-12 of both baud rate generator sets. Select 13 takes the counter/timer
+// output as a 16x clock. The external clocks, selects 14 and 15, are not
+// modelled and run at 9600 baud.
+var duartBitTicks = [2][13]uint64{
+	// 50, 110, 134.5, 200, 300, 600, 1200, 1050, 2400, 4800, 7200, 9600, 38400
+	{73728, 33513, 27408, 18432, 12288, 6144, 3072, 3511, 1536, 768, 512, 384, 96},
+	// 75, 110, 134.5, 150, 300, 600, 1200, 2000, 2400, 4800, 1800, 9600, 19200
+	{49152, 33513, 27408, 24576, 12288, 6144, 3072, 1843, 1536, 768, 2048, 384, 192},
+}
+
+// DUARTChannelOptions connects a DUART channel to the host.
+type DUARTChannelOptions struct {
+	// Input feeds the channel's receiver while it is enabled. A byte is read
+	// each character time at the receive baud rate until the three-byte FIFO
+	// is full, so only Receive can overrun it. The channel ignores Input after
+	// a read error or io.EOF.
+	Input io.Reader
+	// Output receives every transmitted byte once it has left the shift
+	// register. A nil Output discards the bytes.
+	Output io.Writer
+}
+
+// DUARTOptions configures a DUART.
+type DUARTOptions struct {
+	// Base is the address of the first register and Layout spreads the
+	// sixteen registers over the bus. Boards that wire the 68681 to D0-D7 use
+	// m68kemu.RegisterLayoutOdd.
+	Base   uint32
+	Layout m68kemu.RegisterLayout
+	// Name prefixes the register names in bus traces. It defaults to "DUART".
+	Name string
+	// Clock is the X1 crystal in Hz, defaulting to DefaultDUARTClock.
+	Clock uint64
+	// CPUFrequency is the CPU clock in Hz, defaulting to m68kemu.FrequencyST.
+	CPUFrequency uint64
+	// Level, when non-zero, connects the IRQ output to an interrupt line at
+	// that level. The DUART answers the acknowledge with its IVR unless
+	// AutoVector is set.
+	Level      uint8
+	AutoVector bool
+	// A and B connect the two serial channels.
+	A, B DUARTChannelOptions
+}
+
+// DUART models the MC68681 (SCN2681) dual asynchronous receiver/transmitter:
+// two serial channels with three-byte receive FIFOs, the 16-bit
+// counter/timer, the 6-bit input and 8-bit output ports, the interrupt
+// status and mask registers, and a vectored interrupt acknowledge.
+//
+// Characters take the time the selected baud rate gives them on a clock
+// domain of the CPU's CycleScheduler. The counter/timer runs from X1 or
+// X1/16; the IP2 and transmitter clock sources are not modelled and leave it
+// stopped. Channel modes other than normal are stored but not emulated.
+type DUART struct {
+	bank    *m68kemu.RegisterBank
+	clock   *m68kemu.ClockDomain
+	line    *m68kemu.InterruptLine
+	level   uint8
+	peeking bool
+
+	channels [2]duartChannel
+	counter  duartCounter
+	acr      uint8
+	imr      uint8
+	ivr      uint8
+	opr      uint8
+	opcr     uint8
+	inputs   uint8
+	ipcr     uint8 // change-of-state bits 7-4
+}
+
+// duartChannel is one serial channel.
+type duartChannel struct {
+	d      *DUART
+	serial hostLink
+
+	mr        [2]uint8
+	mrPointer int
+	csr       uint8
+	rxEnabled bool
+	txEnabled bool
+	fifo      []byte
+	errors    uint8 // SR bits 7-4
+}
+
+// duartCounter is the counter/timer. Like the MFP timers, a running counter
+// is derived from the tick it was started at.
+type duartCounter struct {
+	preload  uint32
+	value    uint32 // count as of start
+	start    uint64
+	running  bool
+	event    m68kemu.EventHandle
+	output   bool
+	ready    bool
+	halfWave bool
+}
+
+// NewDUART creates a DUART whose two channels and counter/timer share one
+// clock domain on cpu's scheduler, creating the scheduler if cpu lacks one.
+// Both channels start disabled, so the host streams are idle until the program
+// enables them. Add the DUART to the bus as a device.
+func NewDUART(cpu m68kemu.CPU, options DUARTOptions) (*DUART, error) {
+	if options.Name == "" {
+		options.Name = "DUART"
+	}
+	if options.Level > 7 {
+		return nil, fmt.Errorf("duart: invalid interrupt level %d", options.Level)
+	}
+	if options.Clock == 0 {
+		options.Clock = DefaultDUARTClock
+	}
+	if options.CPUFrequency == 0 {
+		options.CPUFrequency = m68kemu.FrequencyST
+	}
+
+	clock, err := schedulerOf(cpu).NewClockDomain(options.Name, options.Clock, options.CPUFrequency)
+	if err != nil {
+		return nil, fmt.Errorf("duart: %w", err)
+	}
+
+	d := &DUART{clock: clock, level: options.Level}
+	if options.Level != 0 {
+		acknowledge := d.acknowledge
+		if options.AutoVector {
+			acknowledge = nil
+		}
+		d.line = cpu.Interrupts().NewLine(acknowledge)
+	}
+	for i, channel := range []DUARTChannelOptions{options.A, options.B} {
+		if channel.Output == nil {
+			channel.Output = io.Discard
+		}
+		c := &d.channels[i]
+		c.d = d
+		c.serial = hostLink{
+			clock:   clock,
+			name:    fmt.Sprintf("%s channel %c", options.Name, 'A'+i),
+			input:   channel.Input,
+			output:  channel.Output,
+			txTicks: func() uint64 { return c.characterTicks(c.csr & 0x0f) },
+			rxTicks: func() uint64 { return c.characterTicks(c.csr >> 4) },
+			load:    func(b byte) byte { return b & c.dataMask() },
+			sent:    d.updateIRQ,
+			room:    func() bool { return len(c.fifo) < duartFIFODepth },
+			deliver: func(b byte) { c.receive(b); d.updateIRQ() },
+			pending: -1,
+		}
+	}
+
+	size := uint32(duartRegisters)
+	if options.Layout != m68kemu.RegisterLayoutPacked {
+		size *= 2
+	}
+	d.bank = m68kemu.NewRegisterBank(options.Name, options.Base, size, options.Layout)
+	names := [duartRegisters]string{
+		"MRA", "SRA/CSRA", "CRA", "RHRA/THRA", "IPCR/ACR", "ISR/IMR", "CUR/CTUR", "CLR/CTLR",
+		"MRB", "SRB/CSRB", "CRB", "RHRB/THRB", "IVR", "IP/OPCR", "START/SOPR", "STOP/ROPR",
+	}
+	for n, name := range names {
+		register := uint32(n)
+		d.bank.Add(m68kemu.Register{
+			Name:    name,
+			Offset:  register,
+			Width:   m68kemu.Byte,
+			OnRead:  func(uint32) uint32 { return d.read(register) },
+			OnWrite: func(_, value uint32) uint32 { d.write(register, uint8(value)); return 0 },
+		})
+	}
+	d.Reset()
+	return d, nil
+}
+
+func (d *DUART) Contains(address uint32) bool {
+	return d.bank.Contains(address)
+}
+
+func (d *DUART) AddressRange() (uint32, uint32) {
+	return d.bank.AddressRange()
+}
+
+func (d *DUART) Read(s m68kemu.Size, address uint32) (uint32, error) {
+	return d.bank.Read(s, address)
+}
+
+func (d *DUART) Write(s m68kemu.Size, address uint32, value uint32) error {
+	return d.bank.Write(s, address, value)
+}
+
+// Peek reads registers without popping the receive FIFOs, clearing the
+// input port changes, or running counter commands.
+func (d *DUART) Peek(s m68kemu.Size, address uint32) (uint32, error) {
+	d.peeking = true
+	defer func() { d.peeking = false }()
+	return d.bank.Read(s, address)
+}
+
+// RegisterName implements m68kemu.RegisterNamer.
+func (d *DUART) RegisterName(address uint32) (string, bool) {
+	return d.bank.RegisterName(address)
+}
+
+// Reset disables both channels, empties their FIFOs, stops the
+// counter/timer, and clears the interrupt mask, the output port, and the
+// auxiliary control register. Input pin levels belong to the host and are
+// kept.
+func (d *DUART) Reset() {
+	for i := range d.channels {
+		c := &d.channels[i]
+		c.serial.stopTransmitter()
+		c.serial.stopReceiver()
+		c.mr, c.mrPointer, c.csr = [2]uint8{}, 0, 0
+		c.rxEnabled, c.txEnabled = false, false
+		c.fifo, c.errors = c.fifo[:0], 0
+	}
+	d.counter.event.Cancel()
+	d.counter = duartCounter{}
+	d.acr, d.imr, d.ivr, d.opr, d.opcr, d.ipcr = 0, 0, duartResetIVR, 0, 0, 0
+	d.updateIRQ()
+}
+
+// Receive puts a byte from the serial line into a channel's receive FIFO,
+// channel 0 being A. A byte arriving at a full FIFO is lost and flags an
+// overrun. It reports whether the byte was taken.
+func (d *DUART) Receive(channel int, b byte) bool {
+	if channel < 0 || channel > 1 {
+		return false
+	}
+	taken := d.channels[channel].receive(b)
+	d.updateIRQ()
+	return taken
+}
+
+// SetInput drives input port pin 0-5. Changes on IP0-IP3 latch in the IPCR
+// and interrupt when the ACR enables the pin.
+func (d *DUART) SetInput(pin int, level bool) {
+	if pin < 0 || pin > 5 {
+		return
+	}
+	bit := uint8(1) << pin
+	if (d.inputs&bit != 0) == level {
+		return
+	}
+	d.inputs ^= bit
+	if pin < 4 {
+		d.ipcr |= bit << 4
+		d.updateIRQ()
+	}
+}
+
+// OutputPort returns the levels of the OP0-OP7 pins, which are the
+// complement of the output port register.
+func (d *DUART) OutputPort() uint8 {
+	return ^d.opr
+}
+
+func (d *DUART) read(register uint32) uint32 {
+	if register >= duartMRB && register <= duartRHRB {
+		return d.channels[1].read(register - duartMRB)
+	}
+	switch register {
+	case duartMRA, duartSRA, duartCRA, duartRHRA:
+		return d.channels[0].read(register)
+	case duartIPCR:
+		value := d.ipcr | d.inputs&0x0f
+		if !d.peeking {
+			d.ipcr = 0
+			d.updateIRQ()
+		}
+		return uint32(value)
+	case duartISR:
+		return uint32(d.isr())
+	case duartCUR:
+		return d.counter.current(d) >> 8
+	case duartCLR:
+		return d.counter.current(d) & 0xff
+	case duartIVR:
+		return uint32(d.ivr)
+	case duartIP:
+		return uint32(d.inputs | 0xc0)
+	case duartStart:
+		if !d.peeking {
+			d.startCounter()
+		}
+	case duartStop:
+		if !d.peeking {
+			d.stopCounter()
+		}
+	}
+	return 0xff
+}
+
+func (d *DUART) write(register uint32, value uint8) {
+	if register >= duartMRB && register <= duartRHRB {
+		d.channels[1].write(register-duartMRB, value)
+		d.updateIRQ()
+		return
+	}
+	switch register {
+	case duartMRA, duartSRA, duartCRA, duartRHRA:
+		d.channels[0].write(register, value)
+	case duartIPCR:
+		d.writeACR(value)
+	case duartISR:
+		d.imr = value
+	case duartCUR:
+		d.counter.preload = d.counter.preload&0x00ff | uint32(value)<<8
+	case duartCLR:
+		d.counter.preload = d.counter.preload&0xff00 | uint32(value)
+	case duartIVR:
+		d.ivr = value
+	case duartIP:
+		d.opcr = value
+	case duartStart:
+		d.opr |= value
+	case duartStop:
+		d.opr &^= value
+	}
+	d.updateIRQ()
+}
+
+// isr assembles the interrupt status register from the channel and
+// counter/timer state.
+func (d *DUART) isr() uint8 {
+	var isr uint8
+	for i := range d.channels {
+		c := &d.channels[i]
+		sr := c.status()
+		if sr&duartSRTxRDY != 0 {
+			isr |= 0x01 << (4 * i)
+		}
+		rx := uint8(duartSRRxRDY)
+		if c.mr[0]&duartMR1FFULLInt != 0 {
+			rx = duartSRFFULL
+		}
+		if sr&rx != 0 {
+			isr |= 0x02 << (4 * i)
+		}
+	}
+	if d.counter.ready {
+		isr |= duartISRCounter
+	}
+	if d.ipcr&(d.acr<<4) != 0 {
+		isr |= duartISRInputChange
+	}
+	return isr
+}
+
+func (d *DUART) updateIRQ() {
+	if d.line == nil {
+		return
+	}
+	if d.isr()&d.imr != 0 {
+		d.line.Set(d.level)
+	} else {
+		d.line.Set(0)
+	}
+}
+
+// acknowledge answers the interrupt acknowledge cycle with the IVR.
+func (d *DUART) acknowledge(uint8) (uint8, bool) {
+	return d.ivr, false
+}
+
+// writeACR selects the baud rate set and the counter/timer mode. Timer mode
+// runs the counter/timer continuously.
+func (d *DUART) writeACR(value uint8) {
+	d.acr = value
+	d.counter.pause(d)
+	if d.acr&duartACRTimer != 0 {
+		d.startCounter()
+	}
+}
+
+// prescaler returns the X1 clocks per counter/timer step, or 0 for the
+// sources that are not modelled.
+func (d *DUART) prescaler() uint64 {
+	switch d.acr >> 4 & 7 {
+	case 6:
+		return 1
+	case 3, 7:
+		return 16
+	}
+	return 0
+}
+
+// startCounter loads the counter/timer from CTUR/CTLR. In counter mode it
+// starts counting down; in timer mode it begins a new square wave cycle.
+func (d *DUART) startCounter() {
+	c := &d.counter
+	c.pause(d)
+	c.value = c.reload(d)
+	c.halfWave = false
+	if d.prescaler() != 0 {
+		c.start = d.clock.Now()
+		c.running = true
+		c.schedule(d)
+	}
+}
+
+// stopCounter clears the counter ready interrupt and, in counter mode,
+// stops the counter.
+func (d *DUART) stopCounter() {
+	d.counter.ready = false
+	if d.acr&duartACRTimer == 0 {
+		d.counter.pause(d)
+	}
+	d.updateIRQ()
+}
+
+// reload returns the count the counter/timer starts a cycle with. A timer
+// preload of zero counts like 0x10000.
+func (c *duartCounter) reload(d *DUART) uint32 {
+	if c.preload == 0 && d.acr&duartACRTimer != 0 {
+		return duartCounterMax
+	}
+	return c.preload
+}
+
+// current returns the count, including steps the scheduler has not
+// delivered yet.
+func (c *duartCounter) current(d *DUART) uint32 {
+	if !c.running {
+		return c.value & 0xffff
+	}
+	steps := (d.clock.Now() - c.start) / d.prescaler()
+	if steps < uint64(c.value) {
+		return (c.value - uint32(steps)) & 0xffff
+	}
+	period := uint64(duartCounterMax)
+	if d.acr&duartACRTimer != 0 {
+		period = uint64(c.reload(d))
+	}
+	return uint32(period-(steps-uint64(c.value))%period) & 0xffff
+}
+
+func (c *duartCounter) pause(d *DUART) {
+	if !c.running {
+		return
+	}
+	c.value = c.current(d)
+	c.running = false
+	c.event.Cancel()
+}
+
+func (c *duartCounter) schedule(d *DUART) {
+	c.event = d.clock.ScheduleNamed("DUART counter", c.start+d.prescaler()*uint64(c.value), func(tick uint64) {
+		c.expire(d, tick)
+	})
+}
+
+// expire handles the terminal count. The timer toggles its square wave
+// output, reloads, and reports ready once per full cycle; the counter
+// reports ready and keeps counting down from 0xffff.
+func (c *duartCounter) expire(d *DUART, tick uint64) {
+	c.start = tick
+	if d.acr&duartACRTimer != 0 {
+		c.value = c.reload(d)
+		c.output = !c.output
+		c.halfWave = !c.halfWave
+		if !c.halfWave {
+			c.ready = true
+		}
+	} else {
+		c.value = duartCounterMax
+		c.ready = true
+	}
+	c.schedule(d)
+	d.updateIRQ()
+}
+
+func (c *duartChannel) read(register uint32) uint32 {
+	switch register {
+	case duartMRA:
+		value := c.mr[c.mrPointer]
+		if !c.d.peeking {
+			c.mrPointer = 1
+		}
+		return uint32(value)
+	case duartSRA:
+		return uint32(c.status())
+	case duartRHRA:
+		if len(c.fifo) == 0 {
+			return 0
+		}
+		value := c.fifo[0]
+		if !c.d.peeking {
+			c.fifo = append(c.fifo[:0], c.fifo[1:]...)
+			c.d.updateIRQ()
+		}
+		return uint32(value)
+	}
+	return 0xff
+}
+
+func (c *duartChannel) write(register uint32, value uint8) {
+	switch register {
+	case duartMRA:
+		c.mr[c.mrPointer] = value
+		c.mrPointer = 1
+	case duartSRA:
+		c.csr = value
+	case duartCRA:
+		c.command(value)
+	case duartRHRA:
+		if !c.txEnabled {
+			return
+		}
+		c.serial.send(value)
+	}
+}
+
+// command runs a channel command register write: the enable bits first,
+// then the miscellaneous command in bits 6-4.
+func (c *duartChannel) command(value uint8) {
+	switch value & 0x03 {
+	case 0x01:
+		if !c.rxEnabled {
+			c.rxEnabled = true
+			c.serial.startReceiver()
+		}
+	case 0x02:
+		c.rxEnabled = false
+		c.serial.stopReceiver()
+	}
+	switch value & 0x0c {
+	case 0x04:
+		c.txEnabled = true
+	case 0x08:
+		c.txEnabled = false
+	}
+	switch value >> 4 & 7 {
+	case 1:
+		c.mrPointer = 0
+	case 2:
+		c.rxEnabled = false
+		c.serial.stopReceiver()
+		c.fifo = c.fifo[:0]
+		c.errors = 0
+	case 3:
+		c.txEnabled = false
+		c.serial.stopTransmitter()
+	case 4:
+		c.errors = 0
+	}
+}
+
+// status assembles the channel status register.
+func (c *duartChannel) status() uint8 {
+	sr := c.errors
+	if len(c.fifo) != 0 {
+		sr |= duartSRRxRDY
+	}
+	if len(c.fifo) == duartFIFODepth {
+		sr |= duartSRFFULL
+	}
+	if c.txEnabled && c.serial.pending < 0 {
+		sr |= duartSRTxRDY
+		if !c.serial.shifting {
+			sr |= duartSRTxEMT
+		}
+	}
+	return sr
+}
+
+func (c *duartChannel) receive(b byte) bool {
+	if !c.rxEnabled {
+		return false
+	}
+	if len(c.fifo) == duartFIFODepth {
+		c.errors |= duartSROverrun
+		return false
+	}
+	c.fifo = append(c.fifo, b&c.dataMask())
+	return true
+}
+
+func (c *duartChannel) dataMask() byte {
+	return byte(0xff >> (3 - c.mr[0]&3))
+}
+
+// characterTicks returns the X1 clocks one character takes at the baud rate
+// in clock select nibble sel: start bit, data bits, parity, and stop bits.
+func (c *duartChannel) characterTicks(sel uint8) uint64 {
+	bits := uint64(1 + 5 + c.mr[0]&3 + 1)
+	if c.mr[0]>>3&3 != 2 {
+		bits++ // parity or multidrop address bit
+	}
+	if c.mr[1]&0x0f >= 8 {
+		bits++ // two stop bits
+	}
+	return bits * c.d.bitTicks(sel)
+}
+
+// bitTicks returns the X1 clocks per bit for a clock select value.
+func (d *DUART) bitTicks(sel uint8) uint64 {
+	switch {
+	case sel < 13:
+		set := 0
+		if d.acr&duartACRBRGSet2 != 0 {
+			set = 1
+		}
+		return duartBitTicks[set][sel]
+	case sel == 13 && d.prescaler() != 0:
+		// The counter/timer square wave is a 16x clock.
+		return 16 * 2 * uint64(d.counter.reload(d)) * d.prescaler()
+	}
+	return duartBitTicks[0][11]
+}
@@ -0,0 +1,197 @@
+package peripheral
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	asm "github.com/jenska/m68kasm"
+	m68kemu "github.com/jenska/m68kemu"
+)
+
+const duartBase = 0xf00000
+
+func newDUARTEnvironment(tb testing.TB, options DUARTOptions) (m68kemu.CPU, *m68kemu.Bus, *DUART) {
+	tb.Helper()
+	bus := m68kemu.NewBus(m68kemu.NewRAM(0, 0x10000))
+	cpu, err := m68kemu.NewCPU(bus)
+	if err != nil {
+		tb.Fatalf("NewCPU failed: %v", err)
+	}
+	options.Base, options.Layout = duartBase, m68kemu.RegisterLayoutOdd
+	duart, err := NewDUART(cpu, options)
+	if err != nil {
+		tb.Fatalf("NewDUART failed: %v", err)
+	}
+	bus.AddDevice(duart)
+	return cpu, bus, duart
+}
+
+func duartWrite(tb testing.TB, d *DUART, values ...uint32) {
+	tb.Helper()
+	for i := 0; i < len(values); i += 2 {
+		if err := d.Write(m68kemu.Byte, duartBase+2*values[i]+1, values[i+1]); err != nil {
+			tb.Fatalf("write register %d: %v", values[i], err)
+		}
+	}
+}
+
+func duartRead(tb testing.TB, d *DUART, register uint32) uint32 {
+	tb.Helper()
+	value, err := d.Read(m68kemu.Byte, duartBase+2*register+1)
+	if err != nil {
+		tb.Fatalf("read register %d: %v", register, err)
+	}
+	return value
+}
+
+func TestDUARTMonitorStyleEcho(t *testing.T) {
+	var output bytes.Buffer
+	cpu, bus, _ := newDUARTEnvironment(t, DUARTOptions{A: DUARTChannelOptions{Input: strings.NewReader("ok\r"), Output: &output}})
+	code, _, err := asm.AssembleStringWithListing(`
+        LEA     $F00001,A0
+        MOVE.B  #$10,4(A0)       ; CRA: reset MR pointer
+        MOVE.B  #$13,(A0)        ; MR1A: 8 bits, no parity
+        MOVE.B  #$07,(A0)        ; MR2A: 1 stop bit
+        MOVE.B  #$CC,2(A0)       ; CSRA: 38400 baud
+        MOVE.B  #$05,4(A0)       ; CRA: enable RX and TX
+wait:   BTST    #0,2(A0)         ; SRA RxRDY
+        BEQ.S   wait
+        MOVE.B  6(A0),D0
+tx:     BTST    #2,2(A0)         ; SRA TxRDY
+        BEQ.S   tx
+        MOVE.B  D0,6(A0)
+        CMP.B   #13,D0
+        BNE.S   wait
+done:   BRA.S   done
+`)
+	if err != nil {
+		t.Fatalf("Assembler failed: %v", err)
+	}
+	for i, b := range code {
+		_ = bus.Write(m68kemu.Byte, 0x1000+uint32(i), uint32(b))
+	}
+	cpu.SetRegisters(m68kemu.Registers{SR: 0x2700, A: [8]uint32{7: 0x8000}, SSP: 0x8000, PC: 0x1000})
+	if err := cpu.RunCycles(50_000); err != nil {
+		t.Fatalf("RunCycles failed: %v", err)
+	}
+	if output.String() != "ok\r" {
+		t.Fatalf("echoed %q, want ok", output.String())
+	}
+}
+
+func TestDUARTReceiveFIFOAndVectoredInterrupt(t *testing.T) {
+	cpu, _, duart := newDUARTEnvironment(t, DUARTOptions{Level: 5})
+	ic := cpu.Interrupts()
+	duartWrite(t, duart, duartMRB, 0x13, duartCRB, 0x01, duartIVR, 0x40, duartISR, 0x20)
+
+	if !duart.Receive(1, 'x') {
+		t.Fatalf("enabled receiver refused a byte")
+	}
+	if level, vector, autoVector, ok := ic.Pending(0); !ok || level != 5 || vector != 0x40 || autoVector {
+		t.Fatalf("Pending = %d, %#x, %v, %v, want the IVR at level 5", level, vector, autoVector, ok)
+	}
+
+	// MR1B bit 6 interrupts on a full FIFO instead.
+	duartWrite(t, duart, duartCRB, 0x10, duartMRB, 0x53, duartCRB, 0x10)
+	if got := duartRead(t, duart, duartMRB); got != 0x53 {
+		t.Fatalf("MR1B = %02x, want 53", got)
+	}
+	if ic.HasPending(0) {
+		t.Fatalf("interrupt with one byte in the FIFO")
+	}
+	for _, b := range []byte("yz") {
+		duart.Receive(1, b)
+	}
+	if duart.Receive(1, '!') || !ic.HasPending(0) {
+		t.Fatalf("full FIFO took a fourth byte or did not interrupt")
+	}
+	if got := duartRead(t, duart, duartSRB); got != duartSRFFULL|duartSRRxRDY|duartSROverrun {
+		t.Fatalf("SRB = %02x, want full, ready, and overrun", got)
+	}
+	if peek, _ := duart.Peek(m68kemu.Byte, duartBase+2*duartRHRB+1); peek != 'x' {
+		t.Fatalf("Peek RHRB = %q, want x", rune(peek))
+	}
+	for _, want := range []byte("xyz") {
+		if got := duartRead(t, duart, duartRHRB); got != uint32(want) {
+			t.Fatalf("RHRB = %q, want %q", rune(got), want)
+		}
+	}
+	duartWrite(t, duart, duartCRB, 0x40)
+	if got := duartRead(t, duart, duartSRB); got != 0 || ic.HasPending(0) {
+		t.Fatalf("SRB = %02x after reading the FIFO and resetting errors, want 0", got)
+	}
+}
+
+func TestDUARTCounterTimer(t *testing.T) {
+	cpu, _, duart := newDUARTEnvironment(t, DUARTOptions{})
+	scheduler := cpu.Scheduler()
+	clock := func(ticks uint64) {
+		scheduler.Advance((ticks*m68kemu.FrequencyST + DefaultDUARTClock - 1) / DefaultDUARTClock)
+	}
+
+	// Timer mode from X1/16 with a preload of 0x100: a full square wave
+	// cycle takes 2*0x100*16 X1 clocks.
+	duartWrite(t, duart, duartCUR, 0x01, duartCLR, 0x00, duartIPCR, 0x70)
+	clock(0x80 * 16)
+	if got := duartRead(t, duart, duartCUR)<<8 | duartRead(t, duart, duartCLR); got != 0x80 {
+		t.Fatalf("timer = %#x after half a period, want 0x80", got)
+	}
+	clock(0x100 * 16)
+	if duartRead(t, duart, duartISR)&duartISRCounter != 0 {
+		t.Fatalf("counter ready after half a square wave")
+	}
+	clock(0x80 * 16)
+	if duartRead(t, duart, duartISR)&duartISRCounter == 0 {
+		t.Fatalf("counter not ready after a full square wave")
+	}
+	duartRead(t, duart, duartStop)
+	if duartRead(t, duart, duartISR)&duartISRCounter != 0 {
+		t.Fatalf("stop command did not clear counter ready")
+	}
+
+	// Counter mode from X1/16 counts down once and wraps through 0xffff.
+	duartWrite(t, duart, duartCUR, 0, duartCLR, 10, duartIPCR, 0x30)
+	duartRead(t, duart, duartStart)
+	clock(10 * 16)
+	if duartRead(t, duart, duartISR)&duartISRCounter == 0 {
+		t.Fatalf("counter not ready at terminal count")
+	}
+	clock(16)
+	if got := duartRead(t, duart, duartCUR)<<8 | duartRead(t, duart, duartCLR); got != 0xffff {
+		t.Fatalf("counter = %#x one step past terminal count, want 0xffff", got)
+	}
+	duartRead(t, duart, duartStop)
+	clock(100 * 16)
+	if got := duartRead(t, duart, duartCLR); got != 0xff {
+		t.Fatalf("stopped counter moved to %#x", got)
+	}
+}
+
+func TestDUARTInputAndOutputPorts(t *testing.T) {
+	cpu, _, duart := newDUARTEnvironment(t, DUARTOptions{Level: 2, AutoVector: true})
+	duartWrite(t, duart, duartIPCR, 0x01, duartISR, 0x80)
+
+	duart.SetInput(1, true)
+	if cpu.Interrupts().HasPending(0) {
+		t.Fatalf("IP1 change interrupted although only IP0 is enabled")
+	}
+	duart.SetInput(0, true)
+	if _, vector, autoVector, ok := cpu.Interrupts().Pending(0); !ok || vector != 26 || !autoVector {
+		t.Fatalf("Pending = %d, %v, %v, want a level 2 autovector", vector, autoVector, ok)
+	}
+	if got := duartRead(t, duart, duartIPCR); got != 0x33 {
+		t.Fatalf("IPCR = %02x, want changes and levels on IP0 and IP1", got)
+	}
+	if got := duartRead(t, duart, duartIPCR); got != 0x03 || cpu.Interrupts().HasPending(0) {
+		t.Fatalf("IPCR = %02x after reading, want the changes cleared", got)
+	}
+	if got := duartRead(t, duart, duartIP); got != 0xc3 {
+		t.Fatalf("IP = %02x, want c3", got)
+	}
+
+	duartWrite(t, duart, duartStart, 0x81, duartStop, 0x01)
+	if got := duart.OutputPort(); got != 0x7f {
+		t.Fatalf("OutputPort = %02x, want OP7 driven low", got)
+	}
+}
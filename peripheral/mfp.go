@@ -0,0 +1,591 @@
+// Package peripheral provides models of the support chips found around a
+// 68000: timers, interrupt controllers, and serial ports. The devices decode
+// their registers with m68kemu.RegisterBank, take their timing from the CPU's
+// CycleScheduler, and raise interrupts through InterruptLines on the CPU's
+// interrupt controller, so they plug into any Bus layout.
+package peripheral
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+
+	m68kemu "github.com/jenska/m68kemu"
+)
+
+const (
+	// DefaultMFPBase is where the Atari ST maps its MFP. With the odd byte
+	// lane layout, register n sits at DefaultMFPBase+2n+1.
+	DefaultMFPBase = 0xfffa00
+	// DefaultMFPClock is the 2.4576 MHz timer clock of the ST's MFP.
+	DefaultMFPClock = 2_457_600
+	// DefaultMFPLevel is the interrupt level the ST wires the MFP to.
+	DefaultMFPLevel = 6
+)
+
+// MFP timers, as passed to SetTimerInput and TimerOutput.
+const (
+	MFPTimerA = iota
+	MFPTimerB
+	MFPTimerC
+	MFPTimerD
+)
+
+// MFP register numbers.
+const (
+	mfpGPIP = iota
+	mfpAER
+	mfpDDR
+	mfpIERA
+	mfpIERB
+	mfpIPRA
+	mfpIPRB
+	mfpISRA
+	mfpISRB
+	mfpIMRA
+	mfpIMRB
+	mfpVR
+	mfpTACR
+	mfpTBCR
+	mfpTCDCR
+	mfpTADR
+	mfpTBDR
+	mfpTCDR
+	mfpTDDR
+	mfpSCR
+	mfpUCR
+	mfpRSR
+	mfpTSR
+	mfpUDR
+	mfpRegisters
+)
+
+// Interrupt channels in priority order. Channel n is bit n of the 16-bit
+// register pairs formed by the A (high) and B (low) registers.
+const (
+	mfpChannelTimerD   = 4
+	mfpChannelTimerC   = 5
+	mfpChannelTimerB   = 8
+	mfpChannelTxError  = 9
+	mfpChannelTxEmpty  = 10
+	mfpChannelRxError  = 11
+	mfpChannelRxFull   = 12
+	mfpChannelTimerA   = 13
+	mfpChannelSpurious = 24
+)
+
+const (
+	mfpVRSoftwareEOI = 0x08
+
+	mfpModeStopped    = 0
+	mfpModeEventCount = 8
+	mfpModePulseWidth = 9
+
+	mfpRSREnable  = 0x01
+	mfpRSROverrun = 0x40
+	mfpRSRFull    = 0x80
+	mfpTSREnable  = 0x01
+	mfpTSREmpty   = 0x80
+)
+
+var (
+	// mfpGPIPChannels maps GPIP pins to their interrupt channels.
+	mfpGPIPChannels = [8]int{0, 1, 2, 3, 6, 7, 14, 15}
+	// mfpPrescalers are the timer clock dividers selected by control values 1-7.
+	mfpPrescalers = [8]uint64{0, 4, 10, 16, 50, 64, 100, 200}
+)
+
+// MFPOptions configures an MFP. Zero fields take the Atari ST defaults.
+type MFPOptions struct {
+	// Base is the first address of the register window. It defaults to
+	// DefaultMFPBase.
+	Base uint32
+	// Level is the interrupt level of the IRQ output. It defaults to
+	// DefaultMFPLevel.
+	Level uint8
+	// Clock is the timer clock in Hz, defaulting to DefaultMFPClock.
+	Clock uint64
+	// CPUFrequency is the CPU clock in Hz, defaulting to m68kemu.FrequencyST.
+	CPUFrequency uint64
+	// Serial receives the bytes the USART transmits. A nil Serial discards
+	// them.
+	Serial io.Writer
+}
+
+// MFP models the MC68901 multi-function peripheral: eight general-purpose
+// I/O lines with edge-triggered interrupts, four timers, a 16-channel
+// prioritised interrupt controller with vector generation, and a USART.
+//
+// Registers sit on the odd byte lane, one every two bytes. Timers run on a
+// clock domain of the CPU's CycleScheduler, so timer interrupts arrive at the
+// exact cycle. The USART transmits instantly to MFPOptions.Serial; the host
+// feeds received bytes with Receive.
+type MFP struct {
+	bank   *m68kemu.RegisterBank
+	clock  *m68kemu.ClockDomain
+	line   *m68kemu.InterruptLine
+	level  uint8
+	serial io.Writer
+
+	timers [4]mfpTimer
+	inputs uint8
+}
+
+// mfpTimer is one of the four timers. While it counts on the clock, the main
+// counter is derived from the tick it was started at instead of being
+// decremented on every prescaler step.
+type mfpTimer struct {
+	mfp     *MFP
+	name    string
+	data    int
+	channel int
+	// edge is the GPIP pin whose AER bit sets the input polarity of timers
+	// A and B, and whose interrupt channel the end of a pulse raises.
+	edge int
+
+	mode    uint8
+	counter uint32 // main counter, 1-256, as of start
+	start   uint64 // clock tick at which a running timer held counter
+	running bool
+	event   m68kemu.EventHandle
+	input   bool
+	output  bool
+}
+
+// NewMFP creates an MFP whose timers count on a clock domain of cpu's
+// scheduler, set up first if cpu has none, and whose IRQ output is an
+// interrupt line on cpu's interrupt controller. Add the MFP to the bus as a
+// device.
+func NewMFP(cpu m68kemu.CPU, options MFPOptions) (*MFP, error) {
+	if options.Base == 0 {
+		options.Base = DefaultMFPBase
+	}
+	if options.Level == 0 {
+		options.Level = DefaultMFPLevel
+	}
+	if options.Level > 7 {
+		return nil, fmt.Errorf("mfp: invalid interrupt level %d", options.Level)
+	}
+	if options.Clock == 0 {
+		options.Clock = DefaultMFPClock
+	}
+	if options.CPUFrequency == 0 {
+		options.CPUFrequency = m68kemu.FrequencyST
+	}
+	if options.Serial == nil {
+		options.Serial = io.Discard
+	}
+
+	clock, err := schedulerOf(cpu).NewClockDomain("MFP", options.Clock, options.CPUFrequency)
+	if err != nil {
+		return nil, fmt.Errorf("mfp: %w", err)
+	}
+
+	m := &MFP{clock: clock, level: options.Level, serial: options.Serial}
+	m.line = cpu.Interrupts().NewLine(m.acknowledge)
+	m.timers = [4]mfpTimer{
+		{name: "MFP timer A", data: mfpTADR, channel: mfpChannelTimerA, edge: 4},
+		{name: "MFP timer B", data: mfpTBDR, channel: mfpChannelTimerB, edge: 3},
+		{name: "MFP timer C", data: mfpTCDR, channel: mfpChannelTimerC},
+		{name: "MFP timer D", data: mfpTDDR, channel: mfpChannelTimerD},
+	}
+	for i := range m.timers {
+		m.timers[i].mfp = m
+		m.timers[i].counter = 256
+	}
+
+	m.bank = m68kemu.NewRegisterBank("MFP", options.Base, 2*mfpRegisters, m68kemu.RegisterLayoutOdd)
+	m.bank.
+		Add(m68kemu.Register{Name: "GPIP", Offset: mfpGPIP, Width: m68kemu.Byte, OnRead: m.readGPIP}).
+		Add(m68kemu.Register{Name: "AER", Offset: mfpAER, Width: m68kemu.Byte, OnWrite: m.writeEdges(mfpAER)}).
+		Add(m68kemu.Register{Name: "DDR", Offset: mfpDDR, Width: m68kemu.Byte, OnWrite: m.writeEdges(mfpDDR)}).
+		Add(m68kemu.Register{Name: "IERA", Offset: mfpIERA, Width: m68kemu.Byte, OnWrite: m.writeEnable(mfpIERA, mfpIPRA)}).
+		Add(m68kemu.Register{Name: "IERB", Offset: mfpIERB, Width: m68kemu.Byte, OnWrite: m.writeEnable(mfpIERB, mfpIPRB)}).
+		Add(m68kemu.Register{Name: "IPRA", Offset: mfpIPRA, Width: m68kemu.Byte, OnWrite: m.writeClearOnly(mfpIPRA)}).
+		Add(m68kemu.Register{Name: "IPRB", Offset: mfpIPRB, Width: m68kemu.Byte, OnWrite: m.writeClearOnly(mfpIPRB)}).
+		Add(m68kemu.Register{Name: "ISRA", Offset: mfpISRA, Width: m68kemu.Byte, OnWrite: m.writeClearOnly(mfpISRA)}).
+		Add(m68kemu.Register{Name: "ISRB", Offset: mfpISRB, Width: m68kemu.Byte, OnWrite: m.writeClearOnly(mfpISRB)}).
+		Add(m68kemu.Register{Name: "IMRA", Offset: mfpIMRA, Width: m68kemu.Byte, OnWrite: m.writeMask(mfpIMRA)}).
+		Add(m68kemu.Register{Name: "IMRB", Offset: mfpIMRB, Width: m68kemu.Byte, OnWrite: m.writeMask(mfpIMRB)}).
+		Add(m68kemu.Register{Name: "VR", Offset: mfpVR, Width: m68kemu.Byte, WriteMask: 0xf8, OnWrite: m.writeVR}).
+		Add(m68kemu.Register{Name: "TACR", Offset: mfpTACR, Width: m68kemu.Byte, WriteMask: 0x1f, OnWrite: m.writeControl(MFPTimerA)}).
+		Add(m68kemu.Register{Name: "TBCR", Offset: mfpTBCR, Width: m68kemu.Byte, WriteMask: 0x1f, OnWrite: m.writeControl(MFPTimerB)}).
+		Add(m68kemu.Register{Name: "TCDCR", Offset: mfpTCDCR, Width: m68kemu.Byte, WriteMask: 0x77, OnWrite: m.writeTCDCR}).
+		Add(m68kemu.Register{Name: "TADR", Offset: mfpTADR, Width: m68kemu.Byte, OnRead: m.readCounter(MFPTimerA), OnWrite: m.writeData(MFPTimerA)}).
+		Add(m68kemu.Register{Name: "TBDR", Offset: mfpTBDR, Width: m68kemu.Byte, OnRead: m.readCounter(MFPTimerB), OnWrite: m.writeData(MFPTimerB)}).
+		Add(m68kemu.Register{Name: "TCDR", Offset: mfpTCDR, Width: m68kemu.Byte, OnRead: m.readCounter(MFPTimerC), OnWrite: m.writeData(MFPTimerC)}).
+		Add(m68kemu.Register{Name: "TDDR", Offset: mfpTDDR, Width: m68kemu.Byte, OnRead: m.readCounter(MFPTimerD), OnWrite: m.writeData(MFPTimerD)}).
+		Add(m68kemu.Register{Name: "SCR", Offset: mfpSCR, Width: m68kemu.Byte}).
+		Add(m68kemu.Register{Name: "UCR", Offset: mfpUCR, Width: m68kemu.Byte, WriteMask: 0xfe}).
+		Add(m68kemu.Register{Name: "RSR", Offset: mfpRSR, Width: m68kemu.Byte, WriteMask: 0x03}).
+		Add(m68kemu.Register{Name: "TSR", Offset: mfpTSR, Width: m68kemu.Byte, WriteMask: 0x2f, Reset: mfpTSREmpty}).
+		Add(m68kemu.Register{Name: "UDR", Offset: mfpUDR, Width: m68kemu.Byte, OnRead: m.readUDR, OnWrite: m.writeUDR})
+	return m, nil
+}
+
+func (m *MFP) Contains(address uint32) bool {
+	return m.bank.Contains(address)
+}
+
+func (m *MFP) AddressRange() (uint32, uint32) {
+	return m.bank.AddressRange()
+}
+
+func (m *MFP) Read(s m68kemu.Size, address uint32) (uint32, error) {
+	return m.bank.Read(s, address)
+}
+
+func (m *MFP) Write(s m68kemu.Size, address uint32, value uint32) error {
+	return m.bank.Write(s, address, value)
+}
+
+// Peek reads registers without side effects such as clearing the USART's
+// buffer-full flag.
+func (m *MFP) Peek(s m68kemu.Size, address uint32) (uint32, error) {
+	return m.bank.Peek(s, address)
+}
+
+// RegisterName implements m68kemu.RegisterNamer.
+func (m *MFP) RegisterName(address uint32) (string, bool) {
+	return m.bank.RegisterName(address)
+}
+
+// Reset clears the registers, stops the timers, and releases the IRQ output.
+// Input pin levels belong to the host and are kept.
+func (m *MFP) Reset() {
+	m.bank.Reset()
+	for i := range m.timers {
+		t := &m.timers[i]
+		t.event.Cancel()
+		t.mode, t.counter, t.running, t.output = mfpModeStopped, 256, false, false
+	}
+	m.line.Set(0)
+}
+
+// SetInput drives GPIP pin 0-7. A transition in the direction the AER
+// selects raises the pin's interrupt when the pin is an input.
+func (m *MFP) SetInput(pin int, level bool) {
+	if pin < 0 || pin > 7 {
+		return
+	}
+	before := m.edgeState()
+	if level {
+		m.inputs |= 1 << pin
+	} else {
+		m.inputs &^= 1 << pin
+	}
+	m.detectEdges(before)
+}
+
+// SetTimerInput drives the TAI or TBI input of timer A or B. In event count
+// mode the active edge, selected by AER bit 4 or 3, decrements the timer; in
+// pulse width mode the timer counts while the input is in the opposite state
+// and the end of the pulse raises the GPIP 4 or 3 interrupt.
+func (m *MFP) SetTimerInput(timer int, level bool) {
+	if timer != MFPTimerA && timer != MFPTimerB {
+		return
+	}
+	t := &m.timers[timer]
+	if t.input == level {
+		return
+	}
+	t.input = level
+	active := level == m.aerBit(t.edge)
+	switch {
+	case t.mode == mfpModeEventCount && active:
+		if t.counter--; t.counter == 0 {
+			t.counter = t.period()
+			t.timeout()
+		}
+	case t.mode >= mfpModePulseWidth && active:
+		t.pause()
+		m.raise(mfpGPIPChannels[t.edge])
+	case t.mode >= mfpModePulseWidth:
+		t.resume()
+	}
+}
+
+// TimerOutput returns the level of a timer's output pin, which toggles every
+// time the timer reaches zero.
+func (m *MFP) TimerOutput(timer int) bool {
+	if timer < MFPTimerA || timer > MFPTimerD {
+		return false
+	}
+	return m.timers[timer].output
+}
+
+// Receive hands the USART a byte from the serial line. It reports whether the
+// receiver took it: a disabled receiver drops the byte, and a full buffer
+// flags an overrun and raises the receive error interrupt.
+func (m *MFP) Receive(b byte) bool {
+	rsr := m.bank.Value(mfpRSR)
+	switch {
+	case rsr&mfpRSREnable == 0:
+		return false
+	case rsr&mfpRSRFull != 0:
+		m.bank.SetValue(mfpRSR, rsr|mfpRSROverrun)
+		m.raise(mfpChannelRxError)
+		return false
+	}
+	m.bank.SetValue(mfpUDR, uint32(b))
+	m.bank.SetValue(mfpRSR, rsr|mfpRSRFull)
+	m.raise(mfpChannelRxFull)
+	return true
+}
+
+// pair reads the 16-bit channel register formed by the A and B registers at
+// offset a and a+1.
+func (m *MFP) pair(a uint32) uint16 {
+	return uint16(m.bank.Value(a))<<8 | uint16(m.bank.Value(a+1))
+}
+
+func (m *MFP) setPair(a uint32, value uint16) {
+	m.bank.SetValue(a, uint32(value>>8))
+	m.bank.SetValue(a+1, uint32(value&0xff))
+}
+
+// raise makes an interrupt channel pending if it is enabled.
+func (m *MFP) raise(channel int) {
+	bit := uint16(1) << channel
+	if m.pair(mfpIERA)&bit == 0 {
+		return
+	}
+	m.setPair(mfpIPRA, m.pair(mfpIPRA)|bit)
+	m.updateIRQ()
+}
+
+// updateIRQ drives the IRQ output while an unmasked pending channel has a
+// higher priority than every channel in service.
+func (m *MFP) updateIRQ() {
+	active := m.pair(mfpIPRA) & m.pair(mfpIMRA)
+	if active != 0 && bits.Len16(active) > bits.Len16(m.pair(mfpISRA)) {
+		m.line.Set(m.level)
+	} else {
+		m.line.Set(0)
+	}
+}
+
+// acknowledge answers the CPU's interrupt acknowledge with the vector of the
+// highest priority unmasked pending channel. In software end-of-interrupt
+// mode the channel moves to in-service until the handler clears its ISR bit.
+func (m *MFP) acknowledge(uint8) (uint8, bool) {
+	active := m.pair(mfpIPRA) & m.pair(mfpIMRA)
+	if active == 0 {
+		return mfpChannelSpurious, false
+	}
+	channel := bits.Len16(active) - 1
+	bit := uint16(1) << channel
+	m.setPair(mfpIPRA, m.pair(mfpIPRA)&^bit)
+	vr := m.bank.Value(mfpVR)
+	if vr&mfpVRSoftwareEOI != 0 {
+		m.setPair(mfpISRA, m.pair(mfpISRA)|bit)
+	}
+	m.updateIRQ()
+	return uint8(vr&0xf0) | uint8(channel), false
+}
+
+// edgeState is 1 for every pin whose level differs from its AER bit. A 1 to
+// 0 transition is an active edge, so rewriting the AER can raise an
+// interrupt just like a pin change, as on the real chip.
+func (m *MFP) edgeState() uint8 {
+	return m.inputs ^ uint8(m.bank.Value(mfpAER))
+}
+
+func (m *MFP) detectEdges(before uint8) {
+	edges := before &^ m.edgeState() &^ uint8(m.bank.Value(mfpDDR))
+	for pin := range 8 {
+		if edges&(1<<pin) != 0 {
+			m.raise(mfpGPIPChannels[pin])
+		}
+	}
+}
+
+func (m *MFP) aerBit(pin int) bool {
+	return m.bank.Value(mfpAER)&(1<<pin) != 0
+}
+
+func (m *MFP) readGPIP(value uint32) uint32 {
+	ddr := m.bank.Value(mfpDDR)
+	return value&ddr | uint32(m.inputs)&^ddr
+}
+
+// writeEdges handles AER and DDR writes, which can complete an edge.
+func (m *MFP) writeEdges(register uint32) func(old, value uint32) uint32 {
+	return func(_, value uint32) uint32 {
+		before := m.edgeState()
+		m.bank.SetValue(register, value)
+		m.detectEdges(before)
+		return value
+	}
+}
+
+// writeEnable clears the pending bits of channels being disabled.
+func (m *MFP) writeEnable(register, pending uint32) func(old, value uint32) uint32 {
+	return func(_, value uint32) uint32 {
+		m.bank.SetValue(pending, m.bank.Value(pending)&value)
+		return m.update(register, value)
+	}
+}
+
+// writeClearOnly handles the pending and in-service registers, where writing
+// 0 clears a bit and writing 1 leaves it alone. Clearing an in-service bit is
+// the software end-of-interrupt.
+func (m *MFP) writeClearOnly(register uint32) func(old, value uint32) uint32 {
+	return func(old, value uint32) uint32 {
+		return m.update(register, old&value)
+	}
+}
+
+func (m *MFP) writeMask(register uint32) func(old, value uint32) uint32 {
+	return func(_, value uint32) uint32 {
+		return m.update(register, value)
+	}
+}
+
+// writeVR switches between software and automatic end-of-interrupt. Leaving
+// software mode clears every in-service bit.
+func (m *MFP) writeVR(_, value uint32) uint32 {
+	if value&mfpVRSoftwareEOI == 0 {
+		m.setPair(mfpISRA, 0)
+		m.updateIRQ()
+	}
+	return value
+}
+
+// update stores a register ahead of the bank so the IRQ output follows it.
+func (m *MFP) update(register, value uint32) uint32 {
+	m.bank.SetValue(register, value)
+	m.updateIRQ()
+	return value
+}
+
+func (m *MFP) writeControl(timer int) func(old, value uint32) uint32 {
+	return func(_, value uint32) uint32 {
+		m.timers[timer].setMode(uint8(value & 0x0f))
+		return value
+	}
+}
+
+func (m *MFP) writeTCDCR(_, value uint32) uint32 {
+	m.timers[MFPTimerC].setMode(uint8(value>>4) & 7)
+	m.timers[MFPTimerD].setMode(uint8(value) & 7)
+	return value
+}
+
+// writeData loads the data register, and the main counter too while the
+// timer is stopped.
+func (m *MFP) writeData(timer int) func(old, value uint32) uint32 {
+	return func(_, value uint32) uint32 {
+		if t := &m.timers[timer]; t.mode == mfpModeStopped {
+			t.counter = counterValue(value)
+		}
+		return value
+	}
+}
+
+// readCounter returns the main counter instead of the data register.
+func (m *MFP) readCounter(timer int) func(uint32) uint32 {
+	return func(uint32) uint32 {
+		return m.timers[timer].current() & 0xff
+	}
+}
+
+func (m *MFP) readUDR(value uint32) uint32 {
+	m.bank.SetValue(mfpRSR, m.bank.Value(mfpRSR)&^(mfpRSRFull|mfpRSROverrun))
+	return value
+}
+
+// writeUDR transmits the byte at once and reports the buffer empty again.
+func (m *MFP) writeUDR(_, value uint32) uint32 {
+	if m.bank.Value(mfpTSR)&mfpTSREnable == 0 {
+		return value
+	}
+	_, _ = m.serial.Write([]byte{byte(value)})
+	m.bank.SetValue(mfpTSR, m.bank.Value(mfpTSR)|mfpTSREmpty)
+	m.raise(mfpChannelTxEmpty)
+	return value
+}
+
+// counterValue maps a data register value to a count, where 0 means 256.
+func counterValue(value uint32) uint32 {
+	if value &= 0xff; value == 0 {
+		return 256
+	}
+	return value
+}
+
+func (t *mfpTimer) period() uint32 {
+	return counterValue(t.mfp.bank.Value(uint32(t.data)))
+}
+
+func (t *mfpTimer) prescaler() uint64 {
+	return mfpPrescalers[t.mode&7]
+}
+
+// clocked reports whether the timer counts prescaled clock ticks now.
+func (t *mfpTimer) clocked() bool {
+	switch {
+	case t.mode == mfpModeStopped || t.mode == mfpModeEventCount:
+		return false
+	case t.mode > mfpModeEventCount:
+		return t.input != t.mfp.aerBit(t.edge)
+	}
+	return true
+}
+
+func (t *mfpTimer) setMode(mode uint8) {
+	if mode == t.mode {
+		return
+	}
+	t.pause()
+	t.mode = mode
+	if t.clocked() {
+		t.resume()
+	}
+}
+
+// current returns the main counter, including the steps counted since the
+// last expiry event even when the scheduler has not run it yet.
+func (t *mfpTimer) current() uint32 {
+	if !t.running {
+		return t.counter
+	}
+	steps := (t.mfp.clock.Now() - t.start) / t.prescaler()
+	if steps < uint64(t.counter) {
+		return t.counter - uint32(steps)
+	}
+	period := uint64(t.period())
+	return uint32(period - (steps-uint64(t.counter))%period)
+}
+
+func (t *mfpTimer) pause() {
+	if !t.running {
+		return
+	}
+	t.counter = t.current()
+	t.running = false
+	t.event.Cancel()
+}
+
+func (t *mfpTimer) resume() {
+	t.start = t.mfp.clock.Now()
+	t.running = true
+	t.schedule()
+}
+
+func (t *mfpTimer) schedule() {
+	t.event = t.mfp.clock.ScheduleNamed(t.name, t.start+t.prescaler()*uint64(t.counter), t.expire)
+}
+
+// expire reloads a clocked timer from its data register when it reaches zero.
+func (t *mfpTimer) expire(tick uint64) {
+	t.start = tick
+	t.counter = t.period()
+	t.schedule()
+	t.timeout()
+}
+
+func (t *mfpTimer) timeout() {
+	t.output = !t.output
+	t.mfp.raise(t.channel)
+}
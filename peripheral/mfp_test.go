@@ -0,0 +1,240 @@
+package peripheral
+
+import (
+	"bytes"
+	"testing"
+
+	asm "github.com/jenska/m68kasm"
+	m68kemu "github.com/jenska/m68kemu"
+)
+
+// mfpRegister returns the ST address of MFP register n.
+func mfpRegister(n uint32) uint32 {
+	return DefaultMFPBase + 2*n + 1
+}
+
+func newMFPEnvironment(tb testing.TB, options MFPOptions) (m68kemu.CPU, *m68kemu.Bus, *MFP) {
+	tb.Helper()
+	bus := m68kemu.NewBus(m68kemu.NewRAM(0, 0x10000))
+	cpu, err := m68kemu.NewCPU(bus)
+	if err != nil {
+		tb.Fatalf("NewCPU failed: %v", err)
+	}
+	mfp, err := NewMFP(cpu, options)
+	if err != nil {
+		tb.Fatalf("NewMFP failed: %v", err)
+	}
+	bus.AddDevice(mfp)
+	return cpu, bus, mfp
+}
+
+func writeRegisters(tb testing.TB, mfp *MFP, values ...uint32) {
+	tb.Helper()
+	for i := 0; i < len(values); i += 2 {
+		if err := mfp.Write(m68kemu.Byte, mfpRegister(values[i]), values[i+1]); err != nil {
+			tb.Fatalf("write register %d: %v", values[i], err)
+		}
+	}
+}
+
+func readRegister(tb testing.TB, mfp *MFP, n uint32) uint32 {
+	tb.Helper()
+	value, err := mfp.Read(m68kemu.Byte, mfpRegister(n))
+	if err != nil {
+		tb.Fatalf("read register %d: %v", n, err)
+	}
+	return value
+}
+
+func TestMFPTimerInterruptsRunTheHandler(t *testing.T) {
+	cpu, bus, _ := newMFPEnvironment(t, MFPOptions{})
+	code, _, err := asm.AssembleStringWithListing(`
+        LEA     handler(PC),A0
+        MOVE.L  A0,$134          ; vector $4D, timer A
+        MOVE.B  #$48,$FFFA17     ; VR: vectors from $40, software EOI
+        MOVE.B  #$20,$FFFA07     ; IERA: timer A
+        MOVE.B  #$20,$FFFA13     ; IMRA
+        MOVE.B  #100,$FFFA1F     ; TADR
+        MOVE.B  #1,$FFFA19       ; TACR: delay mode, prescaler 4
+        MOVE.W  #$2300,SR
+loop:   BRA.S   loop
+handler:
+        ADDQ.L  #1,D7
+        MOVE.B  #$DF,$FFFA0F     ; ISRA: end of interrupt
+        RTE
+`)
+	if err != nil {
+		t.Fatalf("Assembler failed: %v", err)
+	}
+	for i, b := range code {
+		_ = bus.Write(m68kemu.Byte, 0x1000+uint32(i), uint32(b))
+	}
+	cpu.SetRegisters(m68kemu.Registers{SR: 0x2700, A: [8]uint32{7: 0x8000}, SSP: 0x8000, PC: 0x1000})
+
+	// 1000 timer periods of 400 MFP clocks each.
+	if err := cpu.RunCycles(m68kemu.FrequencyST * 400 * 1000 / DefaultMFPClock); err != nil {
+		t.Fatalf("RunCycles failed: %v", err)
+	}
+	if got := cpu.Registers().D[7]; got < 998 || got > 1000 {
+		t.Fatalf("timer interrupts = %d, want 1000 give or take the setup", got)
+	}
+}
+
+func TestMFPPrioritiesAndEndOfInterrupt(t *testing.T) {
+	cpu, _, mfp := newMFPEnvironment(t, MFPOptions{})
+	ic := cpu.Interrupts()
+	writeRegisters(t, mfp,
+		mfpVR, 0x48,
+		mfpIERA, 0xff, mfpIERB, 0xff,
+		mfpIMRA, 0xff, mfpIMRB, 0x7f, // GPIP 5 masked
+	)
+
+	mfp.raise(0)
+	mfp.raise(7)
+	mfp.raise(mfpChannelTimerA)
+	if got := readRegister(t, mfp, mfpIPRB); got != 0x81 {
+		t.Fatalf("IPRB = %02x, want GPIP 0 and 5 pending", got)
+	}
+
+	level, vector, autoVector, ok := ic.Pending(0)
+	if !ok || level != DefaultMFPLevel || vector != 0x4d || autoVector {
+		t.Fatalf("Pending = %d, %#x, %v, %v, want timer A at level 6", level, vector, autoVector, ok)
+	}
+	if ic.HasPending(0) {
+		t.Fatalf("GPIP 0 interrupts while timer A is in service")
+	}
+	if got := readRegister(t, mfp, mfpISRA); got != 0x20 {
+		t.Fatalf("ISRA = %02x, want timer A in service", got)
+	}
+
+	writeRegisters(t, mfp, mfpISRA, 0xdf)
+	if _, vector, _, _ = ic.Pending(0); vector != 0x40 {
+		t.Fatalf("vector after end of interrupt = %#x, want GPIP 0", vector)
+	}
+
+	// Automatic end-of-interrupt leaves nothing in service.
+	writeRegisters(t, mfp, mfpVR, 0x40, mfpISRB, 0)
+	mfp.raise(mfpChannelTimerC)
+	mfp.raise(mfpChannelTimerD)
+	for _, want := range []uint32{0x45, 0x44} {
+		if _, vector, _, _ = ic.Pending(0); vector != want {
+			t.Fatalf("vector = %#x, want %#x", vector, want)
+		}
+	}
+	if ic.HasPending(0) {
+		t.Fatalf("masked GPIP 5 raised an interrupt")
+	}
+
+	// Disabling a channel drops its pending request.
+	writeRegisters(t, mfp, mfpIERB, 0)
+	if got := readRegister(t, mfp, mfpIPRB); got != 0 {
+		t.Fatalf("IPRB = %02x after disabling, want 0", got)
+	}
+}
+
+func TestMFPGPIPEdgesAndEventCount(t *testing.T) {
+	_, _, mfp := newMFPEnvironment(t, MFPOptions{})
+	writeRegisters(t, mfp, mfpIERB, 0xff, mfpIERA, 0xff, mfpDDR, 0x02, mfpGPIP, 0x02)
+
+	mfp.SetInput(0, true)
+	mfp.SetInput(1, true)
+	if got := readRegister(t, mfp, mfpGPIP); got != 0x03 {
+		t.Fatalf("GPIP = %02x, want pin 0 input and pin 1 output high", got)
+	}
+	if got := readRegister(t, mfp, mfpIPRB); got != 0 {
+		t.Fatalf("IPRB = %02x after a rising edge on a falling-edge pin", got)
+	}
+	mfp.SetInput(0, false)
+	if got := readRegister(t, mfp, mfpIPRB); got != 0x01 {
+		t.Fatalf("IPRB = %02x, want the falling edge on pin 0", got)
+	}
+
+	// Flipping the AER while the pin is low completes a rising-edge wait.
+	writeRegisters(t, mfp, mfpIPRB, 0, mfpAER, 0x01)
+	mfp.SetInput(0, true)
+	if got := readRegister(t, mfp, mfpIPRB); got != 0x01 {
+		t.Fatalf("IPRB = %02x, want the rising edge on pin 0", got)
+	}
+
+	// Event count mode: timer B counts rising TBI edges (AER bit 3).
+	writeRegisters(t, mfp, mfpAER, 0x08, mfpTBDR, 3, mfpTBCR, 0x08)
+	for i := range 3 {
+		mfp.SetTimerInput(MFPTimerB, true)
+		mfp.SetTimerInput(MFPTimerB, false)
+		if want := uint32(2 - i); i < 2 && readRegister(t, mfp, mfpTBDR) != want {
+			t.Fatalf("TBDR = %d after %d events, want %d", readRegister(t, mfp, mfpTBDR), i+1, want)
+		}
+	}
+	if got := readRegister(t, mfp, mfpIPRA); got != 0x01 {
+		t.Fatalf("IPRA = %02x, want timer B pending", got)
+	}
+	if got := readRegister(t, mfp, mfpTBDR); got != 3 || !mfp.TimerOutput(MFPTimerB) {
+		t.Fatalf("TBDR = %d, output %v, want a reload and a toggled output", got, mfp.TimerOutput(MFPTimerB))
+	}
+}
+
+func TestMFPTimerCounterAndPulseWidth(t *testing.T) {
+	cpu, _, mfp := newMFPEnvironment(t, MFPOptions{})
+	scheduler := cpu.Scheduler()
+	clock := func(ticks uint64) {
+		scheduler.Advance((ticks*m68kemu.FrequencyST + DefaultMFPClock - 1) / DefaultMFPClock)
+	}
+
+	// Timer C with prescaler 10 counts down from 50 and reloads.
+	writeRegisters(t, mfp, mfpIERB, 0x20, mfpTCDR, 50, mfpTCDCR, 0x20)
+	clock(200)
+	if got := readRegister(t, mfp, mfpTCDR); got != 30 {
+		t.Fatalf("TCDR = %d after 200 clocks, want 30", got)
+	}
+	clock(300)
+	if got := readRegister(t, mfp, mfpTCDR); got != 50 {
+		t.Fatalf("TCDR = %d after a period, want 50", got)
+	}
+	if got := readRegister(t, mfp, mfpIPRB); got != 0x20 {
+		t.Fatalf("IPRB = %02x, want timer C pending", got)
+	}
+
+	// Pulse width mode: timer A counts while TAI is high (AER bit 4 clear)
+	// and the falling edge raises the GPIP 4 interrupt.
+	writeRegisters(t, mfp, mfpIERB, 0x40, mfpTADR, 0, mfpTACR, 0x09)
+	clock(40)
+	mfp.SetTimerInput(MFPTimerA, true)
+	clock(40)
+	mfp.SetTimerInput(MFPTimerA, false)
+	clock(40)
+	if got := readRegister(t, mfp, mfpTADR); got != 246 {
+		t.Fatalf("TADR = %d after a 40 clock pulse, want 246", got)
+	}
+	if got := readRegister(t, mfp, mfpIPRB); got&0x40 == 0 {
+		t.Fatalf("IPRB = %02x, want the end of pulse on GPIP 4", got)
+	}
+}
+
+func TestMFPUSART(t *testing.T) {
+	var serial bytes.Buffer
+	_, _, mfp := newMFPEnvironment(t, MFPOptions{Serial: &serial})
+	writeRegisters(t, mfp, mfpIERA, 0x1c, mfpTSR, 0x01, mfpUDR, 'A')
+	if serial.String() != "A" {
+		t.Fatalf("transmitted %q, want A", serial.String())
+	}
+
+	if mfp.Receive('x') {
+		t.Fatalf("disabled receiver took a byte")
+	}
+	writeRegisters(t, mfp, mfpRSR, 0x01)
+	if !mfp.Receive('x') || mfp.Receive('y') {
+		t.Fatalf("receiver did not take exactly one byte")
+	}
+	if got := readRegister(t, mfp, mfpRSR); got != 0xc1 {
+		t.Fatalf("RSR = %02x, want buffer full and overrun", got)
+	}
+	if got := readRegister(t, mfp, mfpIPRA); got != 0x1c {
+		t.Fatalf("IPRA = %02x, want receive, receive error, and transmit interrupts", got)
+	}
+	if got := readRegister(t, mfp, mfpUDR); got != 'x' {
+		t.Fatalf("UDR = %q, want x", rune(got))
+	}
+	if got := readRegister(t, mfp, mfpRSR); got != 0x01 {
+		t.Fatalf("RSR = %02x after reading UDR, want only the enable bit", got)
+	}
+}
@@ -0,0 +1,110 @@
+package peripheral
+
+import (
+	"io"
+
+	m68kemu "github.com/jenska/m68kemu"
+)
+
+// schedulerOf returns cpu's scheduler, installing one first if cpu has none,
+// so chips can be created before or without the rest of the machine.
+func schedulerOf(cpu m68kemu.CPU) *m68kemu.CycleScheduler {
+	scheduler := cpu.Scheduler()
+	if scheduler == nil {
+		scheduler = m68kemu.NewCycleScheduler()
+		cpu.SetScheduler(scheduler)
+	}
+	return scheduler
+}
+
+// hostLink runs a serial channel's shift registers against host streams. The
+// transmitter takes one waiting byte at a time and writes it to output once
+// its character time has passed. The receiver reads input once per character
+// time, but only while the chip has room, so a slow program is never overrun
+// by a fast host; it stops for good at the first read error, io.EOF included.
+// Reads happen inside scheduled events on the emulation goroutine.
+//
+// The chip supplies its character timing and register updates through the
+// function fields.
+type hostLink struct {
+	clock  *m68kemu.ClockDomain
+	name   string
+	input  io.Reader
+	output io.Writer
+
+	// txTicks and rxTicks return the current character time in clock ticks.
+	txTicks func() uint64
+	rxTicks func() uint64
+	// load runs as the shift register takes a byte and returns the bits
+	// that go out on the line.
+	load func(b byte) byte
+	// sent, if set, runs after a character has left the shift register.
+	sent func()
+	// room reports whether the receiver can take a byte; deliver hands it one.
+	room    func() bool
+	deliver func(b byte)
+
+	pending  int // byte waiting for the shift register, or -1
+	shifting bool
+	txEvent  m68kemu.EventHandle
+	rxEvent  m68kemu.EventHandle
+	inputEOF bool
+}
+
+// send queues b for the transmitter, starting it if it is idle.
+func (l *hostLink) send(b byte) {
+	l.pending = int(b)
+	if !l.shifting {
+		l.shift()
+	}
+}
+
+func (l *hostLink) shift() {
+	b := l.load(byte(l.pending))
+	l.pending = -1
+	l.shifting = true
+	l.txEvent = l.clock.ScheduleNamed(l.name, l.clock.Now()+l.txTicks(), func(uint64) {
+		_, _ = l.output.Write([]byte{b})
+		l.shifting = false
+		if l.pending >= 0 {
+			l.shift()
+		}
+		if l.sent != nil {
+			l.sent()
+		}
+	})
+}
+
+// stopTransmitter drops the waiting byte and the character in flight.
+func (l *hostLink) stopTransmitter() {
+	l.pending = -1
+	l.shifting = false
+	l.txEvent.Cancel()
+}
+
+func (l *hostLink) startReceiver() {
+	if l.input == nil || l.inputEOF {
+		return
+	}
+	l.rxEvent.Cancel()
+	l.rxEvent = l.clock.ScheduleAfter(l.rxTicks(), l.poll)
+}
+
+func (l *hostLink) stopReceiver() {
+	l.rxEvent.Cancel()
+}
+
+func (l *hostLink) poll(uint64) {
+	if l.room() {
+		var b [1]byte
+		n, err := l.input.Read(b[:])
+		if n == 1 {
+			l.deliver(b[0])
+		}
+		if err != nil {
+			l.inputEOF = true
+			return
+		}
+	}
+	l.rxEvent = l.clock.ScheduleAfter(l.rxTicks(), l.poll)
+}
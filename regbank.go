@@ -0,0 +1,310 @@
+package m68kemu
+
+import "fmt"
+
+// RegisterLayout selects how a RegisterBank spreads its registers over the bus.
+type RegisterLayout int
+
+const (
+	// RegisterLayoutPacked places registers at consecutive byte offsets.
+	RegisterLayoutPacked RegisterLayout = iota
+	// RegisterLayoutOdd places 8-bit register n at base+2n+1, the way 8-bit
+	// peripherals wired to the low data byte (D0-D7) appear, such as the ST's MFP.
+	RegisterLayoutOdd
+	// RegisterLayoutEven places 8-bit register n at base+2n, for peripherals
+	// wired to the high data byte (D8-D15).
+	RegisterLayoutEven
+)
+
+// registerBankFloating is returned for byte lanes no register drives.
+const registerBankFloating = 0xff
+
+// RegisterNamer optionally names the device register behind an address so bus
+// traces can print "MFP.TACR" instead of a raw address.
+type RegisterNamer interface {
+	RegisterName(address uint32) (string, bool)
+}
+
+// Register declares one register of a RegisterBank.
+//
+// Offset is the register's index in the bank's register space: a byte offset
+// for packed banks and the register number for odd/even banks. ReadMask and
+// WriteMask limit which bits reads return and writes change; zero means every
+// bit of Width. OnRead may replace the stored value on CPU reads, and OnWrite
+// receives the stored and the newly merged value and returns what to store.
+type Register struct {
+	Name      string
+	Offset    uint32
+	Width     Size
+	ReadMask  uint32
+	WriteMask uint32
+	ReadOnly  bool
+	WriteOnly bool
+	Reset     uint32
+	OnRead    func(value uint32) uint32
+	OnWrite   func(old, value uint32) uint32
+}
+
+// RegisterBank is a memory-mapped register file that takes care of address
+// decoding, byte access to wider registers, and odd/even byte-lane mapping so
+// peripheral models only describe their registers and side effects.
+type RegisterBank struct {
+	name      string
+	base      uint32
+	size      uint32
+	layout    RegisterLayout
+	registers []*bankRegister
+	byteMap   []int16
+}
+
+type bankRegister struct {
+	Register
+	value     uint32
+	full      uint32
+	readMask  uint32
+	writeMask uint32
+}
+
+type pendingRegisterWrite struct {
+	reg     *bankRegister
+	value   uint32
+	touched uint32
+}
+
+// NewRegisterBank creates an empty register bank decoding size bytes of
+// address space starting at base. The name prefixes register names in traces.
+func NewRegisterBank(name string, base, size uint32, layout RegisterLayout) *RegisterBank {
+	spaceSize := size
+	if layout != RegisterLayoutPacked {
+		spaceSize = (size + 1) / 2
+	}
+	bank := &RegisterBank{
+		name:    name,
+		base:    base & 0xffffff,
+		size:    size,
+		layout:  layout,
+		byteMap: make([]int16, spaceSize),
+	}
+	for i := range bank.byteMap {
+		bank.byteMap[i] = -1
+	}
+	return bank
+}
+
+// Add declares a register and returns the bank so declarations can be chained.
+// Overlapping or out-of-range registers are programming errors and panic.
+func (b *RegisterBank) Add(reg Register) *RegisterBank {
+	if reg.Width != Byte && reg.Width != Word && reg.Width != Long {
+		panic(fmt.Errorf("register %s.%s has invalid width %d", b.name, reg.Name, reg.Width))
+	}
+	if b.layout != RegisterLayoutPacked && reg.Width != Byte {
+		panic(fmt.Errorf("register %s.%s: odd/even banks only hold byte registers", b.name, reg.Name))
+	}
+	end := uint64(reg.Offset) + uint64(reg.Width)
+	if end > uint64(len(b.byteMap)) {
+		panic(fmt.Errorf("register %s.%s at offset %d does not fit the bank", b.name, reg.Name, reg.Offset))
+	}
+	for off := reg.Offset; off < uint32(end); off++ {
+		if b.byteMap[off] >= 0 {
+			panic(fmt.Errorf("register %s.%s overlaps %s", b.name, reg.Name, b.registers[b.byteMap[off]].Name))
+		}
+		b.byteMap[off] = int16(len(b.registers))
+	}
+
+	full := reg.Width.mask()
+	entry := &bankRegister{Register: reg, full: full, readMask: full, writeMask: full}
+	if reg.ReadMask != 0 {
+		entry.readMask = reg.ReadMask & full
+	}
+	if reg.WriteMask != 0 {
+		entry.writeMask = reg.WriteMask & full
+	}
+	if reg.WriteOnly {
+		entry.readMask = 0
+	}
+	if reg.ReadOnly {
+		entry.writeMask = 0
+	}
+	entry.value = reg.Reset & full
+	b.registers = append(b.registers, entry)
+	return b
+}
+
+// Value returns the stored value of the register at offset without invoking
+// callbacks or applying masks. Device models use it for their internal state.
+func (b *RegisterBank) Value(offset uint32) uint32 {
+	if reg := b.register(offset); reg != nil {
+		return reg.value
+	}
+	return 0
+}
+
+// SetValue stores a register value directly, bypassing masks and callbacks.
+func (b *RegisterBank) SetValue(offset uint32, value uint32) {
+	if reg := b.register(offset); reg != nil {
+		reg.value = value & reg.full
+	}
+}
+
+func (b *RegisterBank) Contains(address uint32) bool {
+	address &= 0xffffff
+	return address >= b.base && address-b.base < b.size
+}
+
+func (b *RegisterBank) AddressRange() (uint32, uint32) {
+	if b.size == 0 {
+		return b.base, b.base
+	}
+	return b.base, b.base + b.size - 1
+}
+
+func (b *RegisterBank) Read(s Size, address uint32) (uint32, error) {
+	return b.read(s, address&0xffffff, false)
+}
+
+// Peek returns stored register values without invoking OnRead callbacks.
+func (b *RegisterBank) Peek(s Size, address uint32) (uint32, error) {
+	return b.read(s, address&0xffffff, true)
+}
+
+func (b *RegisterBank) Write(s Size, address uint32, value uint32) error {
+	address &= 0xffffff
+	if !b.containsAccess(s, address) {
+		return BusError(address)
+	}
+
+	var pending pendingRegisterWrite
+	for i := range uint32(s) {
+		reg, shift, lane := b.lookup(address + i)
+		if !lane {
+			if s == Byte {
+				return BusError(address)
+			}
+			continue
+		}
+		if reg == nil {
+			continue
+		}
+		if pending.reg != reg {
+			b.flush(pending)
+			pending = pendingRegisterWrite{reg: reg}
+		}
+		byteValue := (value >> (8 * (uint32(s) - 1 - i))) & 0xff
+		pending.value |= byteValue << shift
+		pending.touched |= 0xff << shift
+	}
+	b.flush(pending)
+	return nil
+}
+
+// Reset restores every register to its declared reset value.
+func (b *RegisterBank) Reset() {
+	for _, reg := range b.registers {
+		reg.value = reg.Reset & reg.full
+	}
+}
+
+// RegisterName implements RegisterNamer as "BANK.REGISTER".
+func (b *RegisterBank) RegisterName(address uint32) (string, bool) {
+	address &= 0xffffff
+	if !b.Contains(address) {
+		return "", false
+	}
+	reg, _, lane := b.lookup(address)
+	if (!lane || reg == nil) && b.layout != RegisterLayoutPacked {
+		// Word accesses to odd/even banks start on the other lane.
+		reg, _, lane = b.lookup(address ^ 1)
+	}
+	if !lane || reg == nil || reg.Name == "" {
+		return "", false
+	}
+	if b.name == "" {
+		return reg.Name, true
+	}
+	return b.name + "." + reg.Name, true
+}
+
+func (b *RegisterBank) read(s Size, address uint32, peek bool) (uint32, error) {
+	if !b.containsAccess(s, address) {
+		return 0, BusError(address)
+	}
+
+	var (
+		result uint32
+		last   *bankRegister
+		value  uint32
+	)
+	for i := range uint32(s) {
+		reg, shift, lane := b.lookup(address + i)
+		if !lane && s == Byte {
+			return 0, BusError(address)
+		}
+		byteValue := uint32(registerBankFloating)
+		if lane && reg != nil {
+			if reg != last {
+				value = b.readRegister(reg, peek)
+				last = reg
+			}
+			byteValue = (value >> shift) & 0xff
+		}
+		result = result<<8 | byteValue
+	}
+	return result, nil
+}
+
+func (b *RegisterBank) readRegister(reg *bankRegister, peek bool) uint32 {
+	value := reg.value
+	if !peek && reg.OnRead != nil {
+		value = reg.OnRead(value)
+	}
+	return value & reg.readMask
+}
+
+func (b *RegisterBank) flush(pending pendingRegisterWrite) {
+	reg := pending.reg
+	if reg == nil || reg.ReadOnly {
+		return
+	}
+	mask := reg.writeMask & pending.touched
+	value := (reg.value &^ mask) | (pending.value & mask)
+	if reg.OnWrite != nil {
+		value = reg.OnWrite(reg.value, value)
+	}
+	reg.value = value & reg.full
+}
+
+// lookup maps a bus address to the register byte it addresses. lane is false
+// when an odd/even bank does not drive that byte lane at all.
+func (b *RegisterBank) lookup(address uint32) (reg *bankRegister, shift uint32, lane bool) {
+	rel := address - b.base
+	offset := rel
+	switch b.layout {
+	case RegisterLayoutOdd:
+		if rel&1 == 0 {
+			return nil, 0, false
+		}
+		offset = rel >> 1
+	case RegisterLayoutEven:
+		if rel&1 != 0 {
+			return nil, 0, false
+		}
+		offset = rel >> 1
+	}
+	if offset >= uint32(len(b.byteMap)) || b.byteMap[offset] < 0 {
+		return nil, 0, true
+	}
+	reg = b.registers[b.byteMap[offset]]
+	shift = 8 * (reg.Offset + uint32(reg.Width) - 1 - offset)
+	return reg, shift, true
+}
+
+func (b *RegisterBank) register(offset uint32) *bankRegister {
+	if offset >= uint32(len(b.byteMap)) || b.byteMap[offset] < 0 {
+		return nil
+	}
+	return b.registers[b.byteMap[offset]]
+}
+
+func (b *RegisterBank) containsAccess(s Size, address uint32) bool {
+	return b.Contains(address) && address-b.base+uint32(s) <= b.size
+}
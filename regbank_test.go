@@ -0,0 +1,115 @@
+package m68kemu
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegisterBankOddLayoutDecodesByteLanes(t *testing.T) {
+	bank := NewRegisterBank("MFP", 0xfffa00, 0x40, RegisterLayoutOdd).
+		Add(Register{Name: "GPIP", Offset: 0x00, Width: Byte, Reset: 0xff}).
+		Add(Register{Name: "TACR", Offset: 0x0c, Width: Byte, WriteMask: 0x1f})
+
+	if err := bank.Write(Byte, 0xfffa19, 0xff); err != nil {
+		t.Fatalf("TACR write failed: %v", err)
+	}
+	if got, err := bank.Read(Byte, 0xfffa19); err != nil || got != 0x1f {
+		t.Fatalf("TACR read = (%02x, %v), want (1f, <nil>)", got, err)
+	}
+	if got, err := bank.Read(Word, 0xfffa00); err != nil || got != 0xffff {
+		t.Fatalf("word read of GPIP = (%04x, %v), want (ffff, <nil>)", got, err)
+	}
+	if _, err := bank.Read(Byte, 0xfffa18); err == nil {
+		t.Fatalf("even byte read unexpectedly succeeded")
+	} else {
+		expectBusError(t, err)
+	}
+	if got, err := bank.Read(Byte, 0xfffa03); err != nil || got != registerBankFloating {
+		t.Fatalf("unmapped register read = (%02x, %v), want floating bus", got, err)
+	}
+
+	if name, ok := bank.RegisterName(0xfffa19); !ok || name != "MFP.TACR" {
+		t.Fatalf("register name = (%q, %v), want MFP.TACR", name, ok)
+	}
+
+	bank.Reset()
+	if bank.Value(0x0c) != 0 || bank.Value(0x00) != 0xff {
+		t.Fatalf("reset values = TACR %02x GPIP %02x", bank.Value(0x0c), bank.Value(0x00))
+	}
+}
+
+func TestRegisterBankPackedWordRegisterCallbacks(t *testing.T) {
+	var writes []uint32
+	reads := 0
+	bank := NewRegisterBank("VID", 0xff8200, 0x10, RegisterLayoutPacked).
+		Add(Register{
+			Name:   "STATUS",
+			Offset: 0,
+			Width:  Word,
+			OnRead: func(value uint32) uint32 {
+				reads++
+				return value | 0x8000
+			},
+			OnWrite: func(old, value uint32) uint32 {
+				writes = append(writes, value)
+				return value
+			},
+		}).
+		Add(Register{Name: "MODE", Offset: 2, Width: Byte, ReadOnly: true, Reset: 0x02})
+
+	if err := bank.Write(Byte, 0xff8201, 0x34); err != nil {
+		t.Fatalf("low byte write failed: %v", err)
+	}
+	if err := bank.Write(Byte, 0xff8200, 0x12); err != nil {
+		t.Fatalf("high byte write failed: %v", err)
+	}
+	if len(writes) != 2 || writes[0] != 0x0034 || writes[1] != 0x1234 {
+		t.Fatalf("OnWrite values = %x, want [34 1234]", writes)
+	}
+
+	if got, _ := bank.Read(Word, 0xff8200); got != 0x9234 || reads != 1 {
+		t.Fatalf("word read = %04x after %d callbacks, want 9234 after 1", got, reads)
+	}
+	if got, _ := bank.Peek(Word, 0xff8200); got != 0x1234 || reads != 1 {
+		t.Fatalf("peek = %04x after %d callbacks, want 1234 without callback", got, reads)
+	}
+
+	if err := bank.Write(Byte, 0xff8202, 0xff); err != nil {
+		t.Fatalf("read-only write failed: %v", err)
+	}
+	if got, _ := bank.Read(Byte, 0xff8202); got != 0x02 {
+		t.Fatalf("read-only register = %02x, want 02", got)
+	}
+}
+
+func TestBusTraceReportsRegisterNames(t *testing.T) {
+	ram := NewRAM(0, 0x10000)
+	bank := NewRegisterBank("MFP", 0xfffa00, 0x40, RegisterLayoutOdd).
+		Add(Register{Name: "TACR", Offset: 0x0c, Width: Byte})
+	bus := NewBus(ram, bank)
+	_ = ram.Write(Long, 0, 0x1000)
+	_ = ram.Write(Long, 4, 0x2000)
+	code := assemble(t, "MOVE.B #1,$FFFA19\n")
+	for i, value := range code {
+		_ = ram.Write(Byte, 0x2000+uint32(i), uint32(value))
+	}
+
+	processor, err := NewCPU(bus)
+	if err != nil {
+		t.Fatalf("NewCPU failed: %v", err)
+	}
+	var out bytes.Buffer
+	logger := NewVerboseLogger(processor, bus, &out, VerboseLoggerOptions{})
+	processor.SetBusTracer(logger.BusTrace)
+
+	if err := processor.Step(); err != nil {
+		t.Fatalf("step failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "BUS PC 00002000 WRITE.B MFP.TACR = 01") {
+		t.Fatalf("bus trace missing register name:\n%s", out.String())
+	}
+	if bank.Value(0x0c) != 1 {
+		t.Fatalf("TACR = %02x, want 01", bank.Value(0x0c))
+	}
+}
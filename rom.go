@@ -0,0 +1,190 @@
+package m68kemu
+
+import (
+	"fmt"
+	"os"
+)
+
+// ROMWritePolicy selects how a ROM responds to CPU writes.
+type ROMWritePolicy int
+
+const (
+	// ROMWriteBusError terminates writes with a bus error, as on machines whose
+	// address decoder does not acknowledge writes to ROM.
+	ROMWriteBusError ROMWritePolicy = iota
+	// ROMWriteIgnore acknowledges writes and discards the data.
+	ROMWriteIgnore
+	// ROMWriteCallback forwards writes to the ROM's write callback, for example
+	// to model cartridge-port tricks that decode writes from address lines.
+	ROMWriteCallback
+)
+
+// ROMWriteFunc receives writes to a ROM configured with ROMWriteCallback.
+type ROMWriteFunc func(size Size, address uint32, value uint32) error
+
+// ROM is a read-only memory image. Loaders and debuggers can still change its
+// contents through Poke.
+type ROM struct {
+	offset  uint32
+	mem     []byte
+	policy  ROMWritePolicy
+	onWrite ROMWriteFunc
+}
+
+// BootOverlay mirrors the first bytes of a ROM at address 0 so the CPU fetches
+// its reset SSP and PC from ROM, as the Atari ST does for the first 8 bytes.
+// Place it on the bus ahead of the RAM it overlays.
+type BootOverlay struct {
+	rom  *ROM
+	size uint32
+}
+
+// NewROM creates a ROM at offset holding a copy of data.
+func NewROM(offset uint32, data []byte) *ROM {
+	return &ROM{offset: offset, mem: append([]byte(nil), data...)}
+}
+
+// LoadROM creates a ROM at offset from an image file such as a TOS dump.
+func LoadROM(offset uint32, path string) (*ROM, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("load ROM image: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("load ROM image %s: file is empty", path)
+	}
+	return &ROM{offset: offset, mem: data}, nil
+}
+
+// SetWritePolicy changes how the ROM handles writes. The callback is only used
+// with ROMWriteCallback.
+func (rom *ROM) SetWritePolicy(policy ROMWritePolicy, callback ROMWriteFunc) {
+	rom.policy = policy
+	rom.onWrite = callback
+}
+
+// Bytes returns the ROM contents. The slice aliases the device memory.
+func (rom *ROM) Bytes() []byte {
+	return rom.mem
+}
+
+func (rom *ROM) Contains(address uint32) bool {
+	return address >= rom.offset && address < rom.offset+uint32(len(rom.mem))
+}
+
+func (rom *ROM) AddressRange() (uint32, uint32) {
+	if len(rom.mem) == 0 {
+		return rom.offset, rom.offset
+	}
+	return rom.offset, rom.offset + uint32(len(rom.mem)) - 1
+}
+
+func (rom *ROM) rangeCheck(address uint32, s Size) bool {
+	end := address + uint32(s) - 1
+	return address >= rom.offset && end < rom.offset+uint32(len(rom.mem))
+}
+
+func (rom *ROM) Read(s Size, address uint32) (uint32, error) {
+	if !rom.rangeCheck(address, s) {
+		return 0, BusError(address)
+	}
+	return readROMBytes(rom.mem, address-rom.offset, s)
+}
+
+func (rom *ROM) Peek(s Size, address uint32) (uint32, error) {
+	return rom.Read(s, address)
+}
+
+func (rom *ROM) Write(s Size, address uint32, value uint32) error {
+	if !rom.rangeCheck(address, s) {
+		return BusError(address)
+	}
+	return rom.rejectWrite(s, address, value)
+}
+
+// Poke patches the ROM image regardless of the write policy.
+func (rom *ROM) Poke(s Size, address uint32, value uint32) error {
+	if !rom.rangeCheck(address, s) {
+		return BusError(address)
+	}
+	idx := address - rom.offset
+	for i := int(s) - 1; i >= 0; i-- {
+		rom.mem[idx+uint32(i)] = uint8(value)
+		value >>= 8
+	}
+	return nil
+}
+
+func (rom *ROM) Reset() {}
+
+func (rom *ROM) rejectWrite(s Size, address uint32, value uint32) error {
+	switch rom.policy {
+	case ROMWriteIgnore:
+		return nil
+	case ROMWriteCallback:
+		if rom.onWrite != nil {
+			return rom.onWrite(s, address, value&s.mask())
+		}
+		return nil
+	default:
+		return BusError(address)
+	}
+}
+
+// NewBootOverlay mirrors the first size bytes of rom at address 0.
+func NewBootOverlay(rom *ROM, size uint32) *BootOverlay {
+	size = min(size, uint32(len(rom.mem)))
+	return &BootOverlay{rom: rom, size: size}
+}
+
+func (overlay *BootOverlay) Contains(address uint32) bool {
+	return address < overlay.size
+}
+
+func (overlay *BootOverlay) AddressRange() (uint32, uint32) {
+	if overlay.size == 0 {
+		return 0, 0
+	}
+	return 0, overlay.size - 1
+}
+
+func (overlay *BootOverlay) Read(s Size, address uint32) (uint32, error) {
+	if address+uint32(s) > overlay.size {
+		return 0, BusError(address)
+	}
+	return readROMBytes(overlay.rom.mem, address, s)
+}
+
+func (overlay *BootOverlay) Peek(s Size, address uint32) (uint32, error) {
+	return overlay.Read(s, address)
+}
+
+// Write applies the ROM's write policy; the mirrored vectors stay read-only.
+func (overlay *BootOverlay) Write(s Size, address uint32, value uint32) error {
+	if address+uint32(s) > overlay.size {
+		return BusError(address)
+	}
+	return overlay.rom.rejectWrite(s, address, value)
+}
+
+// Poke patches the underlying ROM image.
+func (overlay *BootOverlay) Poke(s Size, address uint32, value uint32) error {
+	if address+uint32(s) > overlay.size {
+		return BusError(address)
+	}
+	return overlay.rom.Poke(s, overlay.rom.offset+address, value)
+}
+
+func (overlay *BootOverlay) Reset() {}
+
+func readROMBytes(mem []byte, idx uint32, s Size) (uint32, error) {
+	switch s {
+	case Byte:
+		return uint32(mem[idx]), nil
+	case Word:
+		return uint32(mem[idx])<<8 | uint32(mem[idx+1]), nil
+	case Long:
+		return uint32(mem[idx])<<24 | uint32(mem[idx+1])<<16 | uint32(mem[idx+2])<<8 | uint32(mem[idx+3]), nil
+	}
+	return 0, fmt.Errorf("unknown size %d", s)
+}
@@ -0,0 +1,103 @@
+package m68kemu
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestROMWritePolicies(t *testing.T) {
+	rom := NewROM(0xfc0000, []byte{0x12, 0x34, 0x56, 0x78})
+
+	if got, err := rom.Read(Long, 0xfc0000); err != nil || got != 0x12345678 {
+		t.Fatalf("read = (%08x, %v), want (12345678, <nil>)", got, err)
+	}
+	expectBusError(t, rom.Write(Word, 0xfc0000, 0xffff))
+
+	rom.SetWritePolicy(ROMWriteIgnore, nil)
+	if err := rom.Write(Word, 0xfc0000, 0xffff); err != nil {
+		t.Fatalf("ignored write failed: %v", err)
+	}
+
+	var seen uint32
+	rom.SetWritePolicy(ROMWriteCallback, func(size Size, address uint32, value uint32) error {
+		seen = address
+		return nil
+	})
+	if err := rom.Write(Byte, 0xfc0003, 0x01); err != nil || seen != 0xfc0003 {
+		t.Fatalf("callback write = (%v, %08x), want (<nil>, 00fc0003)", err, seen)
+	}
+
+	if got, _ := rom.Read(Word, 0xfc0000); got != 0x1234 {
+		t.Fatalf("ROM changed by write: %04x", got)
+	}
+	if err := rom.Poke(Word, 0xfc0002, 0xbeef); err != nil {
+		t.Fatalf("poke failed: %v", err)
+	}
+	if got, _ := rom.Read(Word, 0xfc0002); got != 0xbeef {
+		t.Fatalf("poked word = %04x, want beef", got)
+	}
+}
+
+func TestLoadROMFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tos.img")
+	if err := os.WriteFile(path, []byte{0x60, 0x2e, 0x01, 0x04}, 0o644); err != nil {
+		t.Fatalf("write image: %v", err)
+	}
+
+	rom, err := LoadROM(0xe00000, path)
+	if err != nil {
+		t.Fatalf("LoadROM failed: %v", err)
+	}
+	if start, end := rom.AddressRange(); start != 0xe00000 || end != 0xe00003 {
+		t.Fatalf("range = %08x-%08x", start, end)
+	}
+	if _, err := LoadROM(0, filepath.Join(t.TempDir(), "missing.img")); err == nil {
+		t.Fatalf("expected error for missing image")
+	}
+}
+
+func TestBootOverlayResetsFromROMAndKeepsFastRAM(t *testing.T) {
+	image := make([]byte, 0x100)
+	copy(image, []byte{0x00, 0x00, 0x80, 0x00, 0x00, 0xfc, 0x00, 0x10})
+	program := assemble(t, "MOVE.L #$11223344,$1000\nMOVE.L D0,$FC0000\n")
+	copy(image[0x10:], program)
+
+	rom := NewROM(0xfc0000, image)
+	ram := NewRAM(0, 0x10000)
+	bus := NewBus(NewBootOverlay(rom, 8), ram, rom)
+
+	if page := bus.fastPages[0]; page.start != 8 || page.limit != 0x1000 || !page.writable {
+		t.Fatalf("fast page 0 = %04x-%04x writable=%v, want RAM 0008-0fff", page.start, page.limit, page.writable)
+	}
+	if page := bus.fastPages[0xfc0]; page.start != 0 || page.limit != 0x100 || page.writable {
+		t.Fatalf("fast ROM page = %04x-%04x writable=%v, want read-only 0000-00ff", page.start, page.limit, page.writable)
+	}
+
+	processor, err := NewCPU(bus)
+	if err != nil {
+		t.Fatalf("NewCPU failed: %v", err)
+	}
+	regs := processor.Registers()
+	if regs.PC != 0xfc0010 || regs.A[7] != 0x8000 {
+		t.Fatalf("reset PC/SSP = %08x/%08x, want 00fc0010/00008000", regs.PC, regs.A[7])
+	}
+
+	if err := ram.Write(Long, XBusError<<2, 0x3000); err != nil {
+		t.Fatalf("install bus error vector: %v", err)
+	}
+	if err := processor.Step(); err != nil {
+		t.Fatalf("step failed: %v", err)
+	}
+	if got, _ := ram.Read(Long, 0x1000); got != 0x11223344 {
+		t.Fatalf("RAM write = %08x, want 11223344", got)
+	}
+
+	expectBusError(t, bus.Write(Word, 0x0000, 0xffff))
+	if err := processor.Step(); err != nil {
+		t.Fatalf("step failed: %v", err)
+	}
+	if processor.Registers().PC != 0x3000 {
+		t.Fatalf("ROM write did not raise a bus error, PC = %08x", processor.Registers().PC)
+	}
+}
@@ -0,0 +1,217 @@
+package m68kemu
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Common 68000 clock frequencies in Hz.
+const (
+	FrequencyST      uint64 = 8_000_000
+	FrequencySTPAL   uint64 = 8_021_247
+	FrequencyMegaSTE uint64 = 16_000_000
+)
+
+const (
+	// defaultRunnerSlice is how much emulated time a Runner executes between
+	// pacing and control checks.
+	defaultRunnerSlice = time.Millisecond
+	// unthrottledSliceCycles is the slice length when no frequency is set.
+	unthrottledSliceCycles = 100_000
+	// maxRunnerLag is how far a throttled Runner may fall behind before it
+	// stops trying to catch up.
+	maxRunnerLag = 100 * time.Millisecond
+)
+
+// ErrRunnerActive is returned by Runner.Run while another Run is in progress.
+var ErrRunnerActive = errors.New("runner is already running")
+
+// RunnerOptions configures a Runner.
+type RunnerOptions struct {
+	// Frequency is the emulated CPU clock in Hz. Zero runs unthrottled.
+	Frequency uint64
+	// Slice is the emulated time executed between pacing and control checks.
+	// Pause, Step, and cancellation take effect at slice boundaries. It
+	// defaults to one millisecond.
+	Slice time.Duration
+	// Paused starts the runner paused.
+	Paused bool
+}
+
+// RunnerStats reports a Runner's progress.
+type RunnerStats struct {
+	// Cycles is the number of CPU cycles executed by the runner.
+	Cycles uint64
+	// Elapsed is the wall-clock time spent running, excluding pauses.
+	Elapsed time.Duration
+	// Frequency is the effective emulated clock rate in Hz.
+	Frequency float64
+	// Speed is Frequency relative to the configured frequency, or zero when
+	// running unthrottled.
+	Speed float64
+}
+
+// Runner executes a CPU in real time, paced against the wall clock, or as
+// fast as possible. Pause, Resume, Step, and Stats may be called from any
+// goroutine, but not from CPU callbacks or scheduled events, which run while
+// the runner holds the CPU.
+type Runner struct {
+	cpu       CPU
+	frequency uint64
+	slice     uint64
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	paused  bool
+	running bool
+	resync  bool
+
+	statsMu sync.Mutex
+	stats   RunnerStats
+}
+
+// NewRunner creates a runner for cpu.
+func NewRunner(cpu CPU, options RunnerOptions) *Runner {
+	r := &Runner{cpu: cpu, frequency: options.Frequency, paused: options.Paused}
+	r.cond = sync.NewCond(&r.mu)
+	r.slice = unthrottledSliceCycles
+	if r.frequency != 0 {
+		slice := options.Slice
+		if slice <= 0 {
+			slice = defaultRunnerSlice
+		}
+		r.slice = max(mulDiv(r.frequency, uint64(slice), uint64(time.Second), false), 1)
+	}
+	return r
+}
+
+// Run executes the CPU until ctx is cancelled or the CPU returns an error.
+// It returns the context's error on cancellation.
+func (r *Runner) Run(ctx context.Context) error {
+	r.mu.Lock()
+	if r.running {
+		r.mu.Unlock()
+		return ErrRunnerActive
+	}
+	r.running = true
+	r.resync = true
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.running = false
+		r.mu.Unlock()
+	}()
+
+	stop := context.AfterFunc(ctx, func() {
+		r.mu.Lock()
+		r.cond.Broadcast()
+		r.mu.Unlock()
+	})
+	defer stop()
+
+	var wallStart time.Time
+	var cycleStart uint64
+	for {
+		r.mu.Lock()
+		for r.paused && ctx.Err() == nil {
+			r.cond.Wait()
+		}
+		if err := ctx.Err(); err != nil {
+			r.mu.Unlock()
+			return err
+		}
+		if r.resync {
+			wallStart, cycleStart = time.Now(), r.cpu.Cycles()
+			r.resync = false
+		}
+		sliceStart := time.Now()
+		before := r.cpu.Cycles()
+		err := r.cpu.RunCycles(r.slice)
+		emulated := r.cpu.Cycles() - cycleStart
+		r.record(r.cpu.Cycles()-before, 0)
+		r.mu.Unlock()
+
+		if err == nil && r.frequency != 0 {
+			due := wallStart.Add(time.Duration(mulDiv(emulated, uint64(time.Second), r.frequency, false)))
+			if wait := time.Until(due); wait > 0 {
+				err = sleepContext(ctx, wait)
+			} else if -wait > maxRunnerLag {
+				r.mu.Lock()
+				r.resync = true
+				r.mu.Unlock()
+			}
+		}
+		r.record(0, time.Since(sliceStart))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Pause stops execution at the end of the current slice and returns once the
+// CPU is idle.
+func (r *Runner) Pause() {
+	r.mu.Lock()
+	r.paused = true
+	r.mu.Unlock()
+}
+
+// Resume continues execution after Pause or Step.
+func (r *Runner) Resume() {
+	r.mu.Lock()
+	r.paused = false
+	r.resync = true
+	r.cond.Broadcast()
+	r.mu.Unlock()
+}
+
+// Paused reports whether the runner is paused.
+func (r *Runner) Paused() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.paused
+}
+
+// Step pauses the runner and executes a single instruction.
+func (r *Runner) Step() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.paused = true
+	before := r.cpu.Cycles()
+	err := r.cpu.Step()
+	r.record(r.cpu.Cycles()-before, 0)
+	return err
+}
+
+// Stats returns the cycles executed so far and the effective speed.
+func (r *Runner) Stats() RunnerStats {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	return r.stats
+}
+
+func (r *Runner) record(cycles uint64, elapsed time.Duration) {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	r.stats.Cycles += cycles
+	r.stats.Elapsed += elapsed
+	if r.stats.Elapsed > 0 {
+		r.stats.Frequency = float64(r.stats.Cycles) / r.stats.Elapsed.Seconds()
+	}
+	if r.frequency != 0 {
+		r.stats.Speed = r.stats.Frequency / float64(r.frequency)
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
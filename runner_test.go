@@ -0,0 +1,93 @@
+package m68kemu
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newLoopingRunner(t *testing.T, options RunnerOptions) (*Runner, *cpu) {
+	t.Helper()
+	cpu, ram := newEnvironment(t)
+	loadProgram(t, ram, cpu.regs.PC, "loop: ADDQ.L #1,D0\nBRA.S loop\n")
+	return NewRunner(cpu, options), cpu
+}
+
+func TestRunnerUnthrottledStopsOnCancel(t *testing.T) {
+	runner, cpu := newLoopingRunner(t, RunnerOptions{})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := runner.Run(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Run = %v, want context.DeadlineExceeded", err)
+	}
+	stats := runner.Stats()
+	if stats.Cycles == 0 || stats.Cycles != cpu.cycles || stats.Frequency <= 0 || stats.Speed != 0 {
+		t.Fatalf("stats = %+v after %d CPU cycles", stats, cpu.cycles)
+	}
+}
+
+func TestRunnerThrottlesToFrequency(t *testing.T) {
+	const frequency = 200_000
+	runner, cpu := newLoopingRunner(t, RunnerOptions{Frequency: frequency})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := runner.Run(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Run = %v, want context.DeadlineExceeded", err)
+	}
+	want := uint64(time.Since(start).Seconds() * frequency)
+	if cpu.cycles > want+frequency/100 {
+		t.Fatalf("ran %d cycles in %v, want at most about %d", cpu.cycles, time.Since(start), want)
+	}
+	if cpu.cycles < want/4 {
+		t.Fatalf("ran only %d cycles in %v, want about %d", cpu.cycles, time.Since(start), want)
+	}
+	if speed := runner.Stats().Speed; speed <= 0 || speed > 1.5 {
+		t.Fatalf("Speed = %v, want about 1", speed)
+	}
+}
+
+func TestRunnerPauseStepResume(t *testing.T) {
+	runner, cpu := newLoopingRunner(t, RunnerOptions{Paused: true})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- runner.Run(ctx) }()
+
+	time.Sleep(5 * time.Millisecond)
+	if !runner.Paused() || runner.Stats().Cycles != 0 {
+		t.Fatalf("runner started paused but ran %d cycles", runner.Stats().Cycles)
+	}
+	if err := runner.Run(ctx); !errors.Is(err, ErrRunnerActive) {
+		t.Fatalf("second Run = %v, want ErrRunnerActive", err)
+	}
+
+	for range 3 {
+		if err := runner.Step(); err != nil {
+			t.Fatalf("Step failed: %v", err)
+		}
+	}
+	if runner.Stats().Cycles != cpu.cycles || cpu.regs.D[0] != 2 {
+		t.Fatalf("after 3 steps D0 = %d, cycles %d/%d", cpu.regs.D[0], runner.Stats().Cycles, cpu.cycles)
+	}
+
+	stepped := cpu.cycles
+	runner.Resume()
+	time.Sleep(5 * time.Millisecond)
+	runner.Pause()
+	paused := runner.Stats().Cycles
+	if paused <= stepped || paused != cpu.cycles {
+		t.Fatalf("resumed runner executed %d cycles, CPU at %d", paused, cpu.cycles)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if runner.Stats().Cycles != paused {
+		t.Fatalf("paused runner kept running")
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run = %v, want context.Canceled", err)
+	}
+}
@@ -1,5 +1,49 @@
 package m68kemu
 
+import "slices"
+
+// eventSlot stores one event. Free slots form a list headed by
+// CycleScheduler.freeSlot, so recycling them never allocates.
+type eventSlot struct {
+	id       uint64
+	name     string
+	fn       func(now uint64)
+	index    int32 // position in queue, runIndex of the position in run, or -1 when free
+	nextFree int32 // next free slot plus one, or zero at the end of the list
+}
+
+// queueEntry is an element of the event queue. Ordering by time and then by
+// the order in which events were (re)scheduled makes equal-time events fire
+// first in, first out; keeping both keys inline avoids chasing slots while
+// sifting.
+//
+// Events scheduled in time order, the common case, are appended to run, a
+// sorted slice consumed from its head, so a burst of them drains without any
+// sifting. Events that sort before the end of run go to queue, a binary
+// min-heap, and Advance fires whichever of the two heads comes first. A
+// cancelled entry in run stays in place with slot -1 until the run is
+// compacted when it would otherwise grow.
+type queueEntry struct {
+	at    uint64
+	order uint64
+	slot  int32
+}
+
+// runIndex encodes a position in run as an eventSlot index.
+func runIndex(i int) int32 { return int32(-2 - i) }
+
+// runPosition decodes an eventSlot index produced by runIndex.
+func runPosition(index int32) int { return int(-2 - index) }
+
+// EventHandle refers to an event returned by Schedule. A handle becomes
+// inert once its event fired or was cancelled, even if the scheduler reuses
+// the event's storage.
+type EventHandle struct {
+	s    *CycleScheduler
+	slot int32
+	id   uint64
+}
+
 func NewCycleScheduler() *CycleScheduler {
 	return &CycleScheduler{}
 }
@@ -9,8 +53,15 @@ func (s *CycleScheduler) Reset(now uint64) {
 		return
 	}
 	s.now = now
-	s.events = s.events[:0]
-	s.eventHead = 0
+	s.target = now
+	clear(s.slots)
+	s.slots = s.slots[:0]
+	s.freeSlot = 0
+	s.queue = s.queue[:0]
+	s.run = s.run[:0]
+	s.runHead = 0
+	s.runDead = 0
+	s.busRequests = s.busRequests[:0]
 }
 
 func (s *CycleScheduler) Now() uint64 {
@@ -27,26 +78,104 @@ func (s *CycleScheduler) AddListener(listener CycleListener) {
 	s.listeners = append(s.listeners, listener)
 }
 
-func (s *CycleScheduler) Schedule(at uint64, fn func(now uint64)) {
+// hasListeners reports whether any CycleListener is registered.
+func (s *CycleScheduler) hasListeners() bool {
+	return s != nil && len(s.listeners) != 0
+}
+
+// Schedule queues fn to run when the scheduler reaches cycle at. Events in
+// the past fire on the next Advance.
+func (s *CycleScheduler) Schedule(at uint64, fn func(now uint64)) EventHandle {
+	return s.ScheduleNamed("", at, fn)
+}
+
+// ScheduleNamed is Schedule with a name that identifies the event in
+// PendingEvents and EventByName.
+func (s *CycleScheduler) ScheduleNamed(name string, at uint64, fn func(now uint64)) EventHandle {
 	if s == nil || fn == nil {
-		return
+		return EventHandle{}
 	}
 
-	event := ScheduledEvent{At: at, Fn: fn}
-	index := len(s.events)
-	s.events = append(s.events, event)
-	for index > s.eventHead && s.events[index-1].At > at {
-		s.events[index] = s.events[index-1]
-		index--
+	var slot int32
+	if s.freeSlot != 0 {
+		slot = s.freeSlot - 1
+		s.freeSlot = s.slots[slot].nextFree
+	} else {
+		slot = int32(len(s.slots))
+		s.slots = append(s.slots, eventSlot{})
 	}
-	s.events[index] = event
+
+	s.nextID++
+	s.nextOrder++
+	s.slots[slot] = eventSlot{id: s.nextID, name: name, fn: fn}
+	s.insert(queueEntry{at: at, order: s.nextOrder, slot: slot})
+	return EventHandle{s: s, slot: slot, id: s.nextID}
 }
 
-func (s *CycleScheduler) ScheduleAfter(delta uint64, fn func(now uint64)) {
+func (s *CycleScheduler) ScheduleAfter(delta uint64, fn func(now uint64)) EventHandle {
 	if s == nil {
-		return
+		return EventHandle{}
+	}
+	return s.Schedule(s.now+delta, fn)
+}
+
+// NextEvent returns the time of the earliest pending event.
+func (s *CycleScheduler) NextEvent() (uint64, bool) {
+	if s == nil {
+		return 0, false
+	}
+	entry, ok := s.earliest()
+	return entry.at, ok
+}
+
+// PendingEvents lists the queued events in firing order.
+func (s *CycleScheduler) PendingEvents() []ScheduledEvent {
+	if s == nil {
+		return nil
 	}
-	s.Schedule(s.now+delta, fn)
+	order := slices.Clone(s.queue)
+	for _, entry := range s.run[s.runHead:] {
+		if entry.slot >= 0 {
+			order = append(order, entry)
+		}
+	}
+	slices.SortFunc(order, func(a, b queueEntry) int {
+		if a.before(b) {
+			return -1
+		}
+		return 1
+	})
+	events := make([]ScheduledEvent, len(order))
+	for i, entry := range order {
+		event := &s.slots[entry.slot]
+		events[i] = ScheduledEvent{ID: event.id, Name: event.name, At: entry.at, Fn: event.fn}
+	}
+	return events
+}
+
+// EventByName returns the handle of the earliest pending event with name.
+func (s *CycleScheduler) EventByName(name string) (EventHandle, bool) {
+	if s == nil {
+		return EventHandle{}, false
+	}
+	var found queueEntry
+	ok := false
+	// The run is sorted, so its first match is its earliest.
+	for _, entry := range s.run[s.runHead:] {
+		if entry.slot >= 0 && s.slots[entry.slot].name == name {
+			found, ok = entry, true
+			break
+		}
+	}
+	for _, entry := range s.queue {
+		if s.slots[entry.slot].name == name && (!ok || entry.before(found)) {
+			found, ok = entry, true
+		}
+	}
+	if !ok {
+		return EventHandle{}, false
+	}
+	return EventHandle{s: s, slot: found.slot, id: s.slots[found.slot].id}, true
 }
 
 func (s *CycleScheduler) Advance(delta uint64) {
@@ -55,19 +184,33 @@ func (s *CycleScheduler) Advance(delta uint64) {
 	}
 
 	target := s.now + delta
-	for s.eventHead < len(s.events) && s.events[s.eventHead].At <= target {
-		event := s.events[s.eventHead]
-		if event.At > s.now {
-			s.advanceTo(event.At)
+	s.target = target
+	for {
+		entry, ok := s.earliest()
+		if !ok || entry.at > target {
+			break
+		}
+		if entry.at > s.now {
+			s.advanceTo(entry.at)
 		}
-		s.eventHead++
-		event.Fn(s.now)
+		fn := s.slots[entry.slot].fn
+		s.remove(entry.slot)
+		fn(s.now)
 	}
 
 	if s.now < target {
 		s.advanceTo(target)
 	}
-	s.compactEvents()
+}
+
+// Overshoot returns, inside an event callback, how many cycles the current
+// Advance runs past the event's due time. With instruction-boundary delivery
+// this is the distance from the event to the boundary it is delivered at.
+func (s *CycleScheduler) Overshoot() uint64 {
+	if s == nil || s.target <= s.now {
+		return 0
+	}
+	return s.target - s.now
 }
 
 func (s *CycleScheduler) advanceTo(target uint64) {
@@ -82,20 +225,201 @@ func (s *CycleScheduler) advanceTo(target uint64) {
 	}
 }
 
-func (s *CycleScheduler) compactEvents() {
-	if s.eventHead == 0 {
-		return
+// ID returns the event's unique identifier, or zero for an empty handle.
+func (h EventHandle) ID() uint64 {
+	return h.id
+}
+
+// Pending reports whether the event is still queued.
+func (h EventHandle) Pending() bool {
+	return h.s != nil && int(h.slot) < len(h.s.slots) && h.s.slots[h.slot].id == h.id && h.s.slots[h.slot].index != -1
+}
+
+// At returns the cycle the event is due at while it is pending.
+func (h EventHandle) At() (uint64, bool) {
+	if !h.Pending() {
+		return 0, false
+	}
+	return h.s.entry(h.slot).at, true
+}
+
+// Cancel removes the event from the queue. It reports false when the event
+// already fired or was cancelled.
+func (h EventHandle) Cancel() bool {
+	if !h.Pending() {
+		return false
+	}
+	h.s.remove(h.slot)
+	return true
+}
+
+// Reschedule moves a pending event to cycle at, keeping its ID and name. The
+// event fires after any events already queued for the same cycle. It reports
+// false when the event is no longer pending.
+func (h EventHandle) Reschedule(at uint64) bool {
+	if !h.Pending() {
+		return false
+	}
+	s := h.s
+	s.nextOrder++
+	if index := int(s.slots[h.slot].index); index >= 0 {
+		entry := &s.queue[index]
+		entry.at = at
+		entry.order = s.nextOrder
+		s.siftUp(index)
+		s.siftDown(index)
+		return true
 	}
-	if s.eventHead == len(s.events) {
-		s.events = s.events[:0]
-		s.eventHead = 0
+	s.unlink(h.slot)
+	s.insert(queueEntry{at: at, order: s.nextOrder, slot: h.slot})
+	return true
+}
+
+// insert appends entry to the run when it sorts after the run's last entry
+// and pushes it onto the heap otherwise.
+func (s *CycleScheduler) insert(entry queueEntry) {
+	if n := len(s.run); n == s.runHead || !entry.before(s.run[n-1]) {
+		if n == cap(s.run) && 2*(s.runHead+s.runDead) >= n {
+			s.compactRun()
+		}
+		s.slots[entry.slot].index = runIndex(len(s.run))
+		s.run = append(s.run, entry)
 		return
 	}
-	if s.eventHead < len(s.events)/2 {
+	s.queue = append(s.queue, entry)
+	s.siftUp(len(s.queue) - 1)
+}
+
+// earliest returns the next event to fire.
+func (s *CycleScheduler) earliest() (queueEntry, bool) {
+	if s.runHead < len(s.run) {
+		if len(s.queue) != 0 && s.queue[0].before(s.run[s.runHead]) {
+			return s.queue[0], true
+		}
+		return s.run[s.runHead], true
+	}
+	if len(s.queue) != 0 {
+		return s.queue[0], true
+	}
+	return queueEntry{}, false
+}
+
+// entry returns the queued entry of a pending event.
+func (s *CycleScheduler) entry(slot int32) *queueEntry {
+	index := s.slots[slot].index
+	if index >= 0 {
+		return &s.queue[index]
+	}
+	return &s.run[runPosition(index)]
+}
+
+// remove takes a pending event out of the queue and frees its slot.
+func (s *CycleScheduler) remove(slot int32) {
+	s.unlink(slot)
+	s.slots[slot] = eventSlot{index: -1, nextFree: s.freeSlot}
+	s.freeSlot = slot + 1
+}
+
+// unlink takes a pending event out of the heap or the run without freeing
+// its slot.
+func (s *CycleScheduler) unlink(slot int32) {
+	index := s.slots[slot].index
+	if index >= 0 {
+		s.removeHeap(int(index))
 		return
 	}
+	s.run[runPosition(index)].slot = -1
+	s.runDead++
+	s.trimRun()
+}
+
+// trimRun drops fired and cancelled entries from both ends of the run, so its
+// head is always pending.
+func (s *CycleScheduler) trimRun() {
+	for s.runHead < len(s.run) && s.run[s.runHead].slot < 0 {
+		s.runHead++
+		s.runDead--
+	}
+	for len(s.run) > s.runHead && s.run[len(s.run)-1].slot < 0 {
+		s.run = s.run[:len(s.run)-1]
+		s.runDead--
+	}
+	if s.runHead == len(s.run) {
+		s.run = s.run[:0]
+		s.runHead = 0
+	}
+}
+
+// compactRun moves the pending entries of the run to its start.
+func (s *CycleScheduler) compactRun() {
+	live := s.run[:0]
+	for _, entry := range s.run[s.runHead:] {
+		if entry.slot >= 0 {
+			s.slots[entry.slot].index = runIndex(len(live))
+			live = append(live, entry)
+		}
+	}
+	s.run = live
+	s.runHead = 0
+	s.runDead = 0
+}
+
+// removeHeap takes the entry at queue position i out of the heap.
+func (s *CycleScheduler) removeHeap(i int) {
+	last := len(s.queue) - 1
+	if i != last {
+		s.place(i, s.queue[last])
+	}
+	s.queue = s.queue[:last]
+	if i != last {
+		s.siftDown(i)
+		s.siftUp(i)
+	}
+}
+
+func (e queueEntry) before(other queueEntry) bool {
+	if e.at != other.at {
+		return e.at < other.at
+	}
+	return e.order < other.order
+}
+
+func (s *CycleScheduler) place(i int, entry queueEntry) {
+	s.queue[i] = entry
+	s.slots[entry.slot].index = int32(i)
+}
+
+// siftUp and siftDown move the entry at i into place, shifting the entries it
+// passes instead of swapping at every level.
+func (s *CycleScheduler) siftUp(i int) {
+	entry := s.queue[i]
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !entry.before(s.queue[parent]) {
+			break
+		}
+		s.place(i, s.queue[parent])
+		i = parent
+	}
+	s.place(i, entry)
+}
 
-	copy(s.events, s.events[s.eventHead:])
-	s.events = s.events[:len(s.events)-s.eventHead]
-	s.eventHead = 0
+func (s *CycleScheduler) siftDown(i int) {
+	entry := s.queue[i]
+	n := len(s.queue)
+	for {
+		child := 2*i + 1
+		if child >= n {
+			break
+		}
+		if right := child + 1; right < n && s.queue[right].before(s.queue[child]) {
+			child = right
+		}
+		if !s.queue[child].before(entry) {
+			break
+		}
+		s.place(i, s.queue[child])
+		i = child
+	}
+	s.place(i, entry)
 }
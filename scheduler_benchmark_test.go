@@ -70,3 +70,83 @@ func BenchmarkRunEightMillionCycles(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkRunEightMillionCyclesBlockCache(b *testing.B) {
+	const cycleBudget = 8_000_000
+	cpu, ram := newEnvironment(b)
+	cpu.SetExecutionEngine(EngineBlockCache)
+	code := assemble(b, "loop: ADDQ.L #1, D0\nMOVE.L D0, D1\nBRA.S loop")
+	for offset, value := range code {
+		addr := cpu.regs.PC + uint32(offset)
+		if err := ram.Write(Byte, addr, uint32(value)); err != nil {
+			b.Fatalf("failed to seed program byte at %04x: %v", addr, err)
+		}
+	}
+
+	for b.Loop() {
+		if err := cpu.RunCycles(cycleBudget); err != nil {
+			b.Fatalf("RunCycles failed: %v", err)
+		}
+	}
+}
+
+// BenchmarkRunEightMillionCyclesSTLayout runs a memory loop from ROM on a bus
+// laid out like an Atari ST: protected low RAM, ROM, and wait-stated I/O.
+func BenchmarkRunEightMillionCyclesSTLayout(b *testing.B) {
+	runSTLayout(b, nil)
+}
+
+// BenchmarkRunEightMillionCyclesSTTiming adds the ST's 4-cycle bus slots.
+func BenchmarkRunEightMillionCyclesSTTiming(b *testing.B) {
+	runSTLayout(b, STBusTiming{})
+}
+
+func runSTLayout(b *testing.B, policy BusTimingPolicy) {
+	const cycleBudget = 8_000_000
+	ram := NewRAM(0, 512*1024)
+	code := assemble(b, "loop: ADDQ.L #1, D0\nMOVE.L D0, $1000\nMOVE.L $1000, D1\nBRA.S loop")
+	rom := NewROM(0xfc0000, code)
+	io := newResetWaitDevice(0xff8000, 0xffffff, 2)
+	bus := NewBus(SupervisorOnly(0, 0x7ff, ram), io, ram, rom)
+	bus.SetTimingPolicy(policy)
+	if err := ram.Write(Long, 0, 0x8000); err != nil {
+		b.Fatalf("failed to seed SSP vector: %v", err)
+	}
+	if err := ram.Write(Long, 4, 0xfc0000); err != nil {
+		b.Fatalf("failed to seed PC vector: %v", err)
+	}
+	cpu, err := NewCPU(bus)
+	if err != nil {
+		b.Fatalf("failed to create CPU: %v", err)
+	}
+
+	for b.Loop() {
+		if err := cpu.RunCycles(cycleBudget); err != nil {
+			b.Fatalf("RunCycles failed: %v", err)
+		}
+	}
+}
+
+// BenchmarkCycleSchedulerManyPendingEvents keeps 4096 timers pending and
+// reprograms one of them per fired event, as a busy machine does.
+func BenchmarkCycleSchedulerManyPendingEvents(b *testing.B) {
+	const pending = 4096
+
+	scheduler := NewCycleScheduler()
+	handles := make([]EventHandle, pending)
+	var fire func(uint64)
+	next := 0
+	fire = func(now uint64) {
+		handles[next].Reschedule(now + pending + uint64(next%17))
+		next = (next + 1) % pending
+		scheduler.ScheduleAfter(pending, fire)
+	}
+	for i := range handles {
+		handles[i] = scheduler.Schedule(uint64(i+1), fire)
+	}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		scheduler.Advance(1)
+	}
+}
@@ -72,3 +72,196 @@ func TestCycleSchedulerFiresEventsAtScheduledTimeWithinLargeAdvance(t *testing.T
 		t.Fatalf("events fired at %v, want [4 5]", fired)
 	}
 }
+
+func TestCycleSchedulerCancelAndReschedule(t *testing.T) {
+	scheduler := NewCycleScheduler()
+	var fired []string
+	record := func(name string) func(uint64) {
+		return func(now uint64) { fired = append(fired, name) }
+	}
+
+	a := scheduler.ScheduleNamed("a", 10, record("a"))
+	b := scheduler.ScheduleNamed("b", 10, record("b"))
+	c := scheduler.ScheduleNamed("c", 20, record("c"))
+	if a.ID() == b.ID() || !a.Pending() {
+		t.Fatalf("handles a=%d b=%d pending=%v, want distinct live IDs", a.ID(), b.ID(), a.Pending())
+	}
+
+	if !b.Cancel() || b.Cancel() || b.Pending() {
+		t.Fatalf("Cancel did not remove b exactly once")
+	}
+	if !c.Reschedule(10) {
+		t.Fatalf("Reschedule(10) failed for pending c")
+	}
+	if at, ok := c.At(); !ok || at != 10 {
+		t.Fatalf("c.At = (%d, %v), want (10, true)", at, ok)
+	}
+	if !a.Reschedule(5) {
+		t.Fatalf("Reschedule(5) failed for pending a")
+	}
+
+	scheduler.Advance(10)
+	if len(fired) != 2 || fired[0] != "a" || fired[1] != "c" {
+		t.Fatalf("fired %v, want [a c]", fired)
+	}
+	if a.Pending() || a.Cancel() || a.Reschedule(30) {
+		t.Fatalf("handle stayed live after its event fired")
+	}
+
+	// A reused slot must not revive the old handle.
+	d := scheduler.Schedule(40, record("d"))
+	if a.Pending() || !d.Pending() || d.ID() == a.ID() {
+		t.Fatalf("slot reuse confused handles: a=%v d=%v", a.Pending(), d.Pending())
+	}
+}
+
+// A periodic tick and a watchdog it rearms are scheduled in time order and
+// take the run, while an event due before them goes to the heap. Cancelling
+// the watchdog on every tick must not grow the run.
+func TestCycleSchedulerPeriodicEventsWithCancelledTimeouts(t *testing.T) {
+	scheduler := NewCycleScheduler()
+	var ticks, early, last uint64
+	ordered := true
+	var watchdog EventHandle
+	var tick func(uint64)
+	tick = func(now uint64) {
+		if now < last {
+			ordered = false
+		}
+		last = now
+		ticks++
+		watchdog.Cancel()
+		scheduler.ScheduleAfter(10, tick)
+		watchdog = scheduler.ScheduleNamed("watchdog", now+1000, func(uint64) { t.Fatalf("watchdog fired") })
+		scheduler.Schedule(now+5, func(at uint64) {
+			if at != now+5 {
+				ordered = false
+			}
+			early++
+		})
+	}
+	scheduler.Schedule(10, tick)
+
+	scheduler.Advance(100_000)
+	if !ordered || ticks != 10_000 || early != 9_999 {
+		t.Fatalf("ordered=%v ticks=%d early=%d, want ordered, 10000 ticks and 9999 early events", ordered, ticks, early)
+	}
+	if got := len(scheduler.PendingEvents()); got != 3 {
+		t.Fatalf("pending = %d, want tick, watchdog, and one early event", got)
+	}
+	if cap(scheduler.run) > 64 {
+		t.Fatalf("run grew to %d entries for 3 pending events", cap(scheduler.run))
+	}
+	if at, ok := watchdog.At(); !ok || at != 101_000 {
+		t.Fatalf("watchdog.At = (%d, %v), want (101000, true)", at, ok)
+	}
+}
+
+func TestCycleSchedulerCompactsCancelledInOrderEvents(t *testing.T) {
+	scheduler := NewCycleScheduler()
+	var fired []uint64
+	record := func(now uint64) { fired = append(fired, now) }
+	handles := make([]EventHandle, 1000)
+	for i := range handles {
+		handles[i] = scheduler.Schedule(uint64(i+1), record)
+	}
+	for i := range handles {
+		if i%4 != 3 {
+			handles[i].Cancel()
+		}
+	}
+	for i := range 1000 {
+		scheduler.Schedule(uint64(1001+i), record)
+	}
+	// The cancelled entries were dropped when the run had to grow.
+	if len(scheduler.run) != 1250 {
+		t.Fatalf("run holds %d entries, want the 1250 pending ones", len(scheduler.run))
+	}
+	handles[3].Reschedule(3000)
+	if at, ok := handles[7].At(); !ok || at != 8 {
+		t.Fatalf("handles[7].At = (%d, %v), want (8, true)", at, ok)
+	}
+
+	scheduler.Advance(3000)
+	if len(fired) != 1250 || fired[0] != 8 || fired[len(fired)-1] != 3000 {
+		t.Fatalf("fired %d events from %d to %d, want 1250 from 8 to 3000", len(fired), fired[0], fired[len(fired)-1])
+	}
+	for i := 1; i < len(fired); i++ {
+		if fired[i] < fired[i-1] {
+			t.Fatalf("events out of order at %d: %d after %d", i, fired[i], fired[i-1])
+		}
+	}
+}
+
+func TestCycleSchedulerEqualTimesFireInScheduleOrder(t *testing.T) {
+	scheduler := NewCycleScheduler()
+	var fired []int
+	for i := range 50 {
+		scheduler.Schedule(uint64(100-i%3), func(uint64) { fired = append(fired, i) })
+	}
+	scheduler.Advance(100)
+
+	if len(fired) != 50 {
+		t.Fatalf("fired %d events, want 50", len(fired))
+	}
+	for i := 1; i < len(fired); i++ {
+		prev, cur := fired[i-1], fired[i]
+		prevAt, curAt := 100-prev%3, 100-cur%3
+		if prevAt > curAt || (prevAt == curAt && prev > cur) {
+			t.Fatalf("events out of order at %d: %v", i, fired)
+		}
+	}
+}
+
+func TestCycleSchedulerPendingEventsAndLookupByName(t *testing.T) {
+	scheduler := NewCycleScheduler()
+	scheduler.ScheduleNamed("vbl", 160256, func(uint64) {})
+	timer := scheduler.ScheduleNamed("mfp.timerC", 4915, func(uint64) {})
+	scheduler.ScheduleNamed("hbl", 512, func(uint64) {})
+
+	events := scheduler.PendingEvents()
+	if len(events) != 3 || events[0].Name != "hbl" || events[1].Name != "mfp.timerC" || events[2].Name != "vbl" {
+		t.Fatalf("PendingEvents = %+v, want hbl, mfp.timerC, vbl", events)
+	}
+	if events[1].ID != timer.ID() || events[1].At != 4915 {
+		t.Fatalf("timer entry = %+v, want ID %d at 4915", events[1], timer.ID())
+	}
+
+	found, ok := scheduler.EventByName("mfp.timerC")
+	if !ok || found.ID() != timer.ID() {
+		t.Fatalf("EventByName = (%d, %v), want (%d, true)", found.ID(), ok, timer.ID())
+	}
+	found.Cancel()
+	if _, ok := scheduler.EventByName("mfp.timerC"); ok || timer.Pending() {
+		t.Fatalf("cancelled event still reachable")
+	}
+}
+
+func TestCycleSchedulerScalesToThousandsOfEvents(t *testing.T) {
+	scheduler := NewCycleScheduler()
+	handles := make([]EventHandle, 5000)
+	var last uint64
+	ordered := true
+	for i := range handles {
+		at := uint64((i * 7919) % 5000)
+		handles[i] = scheduler.Schedule(at, func(now uint64) {
+			if now < last {
+				ordered = false
+			}
+			last = now
+		})
+	}
+	for i := 0; i < len(handles); i += 2 {
+		handles[i].Cancel()
+	}
+	for i := 1; i < len(handles); i += 4 {
+		handles[i].Reschedule(10000 - uint64(i))
+	}
+	if got := len(scheduler.PendingEvents()); got != 2500 {
+		t.Fatalf("pending = %d, want 2500", got)
+	}
+	scheduler.Advance(20000)
+	if remaining := len(scheduler.PendingEvents()); !ordered || remaining != 0 {
+		t.Fatalf("ordered=%v remaining=%d after draining", ordered, remaining)
+	}
+}
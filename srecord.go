@@ -0,0 +1,244 @@
+package m68kemu
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// SRecordFormat selects the address width used by SaveSRecord.
+type SRecordFormat int
+
+const (
+	// SRecordAuto picks the narrowest format that covers the saved range.
+	SRecordAuto SRecordFormat = iota
+	// SRecordS19 uses 16-bit addresses (S1 data, S9 start).
+	SRecordS19
+	// SRecordS28 uses 24-bit addresses (S2 data, S8 start).
+	SRecordS28
+	// SRecordS37 uses 32-bit addresses (S3 data, S7 start).
+	SRecordS37
+)
+
+const defaultImageRecordSize = 16
+
+// SRecordSaveOptions controls the Motorola S-record output of SaveSRecord.
+type SRecordSaveOptions struct {
+	Format     SRecordFormat
+	Header     string
+	RecordSize int
+	Entry      uint32
+	HasEntry   bool
+}
+
+type sRecord struct {
+	kind    byte
+	address uint32
+	data    []byte
+}
+
+// LoadSRecord reads Motorola S19/S28/S37 records and stores their data on the
+// bus. An S7/S8/S9 termination record with a non-zero address becomes the
+// image entry point and, when options.CPU is set, the CPU's program counter.
+func LoadSRecord(bus AddressBus, r io.Reader, options ImageOptions) (ImageInfo, error) {
+	store := newImageStore(bus, options)
+	scanner := bufio.NewScanner(r)
+	line := 0
+	dataRecords := uint32(0)
+	terminated := false
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if terminated {
+			return ImageInfo{}, fmt.Errorf("s-record line %d: record after termination record", line)
+		}
+
+		record, err := parseSRecord(text)
+		if err != nil {
+			return ImageInfo{}, fmt.Errorf("s-record line %d: %w", line, err)
+		}
+
+		switch record.kind {
+		case '0':
+			store.info.Header = strings.TrimRight(string(record.data), "\x00")
+		case '1', '2', '3':
+			if err := store.store(record.address, record.data); err != nil {
+				return ImageInfo{}, fmt.Errorf("s-record line %d: %w", line, err)
+			}
+			dataRecords++
+		case '5', '6':
+			if record.address != dataRecords {
+				return ImageInfo{}, fmt.Errorf("s-record line %d: record count %d, want %d", line, record.address, dataRecords)
+			}
+		case '7', '8', '9':
+			if record.address != 0 {
+				store.setEntry(record.address)
+			}
+			terminated = true
+		default:
+			return ImageInfo{}, fmt.Errorf("s-record line %d: unsupported record type S%c", line, record.kind)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return ImageInfo{}, err
+	}
+
+	return store.finish(options), nil
+}
+
+// SaveSRecord dumps length bytes starting at start as Motorola S-records,
+// followed by a record count and a termination record. It fails when the range
+// or the entry point does not fit the addresses of an explicit format.
+func SaveSRecord(w io.Writer, bus AddressBus, start, length uint32, options SRecordSaveOptions) error {
+	format := options.Format
+	if format == SRecordAuto {
+		format = sRecordFormatFor(start, length, options)
+	}
+	dataKind, endKind, addressBytes := sRecordKinds(format)
+	if dataKind == 0 {
+		return fmt.Errorf("unknown s-record format %d", options.Format)
+	}
+	maxAddress := uint64(1)<<(8*addressBytes) - 1
+	if length != 0 && uint64(start)+uint64(length)-1 > maxAddress {
+		return fmt.Errorf("s-record range %08x-%08x does not fit %d-bit addresses",
+			start, uint64(start)+uint64(length)-1, 8*addressBytes)
+	}
+	if options.HasEntry && uint64(options.Entry) > maxAddress {
+		return fmt.Errorf("s-record entry %08x does not fit %d-bit addresses", options.Entry, 8*addressBytes)
+	}
+
+	data, err := readImageBytes(bus, start, length)
+	if err != nil {
+		return err
+	}
+
+	recordSize := options.RecordSize
+	if recordSize <= 0 {
+		recordSize = defaultImageRecordSize
+	}
+	if recordSize > 255-addressBytes-1 {
+		return fmt.Errorf("s-record size %d too large", recordSize)
+	}
+
+	out := bufio.NewWriter(w)
+	header := []byte(options.Header)
+	if len(header) > 255-3 {
+		header = header[:255-3]
+	}
+	writeSRecord(out, '0', 2, 0, header)
+
+	count := uint32(0)
+	for offset := 0; offset < len(data); offset += recordSize {
+		end := min(offset+recordSize, len(data))
+		writeSRecord(out, dataKind, addressBytes, start+uint32(offset), data[offset:end])
+		count++
+	}
+	if count <= 0xffff {
+		writeSRecord(out, '5', 2, count, nil)
+	} else {
+		writeSRecord(out, '6', 3, count, nil)
+	}
+
+	entry := uint32(0)
+	if options.HasEntry {
+		entry = options.Entry
+	}
+	writeSRecord(out, endKind, addressBytes, entry, nil)
+	return out.Flush()
+}
+
+func parseSRecord(text string) (sRecord, error) {
+	if len(text) < 4 || (text[0] != 'S' && text[0] != 's') {
+		return sRecord{}, fmt.Errorf("missing S-record prefix")
+	}
+	kind := text[1]
+	raw, err := parseHexBytes(text[2:])
+	if err != nil {
+		return sRecord{}, err
+	}
+	if int(raw[0]) != len(raw)-1 {
+		return sRecord{}, fmt.Errorf("byte count %d does not match record length %d", raw[0], len(raw)-1)
+	}
+
+	var sum byte
+	for _, value := range raw[:len(raw)-1] {
+		sum += value
+	}
+	if ^sum != raw[len(raw)-1] {
+		return sRecord{}, fmt.Errorf("checksum %02x, want %02x", raw[len(raw)-1], ^sum)
+	}
+
+	addressBytes := 0
+	switch kind {
+	case '0', '1', '5', '9':
+		addressBytes = 2
+	case '2', '6', '8':
+		addressBytes = 3
+	case '3', '7':
+		addressBytes = 4
+	default:
+		return sRecord{}, fmt.Errorf("unsupported record type S%c", kind)
+	}
+
+	body := raw[1 : len(raw)-1]
+	if len(body) < addressBytes {
+		return sRecord{}, fmt.Errorf("record too short for S%c address", kind)
+	}
+	var address uint32
+	for _, value := range body[:addressBytes] {
+		address = address<<8 | uint32(value)
+	}
+	return sRecord{kind: kind, address: address, data: body[addressBytes:]}, nil
+}
+
+func writeSRecord(w *bufio.Writer, kind byte, addressBytes int, address uint32, data []byte) {
+	count := byte(addressBytes + len(data) + 1)
+	sum := count
+	fmt.Fprintf(w, "S%c%02X", kind, count)
+	for i := addressBytes - 1; i >= 0; i-- {
+		value := byte(address >> (8 * i))
+		sum += value
+		fmt.Fprintf(w, "%02X", value)
+	}
+	for _, value := range data {
+		sum += value
+		fmt.Fprintf(w, "%02X", value)
+	}
+	fmt.Fprintf(w, "%02X\n", ^sum)
+}
+
+func sRecordFormatFor(start, length uint32, options SRecordSaveOptions) SRecordFormat {
+	highest := start
+	if length != 0 {
+		highest = start + length - 1
+	}
+	if options.HasEntry {
+		highest = max(highest, options.Entry)
+	}
+	switch {
+	case highest <= 0xffff:
+		return SRecordS19
+	case highest <= 0xffffff:
+		return SRecordS28
+	default:
+		return SRecordS37
+	}
+}
+
+func sRecordKinds(format SRecordFormat) (data byte, end byte, addressBytes int) {
+	switch format {
+	case SRecordS19:
+		return '1', '9', 2
+	case SRecordS28:
+		return '2', '8', 3
+	case SRecordS37:
+		return '3', '7', 4
+	default:
+		return 0, 0, 0
+	}
+}
@@ -0,0 +1,102 @@
+package m68kemu
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLoadSRecordStoresDataAndSetsEntry(t *testing.T) {
+	cpu, ram := newEnvironment(t)
+	bus := cpu.bus
+
+	image := strings.Join([]string{
+		"S00600004844521B",
+		"S107200070054240E1",
+		"S20800200470054240DC",
+		"S5030002FA",
+		"S804002000DB",
+	}, "\n")
+
+	info, err := LoadSRecord(bus, strings.NewReader(image), ImageOptions{CPU: cpu})
+	if err != nil {
+		t.Fatalf("LoadSRecord failed: %v", err)
+	}
+
+	if info.Header != "HDR" {
+		t.Fatalf("header = %q, want HDR", info.Header)
+	}
+	if !info.HasEntry || info.Entry != 0x2000 {
+		t.Fatalf("entry = (%08x, %v), want (00002000, true)", info.Entry, info.HasEntry)
+	}
+	if cpu.Registers().PC != 0x2000 {
+		t.Fatalf("PC = %08x, want 00002000", cpu.Registers().PC)
+	}
+	if len(info.Segments) != 1 || info.Segments[0] != (ImageSegment{Start: 0x2000, Length: 8}) {
+		t.Fatalf("segments = %+v, want one 8-byte segment at 2000", info.Segments)
+	}
+	if got, _ := ram.Read(Long, 0x2000); got != 0x70054240 {
+		t.Fatalf("first long = %08x, want 70054240", got)
+	}
+	if got, _ := ram.Read(Long, 0x2004); got != 0x70054240 {
+		t.Fatalf("second long = %08x, want 70054240", got)
+	}
+}
+
+func TestLoadSRecordRejectsBadChecksum(t *testing.T) {
+	ram := NewRAM(0, 0x100)
+	_, err := LoadSRecord(NewBus(ram), strings.NewReader("S1070010700542404F\n"), ImageOptions{})
+	if err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Fatalf("expected checksum error on line 1, got %v", err)
+	}
+}
+
+func TestSaveSRecordRoundTrip(t *testing.T) {
+	source := NewRAM(0xfc0000, 0x40)
+	for i := range uint32(0x40) {
+		if err := source.Write(Byte, 0xfc0000+i, i*3); err != nil {
+			t.Fatalf("seed source: %v", err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := SaveSRecord(&out, NewBus(source), 0xfc0000, 0x40, SRecordSaveOptions{
+		Header:   "TOS",
+		Entry:    0xfc0030,
+		HasEntry: true,
+	}); err != nil {
+		t.Fatalf("SaveSRecord failed: %v", err)
+	}
+	if !strings.HasPrefix(strings.Split(out.String(), "\n")[1], "S2") {
+		t.Fatalf("expected S28 output for 24-bit range, got:\n%s", out.String())
+	}
+
+	target := NewRAM(0xfc0000, 0x40)
+	info, err := LoadSRecord(NewBus(target), &out, ImageOptions{})
+	if err != nil {
+		t.Fatalf("LoadSRecord failed: %v", err)
+	}
+	if info.Header != "TOS" || info.Entry != 0xfc0030 || !info.HasEntry {
+		t.Fatalf("round trip info = %+v", info)
+	}
+	if !bytes.Equal(source.mem, target.mem) {
+		t.Fatalf("round trip data mismatch")
+	}
+}
+
+func TestSaveSRecordRejectsAddressesTooWideForFormat(t *testing.T) {
+	bus := NewBus(NewRAM(0, 0x20000))
+	var out bytes.Buffer
+	if err := SaveSRecord(&out, bus, 0xfff0, 0x20, SRecordSaveOptions{Format: SRecordS19}); err == nil {
+		t.Fatalf("S19 accepted a range ending at $1000F")
+	}
+	if err := SaveSRecord(&out, bus, 0, 0x20, SRecordSaveOptions{Format: SRecordS19, Entry: 0x10000, HasEntry: true}); err == nil {
+		t.Fatalf("S19 accepted entry point $10000")
+	}
+	if out.Len() != 0 {
+		t.Fatalf("rejected saves wrote output:\n%s", out.String())
+	}
+	if err := SaveSRecord(&out, bus, 0xffe0, 0x20, SRecordSaveOptions{Format: SRecordS19, Entry: 0xffff, HasEntry: true}); err != nil {
+		t.Fatalf("S19 rejected a range ending at $FFFF: %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+package st
+
+import (
+	"fmt"
+
+	m68kemu "github.com/jenska/m68kemu"
+)
+
+// Slot is an I/O area where a chip model plugs into the GLUE's address
+// decoder. Accesses to a slot without a device, and to I/O addresses outside
+// every slot, end in a bus error.
+type Slot int
+
+const (
+	// SlotShifter holds the video registers: screen base and counter, sync
+	// mode, palette, and resolution.
+	SlotShifter Slot = iota
+	// SlotDMA holds the floppy and hard disk DMA controller.
+	SlotDMA
+	// SlotSound holds the YM2149 sound chip and its I/O ports.
+	SlotSound
+	// SlotMFP holds the MC68901 MFP, such as a peripheral.MFP.
+	SlotMFP
+	// SlotACIA holds the keyboard and MIDI ACIAs, such as two
+	// peripheral.ACIAs on the even byte lane.
+	SlotACIA
+	slotCount
+)
+
+var slotRanges = [slotCount][2]uint32{
+	SlotShifter: {0xff8200, 0xff82ff},
+	SlotDMA:     {0xff8600, 0xff86ff},
+	SlotSound:   {0xff8800, 0xff88ff},
+	SlotMFP:     {0xfffa00, 0xfffa3f},
+	SlotACIA:    {0xfffc00, 0xfffc07},
+}
+
+var slotNames = [slotCount]string{"shifter", "DMA", "sound", "MFP", "ACIA"}
+
+// AddressRange returns the first and last address of the slot.
+func (s Slot) AddressRange() (uint32, uint32) {
+	return slotRanges[s][0], slotRanges[s][1]
+}
+
+func (s Slot) String() string {
+	if s < 0 || s >= slotCount {
+		return fmt.Sprintf("Slot(%d)", int(s))
+	}
+	return slotNames[s]
+}
+
+// glue is the GLUE's address decoder. It sits last on the bus behind the RAM
+// the MMU maps one to one and the ROMs, which the bus reaches directly, and
+// claims the rest of the address space so it can bus-error unmapped and
+// user-mode accesses and dispatch to remapped RAM, the empty cartridge port,
+// and the I/O chips.
+type glue struct {
+	mmu       *MMU
+	overlay   *m68kemu.BootOverlay
+	rom       *m68kemu.ROM
+	cartridge *m68kemu.ROM
+	io        []m68kemu.Device
+}
+
+// lowMemory is the supervisor-only bottom of RAM with the ROM's reset vectors,
+// decoded by the GLUE ahead of the RAM devices. Resets go to the GLUE itself.
+type lowMemory struct {
+	*glue
+}
+
+// memory adapts the MMU to the device interface for the decoder.
+type memory struct {
+	*MMU
+}
+
+// emptyCartridge answers for a cartridge port without a cartridge.
+type emptyCartridge struct{}
+
+func (g *glue) decode(fc m68kemu.FunctionCode, address uint32) (m68kemu.Device, error) {
+	switch {
+	case address < RAMLimit:
+		if address < supervisorLimit && !fc.Supervisor() {
+			return nil, m68kemu.BusError(address)
+		}
+		if g.overlay.Contains(address) {
+			return g.overlay, nil
+		}
+		return memory{g.mmu}, nil
+	case address >= CartridgeBase && address < CartridgeBase+CartridgeSize:
+		if g.cartridge == nil {
+			return emptyCartridge{}, nil
+		}
+		if g.cartridge.Contains(address) {
+			return g.cartridge, nil
+		}
+		return emptyCartridge{}, nil
+	case g.rom.Contains(address):
+		return g.rom, nil
+	case address >= IOBase:
+		if !fc.Supervisor() {
+			return nil, m68kemu.BusError(address)
+		}
+		if g.mmu.bank.Contains(address) {
+			return g.mmu.bank, nil
+		}
+		for _, dev := range g.io {
+			if dev.Contains(address) {
+				return dev, nil
+			}
+		}
+	}
+	return nil, m68kemu.BusError(address)
+}
+
+func (g *glue) Contains(uint32) bool {
+	return true
+}
+
+func (g *glue) Read(s m68kemu.Size, address uint32) (uint32, error) {
+	return g.ReadFC(m68kemu.FunctionCodeSupervisorData, s, address)
+}
+
+func (g *glue) Write(s m68kemu.Size, address uint32, value uint32) error {
+	return g.WriteFC(m68kemu.FunctionCodeSupervisorData, s, address, value)
+}
+
+// ReadFC implements m68kemu.FunctionCodeDevice.
+func (g *glue) ReadFC(fc m68kemu.FunctionCode, s m68kemu.Size, address uint32) (uint32, error) {
+	dev, err := g.decode(fc, address)
+	if err != nil {
+		return 0, err
+	}
+	if fcDev, ok := dev.(m68kemu.FunctionCodeDevice); ok {
+		return fcDev.ReadFC(fc, s, address)
+	}
+	return dev.Read(s, address)
+}
+
+// WriteFC implements m68kemu.FunctionCodeDevice.
+func (g *glue) WriteFC(fc m68kemu.FunctionCode, s m68kemu.Size, address uint32, value uint32) error {
+	dev, err := g.decode(fc, address)
+	if err != nil {
+		return err
+	}
+	if fcDev, ok := dev.(m68kemu.FunctionCodeDevice); ok {
+		return fcDev.WriteFC(fc, s, address, value)
+	}
+	return dev.Write(s, address, value)
+}
+
+func (g *glue) Peek(s m68kemu.Size, address uint32) (uint32, error) {
+	dev, err := g.decode(m68kemu.FunctionCodeSupervisorData, address)
+	if err != nil {
+		return 0, err
+	}
+	peekable, ok := dev.(m68kemu.PeekDevice)
+	if !ok {
+		return 0, fmt.Errorf("peek unsupported at %08x", address)
+	}
+	return peekable.Peek(s, address)
+}
+
+func (g *glue) Poke(s m68kemu.Size, address uint32, value uint32) error {
+	dev, err := g.decode(m68kemu.FunctionCodeSupervisorData, address)
+	if err != nil {
+		return err
+	}
+	pokeable, ok := dev.(m68kemu.PokeDevice)
+	if !ok {
+		return fmt.Errorf("poke unsupported at %08x", address)
+	}
+	return pokeable.Poke(s, address, value)
+}
+
+// WaitStates implements m68kemu.WaitStateDevice for chips that add their own
+// wait states. The 4-cycle alignment comes from m68kemu.STBusTiming.
+func (g *glue) WaitStates(s m68kemu.Size, address uint32) uint32 {
+	dev, err := g.decode(m68kemu.FunctionCodeSupervisorData, address)
+	if err != nil {
+		return 0
+	}
+	if wsDev, ok := dev.(m68kemu.WaitStateDevice); ok {
+		return wsDev.WaitStates(s, address)
+	}
+	return 0
+}
+
+// ValidPeripheralAddress implements m68kemu.VPADevice for the 6800-family
+// chips in the I/O slots.
+func (g *glue) ValidPeripheralAddress(address uint32) bool {
+	dev, err := g.decode(m68kemu.FunctionCodeSupervisorData, address)
+	if err != nil {
+		return false
+	}
+	vpa, ok := dev.(m68kemu.VPADevice)
+	return ok && vpa.ValidPeripheralAddress(address)
+}
+
+// RegisterName implements m68kemu.RegisterNamer.
+func (g *glue) RegisterName(address uint32) (string, bool) {
+	dev, err := g.decode(m68kemu.FunctionCodeSupervisorData, address)
+	if err != nil {
+		return "", false
+	}
+	namer, ok := dev.(m68kemu.RegisterNamer)
+	if !ok {
+		return "", false
+	}
+	return namer.RegisterName(address)
+}
+
+// Reset resets the I/O chips. The MMU keeps its configuration, so a warm
+// reset finds memory as TOS left it.
+func (g *glue) Reset() {
+	for _, dev := range g.io {
+		dev.Reset()
+	}
+}
+
+func (m memory) Read(s m68kemu.Size, address uint32) (uint32, error) {
+	return m.read(s, address), nil
+}
+
+func (m memory) Write(s m68kemu.Size, address uint32, value uint32) error {
+	m.write(s, address, value)
+	return nil
+}
+
+func (m memory) Peek(s m68kemu.Size, address uint32) (uint32, error) {
+	return m.read(s, address), nil
+}
+
+func (m memory) Poke(s m68kemu.Size, address uint32, value uint32) error {
+	m.write(s, address, value)
+	return nil
+}
+
+func (m memory) Contains(address uint32) bool {
+	return address < RAMLimit
+}
+
+func (memory) Reset() {}
+
+func (lowMemory) Contains(address uint32) bool {
+	return address < supervisorLimit
+}
+
+func (lowMemory) AddressRange() (uint32, uint32) {
+	return 0, supervisorLimit - 1
+}
+
+func (lowMemory) Reset() {}
+
+func (emptyCartridge) Contains(address uint32) bool {
+	return address >= CartridgeBase && address < CartridgeBase+CartridgeSize
+}
+
+// Read returns all ones: nothing drives the data bus.
+func (emptyCartridge) Read(s m68kemu.Size, _ uint32) (uint32, error) {
+	return 1<<(8*uint32(s)) - 1, nil
+}
+
+func (c emptyCartridge) Peek(s m68kemu.Size, address uint32) (uint32, error) {
+	return c.Read(s, address)
+}
+
+// Write bus-errors like writes to a cartridge ROM.
+func (emptyCartridge) Write(_ m68kemu.Size, address uint32, _ uint32) error {
+	return m68kemu.BusError(address)
+}
+
+func (emptyCartridge) Reset() {}
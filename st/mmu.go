@@ -0,0 +1,169 @@
+package st
+
+import (
+	"fmt"
+
+	m68kemu "github.com/jenska/m68kemu"
+)
+
+// MemoryConfigAddress is the MMU's memory configuration register. Bits 3-2
+// give the size of bank 0 and bits 1-0 the size of bank 1: 0 for 128 KiB, 1
+// for 512 KiB, and 2 for 2 MiB.
+const MemoryConfigAddress = 0xff8001
+
+// Bank sizes the MMU can address.
+const (
+	Bank128K = 128 << 10
+	Bank512K = 512 << 10
+	Bank2M   = 2 << 20
+)
+
+// bankSizes and bankBits give the size and the row and column address width,
+// in words, of each configuration code. This model treats the reserved code 3
+// like 2 MiB.
+var (
+	bankSizes = [4]uint32{Bank128K, Bank512K, Bank2M, Bank2M}
+	bankBits  = [4]uint32{8, 9, 10, 10}
+)
+
+// MMU models the ST's memory controller: two banks of dynamic RAM, each
+// populated with 128 KiB, 512 KiB, or 2 MiB, and the configuration register
+// that tells the MMU how large it believes the banks are.
+//
+// The MMU splits the word address into a row and a column of the width the
+// configuration selects, and the chips ignore the address bits they do not
+// have. When the configuration does not match the installed chips, memory
+// mirrors the way TOS's size detection expects. Addresses beyond the
+// configured banks, and banks without chips, read as zero and ignore writes.
+type MMU struct {
+	bank  *m68kemu.RegisterBank
+	ram   []byte
+	banks [2]uint32
+	// views expose each bank as plain RAM where the MMU maps it one to one.
+	views [2]*m68kemu.RAM
+	// remap runs when the configuration changes, before it takes effect.
+	remap func(config uint8)
+}
+
+func newMMU(bank0, bank1 uint32) (*MMU, error) {
+	for _, size := range []uint32{bank0, bank1} {
+		if size != 0 && size != Bank128K && size != Bank512K && size != Bank2M {
+			return nil, fmt.Errorf("st: unsupported RAM bank size %#x", size)
+		}
+	}
+	m := &MMU{ram: make([]byte, bank0+bank1), banks: [2]uint32{bank0, bank1}}
+	m.views[0] = m68kemu.NewRAMFrom(0, m.ram[:bank0])
+	m.views[1] = m68kemu.NewRAMFrom(bank0, m.ram[bank0:])
+	m.bank = m68kemu.NewRegisterBank("MMU", MemoryConfigAddress-1, 2, m68kemu.RegisterLayoutOdd).
+		Add(m68kemu.Register{Name: "MEMCONF", Offset: 0, Width: m68kemu.Byte, WriteMask: 0x0f,
+			OnWrite: func(old, value uint32) uint32 {
+				if value != old && m.remap != nil {
+					m.remap(uint8(value))
+				}
+				return value
+			}})
+	m.bank.SetValue(0, bankCode(bank0)<<2|bankCode(bank1))
+	return m, nil
+}
+
+// bankCode returns the configuration code matching an installed bank.
+func bankCode(size uint32) uint32 {
+	switch size {
+	case Bank512K:
+		return 1
+	case Bank2M:
+		return 2
+	}
+	return 0
+}
+
+// Banks returns the installed size of both banks.
+func (m *MMU) Banks() (bank0, bank1 uint32) {
+	return m.banks[0], m.banks[1]
+}
+
+// Config returns the memory configuration register.
+func (m *MMU) Config() uint8 {
+	return uint8(m.bank.Value(0))
+}
+
+// SetConfig writes the memory configuration register, as TOS does early in
+// its boot.
+func (m *MMU) SetConfig(config uint8) {
+	if m.remap != nil {
+		m.remap(config & 0x0f)
+	}
+	m.bank.SetValue(0, uint32(config&0x0f))
+}
+
+// linear returns the banks a configuration maps one to one onto CPU
+// addresses, from bank 0 up. Bank 1 only qualifies behind a matching bank 0,
+// since it starts where the MMU believes bank 0 ends.
+func (m *MMU) linear(config uint8) []*m68kemu.RAM {
+	if config>>2&3 != uint8(bankCode(m.banks[0])) {
+		return nil
+	}
+	if m.banks[1] == 0 || config&3 != uint8(bankCode(m.banks[1])) {
+		return m.views[:1]
+	}
+	return m.views[:]
+}
+
+// RAM returns the physical memory, bank 0 followed by bank 1. The slice
+// aliases the device memory.
+func (m *MMU) RAM() []byte {
+	return m.ram
+}
+
+// Translate returns the index into RAM of the byte at a CPU address below
+// RAMLimit, or false when no memory answers there.
+func (m *MMU) Translate(address uint32) (int, bool) {
+	config := m.Config()
+	code0, code1 := config>>2&3, config&3
+	var code uint8
+	var bank, offset uint32
+	switch {
+	case address < bankSizes[code0]:
+		code, bank, offset = code0, 0, address
+	case address < bankSizes[code0]+bankSizes[code1]:
+		code, bank, offset = code1, 1, address-bankSizes[code0]
+	default:
+		return 0, false
+	}
+	installed := m.banks[bank]
+	if installed == 0 {
+		return 0, false
+	}
+
+	mmuBits := bankBits[code]
+	chipBits := bankBits[bankCode(installed)]
+	word := offset >> 1
+	column := word & (1<<mmuBits - 1) & (1<<chipBits - 1)
+	row := word >> mmuBits & (1<<chipBits - 1)
+	physical := (row<<chipBits|column)<<1 | offset&1
+	return int(bank*m.banks[0] + physical), true
+}
+
+func (m *MMU) read(s m68kemu.Size, address uint32) uint32 {
+	index, ok := m.Translate(address)
+	if !ok {
+		return 0
+	}
+	if s == m68kemu.Byte {
+		return uint32(m.ram[index])
+	}
+	return uint32(m.ram[index])<<8 | uint32(m.ram[index+1])
+}
+
+func (m *MMU) write(s m68kemu.Size, address uint32, value uint32) {
+	index, ok := m.Translate(address)
+	if !ok {
+		return
+	}
+	if s == m68kemu.Byte {
+		m.ram[index] = uint8(value)
+		return
+	}
+	m.ram[index] = uint8(value >> 8)
+	m.ram[index+1] = uint8(value)
+}
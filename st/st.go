@@ -0,0 +1,219 @@
+// Package st is the skeleton of an Atari ST: the address decoding of its GLUE
+// and MMU chips around a 68000, with the RAM banks and their memory
+// configuration register, the TOS ROM and its reset vector overlay, the
+// cartridge port, and I/O slots where chip models plug in.
+//
+// The memory map is:
+//
+//	$000000-$3FFFFF  RAM in two banks; the first 8 bytes read the ROM's
+//	                 reset vectors and $000000-$0007FF is supervisor-only
+//	$E00000-$EFFFFF  TOS 2.x ROM (TOS2Base)
+//	$FA0000-$FBFFFF  cartridge ROM
+//	$FC0000-$FEFFFF  TOS 1.x ROM (TOS1Base)
+//	$FF8000-$FFFFFF  supervisor-only I/O: the memory configuration register
+//	                 at $FF8001 and the Slots
+//
+// Everything else, user-mode accesses to the protected areas, and I/O
+// addresses without a device bus-error. The bus runs with
+// m68kemu.STBusTiming, so every access waits for the next 4-cycle bus slot.
+//
+// The package models no chips beyond the MMU. Shifter, DMA, sound, MFP, and
+// ACIA models, such as those in the peripheral package, plug in with Attach.
+package st
+
+import (
+	"fmt"
+
+	m68kemu "github.com/jenska/m68kemu"
+)
+
+// Memory map.
+const (
+	RAMLimit      = 0x400000
+	CartridgeBase = 0xfa0000
+	CartridgeSize = 0x20000
+	TOS1Base      = 0xfc0000
+	TOS2Base      = 0xe00000
+	IOBase        = 0xff8000
+
+	tos1Size        = 0x30000
+	tos2Size        = 0x100000
+	bootOverlaySize = 8
+	supervisorLimit = 0x800
+)
+
+// Interrupt levels of the ST. The GLUE autovectors the horizontal and
+// vertical blank interrupts; the MFP supplies its own vectors.
+const (
+	HBLLevel = 2
+	VBLLevel = 4
+	MFPLevel = 6
+)
+
+// DefaultRAMSize is the memory of a 1040ST.
+const DefaultRAMSize = 1 << 20
+
+// ramBanks lists the RAM sizes of ST models and how they fill the banks.
+var ramBanks = map[uint32][2]uint32{
+	256 << 10:  {Bank128K, Bank128K},
+	512 << 10:  {Bank512K, 0},
+	1 << 20:    {Bank512K, Bank512K},
+	2 << 20:    {Bank2M, 0},
+	2560 << 10: {Bank2M, Bank512K},
+	4 << 20:    {Bank2M, Bank2M},
+}
+
+// Config configures an ST.
+type Config struct {
+	// ROM is the TOS image.
+	ROM []byte
+	// ROMBase defaults to TOS1Base for images of up to 192 KiB and to
+	// TOS2Base for larger ones.
+	ROMBase uint32
+	// Cartridge is an optional cartridge ROM of up to 128 KiB.
+	Cartridge []byte
+	// RAMSize is 256 KiB, 512 KiB, 1 MiB, 2 MiB, 2.5 MiB, or 4 MiB, filling the
+	// banks as the ST models of that size do. It defaults to DefaultRAMSize.
+	RAMSize uint32
+}
+
+// Machine is an ST without its chips.
+type Machine struct {
+	cpu       m68kemu.CPU
+	bus       *m68kemu.Bus
+	scheduler *m68kemu.CycleScheduler
+	glue      *glue
+}
+
+// New builds an ST and resets the CPU, which fetches its reset vectors from
+// the ROM. The memory configuration register starts out matching the
+// installed RAM.
+func New(config Config) (*Machine, error) {
+	if config.RAMSize == 0 {
+		config.RAMSize = DefaultRAMSize
+	}
+	banks, ok := ramBanks[config.RAMSize]
+	if !ok {
+		return nil, fmt.Errorf("st: unsupported RAM size %#x", config.RAMSize)
+	}
+	if config.ROMBase == 0 {
+		config.ROMBase = TOS1Base
+		if len(config.ROM) > tos1Size {
+			config.ROMBase = TOS2Base
+		}
+	}
+	var romSize int
+	switch config.ROMBase {
+	case TOS1Base:
+		romSize = tos1Size
+	case TOS2Base:
+		romSize = tos2Size
+	default:
+		return nil, fmt.Errorf("st: ROM base %06x is neither %06x nor %06x", config.ROMBase, TOS1Base, TOS2Base)
+	}
+	if len(config.ROM) < bootOverlaySize || len(config.ROM) > romSize {
+		return nil, fmt.Errorf("st: ROM size %d is outside %d-%d bytes", len(config.ROM), bootOverlaySize, romSize)
+	}
+	if len(config.Cartridge) > CartridgeSize {
+		return nil, fmt.Errorf("st: cartridge size %d exceeds %d bytes", len(config.Cartridge), CartridgeSize)
+	}
+
+	mmu, err := newMMU(banks[0], banks[1])
+	if err != nil {
+		return nil, err
+	}
+	rom := m68kemu.NewROM(config.ROMBase, config.ROM)
+	g := &glue{
+		mmu:     mmu,
+		overlay: m68kemu.NewBootOverlay(rom, bootOverlaySize),
+		rom:     rom,
+	}
+	if len(config.Cartridge) != 0 {
+		g.cartridge = m68kemu.NewROM(CartridgeBase, config.Cartridge)
+	}
+
+	m := &Machine{bus: m68kemu.NewBus(), glue: g}
+	m.mapMemory(mmu.Config())
+	mmu.remap = m.mapMemory
+	m.bus.SetTimingPolicy(m68kemu.STBusTiming{})
+	// The HBL and VBL acknowledges run as synchronous cycles.
+	m.bus.SetVPAAutovectors(true)
+	if m.cpu, err = m68kemu.NewCPU(m.bus); err != nil {
+		return nil, fmt.Errorf("st: %w", err)
+	}
+	m.scheduler = m68kemu.NewCycleScheduler()
+	m.cpu.SetScheduler(m.scheduler)
+	if err := m.Reset(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// mapMemory puts the RAM banks the configuration maps one to one and the ROMs
+// on the bus ahead of the GLUE, so the CPU reaches them through the bus's
+// direct page fast path, and leaves everything else to the GLUE.
+func (m *Machine) mapMemory(config uint8) {
+	g := m.glue
+	devices := []m68kemu.Device{lowMemory{g}}
+	for _, bank := range g.mmu.linear(config) {
+		devices = append(devices, bank)
+	}
+	devices = append(devices, g.rom)
+	if g.cartridge != nil {
+		devices = append(devices, g.cartridge)
+	}
+	m.bus.SetDevices(append(devices, g)...)
+	if m.cpu != nil {
+		m.cpu.InvalidateCode(0, RAMLimit)
+	}
+}
+
+// Attach plugs a chip model into an I/O slot. The device must lie within the
+// slot if it reports its AddressRange; a slot can hold several devices, and
+// the first one attached wins where they overlap.
+func (m *Machine) Attach(slot Slot, device m68kemu.Device) error {
+	if slot < 0 || slot >= slotCount {
+		return fmt.Errorf("st: unknown slot %d", int(slot))
+	}
+	if ranged, ok := device.(m68kemu.AddressRangeDevice); ok {
+		start, end := ranged.AddressRange()
+		first, last := slot.AddressRange()
+		if start < first || end > last {
+			return fmt.Errorf("st: device at %06x-%06x is outside the %s slot %06x-%06x", start, end, slot, first, last)
+		}
+	}
+	m.glue.io = append(m.glue.io, device)
+	return nil
+}
+
+// CPU returns the machine's processor.
+func (m *Machine) CPU() m68kemu.CPU { return m.cpu }
+
+// Bus returns the system bus. The machine rebuilds the bus's device list
+// when the memory configuration changes, and the GLUE claims every address
+// RAM and ROM do not, so chips plug in with Attach rather than
+// Bus().AddDevice.
+func (m *Machine) Bus() *m68kemu.Bus { return m.bus }
+
+// Scheduler returns the cycle scheduler that clocks the chips.
+func (m *Machine) Scheduler() *m68kemu.CycleScheduler { return m.scheduler }
+
+// MMU returns the memory controller with the RAM.
+func (m *Machine) MMU() *MMU { return m.glue.mmu }
+
+// ROM returns the TOS ROM.
+func (m *Machine) ROM() *m68kemu.ROM { return m.glue.rom }
+
+// Cartridge returns the cartridge ROM, or nil without a cartridge.
+func (m *Machine) Cartridge() *m68kemu.ROM { return m.glue.cartridge }
+
+// Reset resets the CPU, which fetches the reset vectors again and rewinds
+// the scheduler, and then the attached chips. RAM and the memory
+// configuration survive, as on a warm reset.
+func (m *Machine) Reset() error {
+	if err := m.cpu.Reset(); err != nil {
+		return fmt.Errorf("st: reset: %w", err)
+	}
+	m.bus.Reset()
+	return nil
+}
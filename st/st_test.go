@@ -0,0 +1,248 @@
+package st
+
+import (
+	"errors"
+	"testing"
+
+	m68kemu "github.com/jenska/m68kemu"
+	"github.com/jenska/m68kemu/peripheral"
+
+	asm "github.com/jenska/m68kasm"
+)
+
+func assemble(tb testing.TB, source string) []byte {
+	tb.Helper()
+	code, _, err := asm.AssembleStringWithListing(source)
+	if err != nil {
+		tb.Fatalf("Assembler failed: %v", err)
+	}
+	return code
+}
+
+// testROM starts at TOS1Base+8 with the stack at $8000.
+func testROM(tb testing.TB, program string) []byte {
+	tb.Helper()
+	return assemble(tb, "DC.L $8000\nDC.L $FC0008\n"+program)
+}
+
+func newMachine(tb testing.TB, config Config) *Machine {
+	tb.Helper()
+	if config.ROM == nil {
+		config.ROM = testROM(tb, "loop: BRA.S loop")
+	}
+	m, err := New(config)
+	if err != nil {
+		tb.Fatalf("New failed: %v", err)
+	}
+	return m
+}
+
+func expectBusError(tb testing.TB, err error, access string) {
+	tb.Helper()
+	var busError m68kemu.BusError
+	if !errors.As(err, &busError) {
+		tb.Fatalf("%s: got %v, want a bus error", access, err)
+	}
+}
+
+func TestMachineBootsFromROMWithBusAlignment(t *testing.T) {
+	m := newMachine(t, Config{ROM: testROM(t, `
+        NOP
+        EXG     D0,D1
+        EXG     D0,D1
+        NOP
+`)})
+	cpu := m.CPU()
+	if regs := cpu.Registers(); regs.PC != TOS1Base+8 || regs.SSP != 0x8000 {
+		t.Fatalf("reset PC %06x SSP %06x, want the ROM's vectors", regs.PC, regs.SSP)
+	}
+
+	start := cpu.Cycles()
+	for range 4 {
+		if err := cpu.Step(); err != nil {
+			t.Fatalf("Step failed: %v", err)
+		}
+	}
+	// Each EXG takes 6 cycles, and the next fetch waits for the 4-cycle slot.
+	if got, want := cpu.Cycles()-start, 4+8+8+4+(-start&3); got != want {
+		t.Fatalf("NOP, 2x EXG, NOP took %d cycles, want %d", got, want)
+	}
+}
+
+func TestMMUMemoryConfiguration(t *testing.T) {
+	m := newMachine(t, Config{})
+	mmu, bus := m.MMU(), m.Bus()
+	if bank0, bank1 := mmu.Banks(); bank0 != Bank512K || bank1 != Bank512K || mmu.Config() != 0x05 {
+		t.Fatalf("1 MiB ST has banks %#x/%#x, config %02x, want 512K/512K and $05", bank0, bank1, mmu.Config())
+	}
+
+	write := func(address, value uint32) {
+		t.Helper()
+		if err := bus.Write(m68kemu.Word, address, value); err != nil {
+			t.Fatalf("write %06x: %v", address, err)
+		}
+	}
+	read := func(address uint32) uint32 {
+		t.Helper()
+		value, err := bus.Read(m68kemu.Word, address)
+		if err != nil {
+			t.Fatalf("read %06x: %v", address, err)
+		}
+		return value
+	}
+
+	write(0x1008, 0x1234)
+	write(0x1408, 0x5678)
+	write(0x80008, 0x9abc)
+	if read(0x1008) != 0x1234 || read(0x1408) != 0x5678 {
+		t.Fatalf("matching configuration mirrors memory: %04x %04x", read(0x1008), read(0x1408))
+	}
+	if index, ok := mmu.Translate(0x80008); !ok || index != Bank512K+8 {
+		t.Fatalf("bank 1 starts at %d, %v, want %d", index, ok, Bank512K+8)
+	}
+	write(0x100000, 0xffff)
+	if read(0x100000) != 0 {
+		t.Fatalf("memory beyond the banks reads %04x, want 0", read(0x100000))
+	}
+
+	// TOS probes with both banks set to 2 MiB; 512 KiB chips ignore the top
+	// row and column bit, so memory mirrors every 1 KiB.
+	if err := bus.Write(m68kemu.Byte, MemoryConfigAddress, 0x0a); err != nil {
+		t.Fatalf("write MEMCONF: %v", err)
+	}
+	if mmu.Config() != 0x0a {
+		t.Fatalf("MEMCONF = %02x, want $0A", mmu.Config())
+	}
+	write(0x8, 0xcafe)
+	if read(0x408) != 0xcafe || read(0x100008) != 0xcafe {
+		t.Fatalf("2 MiB configuration over 512 KiB chips reads %04x and %04x, want mirrors", read(0x408), read(0x100008))
+	}
+	if read(0x200008) != 0x9abc {
+		t.Fatalf("bank 1 at $200000 reads %04x, want $9ABC", read(0x200008))
+	}
+	if value, err := bus.Read(m68kemu.Word, IOBase); err != nil || value != 0xff0a {
+		t.Fatalf("word read of MEMCONF = %04x, %v, want $FF0A", value, err)
+	}
+
+	// The configuration survives a reset.
+	if err := m.Reset(); err != nil || mmu.Config() != 0x0a {
+		t.Fatalf("after reset MEMCONF = %02x, %v, want $0A", mmu.Config(), err)
+	}
+	// Back at the matching configuration the banks are plain RAM on the bus
+	// again and still hold what went through the GLUE.
+	mmu.SetConfig(0x05)
+	if read(0x8) != 0xcafe || read(0x1008) != 0x1234 || read(0x80008) != 0x9abc {
+		t.Fatalf("after remapping memory reads %04x %04x %04x", read(0x8), read(0x1008), read(0x80008))
+	}
+	if _, err := bus.ReadFC(m68kemu.FunctionCodeUserData, m68kemu.Word, 0x400); err == nil {
+		t.Fatalf("user-mode read of $400 succeeded with RAM on the bus")
+	}
+}
+
+func TestGLUEDecodingAndBusErrors(t *testing.T) {
+	rom := testROM(t, "loop: BRA.S loop")
+	m := newMachine(t, Config{ROM: rom, RAMSize: 512 << 10})
+	bus := m.Bus()
+
+	if value, err := bus.Read(m68kemu.Long, 4); err != nil || value != TOS1Base+8 {
+		t.Fatalf("reset PC at 4 = %08x, %v, want the ROM's", value, err)
+	}
+	expectBusError(t, bus.Write(m68kemu.Word, 0, 0), "write to the ROM overlay")
+	expectBusError(t, bus.Write(m68kemu.Word, TOS1Base, 0), "write to ROM")
+	if _, err := bus.ReadFC(m68kemu.FunctionCodeUserData, m68kemu.Word, 0x400); err == nil {
+		t.Fatalf("user-mode read of $400 succeeded")
+	}
+	if _, err := bus.ReadFC(m68kemu.FunctionCodeUserData, m68kemu.Word, supervisorLimit); err != nil {
+		t.Fatalf("user-mode read of $800: %v", err)
+	}
+	_, err := bus.ReadFC(m68kemu.FunctionCodeUserData, m68kemu.Byte, MemoryConfigAddress)
+	expectBusError(t, err, "user-mode I/O read")
+	_, err = bus.Read(m68kemu.Word, 0xff8a00)
+	expectBusError(t, err, "unmapped I/O")
+	mfpBase, _ := SlotMFP.AddressRange()
+	_, err = bus.Read(m68kemu.Word, mfpBase)
+	expectBusError(t, err, "empty MFP slot")
+	_, err = bus.Read(m68kemu.Word, RAMLimit)
+	expectBusError(t, err, "read above RAM")
+	_, err = bus.Read(m68kemu.Word, TOS2Base)
+	expectBusError(t, err, "TOS 2 area with a TOS 1 ROM")
+
+	if value, err := bus.Read(m68kemu.Long, CartridgeBase); err != nil || value != 0xffffffff {
+		t.Fatalf("empty cartridge port reads %08x, %v, want all ones", value, err)
+	}
+	expectBusError(t, bus.Write(m68kemu.Word, CartridgeBase, 0), "write to the cartridge port")
+
+	cartridge := []byte{0xab, 0xcd, 0xef, 0x42}
+	big := make([]byte, 256<<10)
+	copy(big, rom)
+	m = newMachine(t, Config{ROM: big, Cartridge: cartridge})
+	if m.ROM().Bytes()[0] != 0 || m.CPU().Registers().SSP != 0x8000 {
+		t.Fatalf("256 KiB ROM did not boot")
+	}
+	if start, _ := m.ROM().AddressRange(); start != TOS2Base {
+		t.Fatalf("256 KiB ROM at %06x, want %06x", start, TOS2Base)
+	}
+	if value, err := m.Bus().Read(m68kemu.Long, CartridgeBase); err != nil || value != 0xabcdef42 {
+		t.Fatalf("cartridge magic = %08x, %v, want $ABCDEF42", value, err)
+	}
+
+	for _, config := range []Config{
+		{ROM: rom, RAMSize: 768 << 10},
+		{ROM: rom[:4]},
+		{ROM: big, ROMBase: TOS1Base},
+		{ROM: rom, ROMBase: 0xf00000},
+		{ROM: rom, Cartridge: make([]byte, CartridgeSize+1)},
+	} {
+		if _, err := New(config); err == nil {
+			t.Fatalf("New accepted RAM %#x, ROM %d bytes at %06x, cartridge %d bytes", config.RAMSize, len(config.ROM), config.ROMBase, len(config.Cartridge))
+		}
+	}
+}
+
+func TestAttachChips(t *testing.T) {
+	m := newMachine(t, Config{})
+	mfp, err := peripheral.NewMFP(m.CPU(), peripheral.MFPOptions{})
+	if err != nil {
+		t.Fatalf("NewMFP failed: %v", err)
+	}
+	keyboard, err := peripheral.NewACIA(m.CPU(), peripheral.ACIAOptions{
+		Base:   peripheral.ACIAKeyboardBase,
+		Layout: m68kemu.RegisterLayoutEven,
+	})
+	if err != nil {
+		t.Fatalf("NewACIA failed: %v", err)
+	}
+	if err := m.Attach(SlotMFP, keyboard); err == nil {
+		t.Fatalf("Attach put the ACIA into the MFP slot")
+	}
+	if err := m.Attach(Slot(99), mfp); err == nil {
+		t.Fatalf("Attach accepted an unknown slot")
+	}
+	if err := m.Attach(SlotMFP, mfp); err != nil {
+		t.Fatalf("Attach MFP: %v", err)
+	}
+	if err := m.Attach(SlotACIA, keyboard); err != nil {
+		t.Fatalf("Attach ACIA: %v", err)
+	}
+
+	bus := m.Bus()
+	if name, ok := bus.RegisterName(0xfffa01); !ok || name != "MFP.GPIP" {
+		t.Fatalf("register at $FFFA01 is %q, %v, want MFP.GPIP", name, ok)
+	}
+	if err := bus.Write(m68kemu.Byte, 0xfffa17, 0x40); err != nil {
+		t.Fatalf("write MFP VR: %v", err)
+	}
+	if value, err := bus.Read(m68kemu.Byte, 0xfffa17); err != nil || value != 0x40 {
+		t.Fatalf("MFP VR = %02x, %v, want $40", value, err)
+	}
+
+	// The ACIA is a VPA device, so its accesses pay the E clock penalty on
+	// top of the 4-cycle alignment.
+	before := m.CPU().Cycles()
+	if value, err := bus.Read(m68kemu.Byte, peripheral.ACIAKeyboardBase); err != nil || value&0x02 == 0 {
+		t.Fatalf("ACIA status = %02x, %v, want TDRE", value, err)
+	}
+	if cycles := m.CPU().Cycles() - before; cycles < 6 {
+		t.Fatalf("ACIA access cost %d cycles, want the E clock penalty", cycles)
+	}
+}
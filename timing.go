@@ -0,0 +1,62 @@
+package m68kemu
+
+// BusCycle describes one CPU bus cycle to a BusTimingPolicy. Long accesses
+// run as two word cycles, each described separately.
+type BusCycle struct {
+	// Cycle is the CPU cycle the access is about to start on. The core
+	// places an instruction's bus cycles back to back from its opcode fetch,
+	// each starting where the previous one and its delays ended, and its
+	// internal work after the last of them, so an opcode fetch starts where
+	// the previous instruction ended.
+	Cycle        uint64
+	Address      uint32
+	Size         Size
+	Write        bool
+	FunctionCode FunctionCode
+}
+
+// Phase returns the position of the cycle within a period of the given
+// length, such as a bus slot or the E clock.
+func (c BusCycle) Phase(period uint64) uint64 {
+	return c.Cycle % period
+}
+
+// BusTimingPolicy models machine-specific bus timing. The bus consults it
+// before every CPU bus cycle, ahead of the wait states of SetWaitStates and
+// WaitStateDevices and the E clock synchronisation of VPA devices, and
+// charges the returned delay to the CPU.
+type BusTimingPolicy interface {
+	BusCycleDelay(cycle BusCycle) uint32
+}
+
+// BusTimingFunc adapts a function to BusTimingPolicy.
+type BusTimingFunc func(cycle BusCycle) uint32
+
+// BusCycleDelay calls f.
+func (f BusTimingFunc) BusCycleDelay(cycle BusCycle) uint32 {
+	return f(cycle)
+}
+
+// STBusTiming is the bus timing of the Atari ST. The MMU interleaves CPU and
+// shifter accesses to RAM, and the GLUE acknowledges ROM and I/O accesses on
+// the same grid, so every CPU bus cycle starts on a 4-cycle boundary. An
+// access that would start in between waits for the next one, which rounds
+// instructions up to a multiple of 4 cycles: EXG takes 8 cycles instead of
+// 6, and LSL.W #2 takes 12 instead of 10. The shifter fetches in the other
+// half of each 4-cycle period, so the alignment is all the contention the
+// CPU sees.
+type STBusTiming struct{}
+
+// BusCycleDelay returns the cycles until the next 4-cycle boundary.
+func (STBusTiming) BusCycleDelay(cycle BusCycle) uint32 {
+	return uint32(-cycle.Cycle & 3)
+}
+
+// SetTimingPolicy installs a policy the bus consults before every CPU bus
+// cycle, or removes it when policy is nil. Accesses the CPU serves from direct
+// RAM and ROM pages and the opcodes of cached blocks are timed as well, so the
+// fast paths stay on.
+func (b *Bus) SetTimingPolicy(policy BusTimingPolicy) {
+	b.timing = policy
+	b.refreshFastPages()
+}
@@ -0,0 +1,140 @@
+package m68kemu
+
+import "testing"
+
+// instructionCycles runs an instruction followed by a NOP from an aligned
+// start and returns the cycles until the NOP has been fetched.
+func instructionCycles(t *testing.T, policy BusTimingPolicy, source string) uint64 {
+	t.Helper()
+	cpu, ram := newEnvironment(t)
+	cpu.busFast.SetTimingPolicy(policy)
+	code := assemble(t, source+"\nNOP\nNOP")
+	for i, b := range code {
+		if err := ram.Write(Byte, cpu.regs.PC+uint32(i), uint32(b)); err != nil {
+			t.Fatalf("write code: %v", err)
+		}
+	}
+	cpu.cycles = 0
+	for range 2 {
+		if err := cpu.Step(); err != nil {
+			t.Fatalf("Step failed: %v", err)
+		}
+	}
+	return cpu.cycles - 4
+}
+
+func TestSTBusTimingRoundsToBusSlots(t *testing.T) {
+	tests := []struct {
+		source    string
+		datasheet uint64
+		st        uint64
+	}{
+		{"EXG D0,D1", 6, 8},
+		{"ABCD D0,D1", 6, 8},
+		{"LSL.W #2,D0", 10, 12},
+		{"ST D0", 6, 8},
+		{"MOVEQ #1,D0", 4, 4},
+		{"MOVE.W D0,$3000.W", 12, 12},
+	}
+	for _, tt := range tests {
+		if got := instructionCycles(t, nil, tt.source); got != tt.datasheet {
+			t.Errorf("%s without a policy took %d cycles, want %d", tt.source, got, tt.datasheet)
+		}
+		if got := instructionCycles(t, STBusTiming{}, tt.source); got != tt.st {
+			t.Errorf("%s on the ST took %d cycles, want %d", tt.source, got, tt.st)
+		}
+	}
+}
+
+func TestBusTimingPolicySeesEveryCycle(t *testing.T) {
+	cpu, ram := newEnvironment(t)
+	code := assemble(t, "MOVE.L D0,$3000")
+	for i, b := range code {
+		if err := ram.Write(Byte, cpu.regs.PC+uint32(i), uint32(b)); err != nil {
+			t.Fatalf("write code: %v", err)
+		}
+	}
+
+	var cycles []BusCycle
+	cpu.busFast.SetTimingPolicy(BusTimingFunc(func(cycle BusCycle) uint32 {
+		cycles = append(cycles, cycle)
+		return 1
+	}))
+	cpu.cycles = 0
+	if err := cpu.Step(); err != nil {
+		t.Fatalf("Step failed: %v", err)
+	}
+
+	// Each bus cycle starts 4 cycles after the previous one plus its one
+	// cycle delay, although the instruction's cycles were charged up front.
+	want := []BusCycle{
+		{Cycle: 0, Address: 0x2000, Size: Word, FunctionCode: FunctionCodeSupervisorProgram},
+		{Cycle: 5, Address: 0x2002, Size: Word, FunctionCode: FunctionCodeSupervisorProgram},
+		{Cycle: 10, Address: 0x2004, Size: Word, FunctionCode: FunctionCodeSupervisorProgram},
+		{Cycle: 15, Address: 0x3000, Size: Word, Write: true, FunctionCode: FunctionCodeSupervisorData},
+		{Cycle: 20, Address: 0x3002, Size: Word, Write: true, FunctionCode: FunctionCodeSupervisorData},
+	}
+	if len(cycles) != len(want) {
+		t.Fatalf("policy saw %d bus cycles, want %d: %+v", len(cycles), len(want), cycles)
+	}
+	for i := range want {
+		if cycles[i] != want[i] {
+			t.Errorf("bus cycle %d = %+v, want %+v", i, cycles[i], want[i])
+		}
+	}
+	if cycles[3].Phase(4) != 3 {
+		t.Errorf("phase of cycle %d = %d, want 3", cycles[3].Cycle, cycles[3].Phase(4))
+	}
+	if want := uint64(opcodeCycleTable[cpu.regs.IR]) + 5; cpu.cycles != want {
+		t.Errorf("instruction took %d cycles, want %d with the delays", cpu.cycles, want)
+	}
+
+	// The policy times accesses served from direct pages too.
+	if mem, _ := cpu.busFast.directPage(0x3000, Word, false); mem == nil {
+		t.Fatalf("a timing policy disabled the direct page fast path")
+	}
+}
+
+func TestBusTimingPolicyAppliesToBlocks(t *testing.T) {
+	const source = `
+		MOVE.W #100,D1
+	loop:
+		EXG D0,D2
+		ADDQ.L #1,D0
+		MOVE.W D0,$3000.W
+		SUBQ.W #1,D1
+		BNE.W loop
+	done:
+		BRA.S done`
+	run := func(engine ExecutionEngine) (uint64, []uint64) {
+		cpu, ram := newEnvironment(t)
+		cpu.SetExecutionEngine(engine)
+		var starts []uint64
+		cpu.busFast.SetTimingPolicy(BusTimingFunc(func(cycle BusCycle) uint32 {
+			starts = append(starts, cycle.Cycle)
+			return STBusTiming{}.BusCycleDelay(cycle)
+		}))
+		for i, b := range assemble(t, source) {
+			if err := ram.Write(Byte, cpu.regs.PC+uint32(i), uint32(b)); err != nil {
+				t.Fatalf("write code: %v", err)
+			}
+		}
+		cpu.cycles = 0
+		if err := cpu.RunCycles(4000); err != nil {
+			t.Fatalf("RunCycles failed: %v", err)
+		}
+		return cpu.cycles, starts
+	}
+
+	interpreted, interpretedStarts := run(EngineInterpreter)
+	blocks, blockStarts := run(EngineBlockCache)
+	if blocks != interpreted || len(blockStarts) != len(interpretedStarts) {
+		t.Fatalf("block engine ran %d cycles and %d bus cycles, interpreter %d and %d",
+			blocks, len(blockStarts), interpreted, len(interpretedStarts))
+	}
+	for i := range blockStarts {
+		if blockStarts[i] != interpretedStarts[i] {
+			t.Fatalf("bus cycle %d starts at %d with blocks, %d interpreted", i, blockStarts[i], interpretedStarts[i])
+		}
+	}
+}
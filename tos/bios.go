@@ -0,0 +1,128 @@
+package tos
+
+import (
+	"time"
+
+	m68kemu "github.com/jenska/m68kemu"
+)
+
+const (
+	deviceConsole = 2
+
+	// tickMillis is the period of the 50 Hz system tick reported by Tickcal.
+	tickMillis = 20
+)
+
+// bios implements TRAP #13.
+func (s *System) bios(frame *m68kemu.TrapFrame) (bool, error) {
+	a := args{frame: frame}
+	var result int32
+	switch a.word(0) {
+	case 1: // Bconstat
+		result = 0
+	case 2: // Bconin
+		result = s.conin()
+	case 3: // Bconout
+		if a.word(2) == deviceConsole {
+			s.conout(byte(a.word(4)))
+		}
+	case 5: // Setexc
+		var err error
+		if result, err = s.setexc(a.word(2), a.long(4)); err != nil {
+			return false, err
+		}
+	case 6: // Tickcal
+		result = tickMillis
+	case 7: // Getbpb
+		result = 0
+	case 8: // Bcostat
+		result = -1
+	case 9: // Mediach
+		result = 0
+	case 10: // Drvmap
+		result = 1 << driveC
+	case 11: // Kbshift
+		result = 0
+	default:
+		result = EINVFN
+	}
+	if a.err != nil {
+		return false, a.err
+	}
+	frame.Regs.D[0] = result
+	return true, nil
+}
+
+// setexc returns an exception vector and replaces it unless address is -1.
+func (s *System) setexc(vector, address uint32) (int32, error) {
+	old, err := s.bus.Read(m68kemu.Long, vector<<2)
+	if err != nil {
+		return 0, err
+	}
+	if address != 0xffffffff {
+		if err := s.bus.Write(m68kemu.Long, vector<<2, address); err != nil {
+			return 0, err
+		}
+	}
+	return int32(old), nil
+}
+
+// xbios implements TRAP #14.
+func (s *System) xbios(frame *m68kemu.TrapFrame) (bool, error) {
+	a := args{frame: frame}
+	var result int32
+	switch a.word(0) {
+	case 2, 3: // Physbase, Logbase
+		result = 0
+	case 4: // Getrez
+		result = 2
+	case 5: // Setscreen
+		result = 0
+	case 17: // Random
+		s.random = s.random*3141592621 + 1
+		result = int32(s.random >> 8 & 0xffffff)
+	case 22: // Settime
+		result = 0
+	case 23: // Gettime
+		now := time.Now()
+		result = int32(uint32(dosDate(now))<<16 | uint32(dosTime(now)))
+	case 37: // Vsync waits one 50 Hz frame
+		frame.AddCycles(uint32(m68kemu.FrequencyST / 50))
+	case 38: // Supexec
+		routine := a.long(2)
+		if a.err != nil {
+			return false, a.err
+		}
+		// The routine's D0 is the result, so leave it alone.
+		return true, s.enterSupexec(frame, routine)
+	default:
+		result = EINVFN
+	}
+	if a.err != nil {
+		return false, a.err
+	}
+	frame.Regs.D[0] = result
+	return true, nil
+}
+
+// enterSupexec calls routine in supervisor mode. Its RTS lands on the
+// Supexec stub, where lineF restores the caller.
+func (s *System) enterSupexec(frame *m68kemu.TrapFrame, routine uint32) error {
+	regs := frame.Regs
+	s.supexec = append(s.supexec, supexecFrame{
+		returnPC: frame.ReturnPC,
+		sr:       regs.SR,
+		usp:      regs.A[7],
+	})
+	if regs.SR&srSupervisor == 0 {
+		regs.USP = regs.A[7]
+		regs.A[7] = regs.SSP
+		regs.SR |= srSupervisor
+	}
+	regs.A[7] -= 4
+	if err := s.bus.Write(m68kemu.Long, regs.A[7], supexecStub); err != nil {
+		return err
+	}
+	frame.ReturnPC = routine
+	return nil
+}
@@ -0,0 +1,428 @@
+package tos
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	m68kemu "github.com/jenska/m68kemu"
+)
+
+// GEMDOS error codes returned in D0.
+const (
+	EOK    = 0
+	ERROR  = -1
+	EINVFN = -32
+	EFILNF = -33
+	EPTHNF = -34
+	ENHNDL = -35
+	EACCDN = -36
+	EIHNDL = -37
+	ENSMEM = -39
+	EIMBA  = -40
+	EDRIVE = -46
+	ENMFIL = -49
+	EGSBF  = -67
+)
+
+const (
+	srSupervisor = 0x2000
+
+	firstFileHandle = 6
+	maxFileHandles  = 64
+	driveC          = 2
+
+	// transferChunk bounds the host buffer of Fread and Fwrite.
+	transferChunk = 4096
+)
+
+// gemdos implements TRAP #1. The function number is the first word on the
+// caller's stack and the arguments follow it.
+func (s *System) gemdos(frame *m68kemu.TrapFrame) (bool, error) {
+	function, err := frame.Arg(m68kemu.Word, 0)
+	if err != nil {
+		return false, err
+	}
+	a := args{frame: frame}
+	var result int32
+	switch function {
+	case 0x00: // Pterm0
+		return s.terminate(0)
+	case 0x01, 0x07, 0x08: // Cconin, Crawcin, Cnecin
+		result = s.conin()
+	case 0x02: // Cconout
+		result = s.conout(byte(a.word(2)))
+	case 0x06: // Crawio
+		if c := a.word(2) & 0xff; c == 0xff {
+			result = s.conin()
+		} else {
+			result = s.conout(byte(c))
+		}
+	case 0x09: // Cconws
+		var text string
+		if text, err = readString(frame, a.long(2)); err == nil {
+			_, _ = io.WriteString(s.options.Stdout, text)
+		}
+	case 0x0b: // Cconis
+		result = 0
+	case 0x0e: // Dsetdrv
+		result = 1 << driveC
+	case 0x19: // Dgetdrv
+		result = driveC
+	case 0x1a: // Fsetdta
+		s.dta = a.long(2)
+	case 0x2a: // Tgetdate
+		result = int32(dosDate(time.Now()))
+	case 0x2b, 0x2d: // Tsetdate, Tsettime
+		result = EOK
+	case 0x2c: // Tgettime
+		result = int32(dosTime(time.Now()))
+	case 0x2f: // Fgetdta
+		result = int32(s.dta)
+	case 0x20: // Super
+		result = s.super(frame, a.long(2))
+	case 0x30: // Sversion
+		result = 0x1500
+	case 0x31: // Ptermres
+		return s.terminate(int(int16(a.word(6))))
+	case 0x36: // Dfree
+		result, err = s.dfree(frame, a.long(2))
+	case 0x39: // Dcreate
+		result, err = s.withPath(frame, a.long(2), func(path string) int32 {
+			return hostError(os.Mkdir(path, 0o755), EPTHNF)
+		})
+	case 0x3a: // Ddelete
+		result, err = s.withPath(frame, a.long(2), func(path string) int32 {
+			return hostError(os.Remove(path), EPTHNF)
+		})
+	case 0x3b: // Dsetpath
+		result, err = s.dsetpath(frame, a.long(2))
+	case 0x3c: // Fcreate
+		result, err = s.withPath(frame, a.long(2), func(path string) int32 {
+			return s.open(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+		})
+	case 0x3d: // Fopen
+		mode := [...]int{os.O_RDONLY, os.O_WRONLY, os.O_RDWR, os.O_RDWR}[a.word(6)&3]
+		result, err = s.withPath(frame, a.long(2), func(path string) int32 {
+			return s.open(path, mode)
+		})
+	case 0x3e: // Fclose
+		result = s.fclose(int16(a.word(2)))
+	case 0x3f: // Fread
+		result, err = s.fread(frame, int16(a.word(2)), a.long(4), a.long(8))
+	case 0x40: // Fwrite
+		result, err = s.fwrite(frame, int16(a.word(2)), a.long(4), a.long(8))
+	case 0x41: // Fdelete
+		result, err = s.withPath(frame, a.long(2), func(path string) int32 {
+			return hostError(os.Remove(path), EFILNF)
+		})
+	case 0x42: // Fseek
+		result = s.fseek(int32(a.long(2)), int16(a.word(6)), a.word(8))
+	case 0x47: // Dgetpath
+		err = writeString(frame, a.long(2), s.cwd)
+	case 0x48: // Malloc
+		result = s.malloc(a.long(2))
+	case 0x49: // Mfree
+		result = EIMBA
+		if s.memory != nil && s.memory.release(a.long(2)) {
+			result = EOK
+		}
+	case 0x4a: // Mshrink
+		result = EGSBF
+		if s.memory != nil && s.memory.shrink(a.long(4), a.long(8)) {
+			result = EOK
+		}
+	case 0x4c: // Pterm
+		return s.terminate(int(int16(a.word(2))))
+	case 0x4e: // Fsfirst
+		result, err = s.fsfirst(frame, a.long(2), a.word(6))
+	case 0x4f: // Fsnext
+		result, err = s.fsnext(frame)
+	case 0x56: // Frename
+		result, err = s.frename(frame, a.long(4), a.long(8))
+	default:
+		result = EINVFN
+	}
+	if err == nil {
+		err = a.err
+	}
+	if err != nil {
+		return false, err
+	}
+	frame.Regs.D[0] = result
+	return true, nil
+}
+
+// args reads trap arguments at byte offsets from the caller's stack and keeps
+// the first error.
+type args struct {
+	frame *m68kemu.TrapFrame
+	err   error
+}
+
+func (a *args) word(offset uint32) uint32 {
+	return a.read(m68kemu.Word, offset)
+}
+
+func (a *args) long(offset uint32) uint32 {
+	return a.read(m68kemu.Long, offset)
+}
+
+func (a *args) read(size m68kemu.Size, offset uint32) uint32 {
+	value, err := a.frame.Arg(size, offset)
+	if err != nil && a.err == nil {
+		a.err = err
+	}
+	return value
+}
+
+func (s *System) conin() int32 {
+	c, ok := s.readByte()
+	if !ok {
+		return 0x04 // end of input reads as Control-D
+	}
+	return int32(c)
+}
+
+func (s *System) conout(c byte) int32 {
+	_, _ = s.options.Stdout.Write([]byte{c})
+	return EOK
+}
+
+// super implements Super: a zero argument switches to supervisor mode on the
+// caller's stack and returns the old supervisor stack, 1 asks for the
+// current mode, and any other value returns to user mode with it as the
+// supervisor stack.
+func (s *System) super(frame *m68kemu.TrapFrame, stack uint32) int32 {
+	regs := frame.Regs
+	supervisor := regs.SR&srSupervisor != 0
+	switch {
+	case stack == 1:
+		if supervisor {
+			return -1
+		}
+		return 0
+	case !supervisor:
+		old := regs.SSP
+		regs.USP = regs.A[7]
+		regs.SR |= srSupervisor
+		if stack != 0 {
+			regs.A[7] = stack
+		}
+		return int32(old)
+	default:
+		regs.USP = regs.A[7]
+		regs.SSP = stack
+		regs.SR &^= srSupervisor
+		return 0
+	}
+}
+
+func (s *System) malloc(size uint32) int32 {
+	if s.memory == nil {
+		return 0
+	}
+	if size == 0xffffffff {
+		return int32(s.memory.largest())
+	}
+	address, ok := s.memory.alloc(size)
+	if !ok {
+		return 0
+	}
+	return int32(address)
+}
+
+// withPath resolves a guest file name and runs fn on the host path.
+func (s *System) withPath(frame *m68kemu.TrapFrame, name uint32, fn func(path string) int32) (int32, error) {
+	guest, err := readString(frame, name)
+	if err != nil {
+		return 0, err
+	}
+	path, code := s.hostPath(guest)
+	if code != EOK {
+		return code, nil
+	}
+	return fn(path), nil
+}
+
+func (s *System) open(path string, flag int) int32 {
+	handle := int16(firstFileHandle)
+	for ; handle < maxFileHandles; handle++ {
+		if s.files[handle] == nil {
+			break
+		}
+	}
+	if handle == maxFileHandles {
+		return ENHNDL
+	}
+	file, err := os.OpenFile(path, flag, 0o644)
+	if err != nil {
+		return hostError(err, EFILNF)
+	}
+	s.files[handle] = file
+	return int32(handle)
+}
+
+func (s *System) fclose(handle int16) int32 {
+	if handle >= 0 && handle < firstFileHandle {
+		return EOK
+	}
+	file := s.files[handle]
+	if file == nil {
+		return EIHNDL
+	}
+	delete(s.files, handle)
+	return hostError(file.Close(), ERROR)
+}
+
+func (s *System) fread(frame *m68kemu.TrapFrame, handle int16, count, buffer uint32) (int32, error) {
+	if handle == 0 {
+		return s.readConsole(frame, count, buffer)
+	}
+	file := s.files[handle]
+	if file == nil {
+		return EIHNDL, nil
+	}
+
+	// The count comes from the guest, so copy through a bounded buffer
+	// instead of allocating it up front.
+	data := make([]byte, min(count, transferChunk))
+	var total uint32
+	for total < count {
+		n, err := file.Read(data[:min(count-total, transferChunk)])
+		for i := range n {
+			if err := frame.Write(m68kemu.Byte, buffer+total+uint32(i), uint32(data[i])); err != nil {
+				return 0, err
+			}
+		}
+		total += uint32(n)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return ERROR, nil
+		}
+	}
+	return int32(total), nil
+}
+
+// readConsole reads console input for Fread on handle 0. Like the TOS console
+// it returns at the end of a line rather than waiting for count bytes.
+func (s *System) readConsole(frame *m68kemu.TrapFrame, count, buffer uint32) (int32, error) {
+	var total uint32
+	for total < count {
+		c, ok := s.readByte()
+		if !ok {
+			break
+		}
+		if err := frame.Write(m68kemu.Byte, buffer+total, uint32(c)); err != nil {
+			return 0, err
+		}
+		total++
+		if c == '\n' {
+			break
+		}
+	}
+	return int32(total), nil
+}
+
+func (s *System) fwrite(frame *m68kemu.TrapFrame, handle int16, count, buffer uint32) (int32, error) {
+	var writer io.Writer
+	switch {
+	case handle == 1:
+		writer = s.options.Stdout
+	case handle == 2:
+		writer = s.options.Stderr
+	case s.files[handle] != nil:
+		writer = s.files[handle]
+	default:
+		return EIHNDL, nil
+	}
+
+	data := make([]byte, 0, min(count, transferChunk))
+	var total int32
+	for offset := uint32(0); offset < count; {
+		data = data[:0]
+		for ; offset < count && len(data) < transferChunk; offset++ {
+			value, err := frame.Read(m68kemu.Byte, buffer+offset)
+			if err != nil {
+				return 0, err
+			}
+			data = append(data, byte(value))
+		}
+		n, err := writer.Write(data)
+		total += int32(n)
+		if err != nil {
+			return EACCDN, nil
+		}
+	}
+	return total, nil
+}
+
+func (s *System) fseek(offset int32, handle int16, mode uint32) int32 {
+	file := s.files[handle]
+	if file == nil {
+		return EIHNDL
+	}
+	if mode > io.SeekEnd {
+		return EINVFN
+	}
+	position, err := file.Seek(int64(offset), int(mode))
+	if err != nil {
+		return -64 // ERANGE
+	}
+	return int32(position)
+}
+
+func (s *System) frename(frame *m68kemu.TrapFrame, oldName, newName uint32) (int32, error) {
+	var target string
+	result, err := s.withPath(frame, newName, func(path string) int32 {
+		target = path
+		return EOK
+	})
+	if err != nil || result != EOK {
+		return result, err
+	}
+	return s.withPath(frame, oldName, func(path string) int32 {
+		return hostError(os.Rename(path, target), EFILNF)
+	})
+}
+
+// dfree reports a fixed, roomy drive: free and total clusters, then bytes per
+// sector and sectors per cluster.
+func (s *System) dfree(frame *m68kemu.TrapFrame, buffer uint32) (int32, error) {
+	for i, value := range [...]uint32{32768, 65535, 512, 2} {
+		if err := frame.Write(m68kemu.Long, buffer+uint32(4*i), value); err != nil {
+			return 0, err
+		}
+	}
+	return EOK, nil
+}
+
+// hostError maps a host file error onto a GEMDOS error code, using notFound
+// for missing files or directories.
+func hostError(err error, notFound int32) int32 {
+	switch {
+	case err == nil:
+		return EOK
+	case errors.Is(err, fs.ErrNotExist):
+		return notFound
+	case errors.Is(err, fs.ErrPermission), errors.Is(err, fs.ErrExist):
+		return EACCDN
+	default:
+		return ERROR
+	}
+}
+
+// dosDate packs a date in the GEMDOS format.
+func dosDate(t time.Time) uint16 {
+	return uint16((t.Year()-1980)<<9 | int(t.Month())<<5 | t.Day())
+}
+
+// dosTime packs a time of day in the GEMDOS format, with two-second
+// resolution.
+func dosTime(t time.Time) uint16 {
+	return uint16(t.Hour()<<11 | t.Minute()<<5 | t.Second()/2)
+}
@@ -0,0 +1,102 @@
+package tos
+
+import "slices"
+
+// memoryBlock is a range of the transient program area.
+type memoryBlock struct {
+	start uint32
+	size  uint32
+}
+
+// memoryPool hands out TPA memory for Malloc, Mfree, and Mshrink. Free blocks
+// are kept sorted by address and merged with their neighbours.
+type memoryPool struct {
+	free []memoryBlock
+	used map[uint32]uint32
+}
+
+func newMemoryPool(start, end uint32) *memoryPool {
+	pool := &memoryPool{used: make(map[uint32]uint32)}
+	if end > start {
+		pool.free = []memoryBlock{{start: start, size: end - start}}
+	}
+	return pool
+}
+
+// largest returns the size of the largest free block, as Malloc(-1) reports.
+func (p *memoryPool) largest() uint32 {
+	var size uint32
+	for _, block := range p.free {
+		size = max(size, block.size)
+	}
+	return size
+}
+
+// alloc takes size bytes, rounded up to an even count, from the first free
+// block large enough.
+func (p *memoryPool) alloc(size uint32) (uint32, bool) {
+	size = (size + 1) &^ 1
+	if size == 0 {
+		return 0, false
+	}
+	for i, block := range p.free {
+		if block.size < size {
+			continue
+		}
+		if block.size == size {
+			p.free = slices.Delete(p.free, i, i+1)
+		} else {
+			p.free[i] = memoryBlock{start: block.start + size, size: block.size - size}
+		}
+		p.used[block.start] = size
+		return block.start, true
+	}
+	return 0, false
+}
+
+// release returns an allocated block to the pool.
+func (p *memoryPool) release(address uint32) bool {
+	size, ok := p.used[address]
+	if !ok {
+		return false
+	}
+	delete(p.used, address)
+	p.insertFree(memoryBlock{start: address, size: size})
+	return true
+}
+
+// shrink cuts an allocated block down to size bytes and frees the rest.
+func (p *memoryPool) shrink(address, size uint32) bool {
+	current, ok := p.used[address]
+	size = (size + 1) &^ 1
+	if !ok || size > current {
+		return false
+	}
+	if size == current {
+		return true
+	}
+	p.used[address] = size
+	p.insertFree(memoryBlock{start: address + size, size: current - size})
+	return true
+}
+
+func (p *memoryPool) insertFree(block memoryBlock) {
+	i, _ := slices.BinarySearchFunc(p.free, block.start, func(b memoryBlock, start uint32) int {
+		switch {
+		case b.start < start:
+			return -1
+		case b.start > start:
+			return 1
+		}
+		return 0
+	})
+	p.free = slices.Insert(p.free, i, block)
+	if i+1 < len(p.free) && p.free[i].start+p.free[i].size == p.free[i+1].start {
+		p.free[i].size += p.free[i+1].size
+		p.free = slices.Delete(p.free, i+1, i+2)
+	}
+	if i > 0 && p.free[i-1].start+p.free[i-1].size == p.free[i].start {
+		p.free[i-1].size += p.free[i].size
+		p.free = slices.Delete(p.free, i, i+1)
+	}
+}
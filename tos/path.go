@@ -0,0 +1,197 @@
+package tos
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	m68kemu "github.com/jenska/m68kemu"
+)
+
+const (
+	attrDirectory = 0x10
+
+	dtaAttr = 21
+	dtaTime = 22
+	dtaDate = 24
+	dtaSize = 26
+	dtaName = 30
+
+	maxDOSName = 12
+)
+
+// searchEntry is a directory entry waiting to be returned by Fsnext.
+type searchEntry struct {
+	name string
+	attr byte
+	time uint16
+	date uint16
+	size uint32
+}
+
+// guestPath turns a TOS file name into a clean absolute path with forward
+// slashes, relative to the root of drive C:.
+func (s *System) guestPath(name string) string {
+	if len(name) >= 2 && name[1] == ':' {
+		name = name[2:]
+	}
+	name = strings.ReplaceAll(name, `\`, "/")
+	if !strings.HasPrefix(name, "/") {
+		name = strings.ReplaceAll(s.cwd, `\`, "/") + "/" + name
+	}
+	return path.Clean("/" + name)
+}
+
+// hostPath maps a TOS file name onto Options.Root. Each existing component
+// is matched without regard to case, since TOS programs use upper-case names.
+func (s *System) hostPath(name string) (string, int32) {
+	if s.options.Root == "" {
+		return "", EDRIVE
+	}
+	host := s.options.Root
+	guest := s.guestPath(name)
+	if guest == "/" {
+		return host, EOK
+	}
+	for _, component := range strings.Split(guest[1:], "/") {
+		host = filepath.Join(host, matchName(host, component))
+	}
+	return host, EOK
+}
+
+// matchName returns the entry of dir that equals name ignoring case, or name
+// itself when there is none.
+func matchName(dir, name string) string {
+	if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+		return name
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return name
+	}
+	for _, entry := range entries {
+		if strings.EqualFold(entry.Name(), name) {
+			return entry.Name()
+		}
+	}
+	return name
+}
+
+func (s *System) dsetpath(frame *m68kemu.TrapFrame, name uint32) (int32, error) {
+	guest, err := readString(frame, name)
+	if err != nil {
+		return 0, err
+	}
+	host, code := s.hostPath(guest)
+	if code != EOK {
+		return code, nil
+	}
+	if info, err := os.Stat(host); err != nil || !info.IsDir() {
+		return EPTHNF, nil
+	}
+	clean := s.guestPath(guest)
+	if clean == "/" {
+		clean = ""
+	}
+	s.cwd = strings.ToUpper(strings.ReplaceAll(clean, "/", `\`))
+	return EOK, nil
+}
+
+// fsfirst collects the directory entries matching a wildcard spec and returns
+// the first one in the DTA. Directories are listed only when attr asks for
+// them.
+func (s *System) fsfirst(frame *m68kemu.TrapFrame, spec uint32, attr uint32) (int32, error) {
+	guest, err := readString(frame, spec)
+	if err != nil {
+		return 0, err
+	}
+	delete(s.searches, s.dta)
+	if s.options.Root == "" {
+		return EDRIVE, nil
+	}
+	clean := s.guestPath(guest)
+	dir, code := s.hostPath(path.Dir(clean))
+	if code != EOK {
+		return code, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return EPTHNF, nil
+	}
+
+	pattern := strings.ToUpper(path.Base(clean))
+	var matches []searchEntry
+	for _, entry := range entries {
+		name := strings.ToUpper(entry.Name())
+		if len(name) > maxDOSName || !matchPattern(pattern, name) {
+			continue
+		}
+		if entry.IsDir() && attr&attrDirectory == 0 {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		found := searchEntry{
+			name: name,
+			time: dosTime(info.ModTime()),
+			date: dosDate(info.ModTime()),
+		}
+		if entry.IsDir() {
+			found.attr = attrDirectory
+		} else {
+			found.size = uint32(info.Size())
+		}
+		matches = append(matches, found)
+	}
+	if len(matches) == 0 {
+		return EFILNF, nil
+	}
+	s.searches[s.dta] = matches
+	return s.fsnext(frame)
+}
+
+// fsnext stores the next pending match of the current DTA.
+func (s *System) fsnext(frame *m68kemu.TrapFrame) (int32, error) {
+	matches := s.searches[s.dta]
+	if len(matches) == 0 {
+		delete(s.searches, s.dta)
+		return ENMFIL, nil
+	}
+	entry := matches[0]
+	s.searches[s.dta] = matches[1:]
+
+	dta := s.dta
+	writes := []struct {
+		size    m68kemu.Size
+		address uint32
+		value   uint32
+	}{
+		{m68kemu.Byte, dta + dtaAttr, uint32(entry.attr)},
+		{m68kemu.Word, dta + dtaTime, uint32(entry.time)},
+		{m68kemu.Word, dta + dtaDate, uint32(entry.date)},
+		{m68kemu.Long, dta + dtaSize, entry.size},
+	}
+	for _, w := range writes {
+		if err := frame.Write(w.size, w.address, w.value); err != nil {
+			return 0, err
+		}
+	}
+	return EOK, writeString(frame, dta+dtaName, entry.name)
+}
+
+// matchPattern matches a TOS wildcard. "*.*" also matches names without an
+// extension.
+func matchPattern(pattern, name string) bool {
+	if pattern == "*.*" {
+		return true
+	}
+	if !strings.Contains(name, ".") && strings.HasSuffix(pattern, ".*") {
+		name += "."
+		pattern = strings.TrimSuffix(pattern, "*")
+	}
+	ok, err := path.Match(pattern, name)
+	return err == nil && ok
+}
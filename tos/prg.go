@@ -0,0 +1,207 @@
+package tos
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	m68kemu "github.com/jenska/m68kemu"
+)
+
+const (
+	prgMagic      = 0x601a
+	prgHeaderSize = 28
+
+	// BasepageSize is the size of the GEMDOS process descriptor in front of
+	// a program's text segment.
+	BasepageSize = 0x100
+
+	basepageLowTPA   = 0x00
+	basepageHighTPA  = 0x04
+	basepageText     = 0x08
+	basepageTextLen  = 0x0c
+	basepageData     = 0x10
+	basepageDataLen  = 0x14
+	basepageBSS      = 0x18
+	basepageBSSLen   = 0x1c
+	basepageDTA      = 0x20
+	basepageParent   = 0x24
+	basepageEnv      = 0x2c
+	basepageCmdline  = 0x80
+	maxCommandLine   = 125
+	relocSkipMarker  = 1
+	relocSkipAdvance = 254
+)
+
+// PRGHeader is the header of a GEMDOS executable.
+type PRGHeader struct {
+	TextSize   uint32
+	DataSize   uint32
+	BSSSize    uint32
+	SymbolSize uint32
+	Flags      uint32
+	// Absolute is set when the program carries no relocation table.
+	Absolute bool
+}
+
+// PRGLoadOptions controls where LoadPRG places a program.
+type PRGLoadOptions struct {
+	// Basepage is the address of the process basepage. The text segment
+	// follows it directly.
+	Basepage uint32
+	// Top is the end of the transient program area, exclusive.
+	Top uint32
+	// CommandLine is stored in the basepage, truncated to 125 characters.
+	CommandLine string
+	// Environment is the address of the environment strings, or zero.
+	Environment uint32
+}
+
+// Program describes a loaded GEMDOS executable.
+type Program struct {
+	Header   PRGHeader
+	Basepage uint32
+	Text     uint32
+	Data     uint32
+	BSS      uint32
+	// End is the first address after the BSS segment.
+	End uint32
+}
+
+// LoadPRG reads a GEMDOS executable (.PRG, .TOS, .TTP), relocates it for
+// options.Basepage+BasepageSize, clears its BSS segment, and fills in the
+// basepage as Pexec does.
+func LoadPRG(bus m68kemu.AddressBus, r io.Reader, options PRGLoadOptions) (Program, error) {
+	var raw [prgHeaderSize]byte
+	if _, err := io.ReadFull(r, raw[:]); err != nil {
+		return Program{}, fmt.Errorf("read PRG header: %w", err)
+	}
+	if magic := binary.BigEndian.Uint16(raw[:]); magic != prgMagic {
+		return Program{}, fmt.Errorf("not a GEMDOS executable: magic %04x", magic)
+	}
+	header := PRGHeader{
+		TextSize:   binary.BigEndian.Uint32(raw[2:]),
+		DataSize:   binary.BigEndian.Uint32(raw[6:]),
+		BSSSize:    binary.BigEndian.Uint32(raw[10:]),
+		SymbolSize: binary.BigEndian.Uint32(raw[14:]),
+		Flags:      binary.BigEndian.Uint32(raw[22:]),
+		Absolute:   binary.BigEndian.Uint16(raw[26:]) != 0,
+	}
+
+	end := uint64(options.Basepage) + BasepageSize +
+		uint64(header.TextSize) + uint64(header.DataSize) + uint64(header.BSSSize)
+	if end > uint64(options.Top) {
+		return Program{}, fmt.Errorf("program needs %d bytes, TPA %08x-%08x is too small",
+			end-uint64(options.Basepage), options.Basepage, options.Top)
+	}
+	program := Program{Header: header, Basepage: options.Basepage}
+	program.Text = options.Basepage + BasepageSize
+	program.Data = program.Text + header.TextSize
+	program.BSS = program.Data + header.DataSize
+	program.End = uint32(end)
+
+	image := make([]byte, header.TextSize+header.DataSize)
+	if _, err := io.ReadFull(r, image); err != nil {
+		return Program{}, fmt.Errorf("read text and data: %w", err)
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(header.SymbolSize)); err != nil {
+		return Program{}, fmt.Errorf("skip symbol table: %w", err)
+	}
+	if !header.Absolute {
+		if err := relocatePRG(r, image, program.Text); err != nil {
+			return Program{}, err
+		}
+	}
+
+	if err := storeBytes(bus, program.Text, image); err != nil {
+		return Program{}, err
+	}
+	if err := storeBytes(bus, program.BSS, make([]byte, header.BSSSize)); err != nil {
+		return Program{}, err
+	}
+	return program, writeBasepage(bus, program, options)
+}
+
+// relocatePRG applies the GEMDOS relocation table: a long offset to the first
+// fixup, then one byte per further fixup giving the distance to it, where 1
+// advances by 254 bytes without a fixup and 0 ends the table.
+func relocatePRG(r io.Reader, image []byte, base uint32) error {
+	var first [4]byte
+	if _, err := io.ReadFull(r, first[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return fmt.Errorf("read relocation table: %w", err)
+	}
+	offset := binary.BigEndian.Uint32(first[:])
+	if offset == 0 {
+		return nil
+	}
+
+	var next [1]byte
+	for {
+		if uint64(offset)+4 > uint64(len(image)) || offset&1 != 0 {
+			return fmt.Errorf("relocation offset %08x out of range", offset)
+		}
+		value := binary.BigEndian.Uint32(image[offset:])
+		binary.BigEndian.PutUint32(image[offset:], value+base)
+
+		for {
+			if _, err := io.ReadFull(r, next[:]); err != nil {
+				return fmt.Errorf("read relocation table: %w", err)
+			}
+			if next[0] != relocSkipMarker {
+				break
+			}
+			offset += relocSkipAdvance
+		}
+		if next[0] == 0 {
+			return nil
+		}
+		offset += uint32(next[0])
+	}
+}
+
+func writeBasepage(bus m68kemu.AddressBus, program Program, options PRGLoadOptions) error {
+	page := make([]byte, BasepageSize)
+	put := func(offset int, value uint32) {
+		binary.BigEndian.PutUint32(page[offset:], value)
+	}
+	put(basepageLowTPA, options.Basepage)
+	put(basepageHighTPA, options.Top)
+	put(basepageText, program.Text)
+	put(basepageTextLen, program.Header.TextSize)
+	put(basepageData, program.Data)
+	put(basepageDataLen, program.Header.DataSize)
+	put(basepageBSS, program.BSS)
+	put(basepageBSSLen, program.Header.BSSSize)
+	put(basepageDTA, options.Basepage+basepageCmdline)
+	put(basepageEnv, options.Environment)
+
+	cmdline := options.CommandLine
+	if len(cmdline) > maxCommandLine {
+		cmdline = cmdline[:maxCommandLine]
+	}
+	page[basepageCmdline] = byte(len(cmdline))
+	copy(page[basepageCmdline+1:], cmdline)
+	return storeBytes(bus, options.Basepage, page)
+}
+
+// storeBytes writes data through the bus, preferring the side-effect-free
+// Poke path.
+func storeBytes(bus m68kemu.AddressBus, address uint32, data []byte) error {
+	poker, _ := bus.(m68kemu.PokeDevice)
+	for i, b := range data {
+		var err error
+		if poker != nil {
+			err = poker.Poke(m68kemu.Byte, address+uint32(i), uint32(b))
+		} else {
+			err = bus.Write(m68kemu.Byte, address+uint32(i), uint32(b))
+		}
+		if err != nil {
+			return fmt.Errorf("store %08x: %w", address+uint32(i), err)
+		}
+	}
+	return nil
+}
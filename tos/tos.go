@@ -0,0 +1,307 @@
+// Package tos runs Atari ST command-line programs without a TOS ROM. It
+// loads GEMDOS executables onto a plain bus with RAM and implements the
+// common GEMDOS, BIOS, and XBIOS calls in Go by intercepting TRAP #1, #13,
+// and #14.
+//
+// File calls map drive C: onto a host directory. Console output goes to an
+// io.Writer and console input comes from an io.Reader, so programs run
+// headless. Calls that need real hardware, such as disk sector access or
+// Pexec, return EINVFN.
+package tos
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	m68kemu "github.com/jenska/m68kemu"
+)
+
+const (
+	// DefaultTPAStart is where the transient program area starts unless
+	// Options.TPAStart says otherwise. Vectors, exception stubs, and the
+	// supervisor stack live below it.
+	DefaultTPAStart = 0x4000
+	// DefaultMemoryTop is the end of the transient program area unless
+	// Options.MemoryTop says otherwise, matching a 512 KiB machine.
+	DefaultMemoryTop = 0x80000
+
+	// stubBase holds one Line-F opcode per exception vector. The vectors point
+	// at these stubs so an unexpected exception stops Run with an error
+	// instead of running off into empty memory.
+	stubBase     = 0x800
+	stubCount    = 64
+	supexecStub  = stubBase + 2*stubCount
+	stubOpcode   = 0xf000
+	runSlice     = 1_000_000
+	maxStringLen = 4096
+)
+
+// ErrCycleLimit is returned by Run when a program exceeds Options.MaxCycles.
+var ErrCycleLimit = errors.New("tos: cycle limit reached")
+
+// errTerminated unwinds RunCycles when the program calls Pterm.
+var errTerminated = errors.New("tos: process terminated")
+
+// Options configures a System.
+type Options struct {
+	// Root is the host directory that appears as drive C:. An empty Root
+	// disables file access.
+	Root string
+	// Stdin feeds console input. A nil Stdin behaves like an empty stream.
+	Stdin io.Reader
+	// Stdout receives console output and GEMDOS handle 1.
+	Stdout io.Writer
+	// Stderr receives GEMDOS handle 2. It defaults to Stdout.
+	Stderr io.Writer
+	// TPAStart and MemoryTop bound the transient program area. They default
+	// to DefaultTPAStart and DefaultMemoryTop.
+	TPAStart  uint32
+	MemoryTop uint32
+	// MaxCycles stops Run with ErrCycleLimit once the program has run this
+	// many cycles. Zero means no limit.
+	MaxCycles uint64
+}
+
+// UnhandledExceptionError reports an exception the program did not install a
+// handler for.
+type UnhandledExceptionError struct {
+	Vector uint32
+	// PC is the program counter the exception stacked.
+	PC uint32
+}
+
+func (e UnhandledExceptionError) Error() string {
+	return fmt.Sprintf("tos: unhandled exception vector %d at %06x", e.Vector, e.PC)
+}
+
+// supexecFrame remembers the caller of an XBIOS Supexec while the routine runs.
+type supexecFrame struct {
+	returnPC uint32
+	sr       uint16
+	usp      uint32
+}
+
+// System is a high-level TOS environment attached to a CPU.
+type System struct {
+	cpu     m68kemu.CPU
+	bus     m68kemu.AddressBus
+	options Options
+
+	memory     *memoryPool
+	program    Program
+	dta        uint32
+	files      map[int16]*os.File
+	searches   map[uint32][]searchEntry
+	cwd        string
+	supexec    []supexecFrame
+	random     uint32
+	exitCode   int
+	terminated bool
+}
+
+// New attaches a TOS environment to cpu and bus and installs its trap
+// handlers.
+func New(cpu m68kemu.CPU, bus m68kemu.AddressBus, options Options) *System {
+	if options.TPAStart == 0 {
+		options.TPAStart = DefaultTPAStart
+	}
+	if options.MemoryTop == 0 {
+		options.MemoryTop = DefaultMemoryTop
+	}
+	if options.Stdout == nil {
+		options.Stdout = io.Discard
+	}
+	if options.Stderr == nil {
+		options.Stderr = options.Stdout
+	}
+	s := &System{
+		cpu:      cpu,
+		bus:      bus,
+		options:  options,
+		files:    make(map[int16]*os.File),
+		searches: make(map[uint32][]searchEntry),
+		random:   0x2a9b_f3c1,
+	}
+	cpu.SetTrapHandler(m68kemu.XTrap+1, s.gemdos)
+	cpu.SetTrapHandler(m68kemu.XTrap+13, s.bios)
+	cpu.SetTrapHandler(m68kemu.XTrap+14, s.xbios)
+	cpu.SetTrapHandler(m68kemu.XLineF, s.lineF)
+	return s
+}
+
+// Load places a GEMDOS executable in the transient program area and prepares
+// the CPU to start it in user mode, with the basepage address at 4(A7) as
+// Pexec leaves it. args become the command line.
+func (s *System) Load(r io.Reader, args ...string) (Program, error) {
+	if err := s.installStubs(); err != nil {
+		return Program{}, err
+	}
+	start, top := s.options.TPAStart, s.options.MemoryTop
+	s.memory = newMemoryPool(start, top)
+	basepage, ok := s.memory.alloc(top - start)
+	if !ok {
+		return Program{}, fmt.Errorf("tos: empty TPA %08x-%08x", start, top)
+	}
+	program, err := LoadPRG(s.bus, r, PRGLoadOptions{
+		Basepage:    basepage,
+		Top:         top,
+		CommandLine: strings.Join(args, " "),
+	})
+	if err != nil {
+		return Program{}, err
+	}
+
+	stack := top - 8
+	if err := s.bus.Write(m68kemu.Long, stack+4, basepage); err != nil {
+		return Program{}, err
+	}
+	var regs m68kemu.Registers
+	regs.A[7] = stack
+	regs.USP = stack
+	regs.SSP = start
+	regs.PC = program.Text
+	s.cpu.SetRegisters(regs)
+
+	s.program = program
+	s.dta = basepage + basepageCmdline
+	s.cwd = ""
+	s.supexec = nil
+	s.exitCode = 0
+	s.terminated = false
+	return program, nil
+}
+
+// Run executes the loaded program until it terminates and returns its exit
+// code.
+func (s *System) Run() (int, error) {
+	start := s.cpu.Cycles()
+	for !s.terminated {
+		slice := uint64(runSlice)
+		if s.options.MaxCycles != 0 {
+			ran := s.cpu.Cycles() - start
+			if ran >= s.options.MaxCycles {
+				return 0, ErrCycleLimit
+			}
+			slice = min(slice, s.options.MaxCycles-ran)
+		}
+		if err := s.cpu.RunCycles(slice); err != nil {
+			if errors.Is(err, errTerminated) {
+				break
+			}
+			return 0, err
+		}
+	}
+	s.closeFiles()
+	return s.exitCode, nil
+}
+
+// Terminated reports whether the program has exited.
+func (s *System) Terminated() bool {
+	return s.terminated
+}
+
+// ExitCode returns the code the program passed to Pterm. Callers that drive
+// the CPU themselves instead of using Run check Terminated when RunCycles
+// fails and then read the exit code here.
+func (s *System) ExitCode() int {
+	return s.exitCode
+}
+
+func (s *System) installStubs() error {
+	for vector := uint32(2); vector < stubCount; vector++ {
+		stub := uint32(stubBase + 2*vector)
+		if err := s.bus.Write(m68kemu.Word, stub, stubOpcode|vector); err != nil {
+			return fmt.Errorf("tos: install exception stubs: %w", err)
+		}
+		if err := s.bus.Write(m68kemu.Long, vector<<2, stub); err != nil {
+			return fmt.Errorf("tos: install exception vectors: %w", err)
+		}
+	}
+	if err := s.bus.Write(m68kemu.Word, supexecStub, stubOpcode|0xff); err != nil {
+		return fmt.Errorf("tos: install Supexec stub: %w", err)
+	}
+	return nil
+}
+
+// lineF catches the exception stubs and the return from a Supexec routine.
+// Other Line-F opcodes take their normal vector.
+func (s *System) lineF(frame *m68kemu.TrapFrame) (bool, error) {
+	switch {
+	case frame.PC == supexecStub && len(s.supexec) != 0:
+		caller := s.supexec[len(s.supexec)-1]
+		s.supexec = s.supexec[:len(s.supexec)-1]
+		if caller.sr&srSupervisor == 0 {
+			frame.Regs.SSP = frame.Regs.A[7]
+			frame.Regs.A[7] = caller.usp
+		}
+		frame.Regs.SR = caller.sr
+		frame.ReturnPC = caller.returnPC
+		return true, nil
+	case frame.PC >= stubBase && frame.PC < stubBase+2*stubCount:
+		// The stub runs as the exception handler, so the stack holds the
+		// faulting code's PC: after the SR in a short frame, and after the
+		// access details in a bus or address error frame.
+		vector := (frame.PC - stubBase) / 2
+		offset := uint32(2)
+		if vector == 2 || vector == 3 {
+			offset = 10
+		}
+		pc, _ := frame.Read(m68kemu.Long, frame.Regs.A[7]+offset)
+		return false, UnhandledExceptionError{Vector: vector, PC: pc}
+	}
+	return false, nil
+}
+
+func (s *System) terminate(code int) (bool, error) {
+	s.exitCode = code
+	s.terminated = true
+	return false, errTerminated
+}
+
+func (s *System) closeFiles() {
+	for handle, file := range s.files {
+		_ = file.Close()
+		delete(s.files, handle)
+	}
+}
+
+// readString reads a NUL-terminated string from guest memory.
+func readString(frame *m68kemu.TrapFrame, address uint32) (string, error) {
+	var b strings.Builder
+	for i := range uint32(maxStringLen) {
+		c, err := frame.Read(m68kemu.Byte, address+i)
+		if err != nil {
+			return "", err
+		}
+		if c == 0 {
+			break
+		}
+		b.WriteByte(byte(c))
+	}
+	return b.String(), nil
+}
+
+// writeString stores s and a terminating NUL in guest memory.
+func writeString(frame *m68kemu.TrapFrame, address uint32, s string) error {
+	for i := range len(s) {
+		if err := frame.Write(m68kemu.Byte, address+uint32(i), uint32(s[i])); err != nil {
+			return err
+		}
+	}
+	return frame.Write(m68kemu.Byte, address+uint32(len(s)), 0)
+}
+
+// readByte takes one byte of console input, or false at the end of input.
+func (s *System) readByte() (byte, bool) {
+	if s.options.Stdin == nil {
+		return 0, false
+	}
+	var b [1]byte
+	if _, err := io.ReadFull(s.options.Stdin, b[:]); err != nil {
+		return 0, false
+	}
+	return b[0], true
+}
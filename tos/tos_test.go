@@ -0,0 +1,423 @@
+package tos
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	asm "github.com/jenska/m68kasm"
+	m68kemu "github.com/jenska/m68kemu"
+)
+
+// buildPRG assembles source into the text segment of a GEMDOS executable
+// with fixups at the given text offsets.
+func buildPRG(tb testing.TB, source string, bss uint32, relocs ...uint32) []byte {
+	tb.Helper()
+	code, _, err := asm.AssembleStringWithListing(source)
+	if err != nil {
+		tb.Fatalf("Assembler failed: %v", err)
+	}
+	if len(code)%2 != 0 {
+		code = append(code, 0)
+	}
+
+	var prg bytes.Buffer
+	header := []any{uint16(prgMagic), uint32(len(code)), uint32(0), bss, uint32(0), uint32(0), uint32(0), uint16(0)}
+	for _, field := range header {
+		_ = binary.Write(&prg, binary.BigEndian, field)
+	}
+	prg.Write(code)
+	if len(relocs) == 0 {
+		_ = binary.Write(&prg, binary.BigEndian, uint32(0))
+		return prg.Bytes()
+	}
+	_ = binary.Write(&prg, binary.BigEndian, relocs[0])
+	for i := 1; i < len(relocs); i++ {
+		distance := relocs[i] - relocs[i-1]
+		for ; distance > relocSkipAdvance; distance -= relocSkipAdvance {
+			prg.WriteByte(relocSkipMarker)
+		}
+		prg.WriteByte(byte(distance))
+	}
+	prg.WriteByte(0)
+	return prg.Bytes()
+}
+
+// dcb renders s as a NUL-terminated DC.B line.
+func dcb(s string) string {
+	values := make([]string, 0, len(s)+1)
+	for i := range len(s) {
+		values = append(values, fmt.Sprint(s[i]))
+	}
+	return "DC.B " + strings.Join(append(values, "0"), ",") + "\n"
+}
+
+func newSystem(tb testing.TB, options Options) (*System, m68kemu.CPU, *m68kemu.RAM) {
+	tb.Helper()
+	ram := m68kemu.NewRAM(0, DefaultMemoryTop)
+	bus := m68kemu.NewBus(ram)
+	cpu, err := m68kemu.NewCPU(bus)
+	if err != nil {
+		tb.Fatalf("Failed to create CPU: %v", err)
+	}
+	if options.MaxCycles == 0 {
+		options.MaxCycles = 10_000_000
+	}
+	return New(cpu, bus, options), cpu, ram
+}
+
+func runPRG(tb testing.TB, options Options, prg []byte, args ...string) (int, *System, m68kemu.CPU) {
+	tb.Helper()
+	system, cpu, _ := newSystem(tb, options)
+	if _, err := system.Load(bytes.NewReader(prg), args...); err != nil {
+		tb.Fatalf("Load failed: %v", err)
+	}
+	code, err := system.Run()
+	if err != nil {
+		tb.Fatalf("Run failed: %v", err)
+	}
+	return code, system, cpu
+}
+
+func TestLoadPRGRelocatesAndFillsBasepage(t *testing.T) {
+	prg := buildPRG(t, `
+        MOVE.L  #target,D0
+        NOP
+target: DC.L    target
+`, 0x20, 2, 8)
+	ram := m68kemu.NewRAM(0, 0x10000)
+	program, err := LoadPRG(m68kemu.NewBus(ram), bytes.NewReader(prg), PRGLoadOptions{
+		Basepage:    0x1000,
+		Top:         0x8000,
+		CommandLine: "-v FILE.TXT",
+	})
+	if err != nil {
+		t.Fatalf("LoadPRG failed: %v", err)
+	}
+	if program.Text != 0x1100 || program.BSS != 0x110c || program.End != 0x112c {
+		t.Fatalf("program = %+v, want text at 1100 and BSS 110c-112c", program)
+	}
+	for _, offset := range []uint32{2, 8} {
+		if value, _ := ram.Read(m68kemu.Long, program.Text+offset); value != 0x1108 {
+			t.Fatalf("fixup at +%d = %08x, want 00001108", offset, value)
+		}
+	}
+	if top, _ := ram.Read(m68kemu.Long, 0x1000+basepageHighTPA); top != 0x8000 {
+		t.Fatalf("basepage high TPA = %08x, want 00008000", top)
+	}
+	if length, _ := ram.Read(m68kemu.Byte, 0x1000+basepageCmdline); length != 11 {
+		t.Fatalf("command line length = %d, want 11", length)
+	}
+
+	if _, err := LoadPRG(m68kemu.NewBus(ram), bytes.NewReader(prg), PRGLoadOptions{Basepage: 0x1000, Top: 0x1110}); err == nil {
+		t.Fatalf("LoadPRG into a tiny TPA succeeded, want an error")
+	}
+	if _, err := LoadPRG(m68kemu.NewBus(ram), bytes.NewReader([]byte("not a program at all......")), PRGLoadOptions{}); err == nil {
+		t.Fatalf("LoadPRG accepted a bad magic number")
+	}
+
+	// Segment sizes whose 32-bit sum wraps around must not fit the TPA.
+	wrapped := append([]byte(nil), prg...)
+	binary.BigEndian.PutUint32(wrapped[2:], 0xffffff00)
+	binary.BigEndian.PutUint32(wrapped[6:], 0x100)
+	_, err = LoadPRG(m68kemu.NewBus(ram), bytes.NewReader(wrapped), PRGLoadOptions{Basepage: 0x1000, Top: 0x8000})
+	if err == nil || !strings.Contains(err.Error(), "too small") {
+		t.Fatalf("LoadPRG with wrapping segment sizes: err = %v, want a TPA size error", err)
+	}
+}
+
+func TestSystemRunsConsoleProgram(t *testing.T) {
+	prg := buildPRG(t, `
+        MOVE.L  #msg,-(A7)
+        MOVE.W  #9,-(A7)
+        TRAP    #1
+        ADDQ.L  #6,A7
+        MOVE.W  #33,-(A7)
+        MOVE.W  #2,-(A7)
+        MOVE.W  #3,-(A7)
+        TRAP    #13
+        ADDQ.L  #6,A7
+        MOVE.W  #3,-(A7)
+        MOVE.W  #$4C,-(A7)
+        TRAP    #1
+msg:    `+dcb("Hello, TOS"), 0, 2)
+
+	var out bytes.Buffer
+	code, system, _ := runPRG(t, Options{Stdout: &out}, prg)
+	if code != 3 || !system.Terminated() {
+		t.Fatalf("exit code = %d, terminated = %v, want 3 and true", code, system.Terminated())
+	}
+	if out.String() != "Hello, TOS!" {
+		t.Fatalf("output = %q, want %q", out.String(), "Hello, TOS!")
+	}
+}
+
+func TestSystemFileCallsUseHostDirectory(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "data"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "data", "in.txt"), []byte("input"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	prg := buildPRG(t, `
+        LEA     dir(PC),A0
+        MOVE.L  A0,-(A7)
+        MOVE.W  #$3B,-(A7)      ; Dsetpath
+        TRAP    #1
+        ADDQ.L  #6,A7
+        CLR.W   -(A7)
+        LEA     in(PC),A0
+        MOVE.L  A0,-(A7)
+        MOVE.W  #$3D,-(A7)      ; Fopen
+        TRAP    #1
+        ADDQ.L  #8,A7
+        MOVE.W  D0,D7
+        LEA     buffer(PC),A6
+        MOVE.L  A6,-(A7)
+        MOVE.L  #-1,-(A7)       ; read to the end of the file
+        MOVE.W  D7,-(A7)
+        MOVE.W  #$3F,-(A7)      ; Fread
+        TRAP    #1
+        LEA     12(A7),A7
+        MOVE.L  D0,D6
+        MOVE.W  D7,-(A7)
+        MOVE.W  #$3E,-(A7)      ; Fclose
+        TRAP    #1
+        ADDQ.L  #4,A7
+        CLR.W   -(A7)
+        LEA     out(PC),A0
+        MOVE.L  A0,-(A7)
+        MOVE.W  #$3C,-(A7)      ; Fcreate
+        TRAP    #1
+        ADDQ.L  #8,A7
+        MOVE.W  D0,D7
+        MOVE.L  A6,-(A7)
+        MOVE.L  D6,-(A7)
+        MOVE.W  D7,-(A7)
+        MOVE.W  #$40,-(A7)      ; Fwrite
+        TRAP    #1
+        LEA     12(A7),A7
+        MOVE.W  D7,-(A7)
+        MOVE.W  #$3E,-(A7)      ; Fclose
+        TRAP    #1
+        ADDQ.L  #4,A7
+        MOVE.W  D6,-(A7)
+        MOVE.W  #$4C,-(A7)      ; Pterm
+        TRAP    #1
+dir:    `+dcb(`C:\DATA`)+`
+in:     `+dcb("IN.TXT")+`
+out:    `+dcb(`\OUT.TXT`)+`
+        DC.B    0
+buffer: DC.L    0
+`, 64)
+
+	code, system, _ := runPRG(t, Options{Root: root}, prg)
+	if code != 5 {
+		t.Fatalf("exit code = %d, want 5 bytes read", code)
+	}
+	got, err := os.ReadFile(filepath.Join(root, "OUT.TXT"))
+	if err != nil || string(got) != "input" {
+		t.Fatalf("OUT.TXT = %q, %v, want the copied input", got, err)
+	}
+	if system.cwd != `\DATA` || len(system.files) != 0 {
+		t.Fatalf("cwd = %q with %d open files, want \\DATA and none", system.cwd, len(system.files))
+	}
+}
+
+func TestSystemConsoleFreadReturnsOneLine(t *testing.T) {
+	prg := buildPRG(t, `
+        LEA     buffer(PC),A6
+        MOVE.L  A6,-(A7)
+        MOVE.L  #-1,-(A7)
+        CLR.W   -(A7)
+        MOVE.W  #$3F,-(A7)      ; Fread(0)
+        TRAP    #1
+        LEA     12(A7),A7
+        MOVE.L  A6,-(A7)
+        MOVE.L  D0,-(A7)
+        MOVE.W  #1,-(A7)
+        MOVE.W  #$40,-(A7)      ; Fwrite(1)
+        TRAP    #1
+        LEA     12(A7),A7
+        MOVE.W  D0,-(A7)
+        MOVE.W  #$4C,-(A7)      ; Pterm
+        TRAP    #1
+buffer: DC.L    0
+`, 64)
+
+	var out bytes.Buffer
+	code, _, _ := runPRG(t, Options{Stdin: strings.NewReader("hi\nrest\n"), Stdout: &out}, prg)
+	if code != 3 || out.String() != "hi\n" {
+		t.Fatalf("exit code = %d, output = %q, want 3 and the first line", code, out.String())
+	}
+}
+
+func TestSystemMemoryCalls(t *testing.T) {
+	prg := buildPRG(t, `
+        MOVE.L  #-1,-(A7)
+        MOVE.W  #$48,-(A7)      ; Malloc(-1)
+        TRAP    #1
+        ADDQ.L  #6,A7
+        MOVE.L  D0,D5
+        MOVE.L  4(A7),A5
+        MOVE.L  #$200,-(A7)
+        MOVE.L  A5,-(A7)
+        CLR.W   -(A7)
+        MOVE.W  #$4A,-(A7)      ; Mshrink
+        TRAP    #1
+        LEA     12(A7),A7
+        MOVE.L  D0,D4
+        MOVE.L  #$1000,-(A7)
+        MOVE.W  #$48,-(A7)      ; Malloc
+        TRAP    #1
+        ADDQ.L  #6,A7
+        MOVE.L  D0,D3
+        MOVE.L  D3,-(A7)
+        MOVE.W  #$49,-(A7)      ; Mfree
+        TRAP    #1
+        ADDQ.L  #6,A7
+        MOVE.L  D0,D2
+        MOVE.L  D3,-(A7)
+        MOVE.W  #$49,-(A7)      ; Mfree twice
+        TRAP    #1
+        ADDQ.L  #6,A7
+        MOVE.L  D0,D1
+        CLR.W   -(A7)
+        TRAP    #1
+`, 0)
+
+	_, _, cpu := runPRG(t, Options{}, prg)
+	regs := cpu.Registers()
+	if regs.D[5] != 0 {
+		t.Fatalf("Malloc(-1) with the whole TPA in use = %d, want 0", regs.D[5])
+	}
+	if regs.D[4] != EOK || uint32(regs.D[3]) != DefaultTPAStart+0x200 {
+		t.Fatalf("Mshrink = %d, Malloc = %08x, want 0 and %08x", regs.D[4], regs.D[3], DefaultTPAStart+0x200)
+	}
+	if regs.D[2] != EOK || regs.D[1] != EIMBA {
+		t.Fatalf("Mfree = %d then %d, want 0 then EIMBA", regs.D[2], regs.D[1])
+	}
+}
+
+func TestSystemSupexecRunsRoutineInSupervisorMode(t *testing.T) {
+	prg := buildPRG(t, `
+        LEA     routine(PC),A0
+        MOVE.L  A0,-(A7)
+        MOVE.W  #38,-(A7)       ; Supexec
+        TRAP    #14
+        ADDQ.L  #6,A7
+        MOVE.L  D0,D7
+        MOVE    SR,D6
+        MOVE.L  A7,D5
+        CLR.W   -(A7)
+        TRAP    #1
+routine:
+        MOVE    SR,D0
+        RTS
+`, 0)
+
+	_, _, cpu := runPRG(t, Options{}, prg)
+	regs := cpu.Registers()
+	if regs.D[7]&srSupervisor == 0 || regs.D[6]&srSupervisor != 0 {
+		t.Fatalf("routine SR = %04x, caller SR = %04x, want supervisor then user", regs.D[7], regs.D[6])
+	}
+	if uint32(regs.D[5]) != DefaultMemoryTop-8 {
+		t.Fatalf("A7 = %08x, want the user stack restored to %08x", regs.D[5], DefaultMemoryTop-8)
+	}
+}
+
+func TestSystemReportsUnhandledExceptions(t *testing.T) {
+	prg := buildPRG(t, "NOP\nDC.W $4AFC\n", 0)
+	system, _, _ := newSystem(t, Options{})
+	program, err := system.Load(bytes.NewReader(prg))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	_, err = system.Run()
+	var unhandled UnhandledExceptionError
+	if !errors.As(err, &unhandled) || unhandled.Vector != m68kemu.XIllegal || unhandled.PC != program.Text+4 {
+		t.Fatalf("Run = %v, want an illegal instruction stacking %06x", err, program.Text+4)
+	}
+
+	loop := buildPRG(t, "loop: BRA.S loop\n", 0)
+	system, _, _ = newSystem(t, Options{MaxCycles: 1000})
+	if _, err := system.Load(bytes.NewReader(loop)); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if _, err := system.Run(); !errors.Is(err, ErrCycleLimit) {
+		t.Fatalf("Run = %v, want ErrCycleLimit", err)
+	}
+}
+
+func TestHostPathMatchesNamesIgnoringCase(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "Src", "lib"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	system := &System{options: Options{Root: root}, cwd: `\SRC`}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{`C:\SRC\LIB\A.C`, filepath.Join(root, "Src", "lib", "A.C")},
+		{`lib\..\..\x.o`, filepath.Join(root, "x.o")},
+		{`\..\..\etc`, filepath.Join(root, "etc")},
+		{`C:\`, root},
+	}
+	for _, tc := range tests {
+		got, code := system.hostPath(tc.name)
+		if code != EOK || got != tc.want {
+			t.Fatalf("hostPath(%q) = %q, %d, want %q", tc.name, got, code, tc.want)
+		}
+	}
+
+	if _, code := (&System{}).hostPath("A.TXT"); code != EDRIVE {
+		t.Fatalf("hostPath without a root = %d, want EDRIVE", code)
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*.*", "README", true},
+		{"*.C", "MAIN.C", true},
+		{"*.C", "MAIN.H", false},
+		{"MAIN.*", "MAIN", true},
+		{"?AIN.C", "MAIN.C", true},
+	}
+	for _, tc := range tests {
+		if got := matchPattern(tc.pattern, tc.name); got != tc.want {
+			t.Fatalf("matchPattern(%q, %q) = %v, want %v", tc.pattern, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestMemoryPoolMergesFreedBlocks(t *testing.T) {
+	pool := newMemoryPool(0x1000, 0x2000)
+	a, _ := pool.alloc(0x100)
+	b, _ := pool.alloc(0x101)
+	c, _ := pool.alloc(0x100)
+	if a != 0x1000 || b != 0x1100 || c != 0x1202 {
+		t.Fatalf("allocations = %x, %x, %x, want 1000, 1100, 1202", a, b, c)
+	}
+	if !pool.release(a) || !pool.release(c) || pool.release(c) {
+		t.Fatalf("release did not accept each block exactly once")
+	}
+	if !pool.release(b) || len(pool.free) != 1 || pool.largest() != 0x1000 {
+		t.Fatalf("free list = %+v, want one merged block", pool.free)
+	}
+	if _, ok := pool.alloc(0x1001); ok {
+		t.Fatalf("alloc larger than the pool succeeded")
+	}
+}
@@ -0,0 +1,94 @@
+package m68kemu
+
+// TrapHandler implements an exception vector in Go. It runs before the CPU
+// takes the exception and returns true when it handled the exception, in
+// which case no exception is taken and execution continues at
+// frame.ReturnPC. Returning false lets normal vectoring proceed. An error
+// stops execution and is returned from Step or Run.
+type TrapHandler func(frame *TrapFrame) (bool, error)
+
+// TrapFrame gives a TrapHandler access to the CPU state at the exception.
+type TrapFrame struct {
+	// Vector is the exception vector number, for example XTrap+1 for TRAP #1.
+	Vector uint32
+	// Opcode is the instruction that raised the exception.
+	Opcode uint16
+	// PC is the address of that instruction.
+	PC uint32
+	// ReturnPC is where execution continues when the handler reports the
+	// exception as handled. It defaults to the instruction after TRAP, Line-A,
+	// Line-F, and illegal opcodes, and otherwise to the PC the exception would
+	// stack. Handlers may change it.
+	ReturnPC uint32
+	// Regs are the live registers. A7 is the stack of the calling code.
+	Regs *Registers
+
+	cpu *cpu
+}
+
+// SetTrapHandler installs handler for an exception vector raised by an
+// instruction: TRAP #n (XTrap+n), Line-A (XLineA), Line-F (XLineF), illegal
+// instructions, privilege violations, CHK, TRAPV, and division by zero.
+// Interrupts, bus errors, and address errors are not intercepted. A nil
+// handler removes the hook.
+func (cpu *cpu) SetTrapHandler(vector uint32, handler TrapHandler) {
+	if vector > 255 {
+		return
+	}
+	if cpu.trapHandlers == nil {
+		if handler == nil {
+			return
+		}
+		cpu.trapHandlers = new([256]TrapHandler)
+	}
+	cpu.trapHandlers[vector] = handler
+}
+
+// interceptException offers an exception to its TrapHandler. It reports true
+// when the handler took care of it.
+func (cpu *cpu) interceptException(vector uint32, stackedPC uint32) (bool, error) {
+	if cpu.trapHandlers == nil || vector > 255 || cpu.trapHandlers[vector] == nil {
+		return false, nil
+	}
+	pc := cpu.currentOpcodeAddress(stackedPC)
+	frame := TrapFrame{
+		Vector:   vector,
+		Opcode:   cpu.regs.IR,
+		PC:       pc,
+		ReturnPC: stackedPC & 0xffffff,
+		Regs:     &cpu.regs,
+		cpu:      cpu,
+	}
+	switch vector {
+	case XIllegal, XLineA, XLineF:
+		frame.ReturnPC = (pc + uint32(Word)) & 0xffffff
+	}
+	handled, err := cpu.trapHandlers[vector](&frame)
+	if err != nil || !handled {
+		return false, err
+	}
+	cpu.regs.PC = frame.ReturnPC
+	return true, nil
+}
+
+// Arg reads a stack argument of the calling code at offset bytes above A7.
+// Offset 0 is the first word pushed before a TRAP, such as a GEMDOS function
+// number.
+func (f *TrapFrame) Arg(size Size, offset uint32) (uint32, error) {
+	return f.cpu.read(size, f.Regs.A[7]+offset)
+}
+
+// Read reads memory with the calling code's privileges.
+func (f *TrapFrame) Read(size Size, address uint32) (uint32, error) {
+	return f.cpu.read(size, address)
+}
+
+// Write writes memory with the calling code's privileges.
+func (f *TrapFrame) Write(size Size, address uint32, value uint32) error {
+	return f.cpu.write(size, address, value)
+}
+
+// AddCycles charges the work done by the handler to the CPU.
+func (f *TrapFrame) AddCycles(cycles uint32) {
+	f.cpu.addCycles(cycles)
+}
@@ -0,0 +1,94 @@
+package m68kemu
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTrapHandlerHandlesTrapNatively(t *testing.T) {
+	cpu, ram := newEnvironment(t)
+	loadProgram(t, ram, cpu.regs.PC, `
+        MOVE.W  #$1234,-(A7)
+        MOVE.W  #9,-(A7)
+        TRAP    #1
+        ADDQ.L  #4,A7
+        MOVEQ   #1,D1
+`)
+	sp := cpu.regs.A[7]
+
+	var frame TrapFrame
+	cpu.SetTrapHandler(XTrap+1, func(f *TrapFrame) (bool, error) {
+		frame = *f
+		function, err := f.Arg(Word, 0)
+		if err != nil {
+			return false, err
+		}
+		arg, err := f.Arg(Word, 2)
+		if err != nil {
+			return false, err
+		}
+		f.Regs.D[0] = int32(function<<16 | arg)
+		f.AddCycles(100)
+		return true, nil
+	})
+
+	if err := cpu.RunInstructions(5); err != nil {
+		t.Fatalf("RunInstructions failed: %v", err)
+	}
+	if frame.Vector != XTrap+1 || frame.Opcode != 0x4e41 || frame.PC != 0x2008 || frame.ReturnPC != 0x200a {
+		t.Fatalf("frame = %+v, want TRAP #1 at 2008 returning to 200a", frame)
+	}
+	if cpu.regs.D[0] != 0x00091234 || cpu.regs.D[1] != 1 || cpu.regs.A[7] != sp {
+		t.Fatalf("D0 = %08x, D1 = %d, A7 = %04x, want the call handled inline", cpu.regs.D[0], cpu.regs.D[1], cpu.regs.A[7])
+	}
+	if cpu.lastExceptionValid {
+		t.Fatalf("handled trap still raised exception %d", cpu.lastException.Vector)
+	}
+}
+
+func TestTrapHandlerCanDeclineAndLineOpcodes(t *testing.T) {
+	cpu, ram := newEnvironment(t)
+	loadProgram(t, ram, cpu.regs.PC, "DC.W $A00A\nDC.W $F123\n")
+	if err := ram.Write(Long, XLineF<<2, 0x3000); err != nil {
+		t.Fatalf("install vector: %v", err)
+	}
+
+	var lineA, lineF int
+	cpu.SetTrapHandler(XLineA, func(f *TrapFrame) (bool, error) {
+		lineA++
+		return f.Opcode == 0xa00a, nil
+	})
+	cpu.SetTrapHandler(XLineF, func(f *TrapFrame) (bool, error) {
+		lineF++
+		return false, nil
+	})
+
+	if err := cpu.Step(); err != nil {
+		t.Fatalf("Line-A step failed: %v", err)
+	}
+	if lineA != 1 || cpu.regs.PC != 0x2002 || cpu.lastExceptionValid {
+		t.Fatalf("Line-A handled %d times, PC = %04x, want 1 and 2002 without an exception", lineA, cpu.regs.PC)
+	}
+	if err := cpu.Step(); err != nil {
+		t.Fatalf("Line-F step failed: %v", err)
+	}
+	if lineF != 1 || cpu.regs.PC != 0x3000 || cpu.lastException.Vector != XLineF {
+		t.Fatalf("declined Line-F: calls %d, PC = %04x, want normal vectoring to 3000", lineF, cpu.regs.PC)
+	}
+
+	cpu.SetTrapHandler(XLineF, nil)
+	if cpu.trapHandlers[XLineF] != nil {
+		t.Fatalf("nil handler did not remove the hook")
+	}
+}
+
+func TestTrapHandlerErrorStopsExecution(t *testing.T) {
+	cpu, ram := newEnvironment(t)
+	loadProgram(t, ram, cpu.regs.PC, "TRAP #0\n")
+	halt := errors.New("Pterm")
+	cpu.SetTrapHandler(XTrap, func(*TrapFrame) (bool, error) { return false, halt })
+
+	if err := cpu.RunCycles(1000); !errors.Is(err, halt) {
+		t.Fatalf("RunCycles = %v, want the handler's error", err)
+	}
+}
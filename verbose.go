@@ -104,6 +104,29 @@ func (logger *VerboseLogger) Trace(info TraceInfo) {
 	_, _ = io.WriteString(logger.writer, text.String())
 }
 
+// BusTrace implements BusAccessCallback for use with CPU.SetBusTracer. Register
+// names reported by RegisterNamer devices replace the raw address, for example
+// "BUS PC 00fc0020 WRITE.B MFP.TACR = 01".
+func (logger *VerboseLogger) BusTrace(info BusAccessInfo) {
+	if logger == nil {
+		return
+	}
+
+	kind := "READ"
+	switch {
+	case info.Write:
+		kind = "WRITE"
+	case info.InstructionFetch:
+		kind = "FETCH"
+	}
+	target := info.Register
+	if target == "" {
+		target = fmt.Sprintf("%08x", info.Address&0xffffff)
+	}
+	fmt.Fprintf(logger.writer, "BUS PC %08x %s.%s %s = %0*x\n",
+		info.PC&0xffffff, kind, formatSizeSuffix(info.Size), target, int(info.Size)*2, info.Value&info.Size.mask())
+}
+
 // DisassembleInstruction decodes one instruction at the given bus address.
 func DisassembleInstruction(bus AddressBus, address uint32) (DisassemblyLine, error) {
 	inst, err := decodeInstruction(bus, address)
@@ -229,6 +252,19 @@ func formatDisassemblyBytes(data []byte) string {
 	return text.String()
 }
 
+func formatSizeSuffix(size Size) string {
+	switch size {
+	case Byte:
+		return "B"
+	case Word:
+		return "W"
+	case Long:
+		return "L"
+	default:
+		return "?"
+	}
+}
+
 func formatMemoryRangeLabel(memRange MemoryRange) string {
 	if memRange.Label != "" {
 		return memRange.Label